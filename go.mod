@@ -3,12 +3,15 @@ module gitlab.com/contextualcode/decision-engine-go
 go 1.16
 
 require (
-	github.com/google/uuid v1.3.0 // indirect
+	github.com/google/uuid v1.3.0
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/sessions v1.2.1 // indirect
-	github.com/martinlindhe/base36 v1.1.1 // indirect
-	github.com/platformsh/config-reader-go/v2 v2.3.1 // indirect
+	github.com/martinlindhe/base36 v1.1.1
+	github.com/platformsh/config-reader-go/v2 v2.3.1
+	github.com/yuin/gopher-lua v1.1.1
+	go.etcd.io/bbolt v1.3.6
 	go.mongodb.org/mongo-driver v1.9.1
 	golang.org/x/crypto v0.0.0-20201216223049-8b5274cf687f
+	golang.org/x/sys v0.10.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0
 )
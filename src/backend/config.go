@@ -12,9 +12,28 @@ import (
 const configPath = "../../config.yaml"
 
 type Config struct {
-	DatabaseURI  string `yaml:"database_uri"`
-	DatabaseName string `yaml:"database_name"`
-	HTTPPort     int    `yaml:"http_port"`
+	DatabaseDriver string `yaml:"database_driver"`
+	DatabaseURI    string `yaml:"database_uri"`
+	DatabaseName   string `yaml:"database_name"`
+	DatabasePath   string `yaml:"database_path"`
+	HTTPPort       int    `yaml:"http_port"`
+	SessionDriver  string `yaml:"session_driver"`
+	// requests per minute each client can make to public forms, 0 uses the default
+	PublicRateLimit int `yaml:"public_rate_limit"`
+	// leading zero bits of the proof of work needed to submit public forms, 0 disables it
+	PublicProofOfWork int `yaml:"public_proof_of_work"`
+	// header a reverse proxy sets to the client address, e.g. X-Forwarded-For or X-Client-IP, it is only read
+	// on requests from trusted proxies, the connection address is used otherwise
+	ClientIPHeader string   `yaml:"client_ip_header"`
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// mail driver is log (default), file or smtp
+	MailDriver   string `yaml:"mail_driver"`
+	MailFrom     string `yaml:"mail_from"`
+	MailPath     string `yaml:"mail_path"`
+	SMTPHost     string `yaml:"smtp_host"`
+	SMTPPort     int    `yaml:"smtp_port"`
+	SMTPUsername string `yaml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password"`
 }
 
 func ConfigLoad() (Config, error) {
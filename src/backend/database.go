@@ -4,41 +4,106 @@ import (
 	"context"
 
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const dbFetchLimit = 25
 
-var dbClient *mongo.Client
-var dbName string
+// Database drivers.
+const (
+	DatabaseDriverMongo  = "mongo"
+	DatabaseDriverBolt   = "bolt"
+	DatabaseDriverMemory = "memory"
+)
+
+// databaseDataTypes lists an empty value of every type that has a database collection.
+var databaseDataTypes = []interface{}{TreeRoot{}, TreeVersion{}, FormSubmission{}, User{}, Team{}, RuleTemplate{}, Session{}, APIToken{}, AuditLog{}, Webhook{}, WebhookDelivery{}, Role{}, SearchEntry{}}
+
+// Store is a storage backend for the objects of the app.
+// Filters, sorts, projections and pipelines use the MongoDB query syntax.
+type Store interface {
+	// Fetch fetches the first object matching filter.
+	Fetch(dataType interface{}, filter interface{}, sort interface{}) (interface{}, error)
+	// List lists up to dbFetchLimit objects matching filter starting at offset, along with the total count.
+	List(dataType interface{}, filter interface{}, sort interface{}, projection interface{}, offset int) ([]interface{}, int, error)
+	// ListAll lists all objects matching filter.
+	ListAll(dataType interface{}, filter interface{}, sort interface{}, projection interface{}) ([]interface{}, error)
+	// ListAggregate runs an aggregation pipeline and lists up to dbFetchLimit results starting at offset, along with the total count.
+	ListAggregate(dataType interface{}, pipeline mongo.Pipeline, offset int) ([]interface{}, int, error)
+	// Aggregate runs an aggregation pipeline and decodes all results in to results, which must be a pointer to a slice.
+	Aggregate(dataType interface{}, pipeline mongo.Pipeline, results interface{}) error
+	// Count counts the objects matching filter.
+	Count(dataType interface{}, filter interface{}) (int, error)
+	// StoreOne inserts or updates an object.
+	StoreOne(data interface{}) error
+	// InsertOne inserts an object, ErrDBDuplicateKey is returned if an object with its key is already stored.
+	InsertOne(data interface{}) error
+	// UpdateOne sets fields of the first object matching filter and returns false if no object matched.
+	// Matching and updating is atomic, so it can be used to claim an object.
+	UpdateOne(dataType interface{}, filter interface{}, set interface{}) (bool, error)
+	// Delete deletes all objects matching filter.
+	Delete(dataType interface{}, filter interface{}) error
+	// Transaction runs fn with a store whose writes are committed if fn succeeds and rolled back if it fails.
+	Transaction(fn func(tx Store) error) error
+	// Close closes the connection to the backend.
+	Close() error
+}
+
+var dbStore Store
 
 func databaseOpen(config *Config) error {
-	opts := options.Client().ApplyURI(config.DatabaseURI)
 	var err error
-	dbClient, err = mongo.Connect(databaseContext(), opts)
-	dbName = config.DatabaseName
+	switch config.DatabaseDriver {
+	case "", DatabaseDriverMongo:
+		{
+			dbStore, err = newMongoStore(config)
+			break
+		}
+	case DatabaseDriverBolt:
+		{
+			dbStore, err = newBoltStore(config)
+			break
+		}
+	case DatabaseDriverMemory:
+		{
+			dbStore = newMemoryStore()
+			break
+		}
+	default:
+		{
+			return ErrDBInvalidDriver
+		}
+	}
 	return err
 }
 
 func databaseClose() error {
-	if dbClient != nil {
-		return dbClient.Disconnect(databaseContext())
+	if dbStore != nil {
+		err := dbStore.Close()
+		dbStore = nil
+		return err
 	}
 	return nil
 }
 
-func databaseCollectionFromData(data interface{}) (*mongo.Collection, error) {
-	if data == nil {
-		return nil, ErrNoData
+// databaseTransaction runs fn inside a single transaction, which is rolled back if fn returns an error.
+// Only the database operations made with the store given to fn are part of the transaction.
+func databaseTransaction(fn func(tx Store) error) error {
+	store, err := databaseGetStore(nil)
+	if err != nil {
+		return err
 	}
-	if dbClient == nil || dbName == "" {
-		return nil, ErrNoDBConnection
+	return store.Transaction(fn)
+}
+
+// databaseGetStore returns the store of a transaction, or the database store if tx is nil.
+func databaseGetStore(tx Store) (Store, error) {
+	if tx != nil {
+		return tx, nil
 	}
-	collectionName := getDatabaseCollectionNameFromData(data)
-	if collectionName == "" {
-		return nil, ErrDBInvalidObjectType
+	if dbStore == nil {
+		return nil, ErrNoDBConnection
 	}
-	return dbClient.Database(dbName).Collection(collectionName), nil
+	return dbStore, nil
 }
 
 func databaseContext() context.Context {
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const dbBoltTimeout = 5 * time.Second
+
+// boltMetaBucket holds the state of the database file itself.
+const boltMetaBucket = "_meta"
+const boltMetaKeyed = "keyed"
+
+// boltStore is a Store backed by a single bbolt database file, for running without a database server.
+// Each collection is a bucket of bson documents keyed by the fields of their store filter, so that
+// fetches, updates and deletes of a single object don't scan the bucket. Other queries are evaluated
+// in process.
+type boltStore struct {
+	documentStore
+	db *bolt.DB
+	tx *bolt.Tx
+}
+
+func newBoltStore(config *Config) (Store, error) {
+	path := config.DatabasePath
+	if path == "" {
+		path = config.DatabaseName + ".db"
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: dbBoltTimeout})
+	if err != nil {
+		return nil, err
+	}
+	s := newBoltStoreTx(db, nil)
+	if err := s.rekey(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func newBoltStoreTx(db *bolt.DB, tx *bolt.Tx) *boltStore {
+	s := &boltStore{db: db, tx: tx}
+	s.documentStore = documentStore{load: s.load}
+	return s
+}
+
+// boltKeyFields returns the fields that identify objects of a data type, the fields of its store filter.
+func boltKeyFields(dataType interface{}) []string {
+	pairs, _ := queryPairs(databaseFilter(getEmptyStruct(dataType)))
+	out := make([]string, 0, len(pairs))
+	for _, p := range pairs {
+		out = append(out, p.Key)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// boltKey returns the key of the object selected by filter, false if filter doesn't select a single
+// object by the key fields of dataType. Filters can have other conditions, the object at the key must
+// still be matched against them.
+func boltKey(dataType interface{}, filter interface{}) ([]byte, bool) {
+	filter, err := queryNormalize(filter)
+	if err != nil {
+		return nil, false
+	}
+	pairs, ok := queryPairs(filter)
+	fields := boltKeyFields(dataType)
+	if !ok || len(fields) == 0 {
+		return nil, false
+	}
+	values := make(map[string]interface{}, len(pairs))
+	for _, p := range pairs {
+		if queryIsOperatorDoc(p.Value) {
+			continue
+		}
+		// numbers are stored with the smallest type that fits them
+		if n, ok := queryNumber(p.Value); ok {
+			p.Value = n
+		}
+		values[p.Key] = p.Value
+	}
+	key := bson.D{}
+	for _, field := range fields {
+		value, exists := values[field]
+		if !exists {
+			return nil, false
+		}
+		key = append(key, bson.E{Key: field, Value: value})
+	}
+	raw, err := bson.Marshal(key)
+	if err != nil {
+		return nil, false
+	}
+	return raw, true
+}
+
+// boltDocKey returns the key of a stored document.
+func boltDocKey(dataType interface{}, doc bson.M) ([]byte, bool) {
+	filter := bson.M{}
+	for _, field := range boltKeyFields(dataType) {
+		filter[field] = doc[field]
+	}
+	return boltKey(dataType, filter)
+}
+
+// rekey moves documents of database files written before documents were keyed by their key fields.
+func (s *boltStore) rekey() error {
+	return s.update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte(boltMetaBucket))
+		if err != nil {
+			return err
+		}
+		if meta.Get([]byte(boltMetaKeyed)) != nil {
+			return nil
+		}
+		for _, dataType := range databaseDataTypes {
+			b := tx.Bucket([]byte(getDatabaseCollectionNameFromData(dataType)))
+			if b == nil {
+				continue
+			}
+			// collect first, changing keys while iterating skips entries
+			moves := make(map[string][]byte)
+			if err := b.ForEach(func(k, v []byte) error {
+				doc, err := s.decode(v)
+				if err != nil {
+					return err
+				}
+				key, ok := boltDocKey(dataType, doc)
+				if !ok {
+					return ErrDBInvalidObjectType
+				}
+				if string(key) != string(k) {
+					moves[string(k)] = key
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			for from, to := range moves {
+				v := append([]byte{}, b.Get([]byte(from))...)
+				if err := b.Delete([]byte(from)); err != nil {
+					return err
+				}
+				if err := b.Put(to, v); err != nil {
+					return err
+				}
+			}
+		}
+		return meta.Put([]byte(boltMetaKeyed), []byte{1})
+	})
+}
+
+// view runs fn in the store's transaction or a new read only one.
+func (s *boltStore) view(fn func(tx *bolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return s.db.View(fn)
+}
+
+// update runs fn in the store's transaction or a new writable one.
+func (s *boltStore) update(fn func(tx *bolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return s.db.Update(fn)
+}
+
+func (s *boltStore) load(collection string) ([]bson.M, error) {
+	out := make([]bson.M, 0)
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(collection))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			doc, err := s.decode(v)
+			if err != nil {
+				return err
+			}
+			out = append(out, doc)
+			return nil
+		})
+	})
+	return out, err
+}
+
+// decode decodes a stored document, values are only valid during a transaction so they are copied first.
+func (s *boltStore) decode(v []byte) (bson.M, error) {
+	raw := make([]byte, len(v))
+	copy(raw, v)
+	doc := bson.M{}
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Fetch fetches objects selected by their key directly, other filters are evaluated on every document.
+func (s *boltStore) Fetch(dataType interface{}, filter interface{}, sort interface{}) (interface{}, error) {
+	key, ok := boltKey(dataType, filter)
+	if !ok {
+		return s.documentStore.Fetch(dataType, filter, sort)
+	}
+	collectionName, err := s.collectionName(dataType)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := queryNormalize(filter)
+	if err != nil {
+		return nil, err
+	}
+	var docs []bson.M
+	if err := s.view(func(tx *bolt.Tx) error {
+		_, docs, err = s.match(tx.Bucket([]byte(collectionName)), key, spec, true)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return databaseReadResult(dataType, docs[0])
+}
+
+// match returns the keys and documents of bucket matching spec, only the document at key if it is given.
+func (s *boltStore) match(b *bolt.Bucket, key []byte, spec interface{}, first bool) ([][]byte, []bson.M, error) {
+	keys, docs := make([][]byte, 0), make([]bson.M, 0)
+	if b == nil {
+		return keys, docs, nil
+	}
+	check := func(k, v []byte) (bool, error) {
+		doc, err := s.decode(v)
+		if err != nil {
+			return false, err
+		}
+		match, err := queryMatch(doc, spec, nil)
+		if err != nil || !match {
+			return false, err
+		}
+		keys = append(keys, append([]byte{}, k...))
+		docs = append(docs, doc)
+		return true, nil
+	}
+	if key != nil {
+		if v := b.Get(key); v != nil {
+			if _, err := check(key, v); err != nil {
+				return nil, nil, err
+			}
+		}
+		return keys, docs, nil
+	}
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		match, err := check(k, v)
+		if err != nil {
+			return nil, nil, err
+		}
+		if match && first {
+			break
+		}
+	}
+	return keys, docs, nil
+}
+
+func (s *boltStore) StoreOne(data interface{}) error {
+	collectionName, err := s.collectionName(data)
+	if err != nil {
+		return err
+	}
+	doc, err := queryToDoc(data)
+	if err != nil {
+		return err
+	}
+	filter := databaseFilter(data)
+	key, ok := boltKey(data, filter)
+	if !ok {
+		return ErrDBInvalidObjectType
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(collectionName))
+		if err != nil {
+			return err
+		}
+		// update existing document or insert new one
+		var stored bson.M
+		if v := b.Get(key); v != nil {
+			if stored, err = s.decode(v); err != nil {
+				return err
+			}
+			for k, value := range doc {
+				stored[k] = value
+			}
+		} else {
+			docs, err := queryUpsert(nil, filter, doc)
+			if err != nil {
+				return err
+			}
+			stored = docs[0]
+		}
+		raw, err := bson.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, raw)
+	})
+}
+
+func (s *boltStore) InsertOne(data interface{}) error {
+	collectionName, err := s.collectionName(data)
+	if err != nil {
+		return err
+	}
+	doc, err := queryToDoc(data)
+	if err != nil {
+		return err
+	}
+	key, ok := boltKey(data, databaseFilter(data))
+	if !ok {
+		return ErrDBInvalidObjectType
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(collectionName))
+		if err != nil {
+			return err
+		}
+		if b.Get(key) != nil {
+			return ErrDBDuplicateKey
+		}
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, raw)
+	})
+}
+
+func (s *boltStore) UpdateOne(dataType interface{}, filter interface{}, set interface{}) (bool, error) {
+	collectionName, err := s.collectionName(dataType)
+	if err != nil {
+		return false, err
+	}
+	spec, err := queryNormalize(filter)
+	if err != nil {
+		return false, err
+	}
+	key, _ := boltKey(dataType, filter)
+	matched := false
+	err = s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(collectionName))
+		keys, docs, err := s.match(b, key, spec, true)
+		if err != nil || len(docs) == 0 {
+			return err
+		}
+		updated, err := querySet(docs[0], set)
+		if err != nil {
+			return err
+		}
+		raw, err := bson.Marshal(updated)
+		if err != nil {
+			return err
+		}
+		matched = true
+		return b.Put(keys[0], raw)
+	})
+	return matched, err
+}
+
+func (s *boltStore) Delete(dataType interface{}, filter interface{}) error {
+	collectionName, err := s.collectionName(dataType)
+	if err != nil {
+		return err
+	}
+	spec, err := queryNormalize(filter)
+	if err != nil {
+		return err
+	}
+	key, _ := boltKey(dataType, filter)
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(collectionName))
+		if b == nil {
+			return nil
+		}
+		// collect keys first, deleting while iterating skips entries
+		keys, _, err := s.match(b, key, spec, false)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Transaction runs fn in a single writable bolt transaction, which is rolled back if fn fails.
+func (s *boltStore) Transaction(fn func(tx Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(newBoltStoreTx(s.db, tx))
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestBoltStore(t *testing.T) {
+	config := testGetConfig()
+	config.DatabaseDriver = DatabaseDriverBolt
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	// fill a memory store to migrate from
+	from := newMemoryStore()
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	testRoot := TreeRoot{ID: GenerateDatabaseId(), Type: TreeForm, Parent: testUser.Team, Label: "Published"}
+	testVersions := []*TreeVersion{
+		{RootID: testRoot.ID, Version: 1, State: TreeArchived, Tree: getTestTree(testRoot.ID.String())},
+		{RootID: testRoot.ID, Version: 2, State: TreePublished, Tree: getTestTree(testRoot.ID.String())},
+	}
+	for _, data := range []interface{}{&testUser, &testRoot, testVersions[0], testVersions[1]} {
+		if err := from.StoreOne(data); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if err := databaseOpen(config); err != nil {
+		t.Error(err)
+		return
+	}
+	total, err := databaseMigrate(from, dbStore)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if total != 4 {
+		t.Errorf("expected 4 migrated objects, got %d", total)
+	}
+	// data should persist after reopening
+	databaseClose()
+	if err := databaseOpen(config); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	version, err := FetchTreeVersionLatestPublished(testRoot.ID.String(), &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if version.Version != 2 || len(version.Tree) != len(testVersions[1].Tree) {
+		t.Errorf("unexpected published version")
+	}
+	roots, info, err := ListPublishedFormRoot(&testUser, ListPage{Count: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if info.Count != 1 || roots[0].ID != testRoot.ID {
+		t.Errorf("expected published form to be listed")
+	}
+	nodes, err := ListNodeVersion(testRoot.ID.String(), 0, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(nodes) != len(version.Tree) {
+		t.Errorf("expected %d nodes, got %d", len(version.Tree), len(nodes))
+	}
+	// update and delete
+	testRoot.Label = "Updated"
+	if err := databaseStoreOne(nil, &testRoot); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := databaseDelete(nil, TreeVersion{}, bson.M{"root_id": testRoot.ID, "version": 1}); err != nil {
+		t.Error(err)
+		return
+	}
+	rootCount, _ := databaseCount(nil, TreeRoot{}, bson.M{"label": "Updated"})
+	versionCount, _ := databaseCount(nil, TreeVersion{}, bson.M{"root_id": testRoot.ID})
+	if rootCount != 1 || versionCount != 1 {
+		t.Errorf("unexpected counts after update and delete")
+	}
+}
+
+func TestStoreTransaction(t *testing.T) {
+	config := testGetConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	fileStore, err := newBoltStore(config)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer fileStore.Close()
+	for _, store := range []Store{newMemoryStore(), fileStore} {
+		kept := RuleTemplate{ID: GenerateDatabaseId(), Label: "Kept"}
+		if err := store.StoreOne(&kept); err != nil {
+			t.Error(err)
+			return
+		}
+		// failed transaction rolls back inserts, updates and deletes
+		err := store.Transaction(func(tx Store) error {
+			if err := tx.StoreOne(&RuleTemplate{ID: GenerateDatabaseId(), Label: "Rolled back"}); err != nil {
+				return err
+			}
+			if err := tx.StoreOne(&RuleTemplate{ID: kept.ID, Label: "Changed"}); err != nil {
+				return err
+			}
+			if err := tx.Delete(RuleTemplate{}, bson.M{"_id": kept.ID}); err != nil {
+				return err
+			}
+			return ErrBatchStepFailed
+		})
+		if err != ErrBatchStepFailed {
+			t.Errorf("%T: expected transaction error, got %v", store, err)
+		}
+		res, err := store.ListAll(RuleTemplate{}, bson.M{}, nil, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(res) != 1 || res[0].(*RuleTemplate).Label != "Kept" {
+			t.Errorf("%T: expected transaction to be rolled back", store)
+		}
+		// successful transaction commits
+		if err := store.Transaction(func(tx Store) error {
+			return tx.StoreOne(&RuleTemplate{ID: kept.ID, Label: "Changed"})
+		}); err != nil {
+			t.Error(err)
+			return
+		}
+		res, err = store.ListAll(RuleTemplate{}, bson.M{}, nil, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(res) != 1 || res[0].(*RuleTemplate).Label != "Changed" {
+			t.Errorf("%T: expected transaction to be committed", store)
+		}
+	}
+}
+
+func TestStoreInsertOne(t *testing.T) {
+	config := testGetConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	fileStore, err := newBoltStore(config)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer fileStore.Close()
+	for _, store := range []Store{newMemoryStore(), fileStore} {
+		entry := AuditLog{ID: "entry", Action: AuditCreate}
+		if err := store.InsertOne(&entry); err != nil {
+			t.Error(err)
+			return
+		}
+		// stored objects are never overwritten, in or out of a transaction
+		if err := store.InsertOne(&AuditLog{ID: "entry", Action: AuditDelete}); err != ErrDBDuplicateKey {
+			t.Errorf("%T: expected duplicate key error, got %v", store, err)
+		}
+		if err := store.Transaction(func(tx Store) error {
+			return tx.InsertOne(&AuditLog{ID: "entry", Action: AuditDelete})
+		}); err != ErrDBDuplicateKey {
+			t.Errorf("%T: expected duplicate key error in transaction, got %v", store, err)
+		}
+		res, err := store.ListAll(AuditLog{}, bson.M{}, nil, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(res) != 1 || res[0].(*AuditLog).Action != AuditCreate {
+			t.Errorf("%T: expected first entry to be kept", store)
+		}
+	}
+}
+
+func TestBoltStoreKeys(t *testing.T) {
+	config := testGetConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	// write a document keyed by insertion sequence like older database files
+	legacy := RuleTemplate{ID: GenerateDatabaseId(), Label: "Legacy"}
+	db, err := bolt.Open(config.DatabasePath, 0600, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(getDatabaseCollectionNameFromData(RuleTemplate{})))
+		if err != nil {
+			return err
+		}
+		raw, err := bson.Marshal(&legacy)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte{0, 0, 0, 0, 0, 0, 0, 1}, raw)
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+	db.Close()
+	store, err := newBoltStore(config)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer store.Close()
+	// documents are re-keyed when opened and fetched by key
+	res, err := store.Fetch(RuleTemplate{}, bson.M{"_id": legacy.ID}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if res.(*RuleTemplate).Label != "Legacy" {
+		t.Errorf("expected legacy document to be fetched by id")
+	}
+	// updates replace the document under its key
+	legacy.Label = "Updated"
+	if err := store.StoreOne(&legacy); err != nil {
+		t.Error(err)
+		return
+	}
+	// conditional updates match once
+	for i, expect := range []bool{true, false} {
+		matched, err := store.UpdateOne(RuleTemplate{}, bson.M{"_id": legacy.ID, "label": "Updated"}, bson.M{"label": "Claimed"})
+		if err != nil || matched != expect {
+			t.Errorf("expected update %d to match %t, got %t %v", i+1, expect, matched, err)
+		}
+	}
+	version := TreeVersion{RootID: legacy.ID, Version: 3, State: TreeDraft}
+	if err := store.StoreOne(&version); err != nil {
+		t.Error(err)
+		return
+	}
+	if count, _ := store.Count(RuleTemplate{}, bson.M{}); count != 1 {
+		t.Errorf("expected update to not insert, got %d documents", count)
+	}
+	res, err = store.Fetch(TreeVersion{}, bson.M{"root_id": legacy.ID, "version": 3}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if res.(*TreeVersion).State != TreeDraft {
+		t.Errorf("expected version to be fetched by root and version")
+	}
+	if _, err := store.Fetch(RuleTemplate{}, bson.M{"_id": GenerateDatabaseId()}, nil); err != mongo.ErrNoDocuments {
+		t.Errorf("expected no documents error, got %v", err)
+	}
+	if err := store.Delete(RuleTemplate{}, bson.M{"_id": legacy.ID}); err != nil {
+		t.Error(err)
+		return
+	}
+	if count, _ := store.Count(RuleTemplate{}, bson.M{}); count != 0 {
+		t.Errorf("expected document to be deleted")
+	}
+}
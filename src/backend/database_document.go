@@ -0,0 +1,125 @@
+package main
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// documentStore implements the read methods of Store for backends that load collections as documents
+// and evaluate queries in process (see database_query.go). Loaded documents must not be modified.
+type documentStore struct {
+	load func(collection string) ([]bson.M, error)
+}
+
+func (s documentStore) collectionName(data interface{}) (string, error) {
+	if data == nil {
+		return "", ErrNoData
+	}
+	collectionName := getDatabaseCollectionNameFromData(data)
+	if collectionName == "" {
+		return "", ErrDBInvalidObjectType
+	}
+	return collectionName, nil
+}
+
+func (s documentStore) documents(dataType interface{}) ([]bson.M, error) {
+	collectionName, err := s.collectionName(dataType)
+	if err != nil {
+		return nil, err
+	}
+	return s.load(collectionName)
+}
+
+func (s documentStore) readResults(dataType interface{}, docs []bson.M) ([]interface{}, error) {
+	out := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		data, err := databaseReadResult(dataType, doc)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, data)
+	}
+	return out, nil
+}
+
+func (s documentStore) Fetch(dataType interface{}, filter interface{}, sort interface{}) (interface{}, error) {
+	docs, err := s.documents(dataType)
+	if err != nil {
+		return nil, err
+	}
+	res, _, err := queryFind(docs, filter, sort, nil, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return databaseReadResult(dataType, res[0])
+}
+
+func (s documentStore) List(dataType interface{}, filter interface{}, sort interface{}, projection interface{}, offset int) ([]interface{}, int, error) {
+	docs, err := s.documents(dataType)
+	if err != nil {
+		return nil, 0, err
+	}
+	res, count, err := queryFind(docs, filter, sort, projection, offset, dbFetchLimit)
+	if err != nil {
+		return nil, 0, err
+	}
+	out, err := s.readResults(dataType, res)
+	return out, count, err
+}
+
+func (s documentStore) ListAll(dataType interface{}, filter interface{}, sort interface{}, projection interface{}) ([]interface{}, error) {
+	docs, err := s.documents(dataType)
+	if err != nil {
+		return nil, err
+	}
+	res, _, err := queryFind(docs, filter, sort, projection, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return s.readResults(dataType, res)
+}
+
+func (s documentStore) ListAggregate(dataType interface{}, pipeline mongo.Pipeline, offset int) ([]interface{}, int, error) {
+	docs, err := s.documents(dataType)
+	if err != nil {
+		return nil, 0, err
+	}
+	res, err := queryAggregate(docs, pipeline, s.load)
+	if err != nil {
+		return nil, 0, err
+	}
+	count := len(res)
+	if offset > len(res) {
+		offset = len(res)
+	}
+	res = res[offset:]
+	if len(res) > dbFetchLimit {
+		res = res[:dbFetchLimit]
+	}
+	out, err := s.readResults(dataType, res)
+	return out, count, err
+}
+
+func (s documentStore) Aggregate(dataType interface{}, pipeline mongo.Pipeline, results interface{}) error {
+	docs, err := s.documents(dataType)
+	if err != nil {
+		return err
+	}
+	res, err := queryAggregate(docs, pipeline, s.load)
+	if err != nil {
+		return err
+	}
+	return queryDecodeAll(res, results)
+}
+
+func (s documentStore) Count(dataType interface{}, filter interface{}) (int, error) {
+	docs, err := s.documents(dataType)
+	if err != nil {
+		return 0, err
+	}
+	res, err := queryFilter(docs, filter, nil)
+	return len(res), err
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"math/big"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/martinlindhe/base36"
@@ -13,15 +14,36 @@ import (
 
 type DatabaseID [5]byte
 
+// ids generated in the same second count up from a random first digit so that they don't collide
+var (
+	databaseIdLock   sync.Mutex
+	databaseIdSecond uint32
+	databaseIdStart  uint8
+	databaseIdCount  int
+)
+
 func GenerateDatabaseId() DatabaseID {
+	databaseIdLock.Lock()
+	defer databaseIdLock.Unlock()
 	out := [5]byte{0, 0, 0, 0, 0}
-	timestamp := time.Now()
-	binary.BigEndian.PutUint32(out[1:5], uint32(timestamp.Unix()))
-	// TODO might be better to have an incrementing rand digit
-	randV, err := rand.Int(rand.Reader, big.NewInt(255))
-	if err == nil {
-		out[0] = uint8(randV.Uint64())
+	for {
+		timestamp := uint32(time.Now().Unix())
+		if timestamp != databaseIdSecond {
+			databaseIdSecond, databaseIdCount = timestamp, 0
+			randV, err := rand.Int(rand.Reader, big.NewInt(256))
+			if err == nil {
+				databaseIdStart = uint8(randV.Uint64())
+			}
+		}
+		if databaseIdCount < 256 {
+			break
+		}
+		// all ids of this second are taken
+		time.Sleep(time.Until(time.Unix(int64(timestamp)+1, 0)))
 	}
+	binary.BigEndian.PutUint32(out[1:5], databaseIdSecond)
+	out[0] = databaseIdStart + uint8(databaseIdCount)
+	databaseIdCount++
 	return DatabaseID(out)
 }
 
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// memoryStore is a Store that keeps all objects in memory, it is used for testing and for running
+// without a database server. Collections are replaced rather than modified on write so readers
+// can evaluate queries on them without holding the lock.
+type memoryStore struct {
+	documentStore
+	mutex       sync.RWMutex
+	collections map[string][]bson.M
+}
+
+func newMemoryStore() *memoryStore {
+	s := &memoryStore{
+		collections: make(map[string][]bson.M),
+	}
+	s.documentStore = documentStore{load: s.load}
+	return s
+}
+
+func (s *memoryStore) load(collection string) ([]bson.M, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.collections[collection], nil
+}
+
+func (s *memoryStore) StoreOne(data interface{}) error {
+	collectionName, err := s.collectionName(data)
+	if err != nil {
+		return err
+	}
+	doc, err := queryToDoc(data)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.upsert(collectionName, databaseFilter(data), doc)
+}
+
+func (s *memoryStore) InsertOne(data interface{}) error {
+	collectionName, err := s.collectionName(data)
+	if err != nil {
+		return err
+	}
+	doc, err := queryToDoc(data)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.insert(collectionName, databaseFilter(data), doc)
+}
+
+func (s *memoryStore) UpdateOne(dataType interface{}, filter interface{}, set interface{}) (bool, error) {
+	collectionName, err := s.collectionName(dataType)
+	if err != nil {
+		return false, err
+	}
+	spec, err := queryNormalize(filter)
+	if err != nil {
+		return false, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.update(collectionName, spec, set)
+}
+
+func (s *memoryStore) Delete(dataType interface{}, filter interface{}) error {
+	collectionName, err := s.collectionName(dataType)
+	if err != nil {
+		return err
+	}
+	spec, err := queryNormalize(filter)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.remove(collectionName, spec)
+}
+
+// upsert inserts or updates the document matching filter, the lock must be held.
+func (s *memoryStore) upsert(collectionName string, filter interface{}, doc bson.M) error {
+	docs, err := queryUpsert(s.collections[collectionName], filter, doc)
+	if err != nil {
+		return err
+	}
+	s.collections[collectionName] = docs
+	return nil
+}
+
+// insert inserts the document if none matches filter, the lock must be held.
+func (s *memoryStore) insert(collectionName string, filter interface{}, doc bson.M) error {
+	spec, err := queryNormalize(filter)
+	if err != nil {
+		return err
+	}
+	for _, stored := range s.collections[collectionName] {
+		match, err := queryMatch(stored, spec, nil)
+		if err != nil {
+			return err
+		}
+		if match {
+			return ErrDBDuplicateKey
+		}
+	}
+	return s.upsert(collectionName, filter, doc)
+}
+
+// update sets fields of the first document matching spec, the lock must be held.
+func (s *memoryStore) update(collectionName string, spec interface{}, set interface{}) (bool, error) {
+	for i, doc := range s.collections[collectionName] {
+		match, err := queryMatch(doc, spec, nil)
+		if err != nil {
+			return false, err
+		}
+		if !match {
+			continue
+		}
+		updated, err := querySet(doc, set)
+		if err != nil {
+			return false, err
+		}
+		docs := append([]bson.M{}, s.collections[collectionName]...)
+		docs[i] = updated
+		s.collections[collectionName] = docs
+		return true, nil
+	}
+	return false, nil
+}
+
+// remove deletes the documents matching spec, the lock must be held.
+func (s *memoryStore) remove(collectionName string, spec interface{}) error {
+	docs := make([]bson.M, 0, len(s.collections[collectionName]))
+	for _, doc := range s.collections[collectionName] {
+		match, err := queryMatch(doc, spec, nil)
+		if err != nil {
+			return err
+		}
+		if !match {
+			docs = append(docs, doc)
+		}
+	}
+	s.collections[collectionName] = docs
+	return nil
+}
+
+// snapshot copies the collection map, collections are never modified in place so keeping the slices is
+// enough. The lock must be held.
+func (s *memoryStore) snapshot() map[string][]bson.M {
+	out := make(map[string][]bson.M, len(s.collections))
+	for name, docs := range s.collections {
+		out[name] = docs
+	}
+	return out
+}
+
+// Transaction runs fn on a snapshot of the store. Writes of fn are recorded and replayed on the store
+// if fn succeeds, so nothing of the transaction is seen by other users of the store before it commits.
+func (s *memoryStore) Transaction(fn func(tx Store) error) error {
+	s.mutex.RLock()
+	tx := &memoryTx{memoryStore: &memoryStore{collections: s.snapshot()}}
+	s.mutex.RUnlock()
+	tx.documentStore = documentStore{load: tx.load}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	// commit
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	snapshot := s.snapshot()
+	for _, op := range tx.ops {
+		if err := op(s); err != nil {
+			s.collections = snapshot
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// memoryTx is a transaction of a memoryStore, it keeps the writes to replay on commit.
+type memoryTx struct {
+	*memoryStore
+	ops []func(s *memoryStore) error
+}
+
+func (t *memoryTx) StoreOne(data interface{}) error {
+	collectionName, err := t.collectionName(data)
+	if err != nil {
+		return err
+	}
+	doc, err := queryToDoc(data)
+	if err != nil {
+		return err
+	}
+	filter := databaseFilter(data)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err := t.upsert(collectionName, filter, doc); err != nil {
+		return err
+	}
+	t.ops = append(t.ops, func(s *memoryStore) error {
+		return s.upsert(collectionName, filter, doc)
+	})
+	return nil
+}
+
+func (t *memoryTx) InsertOne(data interface{}) error {
+	collectionName, err := t.collectionName(data)
+	if err != nil {
+		return err
+	}
+	doc, err := queryToDoc(data)
+	if err != nil {
+		return err
+	}
+	filter := databaseFilter(data)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err := t.insert(collectionName, filter, doc); err != nil {
+		return err
+	}
+	t.ops = append(t.ops, func(s *memoryStore) error {
+		return s.insert(collectionName, filter, doc)
+	})
+	return nil
+}
+
+func (t *memoryTx) UpdateOne(dataType interface{}, filter interface{}, set interface{}) (bool, error) {
+	collectionName, err := t.collectionName(dataType)
+	if err != nil {
+		return false, err
+	}
+	spec, err := queryNormalize(filter)
+	if err != nil {
+		return false, err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	matched, err := t.update(collectionName, spec, set)
+	if err != nil || !matched {
+		return matched, err
+	}
+	t.ops = append(t.ops, func(s *memoryStore) error {
+		_, err := s.update(collectionName, spec, set)
+		return err
+	})
+	return true, nil
+}
+
+func (t *memoryTx) Delete(dataType interface{}, filter interface{}) error {
+	collectionName, err := t.collectionName(dataType)
+	if err != nil {
+		return err
+	}
+	spec, err := queryNormalize(filter)
+	if err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err := t.remove(collectionName, spec); err != nil {
+		return err
+	}
+	t.ops = append(t.ops, func(s *memoryStore) error {
+		return s.remove(collectionName, spec)
+	})
+	return nil
+}
+
+// Transaction runs fn as part of the transaction.
+func (t *memoryTx) Transaction(fn func(tx Store) error) error {
+	return fn(t)
+}
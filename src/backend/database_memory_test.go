@@ -0,0 +1,184 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMemoryStoreQuery(t *testing.T) {
+	docs := []bson.M{
+		{"_id": "a", "type": "form", "version": int32(1), "tags": bson.A{"x", "y"}, "data": bson.M{"label": "Hello"}},
+		{"_id": "b", "type": "form", "version": int32(3), "tags": bson.A{}},
+		{"_id": "c", "type": "document", "version": int32(2)},
+	}
+	testFilters := []struct {
+		filter interface{}
+		expect int
+	}{
+		{bson.M{}, 3},
+		{bson.M{"type": "form"}, 2},
+		{bson.M{"tags": "x"}, 1},
+		{bson.M{"version": bson.M{"$gte": 2}}, 2},
+		{bson.M{"version": bson.M{"$in": bson.A{1, 2}}}, 2},
+		{bson.M{"tags": bson.M{"$exists": false}}, 1},
+		{bson.M{"tags": bson.M{"$size": 0}}, 1},
+		{bson.M{"data.label": bson.M{"$regex": "^hel", "$options": "i"}}, 1},
+		{bson.M{"$or": bson.A{bson.M{"_id": "a"}, bson.M{"type": "document"}}}, 2},
+		{bson.M{"$expr": bson.M{"$gt": bson.A{"$version", 1}}}, 2},
+	}
+	for i, test := range testFilters {
+		res, err := queryFilter(docs, test.filter, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(res) != test.expect {
+			t.Errorf("filter %d: expected %d matches, got %d", i, test.expect, len(res))
+		}
+	}
+	// sort, offset and limit
+	res, count, err := queryFind(docs, bson.M{}, bson.D{{Key: "version", Value: -1}}, bson.M{"version": 1}, 1, 1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if count != 3 || len(res) != 1 || res[0]["_id"] != "c" || res[0]["type"] != nil {
+		t.Errorf("unexpected find result %v", res)
+	}
+}
+
+func TestMemoryStoreUpsert(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	testUser := User{
+		ID:       GenerateDatabaseId(),
+		Team:     GenerateDatabaseId(),
+		Email:    "test@example.com",
+		Password: []byte("secret"),
+	}
+	if err := databaseStoreOne(nil, &testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	// password is omitted when empty and should be kept on update
+	testUser.Password = nil
+	testUser.Email = "test2@example.com"
+	if err := databaseStoreOne(nil, &testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	count, err := databaseCount(nil, User{}, bson.M{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res, err := databaseFetch(nil, User{}, bson.M{"_id": testUser.ID}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if count != 1 || res.(*User).Email != testUser.Email || string(res.(*User).Password) != "secret" {
+		t.Errorf("unexpected stored user")
+	}
+}
+
+func TestMemoryStoreAggregate(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	// one published and one draft form
+	published := TreeRoot{Type: TreeForm, Parent: testUser.Team, Label: "Published"}
+	draft := TreeRoot{Type: TreeForm, Parent: testUser.Team, Label: "Draft"}
+	for _, root := range []*TreeRoot{&published, &draft} {
+		if err := root.Store(&testUser); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	version, err := FetchTreeVersion(published.ID.String(), 1, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	version.Tree = getTestTree(published.ID.String())
+	if err := version.Publish(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	res, info, err := ListPublishedFormRoot(&testUser, ListPage{Count: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if info.Count != 1 || len(res) != 1 || res[0].ID != published.ID {
+		t.Errorf("expected only the published form to be listed")
+		return
+	}
+	// node history
+	nodes, err := ListNodeVersion(published.ID.String(), 0, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(nodes) != len(version.Tree) {
+		t.Errorf("expected %d nodes, got %d", len(version.Tree), len(nodes))
+	}
+}
+
+func TestMemoryStoreTransaction(t *testing.T) {
+	store := newMemoryStore()
+	outside := RuleTemplate{ID: GenerateDatabaseId(), Label: "Outside"}
+	inside := RuleTemplate{ID: outside.ID, Label: "Inside"}
+	inside.ID[0]++
+	err := store.Transaction(func(tx Store) error {
+		if err := tx.StoreOne(&inside); err != nil {
+			return err
+		}
+		// writes of the transaction aren't seen outside of it before it commits
+		if count, _ := store.Count(RuleTemplate{}, bson.M{}); count != 0 {
+			t.Errorf("expected transaction writes to be isolated")
+		}
+		if count, _ := tx.Count(RuleTemplate{}, bson.M{}); count != 1 {
+			t.Errorf("expected transaction to see its own writes")
+		}
+		// writes made outside of the transaction are kept when it rolls back
+		if err := store.StoreOne(&outside); err != nil {
+			return err
+		}
+		return ErrBatchStepFailed
+	})
+	if err != ErrBatchStepFailed {
+		t.Errorf("expected transaction error, got %v", err)
+	}
+	res, _ := store.ListAll(RuleTemplate{}, bson.M{}, nil, nil)
+	if len(res) != 1 || res[0].(*RuleTemplate).Label != "Outside" {
+		t.Errorf("expected only the write made outside of the transaction")
+	}
+	// committed writes are applied on top of other writes
+	if err := store.Transaction(func(tx Store) error {
+		if err := store.Delete(RuleTemplate{}, bson.M{"_id": outside.ID}); err != nil {
+			return err
+		}
+		return tx.StoreOne(&inside)
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+	res, _ = store.ListAll(RuleTemplate{}, bson.M{}, nil, nil)
+	if len(res) != 1 || res[0].(*RuleTemplate).Label != "Inside" {
+		t.Errorf("expected committed write and outside delete")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// databaseMigrate copies all objects from one store to another.
+func databaseMigrate(from Store, to Store) (int, error) {
+	total := 0
+	for _, dataType := range databaseDataTypes {
+		res, err := from.ListAll(dataType, bson.M{}, bson.D{{Key: "_id", Value: 1}}, nil)
+		if err != nil {
+			return total, err
+		}
+		for _, data := range res {
+			if err := to.StoreOne(data); err != nil {
+				return total, err
+			}
+		}
+		log.Printf("Migrated %d %s.", len(res), getDatabaseCollectionNameFromData(dataType))
+		total += len(res)
+	}
+	return total, nil
+}
+
+// MigrateFromMongo copies the Mongo database at config's database_uri in to the store configured by database_driver.
+func MigrateFromMongo(config *Config) error {
+	if config.DatabaseDriver == "" || config.DatabaseDriver == DatabaseDriverMongo {
+		return ErrDBInvalidDriver
+	}
+	from, err := newMongoStore(config)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	if err := databaseOpen(config); err != nil {
+		return err
+	}
+	defer databaseClose()
+	total, err := databaseMigrate(from, dbStore)
+	if err != nil {
+		return err
+	}
+	log.Printf("Migrated %d objects in total.", total)
+	return nil
+}
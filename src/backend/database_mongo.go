@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoStore is a Store backed by a MongoDB server.
+type mongoStore struct {
+	client *mongo.Client
+	name   string
+	ctx    context.Context
+}
+
+func newMongoStore(config *Config) (Store, error) {
+	opts := options.Client().ApplyURI(config.DatabaseURI)
+	client, err := mongo.Connect(databaseContext(), opts)
+	if err != nil {
+		return nil, err
+	}
+	store := &mongoStore{client: client, name: config.DatabaseName}
+	if err := store.createIndexes(); err != nil {
+		log.Printf("Failed to create database indexes: %s", err)
+	}
+	return store, nil
+}
+
+// createIndexes creates the indexes used by list filters, existing indexes are left as is.
+func (s *mongoStore) createIndexes() error {
+	col, err := s.collection(FormSubmission{})
+	if err != nil {
+		return err
+	}
+	_, err = col.Indexes().CreateMany(databaseContext(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "form_id", Value: 1}, {Key: "created", Value: -1}}},
+		{Keys: bson.D{{Key: "creator", Value: 1}, {Key: "created", Value: -1}}},
+		// answers are keyed by question uid
+		{Keys: bson.D{{Key: "answers.$**", Value: 1}}},
+		{Keys: bson.D{{Key: "resume_token", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	if err != nil {
+		return err
+	}
+	col, err = s.collection(TreeRoot{})
+	if err != nil {
+		return err
+	}
+	_, err = col.Indexes().CreateOne(databaseContext(), mongo.IndexModel{Keys: bson.D{{Key: "share_token", Value: 1}}})
+	if err != nil {
+		return err
+	}
+	col, err = s.collection(SearchEntry{})
+	if err != nil {
+		return err
+	}
+	_, err = col.Indexes().CreateMany(databaseContext(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "team", Value: 1}, {Key: "type", Value: 1}}},
+		{Keys: bson.D{{Key: "object_id", Value: 1}}},
+	})
+	return err
+}
+
+// context returns the session context of the store's transaction or the default context.
+func (s *mongoStore) context() context.Context {
+	if s.ctx != nil {
+		return s.ctx
+	}
+	return databaseContext()
+}
+
+func (s *mongoStore) collection(data interface{}) (*mongo.Collection, error) {
+	if data == nil {
+		return nil, ErrNoData
+	}
+	if s.client == nil || s.name == "" {
+		return nil, ErrNoDBConnection
+	}
+	collectionName := getDatabaseCollectionNameFromData(data)
+	if collectionName == "" {
+		return nil, ErrDBInvalidObjectType
+	}
+	return s.client.Database(s.name).Collection(collectionName), nil
+}
+
+func (s *mongoStore) readResults(dataType interface{}, cur *mongo.Cursor) ([]interface{}, error) {
+	out := make([]interface{}, 0)
+	for cur.Next(s.context()) {
+		data, err := databaseReadResult(dataType, cur.Current)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, data)
+	}
+	return out, nil
+}
+
+func (s *mongoStore) Fetch(dataType interface{}, filter interface{}, sort interface{}) (interface{}, error) {
+	// get collection
+	col, err := s.collection(dataType)
+	if err != nil {
+		return nil, err
+	}
+	// sort
+	opts := options.FindOne()
+	if sort != nil {
+		opts.SetSort(sort)
+	}
+	// fetch
+	res := col.FindOne(s.context(), filter, opts)
+	if err := res.Err(); err != nil {
+		return nil, err
+	}
+	// read
+	data := getEmptyStruct(dataType)
+	if err := res.Decode(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *mongoStore) List(dataType interface{}, filter interface{}, sort interface{}, projection interface{}, offset int) ([]interface{}, int, error) {
+	// get collection
+	col, err := s.collection(dataType)
+	if err != nil {
+		return nil, 0, err
+	}
+	// set fetch options
+	opts := options.Find()
+	opts.SetSort(sort)
+	// offset / limit
+	opts.SetLimit(dbFetchLimit)
+	if offset > 0 {
+		opts.SetSkip(int64(offset))
+	}
+	// projection
+	if projection != nil {
+		opts.SetProjection(projection)
+	}
+	// do count
+	count, err := s.Count(dataType, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	// do fetch
+	cur, err := col.Find(s.context(), filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cur.Close(s.context())
+	// convert back to go structs
+	res, err := s.readResults(dataType, cur)
+	if count == 0 {
+		count = len(res)
+	}
+	return res, count, err
+}
+
+func (s *mongoStore) ListAll(dataType interface{}, filter interface{}, sort interface{}, projection interface{}) ([]interface{}, error) {
+	// get collection
+	col, err := s.collection(dataType)
+	if err != nil {
+		return nil, err
+	}
+	// set fetch options
+	opts := options.Find()
+	opts.SetSort(sort)
+	// projection
+	if projection != nil {
+		opts.SetProjection(projection)
+	}
+	// do fetch
+	cur, err := col.Find(s.context(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(s.context())
+	// convert back to go structs
+	return s.readResults(dataType, cur)
+}
+
+func (s *mongoStore) ListAggregate(dataType interface{}, pipeline mongo.Pipeline, offset int) ([]interface{}, int, error) {
+	// get collection
+	col, err := s.collection(dataType)
+	if err != nil {
+		return nil, 0, err
+	}
+	// build facets, set limit and offset
+	facet := bson.D{
+		bson.E{
+			Key: "$facet",
+			Value: bson.M{
+				"count": bson.A{
+					bson.M{"$count": "total"},
+				},
+				"documents": bson.A{
+					bson.M{"$skip": offset},
+					bson.M{"$limit": dbFetchLimit},
+				},
+			},
+		},
+	}
+	pipeline = append(pipeline, facet)
+	// perform aggregate query
+	cur, err := col.Aggregate(s.context(), pipeline)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cur.Close(s.context())
+	// decode results
+	if !cur.Next(s.context()) {
+		return nil, 0, ErrNoData
+	}
+	res := map[string]interface{}{}
+	if err := cur.Decode(&res); err != nil {
+		return nil, 0, err
+	}
+	totalCount := 0
+	if len(res["count"].(bson.A)) > 0 {
+		totalCount = int(res["count"].(bson.A)[0].(map[string]interface{})["total"].(int32))
+	}
+	out := make([]interface{}, 0)
+	if totalCount > 0 {
+		docs := res["documents"].(bson.A)
+		for _, doc := range docs {
+			data, err := databaseReadResult(dataType, doc)
+			if err != nil {
+				return nil, 0, err
+			}
+			out = append(out, data)
+		}
+	}
+	return out, totalCount, nil
+}
+
+func (s *mongoStore) Aggregate(dataType interface{}, pipeline mongo.Pipeline, results interface{}) error {
+	// get collection
+	col, err := s.collection(dataType)
+	if err != nil {
+		return err
+	}
+	// perform aggregate query
+	cur, err := col.Aggregate(s.context(), pipeline)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(s.context())
+	// decode results
+	return cur.All(s.context(), results)
+}
+
+func (s *mongoStore) Count(dataType interface{}, filter interface{}) (int, error) {
+	// get collection
+	col, err := s.collection(dataType)
+	if err != nil {
+		return 0, err
+	}
+	// do count
+	count, err := col.CountDocuments(s.context(), filter)
+	if err != nil && !errors.Is(err, mongo.ErrNilDocument) {
+		return 0, err
+	}
+	return int(count), nil
+}
+
+func (s *mongoStore) StoreOne(data interface{}) error {
+	// get collection
+	col, err := s.collection(data)
+	if err != nil {
+		return err
+	}
+	// options
+	opts := options.Update()
+	opts.SetUpsert(true)
+	// make doc
+	doc, err := toBSONDoc(data)
+	if err != nil {
+		return err
+	}
+	// update
+	if _, err := col.UpdateOne(s.context(), databaseFilter(data), bson.M{"$set": doc}, opts); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *mongoStore) InsertOne(data interface{}) error {
+	// get collection
+	col, err := s.collection(data)
+	if err != nil {
+		return err
+	}
+	// insert
+	if _, err := col.InsertOne(s.context(), data); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrDBDuplicateKey
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *mongoStore) UpdateOne(dataType interface{}, filter interface{}, set interface{}) (bool, error) {
+	// get collection
+	col, err := s.collection(dataType)
+	if err != nil {
+		return false, err
+	}
+	// update
+	res, err := col.UpdateOne(s.context(), filter, bson.M{"$set": set})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
+func (s *mongoStore) Delete(dataType interface{}, filter interface{}) error {
+	// get collection
+	col, err := s.collection(dataType)
+	if err != nil {
+		return err
+	}
+	// delete
+	if _, err := col.DeleteMany(s.context(), filter); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *mongoStore) Close() error {
+	return s.client.Disconnect(databaseContext())
+}
+
+// Transaction runs fn in a multi-document transaction, this requires the server to be a replica set.
+func (s *mongoStore) Transaction(fn func(tx Store) error) error {
+	if s.ctx != nil {
+		return fn(s)
+	}
+	session, err := s.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(databaseContext())
+	if err := session.StartTransaction(); err != nil {
+		return err
+	}
+	tx := &mongoStore{client: s.client, name: s.name, ctx: mongo.NewSessionContext(databaseContext(), session)}
+	if err := fn(tx); err != nil {
+		if abortErr := session.AbortTransaction(databaseContext()); abortErr != nil {
+			log.Printf("Failed to abort database transaction: %s", abortErr)
+		}
+		return err
+	}
+	return session.CommitTransaction(databaseContext())
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const dbMaxFetchLimit = 100
+
+// ListPage selects a page of a list. Pages after the first are selected either by the cursor returned with the
+// previous page or by offset. Sort is a whitelisted field name, prefixed with "-" for descending order.
+type ListPage struct {
+	Cursor string
+	Offset int
+	Limit  int
+	Sort   string
+	Count  bool
+}
+
+// ListPageInfo describes a listed page. Count is only set when counting was requested and
+// NextCursor is empty on the last page.
+type ListPageInfo struct {
+	Count      int
+	NextCursor string
+}
+
+// ListSortFields maps the sort names a list accepts to database fields, Default is used when a page has no sort.
+// Ties are broken by the Unique field, _id when empty.
+type ListSortFields struct {
+	Default string
+	Unique  string
+	Fields  map[string]string
+}
+
+// listCursor is the position after the last item of a page.
+type listCursor struct {
+	Sort  string      `bson:"s"`
+	Value interface{} `bson:"v"`
+	ID    interface{} `bson:"i"`
+}
+
+// limit returns the page size clamped to the server max.
+func (p ListPage) limit() int {
+	if p.Limit <= 0 {
+		return dbFetchLimit
+	}
+	if p.Limit > dbMaxFetchLimit {
+		return dbMaxFetchLimit
+	}
+	return p.Limit
+}
+
+// resolve returns the normalized sort, its database field and direction.
+func (s ListSortFields) resolve(sort string) (string, string, int, error) {
+	if sort == "" {
+		sort = s.Default
+	}
+	name, dir := strings.TrimPrefix(sort, "-"), 1
+	if strings.HasPrefix(sort, "-") {
+		dir = -1
+	}
+	field, exists := s.Fields[name]
+	if !exists {
+		return "", "", 0, ErrInvalidSort
+	}
+	return sort, field, dir, nil
+}
+
+func encodeListCursor(c listCursor) (string, error) {
+	raw, err := bson.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeListCursor(value string) (*listCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := &listCursor{}
+	if err := bson.Unmarshal(raw, c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// databasePage lists a page of the results of pipeline. Results are sorted by the page's sort field with a unique
+// field as tie breaker so that cursors are stable, projection is applied to the listed results only.
+func databasePage(tx Store, dataType interface{}, pipeline mongo.Pipeline, projection interface{}, sortFields ListSortFields, page ListPage) ([]interface{}, ListPageInfo, error) {
+	info := ListPageInfo{}
+	// missing params
+	if dataType == nil || pipeline == nil {
+		return nil, info, ErrNoData
+	}
+	sort, field, dir, err := sortFields.resolve(page.Sort)
+	if err != nil {
+		return nil, info, err
+	}
+	unique := sortFields.Unique
+	if unique == "" {
+		unique = "_id"
+	}
+	limit := page.limit()
+	// count
+	if page.Count {
+		countRes := make([]bson.M, 0)
+		if err := databaseAggregate(tx, dataType, append(append(mongo.Pipeline{}, pipeline...), bson.D{{Key: "$count", Value: "count"}}), &countRes); err != nil {
+			return nil, info, err
+		}
+		if len(countRes) > 0 {
+			count, _ := queryNumber(countRes[0]["count"])
+			info.Count = int(count)
+		}
+	}
+	// position
+	stages := append(mongo.Pipeline{}, pipeline...)
+	if page.Cursor != "" {
+		cursor, err := decodeListCursor(page.Cursor)
+		if err != nil {
+			return nil, info, err
+		}
+		if cursor.Sort != sort {
+			return nil, info, ErrInvalidCursor
+		}
+		// plain comparisons so that the sort index can be used, comparisons are bracketed by type so null
+		// and missing values, which sort before all others, are matched separately
+		op := "$gt"
+		if dir < 0 {
+			op = "$lt"
+		}
+		after := bson.A{
+			bson.M{field: cursor.Value, unique: bson.M{op: cursor.ID}},
+		}
+		if cursor.Value == nil {
+			if dir > 0 {
+				after = append(after, bson.M{field: bson.M{"$ne": nil}})
+			}
+		} else {
+			after = append(after, bson.M{field: bson.M{op: cursor.Value}})
+			if dir < 0 {
+				after = append(after, bson.M{field: nil})
+			}
+		}
+		stages = append(stages, bson.D{{Key: "$match", Value: bson.M{"$or": after}}})
+	}
+	stages = append(stages, bson.D{{Key: "$sort", Value: bson.D{{Key: field, Value: dir}, {Key: unique, Value: dir}}}})
+	if page.Cursor == "" && page.Offset > 0 {
+		stages = append(stages, bson.D{{Key: "$skip", Value: page.Offset}})
+	}
+	// fetch one extra result to know if there is a next page
+	stages = append(stages, bson.D{{Key: "$limit", Value: limit + 1}})
+	if projection != nil {
+		stages = append(stages, bson.D{{Key: "$project", Value: projection}})
+	}
+	res := make([]bson.M, 0)
+	if err := databaseAggregate(tx, dataType, stages, &res); err != nil {
+		return nil, info, err
+	}
+	if len(res) > limit {
+		res = res[:limit]
+		last := res[limit-1]
+		if info.NextCursor, err = encodeListCursor(listCursor{Sort: sort, Value: last[field], ID: last[unique]}); err != nil {
+			return nil, info, err
+		}
+	}
+	// format output
+	out := make([]interface{}, 0, len(res))
+	for _, item := range res {
+		data, err := databaseReadResult(dataType, item)
+		if err != nil {
+			return nil, info, err
+		}
+		out = append(out, data)
+	}
+	return out, info, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDatabasePage(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	// templates share creation times in pairs so ties must be broken by id
+	created := time.Now().Truncate(time.Millisecond)
+	for i := 0; i < 30; i++ {
+		id := GenerateDatabaseId()
+		id[0] = byte(i)
+		template := RuleTemplate{
+			ID:      id,
+			Team:    testUser.Team,
+			Created: created.Add(time.Duration(i/2) * time.Second),
+			Label:   fmt.Sprintf("Template %02d", 29-i),
+		}
+		// a third are never modified
+		if i%3 != 0 {
+			template.Modified = created.Add(time.Duration(i/4) * time.Second)
+		}
+		if err := databaseStoreOne(nil, &template); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	// default limit + count
+	res, info, err := ListRuleTemplate(&testUser, ListPage{Count: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(res) != dbFetchLimit || info.Count != 30 || info.NextCursor == "" {
+		t.Errorf("unexpected first page, %d results, count %d", len(res), info.Count)
+		return
+	}
+	firstCursor := info.NextCursor
+	// walk pages with cursor
+	seen := make(map[DatabaseID]bool)
+	page := ListPage{Limit: 7}
+	var last *RuleTemplate
+	for pages := 0; ; pages++ {
+		res, info, err = ListRuleTemplate(&testUser, page)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if info.Count != 0 {
+			t.Errorf("expected no count")
+		}
+		for _, template := range res {
+			if seen[template.ID] {
+				t.Errorf("template %s listed twice", template.ID.String())
+			}
+			seen[template.ID] = true
+			if last != nil && template.Created.After(last.Created) {
+				t.Errorf("expected templates to be sorted by newest first")
+			}
+			last = template
+		}
+		if info.NextCursor == "" {
+			if pages != 4 {
+				t.Errorf("expected five pages, got %d", pages+1)
+			}
+			break
+		}
+		page.Cursor = info.NextCursor
+	}
+	if len(seen) != 30 {
+		t.Errorf("expected all templates to be listed once, got %d", len(seen))
+	}
+	// cursors step over missing values in both directions
+	for _, sort := range []string{"modified", "-modified"} {
+		seen = make(map[DatabaseID]bool)
+		page = ListPage{Sort: sort, Limit: 4}
+		for {
+			res, info, err = ListRuleTemplate(&testUser, page)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for _, template := range res {
+				seen[template.ID] = true
+			}
+			if info.NextCursor == "" {
+				break
+			}
+			page.Cursor = info.NextCursor
+		}
+		if len(seen) != 30 {
+			t.Errorf("expected all templates to be listed by %s, got %d", sort, len(seen))
+		}
+	}
+	// sort by label + offset
+	res, _, err = ListRuleTemplate(&testUser, ListPage{Sort: "label", Offset: 2, Limit: 2})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(res) != 2 || res[0].Label != "Template 02" || res[1].Label != "Template 03" {
+		t.Errorf("unexpected label sort")
+	}
+	// server max
+	if (ListPage{Limit: 1000}).limit() != dbMaxFetchLimit {
+		t.Errorf("expected limit to be clamped")
+	}
+	// invalid sort + cursor
+	if _, _, err := ListRuleTemplate(&testUser, ListPage{Sort: "script"}); !errors.Is(err, ErrInvalidSort) {
+		t.Errorf("expected invalid sort error, got %v", err)
+	}
+	if _, _, err := ListRuleTemplate(&testUser, ListPage{Sort: "label", Cursor: firstCursor}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected cursor of another sort to be rejected, got %v", err)
+	}
+	if _, _, err := ListRuleTemplate(&testUser, ListPage{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected invalid cursor error, got %v", err)
+	}
+}
@@ -1,209 +1,436 @@
 package main
 
 import (
+	"errors"
+	"reflect"
+
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func checkFetchPermission(i interface{}, user *User) error {
-	switch i := i.(type) {
-	case *TreeRoot:
-		{
-			team := i.Parent
-			if i.Type == TreeDocument {
-				treeForm, err := databaseFetch(TreeRoot{}, bson.M{"_id": i.Parent}, nil)
-				if err != nil {
-					return err
-				}
-				team = treeForm.(*TreeRoot).Parent
-			}
-			if user == nil || user.Team.String() != team.String() {
-				return ErrInvalidPermission
-			}
-		}
-	case *TreeVersion:
-		{
-			treeRoot, err := databaseFetch(TreeRoot{}, bson.M{"_id": i.RootID}, nil)
-			if err != nil {
-				return err
-			}
-			if treeRoot.(*TreeRoot).Type == TreeDocument {
-				treeRoot, err = databaseFetch(TreeRoot{}, bson.M{"_id": treeRoot.(*TreeRoot).Parent}, nil)
-				if err != nil {
-					return err
-				}
-			}
-			if user == nil || user.Team.String() != treeRoot.(*TreeRoot).Parent.String() {
-				return ErrInvalidPermission
-			}
-			break
-		}
-	case *User:
-		{
-			if user == nil || user.Team.String() != i.Team.String() {
-				return ErrInvalidPermission
-			}
-			break
-		}
-	case *Team:
-		{
-			if user == nil || user.Team.String() != i.ID.String() {
-				return ErrInvalidPermission
-			}
-			break
-		}
-	case *RuleTemplate:
-		{
-			if user == nil || user.Team.String() != i.Team.String() {
-				return ErrInvalidPermission
-			}
-		}
-	case *FormSubmission:
-		{
-			if user == nil {
-				return ErrInvalidPermission
-			}
-			if user.ID.String() == i.Creator.String() {
-				return nil
-			}
-			treeRoot, err := databaseFetch(TreeRoot{}, bson.M{"_id": i.FormID}, nil)
-			if err != nil {
-				return err
-			}
-			if user.Team.String() != treeRoot.(*TreeRoot).Parent.String() {
-				return ErrInvalidPermission
-			}
+// Permission check actions.
+const (
+	permActionFetch   = "fetch"
+	permActionStore   = "store"
+	permActionDelete  = "delete"
+	permActionPublish = "publish"
+)
+
+// Kinds of objects permissions are checked for.
+const (
+	permKindForm         = "form"
+	permKindDocument     = "document"
+	permKindSubmission   = "submission"
+	permKindSubmit       = "submit" // new submissions
+	permKindRuleTemplate = "rule_template"
+	permKindUser         = "user"
+	permKindRole         = "role"
+	permKindTeam         = "team"
+	permKindWebhook      = "webhook"
+)
+
+// permRead lists the permissions that grant read access to each kind of object, kinds without any are
+// readable by every member of the team. PermAdmin grants everything.
+var permRead = map[string]UserPermission{
+	permKindForm:         {PermRead, PermManageForm, PermManageDocument, PermManageSubmission, PermApproveForm, PermSubmit},
+	permKindDocument:     {PermRead, PermManageDocument},
+	permKindSubmission:   {PermRead, PermManageSubmission},
+	permKindRuleTemplate: {PermRead, PermManageRuleTemplate, PermManageForm, PermManageDocument, PermSubmit},
+	permKindUser:         {PermRead, PermManageUser},
+	permKindRole:         {PermRead, PermManageUser},
+	permKindWebhook:      {PermManageWebhook},
+}
+
+// permWrite lists the permissions that grant write access to each kind of object.
+var permWrite = map[string]UserPermission{
+	permKindForm:         {PermManageForm},
+	permKindDocument:     {PermManageDocument},
+	permKindSubmission:   {PermManageSubmission},
+	permKindSubmit:       {PermSubmit, PermManageSubmission},
+	permKindRuleTemplate: {PermManageRuleTemplate},
+	permKindUser:         {PermManageUser},
+	permKindRole:         {PermAdmin},
+	permKindTeam:         {PermAdmin},
+	permKindWebhook:      {PermManageWebhook},
+}
+
+// permissionTarget is what a permission check needs to know about an object.
+type permissionTarget struct {
+	kind    string
+	team    DatabaseID // team that owns the object
+	creator DatabaseID // user that can always read and edit the object
+	owner   bool       // only the creator has access
+	new     bool
+	// access control list of the form the object belongs to, the form's creator bypasses it
+	acl      []TreeACLEntry
+	aclOwner DatabaseID
+}
+
+// userCan checks if user can read or write objects of the given kind in their team.
+func userCan(user *User, kind string, write bool) bool {
+	if user == nil {
+		return false
+	}
+	perms := permRead[kind]
+	if write {
+		perms = permWrite[kind]
+	} else if len(perms) == 0 {
+		return true
+	}
+	if user.HasPermission(PermAdmin) {
+		return true
+	}
+	for _, perm := range perms {
+		if user.HasPermission(perm) {
+			return true
 		}
 	}
-	return nil
+	return false
 }
 
-func checkStorePermission(i interface{}, user *User) error {
-	// gather parameters
-	new := false
-	team := DatabaseID{}
-	creator := DatabaseID{}
-	perm := PermAdmin
+// getPermissionTarget gathers the parameters of a permission check for an object, tx is the store to look
+// up related objects in.
+func getPermissionTarget(i interface{}, tx Store) (permissionTarget, error) {
+	out := permissionTarget{kind: permKindTeam}
 	switch i := i.(type) {
 	case *TreeRoot:
 		{
-			new = i.ID.IsEmpty()
-			creator = i.Creator
+			out.new = i.ID.IsEmpty()
+			out.creator = i.Creator
 			switch i.Type {
 			case TreeForm:
 				{
-					team = i.Parent
-					perm = PermManageForm
+					out.kind = permKindForm
+					out.team = i.Parent
+					// new forms are checked against team permissions
+					if !out.new {
+						out.acl, out.aclOwner = i.ACL, i.Creator
+					}
 					break
 				}
 			case TreeDocument:
 				{
-					treeForm, err := databaseFetch(TreeRoot{}, bson.M{"_id": i.Parent}, nil)
+					treeForm, err := databaseFetch(tx, TreeRoot{}, bson.M{"_id": i.Parent}, nil)
 					if err != nil {
-						return err
+						return out, err
 					}
-					team = treeForm.(*TreeRoot).Parent
-					perm = PermManageDocument
+					out.kind = permKindDocument
+					out.team = treeForm.(*TreeRoot).Parent
+					out.acl, out.aclOwner = treeForm.(*TreeRoot).ACL, treeForm.(*TreeRoot).Creator
 					break
 				}
 			default:
 				{
 					if i.Type == "" {
-						return ErrObjMissingParam
+						return out, ErrObjMissingParam
 					}
-					return ErrObjInvalidParam
+					return out, ErrObjInvalidParam
 				}
 			}
 			break
 		}
 	case *TreeVersion:
 		{
-			new = i.Version <= 0
-			creator = i.Creator
-			perm = PermManageForm
-			treeRoot, err := databaseFetch(TreeRoot{}, bson.M{"_id": i.RootID}, nil)
+			out.new = i.Version <= 0
+			out.creator = i.Creator
+			out.kind = permKindForm
+			treeRoot, err := databaseFetch(tx, TreeRoot{}, bson.M{"_id": i.RootID}, nil)
 			if err != nil {
-				return err
+				return out, err
 			}
 			if treeRoot.(*TreeRoot).Type == TreeDocument {
-				treeRoot, err = databaseFetch(TreeRoot{}, bson.M{"_id": treeRoot.(*TreeRoot).Parent}, nil)
+				treeRoot, err = databaseFetch(tx, TreeRoot{}, bson.M{"_id": treeRoot.(*TreeRoot).Parent}, nil)
 				if err != nil {
-					return err
+					return out, err
 				}
-				perm = PermManageDocument
+				out.kind = permKindDocument
 			}
-			team = treeRoot.(*TreeRoot).Parent
+			out.team = treeRoot.(*TreeRoot).Parent
+			out.acl, out.aclOwner = treeRoot.(*TreeRoot).ACL, treeRoot.(*TreeRoot).Creator
 			break
 		}
 	case *FormSubmission:
 		{
-			new = i.ID.IsEmpty()
-			if new {
-				return nil
+			out.new = i.ID.IsEmpty()
+			out.creator = i.Creator
+			out.kind = permKindSubmission
+			if out.new {
+				out.kind = permKindSubmit
 			}
-			creator = i.Creator
-			perm = PermManageSubmission
-			treeRoot, err := databaseFetch(TreeRoot{}, bson.M{"_id": i.FormID}, nil)
+			treeRoot, err := databaseFetch(tx, TreeRoot{}, bson.M{"_id": i.FormID}, nil)
 			if err != nil {
-				return err
+				return out, err
 			}
-			team = treeRoot.(*TreeRoot).Parent
+			out.team = treeRoot.(*TreeRoot).Parent
+			out.acl, out.aclOwner = treeRoot.(*TreeRoot).ACL, treeRoot.(*TreeRoot).Creator
 			break
 		}
 	case *User:
 		{
-			new = i.ID.IsEmpty()
-			team = i.Team
-			perm = PermManageUser
-			// same user can edit their profile
-			if !new && user != nil && i.ID == user.ID {
-				return nil
-			}
-			// allow self creation
-			if new && user == nil && !team.IsEmpty() {
-				// TeamOptionAllowSignUp
-				/*team, err := FetchTeamByID(team.String(), nil)
+			out.new = i.ID.IsEmpty()
+			out.kind = permKindUser
+			out.team = i.Team
+			// users can view and edit their profile
+			if !out.new {
+				out.creator = i.ID
+				// team of existing users comes from the stored user, not the one being edited
+				stored, err := databaseFetch(tx, User{}, bson.M{"_id": i.ID}, nil)
 				if err != nil {
-					return err
-				}*/
-
-				i.Permission = UserPermission{}
-				return nil
-				//}
+					return out, err
+				}
+				out.team = stored.(*User).Team
 			}
 			break
 		}
 	case *Team:
 		{
-			team = i.ID
-			creator = i.Creator
+			out.team = i.ID
+			out.creator = i.Creator
 			break
 		}
 	case *RuleTemplate:
 		{
-			team = i.Team
-			creator = i.Creator
-			perm = PermManageRuleTemplate
+			out.kind = permKindRuleTemplate
+			out.team = i.Team
+			out.creator = i.Creator
+			break
+		}
+	case *Role:
+		{
+			out.new = i.ID.IsEmpty()
+			out.kind = permKindRole
+			out.team = i.Team
+			break
+		}
+	case *Webhook:
+		{
+			out.kind = permKindWebhook
+			out.team = i.Team
+			out.creator = i.Creator
+			break
+		}
+	case *APIToken:
+		{
+			// users can only manage their own tokens
+			out.new = i.ID.IsEmpty()
+			out.owner = true
+			out.creator = i.User
+			break
 		}
 	}
+	return out, nil
+}
+
+// checkPermission checks if user can perform action on an object. All permission checks go through here.
+func checkPermission(action string, i interface{}, user *User) error {
+	// allow self sign up
+	if u, ok := i.(*User); ok && action == permActionStore && user == nil && u.ID.IsEmpty() {
+		return checkSignUpPermission(u)
+	}
 	// user should be provided
 	if user == nil {
+		if action == permActionFetch {
+			return ErrInvalidPermission
+		}
 		return ErrNoUser
 	}
+	target, err := getPermissionTarget(i, user.getStore())
+	if err != nil {
+		return err
+	}
+	if target.owner {
+		if !target.new && user.ID != target.creator {
+			return ErrInvalidPermission
+		}
+		return nil
+	}
 	// not on same team
-	if !team.IsEmpty() && user.Team.String() != team.String() {
+	if !target.team.IsEmpty() && user.Team != target.team {
 		return ErrInvalidPermission
 	}
-	// if editting existing and user is original creator then ok
-	if !new && !creator.IsEmpty() && user.ID.String() == creator.String() {
-		return nil
+	// api tokens can't do more than their permissions allow, not even on objects their user created
+	if user.tokenScoped && !userCan(user, target.kind, action != permActionFetch) {
+		return ErrInvalidPermission
+	}
+	// user that created an object can view and edit it
+	if !target.new && !target.creator.IsEmpty() && user.ID == target.creator {
+		return checkGrantPermission(i, user)
+	}
+	// forms with an access control list only grant what the list grants
+	if len(target.acl) > 0 && !user.HasPermission(PermAdmin) {
+		if user.ID != target.aclOwner && !aclGrants(target.acl, aclRight(action, target.kind), user) {
+			return ErrInvalidPermission
+		}
+	} else if !userCan(user, target.kind, action != permActionFetch) {
+		// check if user has required permission
+		return ErrInvalidPermission
+	}
+	if action == permActionStore {
+		return checkGrantPermission(i, user)
+	}
+	return nil
+}
+
+// checkSignUpPermission checks that a user signing up is allowed to by their team and gives them the team's
+// default permissions. Signed up users must verify their email.
+func checkSignUpPermission(u *User) error {
+	if u.Team.IsEmpty() || u.Pending != UserPendingVerify || u.PendingTokenHash == "" {
+		return ErrNoUser
+	}
+	res, err := databaseFetch(u.getStore(), Team{}, bson.M{"_id": u.Team}, nil)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrSignUpDisabled
+		}
+		return err
+	}
+	team := res.(*Team)
+	if err := team.CanSignUp(u.Email); err != nil {
+		return err
+	}
+	u.Permission, u.Roles = team.signUpPermission()
+	return nil
+}
+
+// checkGrantPermission checks that the permissions a user or role grants are held by the editor, so that
+// users can't give out more than they have.
+func checkGrantPermission(i interface{}, editor *User) error {
+	perms := UserPermission{}
+	switch i := i.(type) {
+	case *User:
+		{
+			if err := i.Permission.Validate(); err != nil {
+				return err
+			}
+			roles, err := listTeamRoles(editor.getStore(), editor.Team, i.Roles)
+			if err != nil {
+				return err
+			}
+			if len(roles) != len(i.Roles) {
+				return ErrInvalidRole
+			}
+			perms = perms.Merge(i.Permission)
+			for _, role := range roles {
+				perms = perms.Merge(role.Permission)
+			}
+			break
+		}
+	case *Role:
+		{
+			perms = i.Permission
+			break
+		}
+	case *TreeRoot:
+		{
+			// only admins and the creator of a form can change its access control list
+			if i.ID.IsEmpty() || editor.HasPermission(PermAdmin) {
+				return nil
+			}
+			stored, err := databaseFetch(editor.getStore(), TreeRoot{}, bson.M{"_id": i.ID}, nil)
+			if err != nil {
+				return err
+			}
+			if stored.(*TreeRoot).Creator != editor.ID && !reflect.DeepEqual(stored.(*TreeRoot).ACL, i.ACL) {
+				return ErrInvalidPermission
+			}
+			return nil
+		}
+	}
+	for _, perm := range perms {
+		if !editor.HasPermission(perm) {
+			return ErrInvalidPermission
+		}
+	}
+	return nil
+}
+
+// aclRight returns the access control list right needed for an action on a kind of object.
+func aclRight(action string, kind string) string {
+	switch {
+	case action == permActionPublish:
+		return TreeACLPublish
+	case kind == permKindSubmit:
+		return TreeACLSubmit
+	case kind == permKindSubmission:
+		return TreeACLManageSubmission
+	case action == permActionFetch:
+		return TreeACLView
+	}
+	return TreeACLEdit
+}
+
+// aclGrants checks if an access control list grants a right to user, directly or through one of
+// their roles. Every right includes view.
+func aclGrants(acl []TreeACLEntry, right string, user *User) bool {
+	for _, entry := range acl {
+		if entry.User != user.ID && (entry.Role.IsEmpty() || !user.HasRole(entry.Role)) {
+			continue
+		}
+		if right == TreeACLView {
+			return true
+		}
+		for _, r := range entry.Rights {
+			if r == right {
+				return true
+			}
+		}
 	}
-	// check if user has required permission
-	if !user.HasPermission(perm) {
+	return false
+}
+
+// aclListFilter returns a filter matching the forms user has a right on, the forms they created and, if
+// their permissions allow them to read kind, the forms without an access control list.
+func aclListFilter(user *User, right string, kind string) bson.M {
+	if user.HasPermission(PermAdmin) {
+		return bson.M{}
+	}
+	// creators of forms with a list have every right, without one they can only view their form
+	created := bson.M{"creator": user.ID}
+	if right != TreeACLView {
+		created["acl.0"] = bson.M{"$exists": true}
+	}
+	granted := bson.A{created}
+	teamAccess := userCan(user, kind, false)
+	if !user.tokenScoped || teamAccess {
+		roles := bson.A{}
+		for _, role := range user.Roles {
+			roles = append(roles, role)
+		}
+		entry := bson.M{"$or": bson.A{bson.M{"user": user.ID}, bson.M{"role": bson.M{"$in": roles}}}}
+		if right != TreeACLView {
+			entry["rights"] = right
+		}
+		granted = append(granted, bson.M{"acl": bson.M{"$elemMatch": entry}})
+	}
+	if teamAccess {
+		granted = append(granted, bson.M{"acl.0": bson.M{"$exists": false}})
+	}
+	return bson.M{"$or": granted}
+}
+
+// checkListPermission checks if user can list objects of the given kind in their team.
+func checkListPermission(kind string, user *User) error {
+	if user == nil {
+		return ErrNoUser
+	}
+	if !userCan(user, kind, false) {
 		return ErrInvalidPermission
 	}
 	return nil
 }
 
+func checkFetchPermission(i interface{}, user *User) error {
+	return checkPermission(permActionFetch, i, user)
+}
+
+func checkStorePermission(i interface{}, user *User) error {
+	return checkPermission(permActionStore, i, user)
+}
+
 func checkDeletePermission(i interface{}, user *User) error {
-	return checkStorePermission(i, user)
+	return checkPermission(permActionDelete, i, user)
+}
+
+func checkPublishPermission(i interface{}, user *User) error {
+	return checkPermission(permActionPublish, i, user)
 }
@@ -0,0 +1,1466 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// In process evaluation of the subset of MongoDB queries and aggregation pipelines used by the backend.
+// Storage backends without a query engine of their own (memory, embedded) load documents as bson.M
+// and use these functions to filter, sort, project and aggregate them.
+
+var ErrQueryUnsupported = errors.New("unsupported query operator")
+
+// queryNormalize converts a filter, sort, projection or pipeline in to bson primitives.
+func queryNormalize(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw, err := bson.Marshal(bson.D{{Key: "v", Value: v}})
+	if err != nil {
+		return nil, err
+	}
+	out := bson.D{}
+	if err := bson.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	return out[0].Value, nil
+}
+
+// queryToDoc converts given object in to a document.
+func queryToDoc(data interface{}) (bson.M, error) {
+	raw, err := bson.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	out := bson.M{}
+	if err := bson.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// queryDecodeAll decodes given documents in to results, results should be a pointer to a slice.
+func queryDecodeAll(docs []bson.M, results interface{}) error {
+	val := reflect.ValueOf(results)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Slice {
+		return ErrNoData
+	}
+	sliceVal := val.Elem()
+	elemType := sliceVal.Type().Elem()
+	sliceVal.Set(reflect.MakeSlice(sliceVal.Type(), 0, len(docs)))
+	for _, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		elem := reflect.New(elemType)
+		if err := bson.Unmarshal(raw, elem.Interface()); err != nil {
+			return err
+		}
+		sliceVal.Set(reflect.Append(sliceVal, elem.Elem()))
+	}
+	return nil
+}
+
+// queryFind returns all documents matching filter, sorted, with offset and limit applied.
+func queryFind(docs []bson.M, filter interface{}, sortSpec interface{}, projection interface{}, offset int, limit int) ([]bson.M, int, error) {
+	res, err := queryFilter(docs, filter, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := querySort(res, sortSpec); err != nil {
+		return nil, 0, err
+	}
+	count := len(res)
+	if offset > 0 {
+		if offset > len(res) {
+			offset = len(res)
+		}
+		res = res[offset:]
+	}
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	if projection != nil {
+		spec, err := queryNormalize(projection)
+		if err != nil {
+			return nil, 0, err
+		}
+		for i := range res {
+			if res[i], err = queryProject(res[i], spec, nil); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	return res, count, nil
+}
+
+// queryUpsert sets the fields of doc on the first document matching filter, or appends doc if there is
+// no match, like an update with $set and upsert. A new slice is returned.
+func queryUpsert(docs []bson.M, filter interface{}, doc bson.M) ([]bson.M, error) {
+	spec, err := queryNormalize(filter)
+	if err != nil {
+		return nil, err
+	}
+	out := append(make([]bson.M, 0, len(docs)+1), docs...)
+	for i := range out {
+		match, err := queryMatch(out[i], spec, nil)
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			out[i] = queryCopy(out[i])
+			for k, v := range doc {
+				out[i][k] = v
+			}
+			return out, nil
+		}
+	}
+	newDoc := bson.M{}
+	pairs, _ := queryPairs(spec)
+	for _, p := range pairs {
+		if !strings.HasPrefix(p.Key, "$") && !queryIsOperatorDoc(p.Value) {
+			querySetPath(newDoc, p.Key, p.Value)
+		}
+	}
+	for k, v := range doc {
+		newDoc[k] = v
+	}
+	return append(out, newDoc), nil
+}
+
+// queryFilter returns the documents matching given filter.
+func queryFilter(docs []bson.M, filter interface{}, vars bson.M) ([]bson.M, error) {
+	spec, err := queryNormalize(filter)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]bson.M, 0)
+	for _, doc := range docs {
+		match, err := queryMatch(doc, spec, vars)
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			out = append(out, doc)
+		}
+	}
+	return out, nil
+}
+
+// queryMatch returns true if given document matches given (normalized) filter.
+func queryMatch(doc bson.M, filter interface{}, vars bson.M) (bool, error) {
+	pairs, ok := queryPairs(filter)
+	if !ok {
+		if filter == nil {
+			return true, nil
+		}
+		return false, ErrNoData
+	}
+	for _, p := range pairs {
+		switch p.Key {
+		case "$and", "$or", "$nor":
+			{
+				subs, _ := querySlice(p.Value)
+				matchCount := 0
+				for _, sub := range subs {
+					match, err := queryMatch(doc, sub, vars)
+					if err != nil {
+						return false, err
+					}
+					if match {
+						matchCount++
+					}
+				}
+				if (p.Key == "$and" && matchCount != len(subs)) ||
+					(p.Key == "$or" && matchCount == 0) ||
+					(p.Key == "$nor" && matchCount > 0) {
+					return false, nil
+				}
+				break
+			}
+		case "$expr":
+			{
+				res, err := queryEval(doc, p.Value, vars)
+				if err != nil {
+					return false, err
+				}
+				if !queryTruthy(res) {
+					return false, nil
+				}
+				break
+			}
+		default:
+			{
+				match, err := queryMatchField(doc, p.Key, p.Value, vars)
+				if err != nil {
+					return false, err
+				}
+				if !match {
+					return false, nil
+				}
+			}
+		}
+	}
+	return true, nil
+}
+
+func queryIsOperatorDoc(v interface{}) bool {
+	pairs, ok := queryPairs(v)
+	return ok && len(pairs) > 0 && strings.HasPrefix(pairs[0].Key, "$")
+}
+
+func queryMatchField(doc bson.M, path string, cond interface{}, vars bson.M) (bool, error) {
+	leaves, exists := queryLookup(doc, path)
+	if !queryIsOperatorDoc(cond) {
+		return queryLeavesEqual(leaves, exists, cond), nil
+	}
+	pairs, _ := queryPairs(cond)
+	for _, p := range pairs {
+		match := false
+		switch p.Key {
+		case "$eq":
+			{
+				match = queryLeavesEqual(leaves, exists, p.Value)
+				break
+			}
+		case "$ne":
+			{
+				match = !queryLeavesEqual(leaves, exists, p.Value)
+				break
+			}
+		case "$gt", "$gte", "$lt", "$lte":
+			{
+				for _, v := range queryExpand(leaves) {
+					if queryTypeRank(v) != queryTypeRank(p.Value) {
+						continue
+					}
+					c := queryCompare(v, p.Value)
+					if (p.Key == "$gt" && c > 0) || (p.Key == "$gte" && c >= 0) ||
+						(p.Key == "$lt" && c < 0) || (p.Key == "$lte" && c <= 0) {
+						match = true
+						break
+					}
+				}
+				break
+			}
+		case "$in", "$nin":
+			{
+				values, _ := querySlice(p.Value)
+				for _, v := range values {
+					if queryLeavesEqual(leaves, exists, v) {
+						match = true
+						break
+					}
+				}
+				if p.Key == "$nin" {
+					match = !match
+				}
+				break
+			}
+		case "$exists":
+			{
+				match = exists == queryTruthy(p.Value)
+				break
+			}
+		case "$size":
+			{
+				size, _ := queryNumber(p.Value)
+				for _, v := range leaves {
+					if values, ok := querySlice(v); ok && float64(len(values)) == size {
+						match = true
+						break
+					}
+				}
+				break
+			}
+		case "$regex":
+			{
+				pattern, options := "", ""
+				switch r := p.Value.(type) {
+				case primitive.Regex:
+					{
+						pattern, options = r.Pattern, r.Options
+						break
+					}
+				case string:
+					{
+						pattern = r
+						for _, op := range pairs {
+							if op.Key == "$options" {
+								options, _ = op.Value.(string)
+							}
+						}
+						break
+					}
+				}
+				if strings.Contains(options, "i") {
+					pattern = "(?i)" + pattern
+				}
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					return false, err
+				}
+				for _, v := range queryExpand(leaves) {
+					if s, ok := v.(string); ok && re.MatchString(s) {
+						match = true
+						break
+					}
+				}
+				break
+			}
+		case "$options":
+			{
+				match = true
+				break
+			}
+		case "$elemMatch":
+			{
+				for _, v := range leaves {
+					values, _ := querySlice(v)
+					for _, elem := range values {
+						if elemDoc, ok := queryDoc(elem); ok {
+							if match, _ = queryMatch(elemDoc, p.Value, vars); match {
+								break
+							}
+						} else if queryIsOperatorDoc(p.Value) {
+							if match, _ = queryMatchField(bson.M{"v": elem}, "v", p.Value, vars); match {
+								break
+							}
+						}
+					}
+					if match {
+						break
+					}
+				}
+				break
+			}
+		case "$not":
+			{
+				res, err := queryMatchField(doc, path, p.Value, vars)
+				if err != nil {
+					return false, err
+				}
+				match = !res
+				break
+			}
+		default:
+			{
+				return false, fmt.Errorf("%w: %s", ErrQueryUnsupported, p.Key)
+			}
+		}
+		if !match {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// queryLeavesEqual checks if any of the values at a path is equal to given value,
+// arrays match if they are equal to the value or contain it.
+func queryLeavesEqual(leaves []interface{}, exists bool, v interface{}) bool {
+	if v == nil && !exists {
+		return true
+	}
+	for _, leaf := range leaves {
+		if queryEqual(leaf, v) {
+			return true
+		}
+		if values, ok := querySlice(leaf); ok {
+			for _, elem := range values {
+				if queryEqual(elem, v) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// queryLookup returns the values at given dotted path, traversing arrays of documents.
+func queryLookup(v interface{}, path string) ([]interface{}, bool) {
+	if path == "" {
+		return []interface{}{v}, true
+	}
+	return queryLookupParts(v, strings.Split(path, "."))
+}
+
+func queryLookupParts(v interface{}, parts []string) ([]interface{}, bool) {
+	if len(parts) == 0 {
+		return []interface{}{v}, true
+	}
+	if doc, ok := queryDoc(v); ok {
+		next, exists := doc[parts[0]]
+		if !exists {
+			return nil, false
+		}
+		return queryLookupParts(next, parts[1:])
+	}
+	if values, ok := querySlice(v); ok {
+		if index, err := strconv.Atoi(parts[0]); err == nil {
+			if index < 0 || index >= len(values) {
+				return nil, false
+			}
+			return queryLookupParts(values[index], parts[1:])
+		}
+		out := make([]interface{}, 0)
+		exists := false
+		for _, elem := range values {
+			res, ok := queryLookupParts(elem, parts)
+			if ok {
+				exists = true
+				out = append(out, res...)
+			}
+		}
+		return out, exists
+	}
+	return nil, false
+}
+
+// queryExpand expands arrays in given values.
+func queryExpand(values []interface{}) []interface{} {
+	out := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		if elems, ok := querySlice(v); ok {
+			out = append(out, elems...)
+			continue
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// querySort sorts documents by given sort specification.
+func querySort(docs []bson.M, sortSpec interface{}) error {
+	if sortSpec == nil {
+		return nil
+	}
+	spec, err := queryNormalize(sortSpec)
+	if err != nil {
+		return err
+	}
+	pairs, ok := queryPairs(spec)
+	if !ok || len(pairs) == 0 {
+		return nil
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, p := range pairs {
+			dir, _ := queryNumber(p.Value)
+			a, _ := queryLookup(docs[i], p.Key)
+			b, _ := queryLookup(docs[j], p.Key)
+			c := queryCompare(queryFirst(a), queryFirst(b))
+			if c == 0 {
+				continue
+			}
+			if dir < 0 {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	return nil
+}
+
+func queryFirst(values []interface{}) interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+// queryProject applies given (normalized) projection to a document.
+func queryProject(doc bson.M, spec interface{}, vars bson.M) (bson.M, error) {
+	pairs, ok := queryPairs(spec)
+	if !ok || len(pairs) == 0 {
+		return doc, nil
+	}
+	inclusion := false
+	for _, p := range pairs {
+		// _id only decides the projection type when it's the only field
+		if p.Key == "_id" && len(pairs) > 1 {
+			continue
+		}
+		if n, isNum := queryNumber(p.Value); (isNum && n != 0) || p.Value == true || (!isNum && p.Value != false) {
+			inclusion = true
+			break
+		}
+	}
+	if !inclusion {
+		out := queryCopy(doc)
+		for _, p := range pairs {
+			queryUnsetPath(out, p.Key)
+		}
+		return out, nil
+	}
+	out := bson.M{}
+	if v, exists := doc["_id"]; exists {
+		out["_id"] = v
+	}
+	for _, p := range pairs {
+		n, isNum := queryNumber(p.Value)
+		switch {
+		case isNum || p.Value == true || p.Value == false:
+			{
+				if (isNum && n == 0) || p.Value == false {
+					delete(out, p.Key)
+					continue
+				}
+				if values, exists := queryLookup(doc, p.Key); exists {
+					querySetPath(out, p.Key, queryFirst(values))
+				}
+				break
+			}
+		default:
+			{
+				v, err := queryEval(doc, p.Value, vars)
+				if err != nil {
+					return nil, err
+				}
+				querySetPath(out, p.Key, v)
+			}
+		}
+	}
+	return out, nil
+}
+
+// queryAggregate runs the aggregation pipeline on given documents, source is used to fetch
+// documents from other collections for $lookup stages.
+func queryAggregate(docs []bson.M, pipeline mongo.Pipeline, source func(collection string) ([]bson.M, error)) ([]bson.M, error) {
+	stages := make([]interface{}, 0, len(pipeline))
+	for _, stage := range pipeline {
+		stages = append(stages, stage)
+	}
+	spec, err := queryNormalize(bson.A(stages))
+	if err != nil {
+		return nil, err
+	}
+	normalized, _ := querySlice(spec)
+	return queryRunPipeline(docs, normalized, nil, source)
+}
+
+func queryRunPipeline(docs []bson.M, stages []interface{}, vars bson.M, source func(collection string) ([]bson.M, error)) ([]bson.M, error) {
+	var err error
+	for _, stage := range stages {
+		pairs, ok := queryPairs(stage)
+		if !ok || len(pairs) != 1 {
+			return nil, ErrNoData
+		}
+		spec := pairs[0].Value
+		switch pairs[0].Key {
+		case "$match":
+			{
+				out := make([]bson.M, 0)
+				for _, doc := range docs {
+					match, err := queryMatch(doc, spec, vars)
+					if err != nil {
+						return nil, err
+					}
+					if match {
+						out = append(out, doc)
+					}
+				}
+				docs = out
+				break
+			}
+		case "$lookup":
+			{
+				if docs, err = queryStageLookup(docs, spec, vars, source); err != nil {
+					return nil, err
+				}
+				break
+			}
+		case "$project":
+			{
+				out := make([]bson.M, len(docs))
+				for i := range docs {
+					if out[i], err = queryProject(docs[i], spec, vars); err != nil {
+						return nil, err
+					}
+				}
+				docs = out
+				break
+			}
+		case "$addFields", "$set":
+			{
+				fields, _ := queryPairs(spec)
+				out := make([]bson.M, len(docs))
+				for i := range docs {
+					out[i] = queryCopy(docs[i])
+					for _, f := range fields {
+						v, err := queryEval(docs[i], f.Value, vars)
+						if err != nil {
+							return nil, err
+						}
+						querySetPath(out[i], f.Key, v)
+					}
+				}
+				docs = out
+				break
+			}
+		case "$unset":
+			{
+				fields := []interface{}{spec}
+				if values, ok := querySlice(spec); ok {
+					fields = values
+				}
+				out := make([]bson.M, len(docs))
+				for i := range docs {
+					out[i] = queryCopy(docs[i])
+					for _, f := range fields {
+						if s, ok := f.(string); ok {
+							queryUnsetPath(out[i], s)
+						}
+					}
+				}
+				docs = out
+				break
+			}
+		case "$sort":
+			{
+				docs = append([]bson.M{}, docs...)
+				if err := querySort(docs, spec); err != nil {
+					return nil, err
+				}
+				break
+			}
+		case "$skip":
+			{
+				n, _ := queryNumber(spec)
+				if int(n) >= len(docs) {
+					docs = []bson.M{}
+				} else if n > 0 {
+					docs = docs[int(n):]
+				}
+				break
+			}
+		case "$limit":
+			{
+				n, _ := queryNumber(spec)
+				if int(n) < len(docs) {
+					docs = docs[:int(n)]
+				}
+				break
+			}
+		case "$count":
+			{
+				name, _ := spec.(string)
+				if len(docs) == 0 {
+					docs = []bson.M{}
+					break
+				}
+				docs = []bson.M{{name: int32(len(docs))}}
+				break
+			}
+		case "$unwind":
+			{
+				if docs, err = queryStageUnwind(docs, spec); err != nil {
+					return nil, err
+				}
+				break
+			}
+		case "$group":
+			{
+				if docs, err = queryStageGroup(docs, spec, vars); err != nil {
+					return nil, err
+				}
+				break
+			}
+		case "$replaceRoot", "$replaceWith":
+			{
+				expr := spec
+				if pairs[0].Key == "$replaceRoot" {
+					expr = queryGet(spec, "newRoot")
+				}
+				out := make([]bson.M, 0, len(docs))
+				for _, doc := range docs {
+					v, err := queryEval(doc, expr, vars)
+					if err != nil {
+						return nil, err
+					}
+					newDoc, ok := queryDoc(v)
+					if !ok {
+						return nil, ErrNoData
+					}
+					out = append(out, newDoc)
+				}
+				docs = out
+				break
+			}
+		case "$facet":
+			{
+				out := bson.M{}
+				facets, _ := queryPairs(spec)
+				for _, f := range facets {
+					subStages, _ := querySlice(f.Value)
+					res, err := queryRunPipeline(docs, subStages, vars, source)
+					if err != nil {
+						return nil, err
+					}
+					values := make(bson.A, len(res))
+					for i := range res {
+						values[i] = res[i]
+					}
+					out[f.Key] = values
+				}
+				docs = []bson.M{out}
+				break
+			}
+		default:
+			{
+				return nil, fmt.Errorf("%w: %s", ErrQueryUnsupported, pairs[0].Key)
+			}
+		}
+	}
+	return docs, nil
+}
+
+func queryStageLookup(docs []bson.M, spec interface{}, vars bson.M, source func(collection string) ([]bson.M, error)) ([]bson.M, error) {
+	from, _ := queryGet(spec, "from").(string)
+	as, _ := queryGet(spec, "as").(string)
+	localField, _ := queryGet(spec, "localField").(string)
+	foreignField, _ := queryGet(spec, "foreignField").(string)
+	subStages, hasPipeline := querySlice(queryGet(spec, "pipeline"))
+	letPairs, _ := queryPairs(queryGet(spec, "let"))
+	if source == nil || from == "" || as == "" {
+		return nil, ErrNoData
+	}
+	foreign, err := source(from)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]bson.M, 0, len(docs))
+	for _, doc := range docs {
+		matches := foreign
+		if localField != "" && foreignField != "" {
+			localValues, localExists := queryLookup(doc, localField)
+			localValues = queryExpand(localValues)
+			if !localExists || len(localValues) == 0 {
+				localValues = []interface{}{nil}
+			}
+			matches = make([]bson.M, 0)
+			for _, f := range foreign {
+				foreignValues, foreignExists := queryLookup(f, foreignField)
+				for _, lv := range localValues {
+					if queryLeavesEqual(foreignValues, foreignExists, lv) {
+						matches = append(matches, f)
+						break
+					}
+				}
+			}
+		}
+		if hasPipeline {
+			subVars := bson.M{}
+			for k, v := range vars {
+				subVars[k] = v
+			}
+			for _, l := range letPairs {
+				if subVars[l.Key], err = queryEval(doc, l.Value, vars); err != nil {
+					return nil, err
+				}
+			}
+			if matches, err = queryRunPipeline(matches, subStages, subVars, source); err != nil {
+				return nil, err
+			}
+		}
+		values := make(bson.A, len(matches))
+		for i := range matches {
+			values[i] = matches[i]
+		}
+		newDoc := queryCopy(doc)
+		newDoc[as] = values
+		out = append(out, newDoc)
+	}
+	return out, nil
+}
+
+func queryStageUnwind(docs []bson.M, spec interface{}) ([]bson.M, error) {
+	path, _ := spec.(string)
+	preserve := false
+	if path == "" {
+		path, _ = queryGet(spec, "path").(string)
+		preserve = queryTruthy(queryGet(spec, "preserveNullAndEmptyArrays"))
+	}
+	if !strings.HasPrefix(path, "$") {
+		return nil, ErrNoData
+	}
+	path = path[1:]
+	out := make([]bson.M, 0, len(docs))
+	for _, doc := range docs {
+		values, exists := queryLookup(doc, path)
+		v := queryFirst(values)
+		elems, isArray := querySlice(v)
+		if !exists || v == nil || (isArray && len(elems) == 0) {
+			if preserve {
+				out = append(out, doc)
+			}
+			continue
+		}
+		if !isArray {
+			out = append(out, doc)
+			continue
+		}
+		for _, elem := range elems {
+			newDoc := queryCopy(doc)
+			querySetPath(newDoc, path, elem)
+			out = append(out, newDoc)
+		}
+	}
+	return out, nil
+}
+
+func queryStageGroup(docs []bson.M, spec interface{}, vars bson.M) ([]bson.M, error) {
+	fields, _ := queryPairs(spec)
+	idExpr := queryGet(spec, "_id")
+	keys := make([]string, 0)
+	groups := make(map[string]bson.M)
+	counts := make(map[string]map[string]int)
+	for _, doc := range docs {
+		id, err := queryEval(doc, idExpr, vars)
+		if err != nil {
+			return nil, err
+		}
+		rawKey, err := bson.Marshal(bson.D{{Key: "k", Value: id}})
+		if err != nil {
+			return nil, err
+		}
+		key := string(rawKey)
+		group, exists := groups[key]
+		if !exists {
+			group = bson.M{"_id": id}
+			groups[key] = group
+			counts[key] = make(map[string]int)
+			keys = append(keys, key)
+		}
+		for _, f := range fields {
+			if f.Key == "_id" {
+				continue
+			}
+			acc, ok := queryPairs(f.Value)
+			if !ok || len(acc) != 1 {
+				return nil, ErrNoData
+			}
+			v, err := queryEval(doc, acc[0].Value, vars)
+			if err != nil {
+				return nil, err
+			}
+			current, hasCurrent := group[f.Key]
+			switch acc[0].Key {
+			case "$max", "$min":
+				{
+					if v == nil {
+						break
+					}
+					c := queryCompare(v, current)
+					if !hasCurrent || current == nil || (acc[0].Key == "$max" && c > 0) || (acc[0].Key == "$min" && c < 0) {
+						group[f.Key] = v
+					}
+					break
+				}
+			case "$sum", "$avg":
+				{
+					n, _ := queryNumber(v)
+					total, _ := queryNumber(current)
+					group[f.Key] = total + n
+					if _, isNum := queryNumber(v); isNum {
+						counts[key][f.Key]++
+					}
+					break
+				}
+			case "$first":
+				{
+					if !hasCurrent {
+						group[f.Key] = v
+					}
+					break
+				}
+			case "$last":
+				{
+					group[f.Key] = v
+					break
+				}
+			case "$push", "$addToSet":
+				{
+					values, _ := querySlice(current)
+					if acc[0].Key == "$addToSet" {
+						for _, existing := range values {
+							if queryEqual(existing, v) {
+								v = nil
+								break
+							}
+						}
+						if v == nil {
+							group[f.Key] = values
+							break
+						}
+					}
+					group[f.Key] = append(append(bson.A{}, values...), v)
+					break
+				}
+			default:
+				{
+					return nil, fmt.Errorf("%w: %s", ErrQueryUnsupported, acc[0].Key)
+				}
+			}
+		}
+	}
+	out := make([]bson.M, 0, len(keys))
+	for _, key := range keys {
+		group := groups[key]
+		for _, f := range fields {
+			acc, _ := queryPairs(f.Value)
+			if len(acc) != 1 {
+				continue
+			}
+			switch acc[0].Key {
+			case "$sum":
+				{
+					if n, _ := queryNumber(group[f.Key]); n == float64(int32(n)) {
+						group[f.Key] = int32(n)
+					}
+					break
+				}
+			case "$avg":
+				{
+					total, _ := queryNumber(group[f.Key])
+					group[f.Key] = nil
+					if counts[key][f.Key] > 0 {
+						group[f.Key] = total / float64(counts[key][f.Key])
+					}
+					break
+				}
+			case "$push", "$addToSet":
+				{
+					if _, exists := group[f.Key]; !exists {
+						group[f.Key] = bson.A{}
+					}
+					break
+				}
+			}
+		}
+		out = append(out, group)
+	}
+	return out, nil
+}
+
+// queryEval evaluates an aggregation expression against a document.
+func queryEval(doc bson.M, expr interface{}, vars bson.M) (interface{}, error) {
+	switch e := expr.(type) {
+	case string:
+		{
+			if strings.HasPrefix(e, "$$") {
+				name := e[2:]
+				path := ""
+				if i := strings.Index(name, "."); i >= 0 {
+					name, path = name[:i], name[i+1:]
+				}
+				var v interface{}
+				switch name {
+				case "ROOT", "CURRENT":
+					v = doc
+				default:
+					v = vars[name]
+				}
+				values, _ := queryLookup(v, path)
+				return queryFirst(values), nil
+			}
+			if strings.HasPrefix(e, "$") {
+				values, exists := queryLookup(doc, e[1:])
+				if !exists {
+					return nil, nil
+				}
+				if len(values) == 1 {
+					return values[0], nil
+				}
+				return bson.A(values), nil
+			}
+			return e, nil
+		}
+	case primitive.A:
+		{
+			out := make(bson.A, len(e))
+			for i := range e {
+				v, err := queryEval(doc, e[i], vars)
+				if err != nil {
+					return nil, err
+				}
+				out[i] = v
+			}
+			return out, nil
+		}
+	}
+	pairs, ok := queryPairs(expr)
+	if !ok {
+		return expr, nil
+	}
+	if len(pairs) != 1 || !strings.HasPrefix(pairs[0].Key, "$") {
+		out := bson.M{}
+		for _, p := range pairs {
+			v, err := queryEval(doc, p.Value, vars)
+			if err != nil {
+				return nil, err
+			}
+			out[p.Key] = v
+		}
+		return out, nil
+	}
+	op := pairs[0].Key
+	if op == "$literal" {
+		return pairs[0].Value, nil
+	}
+	argValue, err := queryEval(doc, pairs[0].Value, vars)
+	if err != nil {
+		return nil, err
+	}
+	args, isArgs := querySlice(argValue)
+	if !isArgs {
+		args = []interface{}{argValue}
+	}
+	arg := func(i int) interface{} {
+		if i < len(args) {
+			return args[i]
+		}
+		return nil
+	}
+	switch op {
+	case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte", "$cmp":
+		{
+			c := queryCompare(arg(0), arg(1))
+			switch op {
+			case "$eq":
+				return c == 0, nil
+			case "$ne":
+				return c != 0, nil
+			case "$gt":
+				return c > 0, nil
+			case "$gte":
+				return c >= 0, nil
+			case "$lt":
+				return c < 0, nil
+			case "$lte":
+				return c <= 0, nil
+			}
+			return int32(c), nil
+		}
+	case "$and":
+		{
+			for _, a := range args {
+				if !queryTruthy(a) {
+					return false, nil
+				}
+			}
+			return true, nil
+		}
+	case "$or":
+		{
+			for _, a := range args {
+				if queryTruthy(a) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+	case "$not":
+		{
+			return !queryTruthy(arg(0)), nil
+		}
+	case "$in":
+		{
+			values, _ := querySlice(arg(1))
+			for _, v := range values {
+				if queryEqual(arg(0), v) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+	case "$ifNull":
+		{
+			for _, a := range args {
+				if a != nil {
+					return a, nil
+				}
+			}
+			return nil, nil
+		}
+	case "$size":
+		{
+			values, ok := querySlice(arg(0))
+			if !ok {
+				return nil, ErrNoData
+			}
+			return int32(len(values)), nil
+		}
+	case "$concat":
+		{
+			out := ""
+			for _, a := range args {
+				s, _ := a.(string)
+				out += s
+			}
+			return out, nil
+		}
+	case "$toLower", "$toUpper":
+		{
+			s, _ := arg(0).(string)
+			if op == "$toLower" {
+				return strings.ToLower(s), nil
+			}
+			return strings.ToUpper(s), nil
+		}
+	case "$max", "$min":
+		{
+			values := queryExpand(args)
+			var out interface{}
+			for _, v := range values {
+				if v == nil {
+					continue
+				}
+				c := queryCompare(v, out)
+				if out == nil || (op == "$max" && c > 0) || (op == "$min" && c < 0) {
+					out = v
+				}
+			}
+			return out, nil
+		}
+	case "$add":
+		{
+			sum, isFloat := 0.0, false
+			for _, a := range args {
+				n, ok := queryNumber(a)
+				if !ok {
+					return nil, nil
+				}
+				if _, ok := a.(float64); ok {
+					isFloat = true
+				}
+				sum += n
+			}
+			if isFloat {
+				return sum, nil
+			}
+			return int64(sum), nil
+		}
+	case "$cond":
+		{
+			cond, then, otherwise := arg(0), arg(1), arg(2)
+			if !isArgs {
+				cond, then, otherwise = queryGet(argValue, "if"), queryGet(argValue, "then"), queryGet(argValue, "else")
+			}
+			if queryTruthy(cond) {
+				return then, nil
+			}
+			return otherwise, nil
+		}
+	case "$regexMatch":
+		{
+			input, _ := queryGet(argValue, "input").(string)
+			pattern, _ := queryGet(argValue, "regex").(string)
+			if options, _ := queryGet(argValue, "options").(string); strings.Contains(options, "i") {
+				pattern = "(?i)" + pattern
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, err
+			}
+			return re.MatchString(input), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrQueryUnsupported, op)
+}
+
+// queryTruthy returns the aggregation truthiness of a value.
+func queryTruthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	if n, ok := queryNumber(v); ok {
+		return n != 0
+	}
+	return true
+}
+
+// queryEqual returns true if both values are of the same type and equal.
+func queryEqual(a interface{}, b interface{}) bool {
+	return queryTypeRank(a) == queryTypeRank(b) && queryCompare(a, b) == 0
+}
+
+// queryTypeRank returns the bson comparison order of a value's type.
+func queryTypeRank(v interface{}) int {
+	switch v.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return 1
+	case string, primitive.Symbol:
+		return 3
+	case primitive.A, []interface{}:
+		return 5
+	case primitive.Binary, []byte:
+		return 6
+	case primitive.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case primitive.DateTime, time.Time:
+		return 9
+	case primitive.Timestamp:
+		return 10
+	case primitive.Regex:
+		return 11
+	}
+	if _, ok := queryNumber(v); ok {
+		return 2
+	}
+	if _, ok := queryPairs(v); ok {
+		return 4
+	}
+	return 12
+}
+
+// queryCompare compares two values using bson comparison order.
+func queryCompare(a interface{}, b interface{}) int {
+	rankA, rankB := queryTypeRank(a), queryTypeRank(b)
+	if rankA != rankB {
+		if rankA < rankB {
+			return -1
+		}
+		return 1
+	}
+	switch rankA {
+	case 1:
+		return 0
+	case 2:
+		{
+			na, _ := queryNumber(a)
+			nb, _ := queryNumber(b)
+			return queryCompareFloat(na, nb)
+		}
+	case 3:
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	case 4:
+		{
+			pa, _ := queryPairs(a)
+			pb, _ := queryPairs(b)
+			for i := 0; i < len(pa) && i < len(pb); i++ {
+				if c := strings.Compare(pa[i].Key, pb[i].Key); c != 0 {
+					return c
+				}
+				if c := queryCompare(pa[i].Value, pb[i].Value); c != 0 {
+					return c
+				}
+			}
+			return queryCompareFloat(float64(len(pa)), float64(len(pb)))
+		}
+	case 5:
+		{
+			va, _ := querySlice(a)
+			vb, _ := querySlice(b)
+			for i := 0; i < len(va) && i < len(vb); i++ {
+				if c := queryCompare(va[i], vb[i]); c != 0 {
+					return c
+				}
+			}
+			return queryCompareFloat(float64(len(va)), float64(len(vb)))
+		}
+	case 6:
+		return bytes.Compare(queryBytes(a), queryBytes(b))
+	case 7:
+		{
+			oa, ob := a.(primitive.ObjectID), b.(primitive.ObjectID)
+			return bytes.Compare(oa[:], ob[:])
+		}
+	case 8:
+		{
+			ba, bb := a.(bool), b.(bool)
+			if ba == bb {
+				return 0
+			} else if !ba {
+				return -1
+			}
+			return 1
+		}
+	case 9:
+		return queryCompareFloat(float64(queryDateTime(a)), float64(queryDateTime(b)))
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func queryCompareFloat(a float64, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func queryNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func queryBytes(v interface{}) []byte {
+	switch b := v.(type) {
+	case primitive.Binary:
+		return b.Data
+	case []byte:
+		return b
+	}
+	return nil
+}
+
+func queryDateTime(v interface{}) int64 {
+	switch t := v.(type) {
+	case primitive.DateTime:
+		return int64(t)
+	case time.Time:
+		return int64(primitive.NewDateTimeFromTime(t))
+	}
+	return 0
+}
+
+// queryPairs returns the ordered key/value pairs of a document.
+func queryPairs(v interface{}) ([]primitive.E, bool) {
+	switch d := v.(type) {
+	case primitive.D:
+		return d, true
+	case primitive.M, map[string]interface{}:
+		{
+			m, _ := queryDoc(d)
+			keys := make([]string, 0, len(m))
+			for k := range m {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			out := make([]primitive.E, 0, len(keys))
+			for _, k := range keys {
+				out = append(out, primitive.E{Key: k, Value: m[k]})
+			}
+			return out, true
+		}
+	}
+	return nil, false
+}
+
+// queryDoc returns given value as a document.
+func queryDoc(v interface{}) (bson.M, bool) {
+	switch d := v.(type) {
+	case primitive.M:
+		return d, true
+	case map[string]interface{}:
+		return bson.M(d), true
+	case primitive.D:
+		{
+			out := bson.M{}
+			for _, e := range d {
+				out[e.Key] = e.Value
+			}
+			return out, true
+		}
+	}
+	return nil, false
+}
+
+// querySlice returns given value as an array.
+func querySlice(v interface{}) ([]interface{}, bool) {
+	switch a := v.(type) {
+	case primitive.A:
+		return a, true
+	case []interface{}:
+		return a, true
+	}
+	return nil, false
+}
+
+// queryGet returns the value of a key in a document.
+func queryGet(v interface{}, key string) interface{} {
+	doc, _ := queryDoc(v)
+	if doc == nil {
+		return nil
+	}
+	return doc[key]
+}
+
+// queryCopy makes a shallow copy of a document.
+func queryCopy(doc bson.M) bson.M {
+	out := make(bson.M, len(doc))
+	for k, v := range doc {
+		out[k] = v
+	}
+	return out
+}
+
+// querySet returns a copy of doc with the fields of set changed, set uses dotted paths for nested fields.
+func querySet(doc bson.M, set interface{}) (bson.M, error) {
+	set, err := queryNormalize(set)
+	if err != nil {
+		return nil, err
+	}
+	pairs, ok := queryPairs(set)
+	if !ok {
+		return nil, ErrNoData
+	}
+	out := queryCopy(doc)
+	for _, p := range pairs {
+		querySetPath(out, p.Key, p.Value)
+	}
+	return out, nil
+}
+
+// querySetPath sets the value at a dotted path, copying nested documents along the path.
+func querySetPath(doc bson.M, path string, v interface{}) {
+	parts := strings.SplitN(path, ".", 2)
+	if len(parts) == 1 {
+		doc[path] = v
+		return
+	}
+	child, ok := queryDoc(doc[parts[0]])
+	if !ok {
+		child = bson.M{}
+	} else {
+		child = queryCopy(child)
+	}
+	querySetPath(child, parts[1], v)
+	doc[parts[0]] = child
+}
+
+// queryUnsetPath removes the value at a dotted path, copying nested documents along the path.
+func queryUnsetPath(doc bson.M, path string) {
+	parts := strings.SplitN(path, ".", 2)
+	if len(parts) == 1 {
+		delete(doc, path)
+		return
+	}
+	child, ok := queryDoc(doc[parts[0]])
+	if !ok {
+		return
+	}
+	child = queryCopy(child)
+	queryUnsetPath(child, parts[1])
+	doc[parts[0]] = child
+}
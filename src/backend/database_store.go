@@ -1,9 +1,6 @@
 package main
 
-import (
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo/options"
-)
+import "go.mongodb.org/mongo-driver/bson"
 
 func databaseFilter(data interface{}) interface{} {
 	switch d := data.(type) {
@@ -31,48 +28,88 @@ func databaseFilter(data interface{}) interface{} {
 		{
 			return bson.M{"_id": d.ID}
 		}
+	case *Session:
+		{
+			return bson.M{"_id": d.TokenHash}
+		}
+	case *APIToken:
+		{
+			return bson.M{"_id": d.ID}
+		}
+	case *AuditLog:
+		{
+			return bson.M{"_id": d.ID}
+		}
+	case *Webhook:
+		{
+			return bson.M{"_id": d.ID}
+		}
+	case *WebhookDelivery:
+		{
+			return bson.M{"_id": d.ID}
+		}
+	case *Role:
+		{
+			return bson.M{"_id": d.ID}
+		}
+	case *SearchEntry:
+		{
+			return bson.M{"_id": d.ID}
+		}
 	}
 	return nil
 }
 
-func databaseStoreOne(data interface{}) error {
+func databaseStoreOne(tx Store, data interface{}) error {
 	// missing param
 	if data == nil {
 		return ErrNoData
 	}
-	// get collection
-	col, err := databaseCollectionFromData(data)
+	// get store
+	store, err := databaseGetStore(tx)
 	if err != nil {
 		return err
 	}
-	// options
-	opts := options.Update()
-	opts.SetUpsert(true)
-	// make doc
-	doc, err := toBSONDoc(data)
+	return store.StoreOne(data)
+}
+
+// databaseInsertOne inserts an object, it fails with ErrDBDuplicateKey instead of overwriting a stored one.
+func databaseInsertOne(tx Store, data interface{}) error {
+	// missing param
+	if data == nil {
+		return ErrNoData
+	}
+	// get store
+	store, err := databaseGetStore(tx)
 	if err != nil {
 		return err
 	}
-	// update
-	if _, err := col.UpdateOne(databaseContext(), databaseFilter(data), bson.M{"$set": doc}, opts); err != nil {
-		return err
+	return store.InsertOne(data)
+}
+
+// databaseUpdateOne sets fields of the first object matching filter, false is returned if none matched.
+func databaseUpdateOne(tx Store, dataType interface{}, filter interface{}, set interface{}) (bool, error) {
+	// missing param
+	if dataType == nil || filter == nil || set == nil {
+		return false, ErrNoData
 	}
-	return nil
+	// get store
+	store, err := databaseGetStore(tx)
+	if err != nil {
+		return false, err
+	}
+	return store.UpdateOne(dataType, filter, set)
 }
 
-func databaseDelete(dataType interface{}, filter interface{}) error {
+func databaseDelete(tx Store, dataType interface{}, filter interface{}) error {
 	// missing param
 	if dataType == nil || filter == nil {
 		return ErrNoData
 	}
-	// get collection
-	col, err := databaseCollectionFromData(dataType)
+	// get store
+	store, err := databaseGetStore(tx)
 	if err != nil {
 		return err
 	}
-	// delete
-	if _, err := col.DeleteMany(databaseContext(), filter); err != nil {
-		return err
-	}
-	return nil
+	return store.Delete(dataType, filter)
 }
@@ -3,19 +3,52 @@ package main
 import "errors"
 
 var (
-	ErrNoData                  = errors.New("no data provided")
-	ErrNoUser                  = errors.New("no user provided")
-	ErrNoDBConnection          = errors.New("no database connection")
-	ErrUserNotFound            = errors.New("user not found")
-	ErrUserNotOnTeam           = errors.New("user does not belong to team")
-	ErrInvalidCredentials      = errors.New("invalid login credentials")
-	ErrInvalidPermission       = errors.New("user does not have permission")
-	ErrHTTPInvalidPayload      = errors.New("http invalid payload")
-	ErrHTTPInvalidSession      = errors.New("http invalid session")
-	ErrHTTPMissingParam        = errors.New("http missing query parameter")
-	ErrHTTPLoginRequired       = errors.New("http login required")
-	ErrDBInvalidObjectType     = errors.New("invalid object type for database storage")
-	ErrObjMissingParam         = errors.New("object missing required parameter")
-	ErrObjInvalidParam         = errors.New("object has an invalid parameter")
-	ErrCannotDeleteOnlyVersion = errors.New("cannot delete only tree version")
+	ErrNoData                   = errors.New("no data provided")
+	ErrNoUser                   = errors.New("no user provided")
+	ErrNoDBConnection           = errors.New("no database connection")
+	ErrUserNotFound             = errors.New("user not found")
+	ErrUserNotOnTeam            = errors.New("user does not belong to team")
+	ErrInvalidCredentials       = errors.New("invalid login credentials")
+	ErrInvalidPermission        = errors.New("user does not have permission")
+	ErrInvalidPermissionName    = errors.New("unknown permission")
+	ErrInvalidRole              = errors.New("role does not exist on team")
+	ErrHTTPInvalidPayload       = errors.New("http invalid payload")
+	ErrHTTPInvalidSession       = errors.New("http invalid session")
+	ErrHTTPMissingParam         = errors.New("http missing query parameter")
+	ErrHTTPLoginRequired        = errors.New("http login required")
+	ErrInvalidSessionDriver     = errors.New("invalid session driver")
+	ErrInvalidMailDriver        = errors.New("invalid or incomplete mail driver config")
+	ErrInvalidTrustedProxy      = errors.New("invalid trusted proxy address")
+	ErrDBInvalidObjectType      = errors.New("invalid object type for database storage")
+	ErrDBInvalidDriver          = errors.New("invalid database driver")
+	ErrDBDuplicateKey           = errors.New("object with the same key is already stored")
+	ErrObjMissingParam          = errors.New("object missing required parameter")
+	ErrObjInvalidParam          = errors.New("object has an invalid parameter")
+	ErrCannotDeleteOnlyVersion  = errors.New("cannot delete only tree version")
+	ErrTreeVersionPublished     = errors.New("tree version is already published")
+	ErrInvalidTreeState         = errors.New("tree version state does not allow this action")
+	ErrApprovalRequired         = errors.New("tree version must be approved before it is published")
+	ErrSelfApproval             = errors.New("tree version cannot be approved by its author")
+	ErrTreeInvalid              = errors.New("tree has errors that must be fixed before it is published")
+	ErrSubmissionInvalidAnswers = errors.New("submission contains invalid answers")
+	ErrFormClosed               = errors.New("form is closed for new submissions")
+	ErrInvalidSort              = errors.New("invalid sort field")
+	ErrInvalidCursor            = errors.New("invalid or expired list cursor")
+	ErrBatchStepFailed          = errors.New("batch step failed, all changes were rolled back")
+	ErrBatchInvalidRef          = errors.New("batch reference does not point to a value of an earlier result")
+	ErrBatchFailedRef           = errors.New("batch reference points to a failed step")
+	ErrBatchFailedDependency    = errors.New("batch step depends on a failed step")
+	ErrBatchDependencyCycle     = errors.New("batch steps depend on each other")
+	ErrBatchDuplicateID         = errors.New("batch step id is used more than once")
+	ErrBatchSkipped             = errors.New("batch step was not run")
+	ErrBatchNoTransaction       = errors.New("batch step can't run in a transaction")
+	ErrPublicFormNotFound       = errors.New("public form not found")
+	ErrPublicSubmissionNotFound = errors.New("public form submission not found")
+	ErrRateLimited              = errors.New("too many requests, try again later")
+	ErrProofOfWorkInvalid       = errors.New("invalid or expired proof of work")
+	ErrSignUpDisabled           = errors.New("team does not allow sign up")
+	ErrSignUpDomain             = errors.New("email domain is not allowed to sign up")
+	ErrEmailInUse               = errors.New("email is already used by a user of the team")
+	ErrUserPending              = errors.New("user has not verified their email")
+	ErrInvalidToken             = errors.New("invalid or expired token")
 )
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Question types, these match the field types used by the webapp.
+const (
+	QuestionText     = "text"
+	QuestionChoice   = "choice"
+	QuestionDropdown = "dropdown"
+	QuestionUpload   = "upload"
+)
+
+// Submission validation error codes.
+const (
+	SubmissionErrUnknownQuestion = "unknown_question"
+	SubmissionErrInvalidAnswer   = "invalid_answer"
+	SubmissionErrMultipleAnswers = "multiple_answers"
+	SubmissionErrRequired        = "required"
+	SubmissionErrRule            = "rule"
+)
+
+// SubmissionError is a validation error for a single question in a form submission.
+type SubmissionError struct {
+	Question string `json:"question"`
+	MatrixID string `json:"matrix_id,omitempty"`
+	Answer   string `json:"answer,omitempty"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+}
+
+// Rejected returns true if the error should prevent the submission from being stored.
+func (e SubmissionError) Rejected() bool {
+	switch e.Code {
+	case SubmissionErrUnknownQuestion, SubmissionErrInvalidAnswer, SubmissionErrMultipleAnswers:
+		{
+			return true
+		}
+	}
+	return false
+}
+
+// SubmissionValidation is the result of checking a form submission against its tree version.
+type SubmissionValidation struct {
+	Valid      bool              `json:"valid"`
+	Errors     []SubmissionError `json:"errors"`
+	Evaluation *RuleEvaluation   `json:"-"`
+}
+
+// Rejected returns true if any of the errors should prevent the submission from being stored.
+func (v *SubmissionValidation) Rejected() bool {
+	for _, e := range v.Errors {
+		if e.Rejected() {
+			return true
+		}
+	}
+	return false
+}
+
+// RejectedErrors returns the errors that prevent the submission from being stored.
+func (v *SubmissionValidation) RejectedErrors() []SubmissionError {
+	out := make([]SubmissionError, 0)
+	for _, e := range v.Errors {
+		if e.Rejected() {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// ValidateSubmission checks the answers of given submission against given tree version.
+// Answer keys must be questions in the tree, choice answers must be child answers of their question
+// and required questions must be answered unless hidden by a visibility rule. Failed validation rules
+// are included as errors.
+func ValidateSubmission(treeVersion *TreeVersion, nodeHistory []NodeLookup, submission *FormSubmission) *SubmissionValidation {
+	out := &SubmissionValidation{
+		Valid:  true,
+		Errors: make([]SubmissionError, 0),
+	}
+	// evaluate rules
+	engine := NewRuleEngine(treeVersion, submission)
+	defer engine.Close()
+	engine.SetNodeHistory(nodeHistory)
+	out.Evaluation = engine.Evaluate()
+	// check answers
+	keys := make([]string, 0, len(submission.Answers))
+	for key := range submission.Answers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		question, matrixID := engine.splitAnswerKey(key)
+		if question == nil {
+			out.Errors = append(out.Errors, SubmissionError{
+				Question: key,
+				Code:     SubmissionErrUnknownQuestion,
+				Message:  "Question does not exist in this form version.",
+			})
+			continue
+		}
+		answers := nonEmptyAnswers(submission.Answers[key])
+		questionType := question.Data.GetString("type")
+		if questionType != QuestionChoice && questionType != QuestionDropdown {
+			continue
+		}
+		// the matrix ids of matrix questions are stored without the matrix suffix
+		if matrixID == "" && engine.matrixNode(question) != nil {
+			continue
+		}
+		for _, answer := range answers {
+			answerNode := engine.nodes[answer]
+			if answerNode == nil || answerNode.Type != NodeAnswer || answerNode.Parent != question.UID {
+				out.Errors = append(out.Errors, SubmissionError{
+					Question: question.UID,
+					MatrixID: matrixID,
+					Answer:   answer,
+					Code:     SubmissionErrInvalidAnswer,
+					Message:  fmt.Sprintf("'%s' is not a valid choice.", answer),
+				})
+			}
+		}
+		if len(answers) > 1 && !question.Data.GetBool("multiple") {
+			out.Errors = append(out.Errors, SubmissionError{
+				Question: question.UID,
+				MatrixID: matrixID,
+				Code:     SubmissionErrMultipleAnswers,
+				Message:  "Only one answer is allowed.",
+			})
+		}
+	}
+	// check required questions
+	for i := range treeVersion.Tree {
+		node := &treeVersion.Tree[i]
+		if node.Type != NodeQuestion || !node.Data.GetBool("required") {
+			continue
+		}
+		matrixIDs := []string{""}
+		if matrix := engine.matrixNode(node); matrix != nil {
+			matrixIDs = engine.findMatrixIDs(matrix)
+		}
+		for _, matrixID := range matrixIDs {
+			if engine.IsHidden(node.UID, matrixID) {
+				continue
+			}
+			if len(nonEmptyAnswers(submission.Answers[answerKey(node.UID, matrixID)])) == 0 {
+				out.Errors = append(out.Errors, SubmissionError{
+					Question: node.UID,
+					MatrixID: matrixID,
+					Code:     SubmissionErrRequired,
+					Message:  "This field is required.",
+				})
+			}
+		}
+	}
+	// failed validation rules
+	messageKeys := make([]string, 0, len(out.Evaluation.Messages))
+	for key := range out.Evaluation.Messages {
+		messageKeys = append(messageKeys, key)
+	}
+	sort.Strings(messageKeys)
+	for _, key := range messageKeys {
+		question, matrixID := engine.splitAnswerKey(key)
+		uid := key
+		if question != nil {
+			uid = question.UID
+		}
+		for _, message := range out.Evaluation.Messages[key] {
+			out.Errors = append(out.Errors, SubmissionError{
+				Question: uid,
+				MatrixID: matrixID,
+				Code:     SubmissionErrRule,
+				Message:  message,
+			})
+		}
+	}
+	out.Valid = len(out.Errors) == 0
+	return out
+}
+
+// splitAnswerKey returns the question and matrix id for given submission answer key.
+func (e *RuleEngine) splitAnswerKey(key string) (*Node, string) {
+	if n := e.nodes[key]; n != nil {
+		if n.Type == NodeQuestion {
+			return n, ""
+		}
+		return nil, ""
+	}
+	for i := strings.Index(key, "_"); i > 0; {
+		if n := e.nodes[key[:i]]; n != nil && n.Type == NodeQuestion && e.matrixNode(n) != nil {
+			return n, key[i+1:]
+		}
+		next := strings.Index(key[i+1:], "_")
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return nil, ""
+}
+
+// matrixNode returns the matrix node that given node belongs to.
+func (e *RuleEngine) matrixNode(node *Node) *Node {
+	parent := e.nodes[node.Parent]
+	for i := 0; parent != nil && i < len(e.nodes); i++ {
+		if parent.Type == NodeMatrix {
+			return parent
+		}
+		parent = e.nodes[parent.Parent]
+	}
+	return nil
+}
+
+func nonEmptyAnswers(answers []string) []string {
+	out := make([]string, 0, len(answers))
+	for _, a := range answers {
+		if strings.TrimSpace(a) != "" {
+			out = append(out, a)
+		}
+	}
+	return out
+}
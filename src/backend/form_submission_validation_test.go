@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+)
+
+func getTestValidationTreeVersion() *TreeVersion {
+	tree := getTestTree("root")
+	for i := range tree {
+		switch tree[i].UID {
+		case "node-a-1":
+			{
+				tree[i].Data["type"] = QuestionChoice
+				tree[i].Data["required"] = true
+				break
+			}
+		case "node-b-1":
+			{
+				tree[i].Data["type"] = QuestionText
+				break
+			}
+		}
+	}
+	return &TreeVersion{Version: 1, Tree: tree}
+}
+
+func hasSubmissionError(errs []SubmissionError, question string, code string) bool {
+	for _, e := range errs {
+		if e.Question == question && e.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func TestValidateSubmission(t *testing.T) {
+	treeVersion := getTestValidationTreeVersion()
+	// valid answers
+	res := ValidateSubmission(treeVersion, nil, &FormSubmission{Answers: map[string][]string{
+		"node-a-1": {"node-a-1-1"},
+		"node-b-1": {"some text"},
+	}})
+	if !res.Valid || len(res.Errors) > 0 {
+		t.Errorf("expected submission to be valid, got %v", res.Errors)
+		return
+	}
+	// unknown question, answer that isn't a child answer, too many answers
+	res = ValidateSubmission(treeVersion, nil, &FormSubmission{Answers: map[string][]string{
+		"node-a-1":   {"node-a-1-1", "node-b-1"},
+		"node-x":     {"test"},
+		"node-a-1-1": {"test"},
+	}})
+	if !res.Rejected() {
+		t.Errorf("expected submission to be rejected")
+		return
+	}
+	if !hasSubmissionError(res.Errors, "node-x", SubmissionErrUnknownQuestion) ||
+		!hasSubmissionError(res.Errors, "node-a-1-1", SubmissionErrUnknownQuestion) {
+		t.Errorf("expected unknown question errors, got %v", res.Errors)
+		return
+	}
+	if !hasSubmissionError(res.Errors, "node-a-1", SubmissionErrInvalidAnswer) ||
+		!hasSubmissionError(res.Errors, "node-a-1", SubmissionErrMultipleAnswers) {
+		t.Errorf("expected invalid answer errors, got %v", res.Errors)
+		return
+	}
+	// required question missing, submission should be stored but not valid
+	res = ValidateSubmission(treeVersion, nil, &FormSubmission{Answers: map[string][]string{
+		"node-b-1": {"some text"},
+	}})
+	if res.Rejected() || res.Valid {
+		t.Errorf("expected submission to be invalid but not rejected")
+		return
+	}
+	if !hasSubmissionError(res.Errors, "node-a-1", SubmissionErrRequired) {
+		t.Errorf("expected required error, got %v", res.Errors)
+		return
+	}
+}
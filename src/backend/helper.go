@@ -1,6 +1,9 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+
 	"go.mongodb.org/mongo-driver/bson"
 )
 
@@ -30,6 +33,34 @@ func getDatabaseCollectionNameFromData(data interface{}) string {
 		{
 			return "rule_template"
 		}
+	case Session, *Session:
+		{
+			return "session"
+		}
+	case APIToken, *APIToken:
+		{
+			return "api_token"
+		}
+	case AuditLog, *AuditLog:
+		{
+			return "audit_log"
+		}
+	case Webhook, *Webhook:
+		{
+			return "webhook"
+		}
+	case WebhookDelivery, *WebhookDelivery:
+		{
+			return "webhook_delivery"
+		}
+	case Role, *Role:
+		{
+			return "role"
+		}
+	case SearchEntry, *SearchEntry:
+		{
+			return "search"
+		}
 	}
 	return ""
 }
@@ -77,6 +108,43 @@ func getEmptyStruct(dataType interface{}) interface{} {
 		{
 			return &RuleTemplate{}
 		}
+	case Session, *Session:
+		{
+			return &Session{}
+		}
+	case APIToken, *APIToken:
+		{
+			return &APIToken{}
+		}
+	case AuditLog, *AuditLog:
+		{
+			return &AuditLog{}
+		}
+	case Webhook, *Webhook:
+		{
+			return &Webhook{}
+		}
+	case WebhookDelivery, *WebhookDelivery:
+		{
+			return &WebhookDelivery{}
+		}
+	case Role, *Role:
+		{
+			return &Role{}
+		}
+	case SearchEntry, *SearchEntry:
+		{
+			return &SearchEntry{}
+		}
 	}
 	return nil
 }
+
+// generateToken generates a random secret token with given prefix, only its hash should be stored.
+func generateToken(prefix string) (string, error) {
+	rawToken := make([]byte, 24)
+	if _, err := rand.Read(rawToken); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(rawToken), nil
+}
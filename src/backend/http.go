@@ -6,6 +6,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gorilla/mux"
@@ -16,11 +17,13 @@ const UserCanDelete = "delete"
 const UserCanCreate = "create"
 
 type HTTPMessage struct {
-	Success bool        `json:"success"`
-	Message string      `json:"message,omitempty"`
-	Count   int         `json:"count,omitempty"`
-	Data    interface{} `json:"data,omitempty"`
-	UserCan []string    `json:"user_can,omitempty"`
+	Success    bool        `json:"success"`
+	Message    string      `json:"message,omitempty"`
+	Count      int         `json:"count,omitempty"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	Data       interface{} `json:"data,omitempty"`
+	UserCan    []string    `json:"user_can,omitempty"`
+	Errors     interface{} `json:"errors,omitempty"`
 }
 
 type HTTPEndpoint struct {
@@ -32,13 +35,26 @@ type HTTPEndpoint struct {
 var httpEndpoints = []HTTPEndpoint{
 	{"/api/user/login", HTTPUserLogin, "POST"},
 	{"/api/user/logout", HTTPUserLogout, "GET,POST"},
+	{"/api/user/logout_all", HTTPUserLogoutAll, "POST"},
 	{"/api/user/me", HTTPUserMe, "GET"},
 	{"/api/user/fetch", HTTPUserFetch, "GET"},
 	{"/api/user/store", HTTPUserStore, "POST"},
 	{"/api/user/delete", HTTPUserDelete, "POST"},
+	{"/api/user/signup", HTTPUserSignUp, "POST"},
+	{"/api/user/verify", HTTPUserVerify, "POST"},
+	{"/api/user/invite", HTTPUserInvite, "POST"},
+	{"/api/user/accept", HTTPUserAccept, "POST"},
+	{"/api/user/token/list", HTTPAPITokenList, "GET"},
+	{"/api/user/token/create", HTTPAPITokenCreate, "POST"},
+	{"/api/user/token/delete", HTTPAPITokenDelete, "POST"},
 	{"/api/team/fetch", HTTPTeamFetch, "GET"},
 	{"/api/team/store", HTTPTeamStore, "POST"},
 	{"/api/team/users", HTTPTeamUsers, "GET"},
+	{"/api/team/sessions", HTTPTeamSessions, "GET"},
+	{"/api/role/fetch", HTTPRoleFetch, "GET"},
+	{"/api/role/list", HTTPRoleList, "GET"},
+	{"/api/role/store", HTTPRoleStore, "POST"},
+	{"/api/role/delete", HTTPRoleDelete, "POST"},
 	{"/api/tree/fetch", HTTPTreeRootFetch, "GET"},
 	{"/api/tree/list", HTTPTreeRootList, "GET"},
 	{"/api/tree/store", HTTPTreeRootStore, "POST"},
@@ -49,18 +65,50 @@ var httpEndpoints = []HTTPEndpoint{
 	{"/api/tree/version/store", HTTPTreeVersionStore, "POST"},
 	{"/api/tree/version/delete", HTTPTreeVersionDelete, "POST"},
 	{"/api/tree/version/publish", HTTPTreeVersionPublish, "POST"},
+	{"/api/tree/version/request_review", HTTPTreeVersionRequestReview, "POST"},
+	{"/api/tree/version/approve", HTTPTreeVersionApprove, "POST"},
+	{"/api/tree/version/reject", HTTPTreeVersionReject, "POST"},
+	{"/api/tree/version/copy", HTTPTreeVersionCopy, "POST"},
+	{"/api/tree/version/rollback", HTTPTreeVersionRollback, "POST"},
+	{"/api/tree/version/diff", HTTPTreeVersionDiff, "GET"},
 	{"/api/submission/fetch", HTTPFormSubmissionFetch, "GET"},
 	{"/api/submission/list", HTTPFormSubmissionList, "GET"},
 	{"/api/submission/store", HTTPFormSubmissionStore, "POST"},
 	{"/api/submission/delete", HTTPFormSubmissionDelete, "POST"},
+	{"/api/submission/export", HTTPFormSubmissionExport, "GET"},
 	{"/api/rule_template/fetch", HTTPRuleTemplateFetch, "GET"},
 	{"/api/rule_template/list", HTTPRuleTemplateList, "GET"},
 	{"/api/rule_template/list_all", HTTPRuleTemplateListAll, "GET"},
 	{"/api/rule_template/store", HTTPRuleTemplateStore, "POST"},
 	{"/api/rule_template/delete", HTTPRuleTemplateDelete, "POST"},
+	{"/api/search", HTTPSearch, "GET"},
+	{"/api/audit/list", HTTPAuditList, "GET"},
+	{"/api/webhook/list", HTTPWebhookList, "GET"},
+	{"/api/webhook/store", HTTPWebhookStore, "POST"},
+	{"/api/webhook/delete", HTTPWebhookDelete, "POST"},
+	{"/api/webhook/rotate_secret", HTTPWebhookRotateSecret, "POST"},
+	{"/api/webhook/deliveries", HTTPWebhookDeliveryList, "GET"},
+	{"/api/public/form", HTTPPublicFormFetch, "GET"},
+	{"/api/public/challenge", HTTPPublicChallenge, "GET"},
+	{"/api/public/submission/fetch", HTTPPublicSubmissionFetch, "GET"},
+	{"/api/public/submission/store", HTTPPublicSubmissionStore, "POST"},
 }
 
 func HTTPStart(config *Config) error {
+	if err := sessionStoreOpen(config); err != nil {
+		return err
+	}
+	if err := mailerOpen(config); err != nil {
+		return err
+	}
+	if config.PublicRateLimit > 0 {
+		publicRateLimit = config.PublicRateLimit
+	}
+	publicProofOfWork = config.PublicProofOfWork
+	publicClientIPHeader = config.ClientIPHeader
+	if err := publicSetTrustedProxies(config.TrustedProxies); err != nil {
+		return err
+	}
 	r := mux.NewRouter()
 	for _, e := range httpEndpoints {
 		r.HandleFunc(e.Path, e.Function).Methods(strings.Split(e.Methods, ",")...)
@@ -91,3 +139,23 @@ func HTTPReadPayload(r *http.Request, payload interface{}) error {
 	}
 	return json.Unmarshal(rawBody, payload)
 }
+
+// HTTPReadListPage reads the offset, cursor, limit, sort and count query params of a list request.
+// Results are counted unless a cursor is given or count is false.
+func HTTPReadListPage(r *http.Request) ListPage {
+	query := r.URL.Query()
+	page := ListPage{
+		Cursor: query.Get("cursor"),
+		Sort:   query.Get("sort"),
+	}
+	page.Offset, _ = strconv.Atoi(query.Get("offset"))
+	if page.Offset < 0 {
+		page.Offset = 0
+	}
+	page.Limit, _ = strconv.Atoi(query.Get("limit"))
+	page.Count = page.Cursor == ""
+	if count, err := strconv.ParseBool(query.Get("count")); err == nil {
+		page.Count = count
+	}
+	return page
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+type HTTPAPITokenPayload struct {
+	ID         string         `json:"id"`
+	Label      string         `json:"label"`
+	Permission UserPermission `json:"permission"`
+	Expires    time.Time      `json:"expires"`
+}
+
+type HTTPAPITokenCreateResponse struct {
+	*APIToken
+	Token string `json:"token"`
+}
+
+// httpAPITokenUser returns the user of a cookie session, api tokens cannot be used to manage api tokens.
+func httpAPITokenUser(r *http.Request) (*User, error) {
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	if user == nil {
+		return nil, ErrHTTPLoginRequired
+	}
+	if s.APIToken != nil {
+		return nil, ErrInvalidPermission
+	}
+	return user, nil
+}
+
+func HTTPAPITokenList(w http.ResponseWriter, r *http.Request) {
+	// get user
+	user, err := httpAPITokenUser(r)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// fetch
+	res, err := ListAPIToken(user)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Count:   len(res),
+		Data:    res,
+	}, http.StatusOK)
+}
+
+func HTTPAPITokenCreate(w http.ResponseWriter, r *http.Request) {
+	// parse payload
+	payload := HTTPAPITokenPayload{}
+	if err := HTTPReadPayload(r, &payload); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// get user
+	user, err := httpAPITokenUser(r)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// create
+	apiToken, token, err := NewAPIToken(payload.Label, payload.Permission, payload.Expires, user)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// send results, the plain text token is only ever sent here
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Data:    HTTPAPITokenCreateResponse{APIToken: apiToken, Token: token},
+	}, http.StatusOK)
+}
+
+func HTTPAPITokenDelete(w http.ResponseWriter, r *http.Request) {
+	// parse payload
+	payload := HTTPAPITokenPayload{}
+	if err := HTTPReadPayload(r, &payload); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	if payload.ID == "" {
+		HTTPSendError(w, ErrHTTPInvalidPayload)
+		return
+	}
+	// get user
+	user, err := httpAPITokenUser(r)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// fetch
+	apiToken, err := FetchAPIToken(payload.ID, user)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// delete
+	if err := apiToken.Delete(user); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+	}, http.StatusOK)
+}
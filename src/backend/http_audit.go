@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// httpParseTime parses a RFC3339 time or a date.
+func httpParseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, ErrHTTPInvalidPayload
+	}
+	return t, nil
+}
+
+func HTTPAuditList(w http.ResponseWriter, r *http.Request) {
+	// get params
+	query := r.URL.Query()
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	filter := AuditFilter{
+		ObjectType: query.Get("object_type"),
+		ObjectID:   query.Get("object_id"),
+		User:       query.Get("user"),
+	}
+	var err error
+	if filter.From, err = httpParseTime(query.Get("from")); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	if filter.To, err = httpParseTime(query.Get("to")); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// get user
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	if user == nil {
+		HTTPSendError(w, ErrHTTPLoginRequired)
+		return
+	}
+	// fetch
+	res, count, err := ListAuditLog(filter, user, offset)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Count:   count,
+		Data:    res,
+	}, http.StatusOK)
+}
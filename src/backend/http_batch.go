@@ -2,12 +2,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type BatchResponseWriter struct {
@@ -45,11 +49,67 @@ func (w BatchResponseWriter) WriteHeader(statusCode int) {
 	*w.StatusCode = statusCode
 }
 
+// httpBatchWorkers is the number of requests of a parallel batch that run at the same time.
+const httpBatchWorkers = 4
+
+// httpBatchNoTransaction lists the endpoints that don't act as the session's user, they can't be part of
+// the transaction of an atomic batch.
+var httpBatchNoTransaction = map[string]bool{
+	"/api/user/login":              true,
+	"/api/user/logout":             true,
+	"/api/user/signup":             true,
+	"/api/user/verify":             true,
+	"/api/user/accept":             true,
+	"/api/public/form":             true,
+	"/api/public/challenge":        true,
+	"/api/public/submission/fetch": true,
+	"/api/public/submission/store": true,
+}
+
+// httpBatchLegacyRef matches the step numbers of "$<step>.<key>" replacements.
+var httpBatchLegacyRef = regexp.MustCompile(`\$(\d+)\.`)
+
+// HTTPBatchPayload is a request of a batch. Requests run once the requests they depend on are done,
+// dependencies are the requests listed in DependsOn, by id or step number, and the requests whose
+// results are referenced in the payload.
 type HTTPBatchPayload struct {
-	Path    string      `json:"path"`
-	Payload interface{} `json:"payload"`
+	ID        string      `json:"id,omitempty"`
+	Path      string      `json:"path"`
+	Payload   interface{} `json:"payload"`
+	DependsOn []string    `json:"depends_on,omitempty"`
+}
+
+// HTTPBatchResult is the response to a request of a batch, with its start and duration in milliseconds
+// relative to the start of the batch.
+type HTTPBatchResult struct {
+	HTTPMessage
+	ID         string  `json:"id,omitempty"`
+	StartMs    float64 `json:"start_ms"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// HTTPBatchError describes the failed step of an atomic batch, steps are numbered from 1.
+type HTTPBatchError struct {
+	Step    int    `json:"step"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// httpBatchDeps are the dependencies of a request of a batch.
+type httpBatchDeps struct {
+	steps    []int // all steps that must be done first
+	required []int // steps that must have succeeded
+	legacy   []int // steps used by "$<step>.<key>" replacements
+	err      error
 }
 
+// HTTPBatch runs a list of requests one at a time in request order, requests that depend on later requests
+// run once those are done. With the parallel query param set independent requests run concurrently. Results
+// are sent in request order. With the atomic query param set the batch runs one request at a time in a single
+// database transaction and stops at the first failed request, rolling back the changes of every request.
+// Requests run with the session of the batch, in atomic batches its user carries the transaction's store.
+// Payloads can use the results of other requests with {"$ref": "<step or id>/<json pointer>"} objects,
+// for example {"$ref": "1/data/id"}, or the older "$<step>.<key>" string replacement.
 func HTTPBatch(w http.ResponseWriter, r *http.Request) {
 	// parse payload
 	requests := []HTTPBatchPayload{}
@@ -57,144 +117,466 @@ func HTTPBatch(w http.ResponseWriter, r *http.Request) {
 		HTTPSendError(w, err)
 		return
 	}
-	out := make([]HTTPMessage, 0)
-	// replace parameters in payload to allow subsequent calls to use
-	// values from previous call
-	replVal := func(rv string) string {
-		if !strings.Contains(rv, "$") {
-			return rv
+	ids := make(map[string]int)
+	for i, req := range requests {
+		if req.ID == "" {
+			continue
+		}
+		if _, exists := ids[req.ID]; exists {
+			HTTPSendError(w, ErrBatchDuplicateID)
+			return
+		}
+		ids[req.ID] = i
+	}
+	atomic, _ := strconv.ParseBool(r.URL.Query().Get("atomic"))
+	parallel, _ := strconv.ParseBool(r.URL.Query().Get("parallel"))
+	workers := 1
+	if parallel {
+		workers = httpBatchWorkers
+	}
+	if atomic {
+		for _, req := range requests {
+			if httpBatchNoTransaction[req.Path] {
+				HTTPSendError(w, ErrBatchNoTransaction)
+				return
+			}
+		}
+		// transactions can't be shared between goroutines
+		workers = 1
+	}
+	session := HTTPGetSession(r)
+	deps := make([]httpBatchDeps, len(requests))
+	for i := range requests {
+		deps[i] = httpBatchDependencies(i, requests, ids)
+	}
+	out := make([]HTTPBatchResult, len(requests))
+	start := time.Now()
+	// runStep runs a request once its dependencies are done
+	runStep := func(i int) {
+		req := requests[i]
+		out[i].ID = req.ID
+		out[i].StartMs = float64(time.Since(start).Microseconds()) / 1000
+		defer func() {
+			out[i].DurationMs = float64(time.Since(start).Microseconds())/1000 - out[i].StartMs
+		}()
+		if deps[i].err != nil {
+			out[i].Message = deps[i].err.Error()
+			return
+		}
+		for _, dep := range deps[i].required {
+			if !out[dep].Success {
+				out[i].Message = fmt.Errorf("%w: %d", ErrBatchFailedDependency, dep+1).Error()
+				return
+			}
+		}
+		// process value replaces on payload
+		switch p := req.Payload.(type) {
+		case map[string]interface{}:
+			{
+				for k, v := range p {
+					switch v := v.(type) {
+					case string:
+						{
+							// only values with a replaced legacy reference are numbers, literal strings are kept
+							replaced := httpBatchReplace(v, deps[i].legacy, out)
+							if replaced == v {
+								break
+							}
+							p[k] = replaced
+							if num, err := strconv.Atoi(replaced); err == nil {
+								p[k] = num
+							}
+						}
+					}
+				}
+				break
+			}
+		}
+		payload, err := httpBatchResolve(req.Payload, out, ids)
+		if err != nil {
+			out[i].Message = err.Error()
+			return
+		}
+		req.Payload = payload
+		out[i].HTTPMessage = httpBatchSend(r, req, session)
+	}
+	var failed *HTTPBatchError
+	run := func() error {
+		// count unmet dependencies
+		waiting := make([]int, len(requests))
+		dependents := make([][]int, len(requests))
+		ready := make([]int, 0)
+		for i := range requests {
+			waiting[i] = len(deps[i].steps)
+			for _, dep := range deps[i].steps {
+				dependents[dep] = append(dependents[dep], i)
+			}
+			if waiting[i] == 0 {
+				ready = append(ready, i)
+			}
+		}
+		ran := make([]bool, len(requests))
+		done := make(chan int)
+		running := 0
+		for {
+			// earlier requests go first
+			sort.Ints(ready)
+			for failed == nil && running < workers && len(ready) > 0 {
+				i := ready[0]
+				ready = ready[1:]
+				ran[i] = true
+				running++
+				go func() {
+					runStep(i)
+					done <- i
+				}()
+			}
+			if running == 0 {
+				break
+			}
+			i := <-done
+			running--
+			if atomic && !out[i].Success && failed == nil {
+				failed = &HTTPBatchError{Step: i + 1, Path: requests[i].Path, Message: out[i].Message}
+			}
+			for _, dependent := range dependents[i] {
+				waiting[dependent]--
+				if waiting[dependent] == 0 {
+					ready = append(ready, dependent)
+				}
+			}
 		}
+		// requests left are skipped or wait on each other
 		for i := range out {
-			if out[i].Data == nil {
+			if ran[i] {
 				continue
 			}
-			for k, v := range out[i].Data.(map[string]interface{}) {
-				k = fmt.Sprintf("$%d.%s", i+1, k)
-				switch v := v.(type) {
-				case string:
-					{
-						rv = strings.ReplaceAll(rv, k, v)
-						break
-					}
-				case float64:
-					{
-						rv = strings.ReplaceAll(rv, k, fmt.Sprintf("%d", int(v)))
-						break
-					}
-				case int:
-					{
-						rv = strings.ReplaceAll(rv, k, fmt.Sprintf("%d", v))
-						break
-					}
+			out[i].ID = requests[i].ID
+			out[i].Message = ErrBatchDependencyCycle.Error()
+			if failed != nil {
+				out[i].Message = ErrBatchSkipped.Error()
+			}
+		}
+		if failed != nil {
+			return ErrBatchStepFailed
+		}
+		return nil
+	}
+	if atomic {
+		if err := databaseTransaction(func(tx Store) error {
+			if session != nil {
+				txSession := *session
+				txSession.txStore = tx
+				session = &txSession
+			}
+			return run()
+		}); err != nil {
+			if failed == nil {
+				failed = &HTTPBatchError{Message: err.Error()}
+			}
+			HTTPSendMessage(w, &HTTPMessage{
+				Success: false,
+				Message: ErrBatchStepFailed.Error(),
+				Count:   len(out),
+				Data:    out,
+				Errors:  failed,
+			}, http.StatusOK)
+			return
+		}
+	} else {
+		run()
+	}
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Count:   len(out),
+		Data:    out,
+	}, http.StatusOK)
+}
+
+// httpBatchStep returns the index of a step given by id or step number.
+func httpBatchStep(name string, count int, ids map[string]int) (int, bool) {
+	if i, exists := ids[name]; exists {
+		return i, true
+	}
+	step, err := strconv.Atoi(name)
+	if err != nil || step < 1 || step > count {
+		return 0, false
+	}
+	return step - 1, true
+}
+
+// httpBatchDependencies collects the dependencies of request i from its depends on list and references.
+func httpBatchDependencies(i int, requests []HTTPBatchPayload, ids map[string]int) httpBatchDeps {
+	out := httpBatchDeps{}
+	seen := make(map[int]bool)
+	add := func(name string) bool {
+		step, ok := httpBatchStep(name, len(requests), ids)
+		if !ok {
+			out.err = fmt.Errorf("%w: %s", ErrBatchInvalidRef, name)
+			return false
+		}
+		if step == i {
+			out.err = fmt.Errorf("%w: %s", ErrBatchDependencyCycle, name)
+			return false
+		}
+		if !seen[step] {
+			seen[step] = true
+			out.steps = append(out.steps, step)
+		}
+		return true
+	}
+	for _, name := range requests[i].DependsOn {
+		if add(name) {
+			step, _ := httpBatchStep(name, len(requests), ids)
+			out.required = append(out.required, step)
+		}
+	}
+	// references in payload
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			{
+				if ref, ok := v["$ref"].(string); ok && len(v) == 1 {
+					add(strings.SplitN(ref, "/", 2)[0])
+					return
+				}
+				for _, item := range v {
+					walk(item)
+				}
+				break
+			}
+		case []interface{}:
+			{
+				for _, item := range v {
+					walk(item)
+				}
+				break
+			}
+		}
+	}
+	walk(requests[i].Payload)
+	// legacy replacements only order the requests, they don't require success
+	if p, ok := requests[i].Payload.(map[string]interface{}); ok {
+		for _, v := range p {
+			s, ok := v.(string)
+			if !ok {
+				continue
+			}
+			for _, match := range httpBatchLegacyRef.FindAllStringSubmatch(s, -1) {
+				step, ok := httpBatchStep(match[1], len(requests), nil)
+				if ok && step != i {
+					add(match[1])
+					out.legacy = append(out.legacy, step)
 				}
 			}
 		}
+	}
+	return out
+}
+
+// httpBatchReplace replaces "$<step>.<key>" in a string with the value of key in the data of the given steps.
+func httpBatchReplace(rv string, steps []int, results []HTTPBatchResult) string {
+	if !strings.Contains(rv, "$") {
 		return rv
 	}
-	// itterate all requests in batch
-	for _, req := range requests {
-		hasEndpoint := false
-		for _, endpoint := range httpEndpoints {
-			if endpoint.Path == req.Path {
-				hasEndpoint = true
-				method := strings.Split(endpoint.Methods, ",")[0]
-				// process value replaces on payload
-				switch p := req.Payload.(type) {
-				case map[string]interface{}:
-					{
-						for k, v := range p {
-							switch v := v.(type) {
-							case string:
-								{
-									p[k] = replVal(v)
-									num, err := strconv.Atoi(p[k].(string))
-									if err == nil {
-										p[k] = num
-									}
-								}
-							}
-						}
-						break
-					}
+	for _, i := range steps {
+		data, ok := results[i].Data.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for k, v := range data {
+			k = fmt.Sprintf("$%d.%s", i+1, k)
+			switch v := v.(type) {
+			case string:
+				{
+					rv = strings.ReplaceAll(rv, k, v)
+					break
 				}
-				// generate url w/ query string
-				rawUrl := endpoint.Path
-				if method == "GET" {
-					queryStr := ""
-					if req.Payload != nil {
-						for k, v := range req.Payload.(map[string]interface{}) {
-							switch v := v.(type) {
-							case float64:
-								{
-									queryStr += fmt.Sprintf("&%s=%d", k, int(v))
-									break
-								}
-							case string:
-								{
-									queryStr += fmt.Sprintf("&%s=%s", k, v)
-									break
-								}
-							case bool:
-								{
-									queryStr += fmt.Sprintf("&%s=%t", k, v)
-									break
-								}
-							}
-
-						}
-						queryStr = strings.TrimLeft(queryStr, "&")
-					}
-					rawUrl += "?" + queryStr
+			case float64:
+				{
+					rv = strings.ReplaceAll(rv, k, fmt.Sprintf("%d", int(v)))
+					break
 				}
-				// build request
-				subReq, err := http.NewRequest(method, rawUrl, nil)
-				if err != nil {
-					out = append(out, HTTPMessage{Success: false, Message: err.Error()})
+			case int:
+				{
+					rv = strings.ReplaceAll(rv, k, fmt.Sprintf("%d", v))
 					break
 				}
-				if method == "POST" {
-					rawPayload, err := json.Marshal(req.Payload)
-					if err != nil {
-						out = append(out, HTTPMessage{Success: false, Message: err.Error()})
-						break
-					}
-					subReq, err = http.NewRequest(method, rawUrl, bytes.NewReader(rawPayload))
-					if err != nil {
-						out = append(out, HTTPMessage{Success: false, Message: err.Error()})
-						break
-					}
+			}
+		}
+	}
+	return rv
+}
+
+// httpBatchResolve replaces the $ref objects in a payload with the values they reference in the results
+// of other requests, the value's type is kept.
+func httpBatchResolve(v interface{}, results []HTTPBatchResult, ids map[string]int) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		{
+			if ref, exists := v["$ref"]; exists && len(v) == 1 {
+				refStr, ok := ref.(string)
+				if !ok {
+					return nil, fmt.Errorf("%w: %v", ErrBatchInvalidRef, ref)
 				}
-				for _, c := range r.Cookies() {
-					subReq.AddCookie(c)
+				return httpBatchRef(refStr, results, ids)
+			}
+			for k, item := range v {
+				resolved, err := httpBatchResolve(item, results, ids)
+				if err != nil {
+					return nil, err
 				}
-				// send request
-				w := NewBatchResponseWriter()
-				endpoint.Function(w, subReq)
-				// read response
-				rawResp, err := io.ReadAll(w.Body)
+				v[k] = resolved
+			}
+			return v, nil
+		}
+	case []interface{}:
+		{
+			for i, item := range v {
+				resolved, err := httpBatchResolve(item, results, ids)
 				if err != nil {
-					out = append(out, HTTPMessage{Success: false, Message: err.Error()})
-					break
+					return nil, err
 				}
-				resp := HTTPMessage{}
-				if err := json.Unmarshal(rawResp, &resp); err != nil {
-					out = append(out, HTTPMessage{Success: false, Message: err.Error()})
-					break
+				v[i] = resolved
+			}
+			return v, nil
+		}
+	}
+	return v, nil
+}
+
+// httpBatchRef returns the value a reference points to. References start with the id or step number of
+// the result, counted from 1, followed by a JSON pointer (RFC 6901) in to the result.
+func httpBatchRef(ref string, results []HTTPBatchResult, ids map[string]int) (interface{}, error) {
+	parts := strings.SplitN(ref, "/", 2)
+	name, pointer := parts[0], ""
+	if len(parts) > 1 {
+		pointer = parts[1]
+	}
+	step, ok := httpBatchStep(name, len(results), ids)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrBatchInvalidRef, ref)
+	}
+	if !results[step].Success {
+		return nil, fmt.Errorf("%w: %s", ErrBatchFailedRef, ref)
+	}
+	// walk the result as it was sent
+	rawResult, err := json.Marshal(results[step])
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(rawResult, &value); err != nil {
+		return nil, err
+	}
+	if pointer == "" {
+		return value, nil
+	}
+	for _, token := range strings.Split(pointer, "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch current := value.(type) {
+		case map[string]interface{}:
+			{
+				next, exists := current[token]
+				if !exists {
+					return nil, fmt.Errorf("%w: %s", ErrBatchInvalidRef, ref)
+				}
+				value = next
+				break
+			}
+		case []interface{}:
+			{
+				index, err := strconv.Atoi(token)
+				if err != nil || index < 0 || index >= len(current) {
+					return nil, fmt.Errorf("%w: %s", ErrBatchInvalidRef, ref)
 				}
-				out = append(out, resp)
+				value = current[index]
 				break
 			}
+		default:
+			{
+				return nil, fmt.Errorf("%w: %s", ErrBatchInvalidRef, ref)
+			}
 		}
-		if !hasEndpoint {
-			out = append(out, HTTPMessage{
-				Success: false,
-				Message: "Endpoint not found.",
-			})
+	}
+	return value, nil
+}
+
+// httpBatchSend sends a request of a batch to its endpoint with the credentials and session of the batch request.
+func httpBatchSend(r *http.Request, req HTTPBatchPayload, session *Session) HTTPMessage {
+	for _, endpoint := range httpEndpoints {
+		if endpoint.Path != req.Path {
+			continue
 		}
+		method := strings.Split(endpoint.Methods, ",")[0]
+		// generate url w/ query string
+		rawUrl := endpoint.Path
+		if method == "GET" {
+			queryStr := ""
+			if req.Payload != nil {
+				for k, v := range req.Payload.(map[string]interface{}) {
+					switch v := v.(type) {
+					case float64:
+						{
+							queryStr += fmt.Sprintf("&%s=%d", k, int(v))
+							break
+						}
+					case string:
+						{
+							queryStr += fmt.Sprintf("&%s=%s", k, v)
+							break
+						}
+					case bool:
+						{
+							queryStr += fmt.Sprintf("&%s=%t", k, v)
+							break
+						}
+					}
+
+				}
+				queryStr = strings.TrimLeft(queryStr, "&")
+			}
+			rawUrl += "?" + queryStr
+		}
+		// build request
+		subReq, err := http.NewRequest(method, rawUrl, nil)
+		if err != nil {
+			return HTTPMessage{Success: false, Message: err.Error()}
+		}
+		if method == "POST" {
+			rawPayload, err := json.Marshal(req.Payload)
+			if err != nil {
+				return HTTPMessage{Success: false, Message: err.Error()}
+			}
+			subReq, err = http.NewRequest(method, rawUrl, bytes.NewReader(rawPayload))
+			if err != nil {
+				return HTTPMessage{Success: false, Message: err.Error()}
+			}
+		}
+		for _, c := range r.Cookies() {
+			subReq.AddCookie(c)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			subReq.Header.Set("Authorization", auth)
+		}
+		subReq = subReq.WithContext(context.WithValue(subReq.Context(), httpSessionContextKey{}, session))
+		// send request
+		w := NewBatchResponseWriter()
+		endpoint.Function(w, subReq)
+		// read response
+		rawResp, err := io.ReadAll(w.Body)
+		if err != nil {
+			return HTTPMessage{Success: false, Message: err.Error()}
+		}
+		resp := HTTPMessage{}
+		if err := json.Unmarshal(rawResp, &resp); err != nil {
+			return HTTPMessage{Success: false, Message: err.Error()}
+		}
+		return resp
+	}
+	return HTTPMessage{
+		Success: false,
+		Message: "Endpoint not found.",
 	}
-	HTTPSendMessage(w, &HTTPMessage{
-		Success: true,
-		Count:   len(out),
-		Data:    out,
-	}, http.StatusOK)
 }
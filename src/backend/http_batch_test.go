@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// testBatch sends a batch request authenticated with token and decodes the response.
+func testBatch(token string, query string, requests []HTTPBatchPayload) (*HTTPMessage, error) {
+	rawPayload, err := json.Marshal(requests)
+	if err != nil {
+		return nil, err
+	}
+	r, _ := http.NewRequest("POST", "/api/batch"+query, bytes.NewReader(rawPayload))
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	HTTPBatch(w, r)
+	out := &HTTPMessage{}
+	if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func TestHTTPBatchAtomic(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	if err := databaseStoreOne(nil, &testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	_, token, err := NewAPIToken("Batch", UserPermission{PermAdmin}, time.Time{}, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	requests := []HTTPBatchPayload{
+		{Path: "/api/tree/store", Payload: map[string]interface{}{"type": "form", "team": testUser.Team.String(), "label": "Atomic"}},
+		{Path: "/api/rule_template/delete", Payload: map[string]interface{}{"id": ""}},
+	}
+	// atomic batch rolls back the stored form
+	res, err := testBatch(token, "?atomic=true", requests)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	batchErr, _ := res.Errors.(map[string]interface{})
+	if res.Success || res.Count != 2 || batchErr == nil || batchErr["step"] != float64(2) || batchErr["path"] != "/api/rule_template/delete" {
+		t.Errorf("expected second step to fail, got %+v", res)
+		return
+	}
+	for _, dataType := range []interface{}{TreeRoot{}, TreeVersion{}, AuditLog{}} {
+		if count, _ := databaseCount(nil, dataType, bson.M{}); count != 0 {
+			t.Errorf("expected %T to be rolled back, found %d", dataType, count)
+		}
+	}
+	// requests that don't act as the session's user can't be part of the transaction
+	res, err = testBatch(token, "?atomic=true", []HTTPBatchPayload{{Path: "/api/user/logout"}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if res.Success || res.Message != ErrBatchNoTransaction.Error() {
+		t.Errorf("expected no transaction error, got %+v", res)
+	}
+	// other batches keep the changes of successful steps
+	res, err = testBatch(token, "", requests)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !res.Success || res.Count != 2 {
+		t.Errorf("expected batch to succeed, got %+v", res)
+		return
+	}
+	if count, _ := databaseCount(nil, TreeRoot{}, bson.M{}); count != 1 {
+		t.Errorf("expected stored form, found %d", count)
+	}
+}
+
+func TestHTTPBatchAtomicBolt(t *testing.T) {
+	config := testGetConfig()
+	config.DatabaseDriver = DatabaseDriverBolt
+	config.DatabasePath = filepath.Join(t.TempDir(), "test.db")
+	if err := databaseOpen(config); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	if err := sessionStoreOpen(&Config{SessionDriver: SessionDriverDatabase}); err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() { sessionStore = newMemorySessionStore() }()
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	if err := databaseStoreOne(nil, &testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	// log in, token endpoints need a session
+	w := httptest.NewRecorder()
+	if err := HTTPNewSession(w, httptest.NewRequest("POST", "/api/user/login", nil), &testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	cookies := w.Result().Cookies()
+	rawPayload, _ := json.Marshal([]HTTPBatchPayload{
+		{Path: "/api/user/token/create", Payload: map[string]interface{}{"label": "Batch"}},
+		{Path: "/api/user/logout_all"},
+		{Path: "/api/rule_template/delete", Payload: map[string]interface{}{"id": ""}},
+	})
+	r, _ := http.NewRequest("POST", "/api/batch?atomic=true", bytes.NewReader(rawPayload))
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	// writes of the steps must use the batch's transaction, they wait on it forever otherwise
+	done := make(chan struct{})
+	w = httptest.NewRecorder()
+	go func() {
+		HTTPBatch(w, r)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected atomic batch to finish")
+	}
+	res := &HTTPMessage{}
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Error(err)
+		return
+	}
+	if res.Success || res.Count != 3 {
+		t.Errorf("expected third step to fail, got %+v", res)
+	}
+	// token and session changes are rolled back
+	if count, _ := databaseCount(nil, APIToken{}, bson.M{}); count != 0 {
+		t.Errorf("expected api token to be rolled back, found %d", count)
+	}
+	if count, _ := databaseCount(nil, Session{}, bson.M{}); count != 1 {
+		t.Errorf("expected session to be kept, found %d", count)
+	}
+}
+
+func TestHTTPBatchRef(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	if err := databaseStoreOne(nil, &testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	_, token, err := NewAPIToken("Batch", UserPermission{PermAdmin}, time.Time{}, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res, err := testBatch(token, "", []HTTPBatchPayload{
+		// numeric strings without a reference stay strings
+		{Path: "/api/tree/store", Payload: map[string]interface{}{"type": "form", "team": testUser.Team.String(), "label": "2024"}},
+		{Path: "/api/tree/version/list", Payload: map[string]interface{}{"id": map[string]interface{}{"$ref": "1/data/id"}}},
+		// nested reference, version keeps its number type
+		{Path: "/api/tree/version/store", Payload: map[string]interface{}{
+			"id":      map[string]interface{}{"$ref": "1/data/id"},
+			"version": map[string]interface{}{"$ref": "2/data/0/version"},
+			"state":   "draft",
+			"tree": []interface{}{
+				map[string]interface{}{"uid": map[string]interface{}{"$ref": "1/data/id"}, "type": "root"},
+			},
+		}},
+		{Path: "/api/rule_template/delete", Payload: map[string]interface{}{"id": ""}},
+		{Path: "/api/tree/fetch", Payload: map[string]interface{}{"id": map[string]interface{}{"$ref": "4/data/id"}}},
+		{Path: "/api/tree/fetch", Payload: map[string]interface{}{"id": map[string]interface{}{"$ref": "1/data/missing"}}},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	steps, _ := res.Data.([]interface{})
+	if len(steps) != 6 {
+		t.Errorf("expected six results, got %+v", res)
+		return
+	}
+	for i, expect := range []bool{true, true, true, false, false, false} {
+		step := steps[i].(map[string]interface{})
+		if step["success"] != expect {
+			t.Errorf("expected step %d success to be %t, got %v", i+1, expect, step["message"])
+		}
+	}
+	if msg := steps[4].(map[string]interface{})["message"]; !strings.Contains(msg.(string), ErrBatchFailedRef.Error()) {
+		t.Errorf("expected failed reference error, got %v", msg)
+	}
+	if msg := steps[5].(map[string]interface{})["message"]; !strings.Contains(msg.(string), ErrBatchInvalidRef.Error()) {
+		t.Errorf("expected invalid reference error, got %v", msg)
+	}
+	if label := steps[0].(map[string]interface{})["data"].(map[string]interface{})["label"]; label != "2024" {
+		t.Errorf("expected label to be kept as a string, got %v", label)
+	}
+	version := steps[2].(map[string]interface{})["data"].(map[string]interface{})
+	tree := version["tree"].([]interface{})
+	if version["version"] != float64(1) || tree[0].(map[string]interface{})["uid"] != version["root_id"] {
+		t.Errorf("unexpected stored version %v", version)
+	}
+}
+
+func TestHTTPBatchDependencies(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	if err := databaseStoreOne(nil, &testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	_, token, err := NewAPIToken("Batch", UserPermission{PermAdmin}, time.Time{}, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res, err := testBatch(token, "?parallel=true", []HTTPBatchPayload{
+		// depends on a later step by id
+		{ID: "versions", Path: "/api/tree/version/list", Payload: map[string]interface{}{"id": map[string]interface{}{"$ref": "form/data/id"}}},
+		{ID: "form", Path: "/api/tree/store", Payload: map[string]interface{}{"type": "form", "team": testUser.Team.String(), "label": "Deps"}},
+		{ID: "other", Path: "/api/tree/store", Payload: map[string]interface{}{"type": "form", "team": testUser.Team.String(), "label": "Other"}},
+		{ID: "fail", Path: "/api/rule_template/delete", Payload: map[string]interface{}{"id": ""}},
+		{Path: "/api/tree/list", DependsOn: []string{"fail"}},
+		{ID: "a", Path: "/api/tree/list", DependsOn: []string{"b"}},
+		{ID: "b", Path: "/api/tree/list", DependsOn: []string{"a"}},
+		{Path: "/api/tree/list", DependsOn: []string{"missing"}},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	steps, _ := res.Data.([]interface{})
+	if !res.Success || len(steps) != 8 {
+		t.Errorf("expected eight results, got %+v", res)
+		return
+	}
+	for i, expect := range []struct {
+		id      string
+		success bool
+		message error
+	}{
+		{"versions", true, nil},
+		{"form", true, nil},
+		{"other", true, nil},
+		{"fail", false, nil},
+		{"", false, ErrBatchFailedDependency},
+		{"a", false, ErrBatchDependencyCycle},
+		{"b", false, ErrBatchDependencyCycle},
+		{"", false, ErrBatchInvalidRef},
+	} {
+		step := steps[i].(map[string]interface{})
+		if step["success"] != expect.success || (step["id"] != nil && step["id"] != expect.id) || (step["id"] == nil && expect.id != "") {
+			t.Errorf("unexpected result at step %d, %v", i+1, step)
+		}
+		if expect.message != nil && !strings.Contains(step["message"].(string), expect.message.Error()) {
+			t.Errorf("expected step %d to fail with %s, got %v", i+1, expect.message, step["message"])
+		}
+		if _, ok := step["start_ms"].(float64); !ok {
+			t.Errorf("expected timing on step %d", i+1)
+		}
+	}
+	// referencing step runs after the step it references
+	versions, form := steps[0].(map[string]interface{}), steps[1].(map[string]interface{})
+	if versions["start_ms"].(float64) < form["start_ms"].(float64)+form["duration_ms"].(float64) {
+		t.Errorf("expected versions to be listed after form was stored")
+	}
+	// duplicate ids
+	res, err = testBatch(token, "", []HTTPBatchPayload{{ID: "a", Path: "/api/tree/list"}, {ID: "a", Path: "/api/tree/list"}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if res.Success || res.Message != ErrBatchDuplicateID.Error() {
+		t.Errorf("expected duplicate id error, got %+v", res)
+	}
+}
+
+func TestHTTPBatchSerial(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	if err := databaseStoreOne(nil, &testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	_, token, err := NewAPIToken("Batch", UserPermission{PermAdmin}, time.Time{}, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	form := TreeRoot{Type: TreeForm, Parent: testUser.Team, Label: "Serial"}
+	if err := form.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	// requests without dependencies run in order, each new version sees the one before
+	newVersion := HTTPBatchPayload{Path: "/api/tree/version/store", Payload: map[string]interface{}{"id": form.ID.String(), "state": "draft"}}
+	res, err := testBatch(token, "", []HTTPBatchPayload{newVersion, newVersion, newVersion})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	steps, _ := res.Data.([]interface{})
+	if !res.Success || len(steps) != 3 {
+		t.Errorf("expected three results, got %+v", res)
+		return
+	}
+	end := 0.0
+	for i, step := range steps {
+		step := step.(map[string]interface{})
+		if step["data"].(map[string]interface{})["version"] != float64(i+2) {
+			t.Errorf("expected step %d to store version %d, got %v", i+1, i+2, step["data"])
+		}
+		if step["start_ms"].(float64) < end {
+			t.Errorf("expected step %d to start after the step before", i+1)
+		}
+		end = step["start_ms"].(float64) + step["duration_ms"].(float64)
+	}
+	if count, _ := databaseCount(nil, TreeVersion{}, bson.M{"root_id": form.ID}); count != 4 {
+		t.Errorf("expected four versions, found %d", count)
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"fmt"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type HTTPFormSubmissionPayload struct {
@@ -38,31 +42,67 @@ func HTTPFormSubmissionFetch(w http.ResponseWriter, r *http.Request) {
 
 func HTTPFormSubmissionList(w http.ResponseWriter, r *http.Request) {
 	// get params
-	formId := r.URL.Query().Get("form")
-	userId := r.URL.Query().Get("user")
-	if formId == "" && userId == "" {
+	query := r.URL.Query()
+	filter := FormSubmissionFilter{
+		FormID: query.Get("form"),
+		UserID: query.Get("user"),
+	}
+	if filter.FormID == "" && filter.UserID == "" {
 		HTTPSendError(w, ErrHTTPMissingParam)
 		return
 	}
-	offsetStr := r.URL.Query().Get("offset")
-	offset, _ := strconv.Atoi(offsetStr)
-	if offset < 0 {
-		offset = 0
+	filter.Version, _ = strconv.Atoi(query.Get("version"))
+	if validStr := query.Get("valid"); validStr != "" {
+		valid, err := strconv.ParseBool(validStr)
+		if err != nil {
+			HTTPSendError(w, ErrHTTPInvalidPayload)
+			return
+		}
+		filter.Valid = &valid
 	}
+	var err error
+	for param, value := range map[string]*time.Time{
+		"created_from":  &filter.CreatedFrom,
+		"created_to":    &filter.CreatedTo,
+		"modified_from": &filter.ModifiedFrom,
+		"modified_to":   &filter.ModifiedTo,
+	} {
+		if *value, err = httpParseTime(query.Get(param)); err != nil {
+			HTTPSendError(w, err)
+			return
+		}
+	}
+	// answer filters, answer=<question>:<value>, contains=<question>:<text> and answered=<question>
+	for param, op := range map[string]string{"answer": AnswerEquals, "contains": AnswerContains, "answered": AnswerExists} {
+		for _, value := range query[param] {
+			answer := FormSubmissionAnswerFilter{Question: value, Op: op}
+			if op != AnswerExists {
+				i := strings.Index(value, ":")
+				if i < 0 {
+					HTTPSendError(w, ErrHTTPInvalidPayload)
+					return
+				}
+				answer.Question, answer.Value = value[:i], value[i+1:]
+			}
+			filter.Answers = append(filter.Answers, answer)
+		}
+	}
+	page := HTTPReadListPage(r)
 	// get user
 	s := HTTPGetSession(r)
 	user := s.getUser()
 	// fetch
-	res, count, err := ListFormSubmission(formId, userId, user, offset)
+	res, info, err := ListFormSubmission(filter, user, page)
 	if err != nil {
 		HTTPSendError(w, err)
 		return
 	}
 	// send results
 	HTTPSendMessage(w, &HTTPMessage{
-		Success: true,
-		Count:   count,
-		Data:    res,
+		Success:    true,
+		Count:      info.Count,
+		NextCursor: info.NextCursor,
+		Data:       res,
 	}, http.StatusOK)
 }
 
@@ -73,8 +113,8 @@ func HTTPFormSubmissionStore(w http.ResponseWriter, r *http.Request) {
 		HTTPSendError(w, err)
 		return
 	}
-	// missing params
-	if (payload.ID == "" && payload.FormID == "") || payload.FormVersion <= 0 {
+	// missing params, existing submissions keep their form and version
+	if payload.ID == "" && (payload.FormID == "" || payload.FormVersion <= 0) {
 		HTTPSendError(w, ErrHTTPInvalidPayload)
 		return
 	}
@@ -87,7 +127,6 @@ func HTTPFormSubmissionStore(w http.ResponseWriter, r *http.Request) {
 		FormID:      DatabaseIDFromString(payload.FormID),
 		FormVersion: payload.FormVersion,
 		Answers:     payload.Answers,
-		Valid:       payload.Valid,
 	}
 	if payload.ID != "" {
 		prevSubmission, err := FetchFormSubmission(payload.ID, user)
@@ -98,7 +137,24 @@ func HTTPFormSubmissionStore(w http.ResponseWriter, r *http.Request) {
 		submission.SaveCount = prevSubmission.SaveCount + 1
 		submission.Creator = prevSubmission.Creator
 		submission.Created = prevSubmission.Created
+		submission.FormID = prevSubmission.FormID
+		submission.FormVersion = prevSubmission.FormVersion
+	}
+	// validate answers and evaluate rules, valid flag from payload is not trusted
+	validation, err := submission.Validate(user)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
 	}
+	if validation.Rejected() {
+		HTTPSendMessage(w, &HTTPMessage{
+			Success: false,
+			Message: ErrSubmissionInvalidAnswers.Error(),
+			Errors:  validation.RejectedErrors(),
+		}, http.StatusBadRequest)
+		return
+	}
+	submission.Valid = validation.Valid
 	// store
 	if err := submission.Store(user); err != nil {
 		HTTPSendError(w, err)
@@ -108,6 +164,7 @@ func HTTPFormSubmissionStore(w http.ResponseWriter, r *http.Request) {
 	HTTPSendMessage(w, &HTTPMessage{
 		Success: true,
 		Data:    submission,
+		Errors:  validation.Errors,
 	}, http.StatusOK)
 }
 
@@ -142,3 +199,52 @@ func HTTPFormSubmissionDelete(w http.ResponseWriter, r *http.Request) {
 		Success: true,
 	}, http.StatusOK)
 }
+
+func HTTPFormSubmissionExport(w http.ResponseWriter, r *http.Request) {
+	// get params
+	query := r.URL.Query()
+	formId := query.Get("form")
+	if formId == "" {
+		HTTPSendError(w, ErrHTTPMissingParam)
+		return
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = ExportCSV
+	}
+	filter := SubmissionExportFilter{}
+	filter.Version, _ = strconv.Atoi(query.Get("version"))
+	if validStr := query.Get("valid"); validStr != "" {
+		valid, err := strconv.ParseBool(validStr)
+		if err != nil {
+			HTTPSendError(w, ErrHTTPInvalidPayload)
+			return
+		}
+		filter.Valid = &valid
+	}
+	var err error
+	if filter.From, err = httpParseTime(query.Get("from")); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	if filter.To, err = httpParseTime(query.Get("to")); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// get user
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	// prepare export, errors after this point can't be sent as the response has started
+	export, err := NewSubmissionExport(formId, format, filter, user)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	contentType, ext := export.ContentType()
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"submissions-%s.%s\"", export.Form.ID.String(), ext))
+	w.WriteHeader(http.StatusOK)
+	if err := export.Write(w); err != nil {
+		log.Printf("Submission export of form %s failed: %s", formId, err)
+	}
+}
@@ -22,10 +22,6 @@ func HTTPListNodeVersion(w http.ResponseWriter, r *http.Request) {
 		HTTPSendError(w, ErrHTTPInvalidSession)
 		return
 	}
-	if err := checkFetchPermission(&TreeRoot{Parent: user.Team}, user); err != nil {
-		HTTPSendError(w, err)
-		return
-	}
 	// fetch
 	nodeList, err := ListNodeVersion(id, maxVer, user)
 	if err != nil {
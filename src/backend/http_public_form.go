@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+)
+
+type HTTPPublicSubmissionPayload struct {
+	Token     string              `json:"token"`
+	Resume    string              `json:"resume"`
+	Answers   map[string][]string `json:"answers"`
+	Challenge string              `json:"challenge"`
+	Nonce     string              `json:"nonce"`
+}
+
+type HTTPPublicSubmissionResponse struct {
+	Submission *FormSubmission `json:"submission"`
+	Resume     string          `json:"resume"`
+}
+
+type HTTPPublicChallengeResponse struct {
+	Challenge  string `json:"challenge"`
+	Difficulty int    `json:"difficulty"`
+}
+
+// httpPublicRateLimit counts a request to the public endpoints and sends an error if the client made too many.
+func httpPublicRateLimit(w http.ResponseWriter, r *http.Request) bool {
+	if !publicLimiter.Allow(publicClientIP(r), publicRateLimit) {
+		HTTPSendMessage(w, &HTTPMessage{
+			Success: false, Message: ErrRateLimited.Error(),
+		}, http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+func HTTPPublicFormFetch(w http.ResponseWriter, r *http.Request) {
+	if !httpPublicRateLimit(w, r) {
+		return
+	}
+	// get token
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		HTTPSendError(w, ErrHTTPMissingParam)
+		return
+	}
+	// fetch
+	form, err := FetchPublicForm(token)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// send results
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Data:    form,
+	}, http.StatusOK)
+}
+
+func HTTPPublicChallenge(w http.ResponseWriter, r *http.Request) {
+	if !httpPublicRateLimit(w, r) {
+		return
+	}
+	challenge := ""
+	if publicProofOfWork > 0 {
+		var err error
+		if challenge, err = NewPublicChallenge(); err != nil {
+			HTTPSendError(w, err)
+			return
+		}
+	}
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Data:    HTTPPublicChallengeResponse{Challenge: challenge, Difficulty: publicProofOfWork},
+	}, http.StatusOK)
+}
+
+func HTTPPublicSubmissionFetch(w http.ResponseWriter, r *http.Request) {
+	if !httpPublicRateLimit(w, r) {
+		return
+	}
+	// get params
+	token := r.URL.Query().Get("token")
+	resume := r.URL.Query().Get("resume")
+	if token == "" || resume == "" {
+		HTTPSendError(w, ErrHTTPMissingParam)
+		return
+	}
+	// fetch
+	submission, err := FetchPublicSubmission(token, resume)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// send results
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Data:    submission,
+	}, http.StatusOK)
+}
+
+func HTTPPublicSubmissionStore(w http.ResponseWriter, r *http.Request) {
+	if !httpPublicRateLimit(w, r) {
+		return
+	}
+	// parse payload
+	payload := HTTPPublicSubmissionPayload{}
+	if err := HTTPReadPayload(r, &payload); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	if payload.Token == "" {
+		HTTPSendError(w, ErrHTTPInvalidPayload)
+		return
+	}
+	// check proof of work
+	if err := VerifyPublicChallenge(payload.Challenge, payload.Nonce, publicProofOfWork); err != nil {
+		HTTPSendMessage(w, &HTTPMessage{
+			Success: false, Message: err.Error(),
+		}, http.StatusForbidden)
+		return
+	}
+	// store
+	submission, validation, resume, err := StorePublicSubmission(payload.Token, payload.Resume, payload.Answers)
+	if err != nil {
+		if validation != nil {
+			HTTPSendMessage(w, &HTTPMessage{
+				Success: false,
+				Message: err.Error(),
+				Errors:  validation.RejectedErrors(),
+			}, http.StatusBadRequest)
+			return
+		}
+		HTTPSendError(w, err)
+		return
+	}
+	// send results
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Data:    HTTPPublicSubmissionResponse{Submission: submission, Resume: resume},
+		Errors:  validation.Errors,
+	}, http.StatusOK)
+}
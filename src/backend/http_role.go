@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+)
+
+type HTTPRolePayload struct {
+	ID         string         `json:"id"`
+	Label      string         `json:"label"`
+	Permission UserPermission `json:"permission"`
+}
+
+func HTTPRoleFetch(w http.ResponseWriter, r *http.Request) {
+	// get id
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		HTTPSendError(w, ErrHTTPMissingParam)
+		return
+	}
+	// get user
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	// fetch
+	role, err := FetchRole(id, user)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// send results
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Data:    role,
+	}, http.StatusOK)
+}
+
+func HTTPRoleList(w http.ResponseWriter, r *http.Request) {
+	// get page
+	page := HTTPReadListPage(r)
+	// get user
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	// fetch
+	res, info, err := ListRole(user, page)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	HTTPSendMessage(w, &HTTPMessage{
+		Success:    true,
+		Count:      info.Count,
+		NextCursor: info.NextCursor,
+		Data:       res,
+	}, http.StatusOK)
+}
+
+func HTTPRoleStore(w http.ResponseWriter, r *http.Request) {
+	// parse payload
+	payload := HTTPRolePayload{}
+	if err := HTTPReadPayload(r, &payload); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// get user
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	if user == nil {
+		HTTPSendError(w, ErrHTTPLoginRequired)
+		return
+	}
+	// build + validate
+	role := Role{
+		ID:         DatabaseIDFromString(payload.ID),
+		Label:      payload.Label,
+		Permission: payload.Permission,
+		Team:       user.Team,
+	}
+	// store
+	if err := role.Store(user); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// send results
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Data:    role,
+	}, http.StatusOK)
+}
+
+func HTTPRoleDelete(w http.ResponseWriter, r *http.Request) {
+	// parse payload
+	payload := HTTPRolePayload{}
+	if err := HTTPReadPayload(r, &payload); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// missing id
+	if payload.ID == "" {
+		HTTPSendError(w, ErrHTTPInvalidPayload)
+		return
+	}
+	// get user
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	// fetch
+	role, err := FetchRole(payload.ID, user)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// delete
+	if err := role.Delete(user); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// send results
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+	}, http.StatusOK)
+}
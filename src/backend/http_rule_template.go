@@ -2,7 +2,6 @@ package main
 
 import (
 	"net/http"
-	"strconv"
 )
 
 type HTTPRuleTemplatePayload struct {
@@ -35,25 +34,22 @@ func HTTPRuleTemplateFetch(w http.ResponseWriter, r *http.Request) {
 }
 
 func HTTPRuleTemplateList(w http.ResponseWriter, r *http.Request) {
-	// get offset
-	offsetStr := r.URL.Query().Get("offset")
-	offset, _ := strconv.Atoi(offsetStr)
-	if offset < 0 {
-		offset = 0
-	}
+	// get page
+	page := HTTPReadListPage(r)
 	// get user
 	s := HTTPGetSession(r)
 	user := s.getUser()
 	// fetch
-	res, count, err := ListRuleTemplate(user, offset)
+	res, info, err := ListRuleTemplate(user, page)
 	if err != nil {
 		HTTPSendError(w, err)
 		return
 	}
 	HTTPSendMessage(w, &HTTPMessage{
-		Success: true,
-		Count:   count,
-		Data:    res,
+		Success:    true,
+		Count:      info.Count,
+		NextCursor: info.NextCursor,
+		Data:       res,
 	}, http.StatusOK)
 }
 
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func HTTPSearch(w http.ResponseWriter, r *http.Request) {
+	// get params
+	query := r.URL.Query()
+	q := query.Get("q")
+	if strings.TrimSpace(q) == "" {
+		HTTPSendError(w, ErrHTTPMissingParam)
+		return
+	}
+	types := make([]string, 0)
+	if typeStr := query.Get("type"); typeStr != "" {
+		types = strings.Split(typeStr, ",")
+	}
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	// get user
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	// search
+	res, err := Search(q, types, limit, user)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// send results
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Count:   len(res),
+		Data:    res,
+	}, http.StatusOK)
+}
@@ -1,43 +1,63 @@
 package main
 
 import (
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 const httpSessionCookieName = "ccde_session"
-const httpSessionExpire = 14400 // 4 hours
+const httpSessionExpire = 14400              // 4 hours of inactivity
+const httpSessionMaxAge = 604800             // 7 days
+const httpSessionTouchInterval = time.Minute // minimum time between activity updates
+const httpBearerPrefix = "Bearer "
 
-type httpSession struct {
-	id      string
-	created time.Time
-}
-
-var httpSessions = map[string]httpSession{}
+// httpSessionContextKey is the request context key of the session of a batch request.
+type httpSessionContextKey struct{}
 
-func HTTPNewSession(w http.ResponseWriter, user *User) {
+func HTTPNewSession(w http.ResponseWriter, r *http.Request, user *User) error {
 	// clean up sessions before creating a new one
-	httpCleanUpSessions()
+	if err := sessionStore.CleanUp(); err != nil {
+		log.Printf("Session clean up failed: %s", err)
+	}
 	// generate token
 	sessionToken := uuid.NewString()
 	// store session
-	httpSessions[sessionToken] = httpSession{
-		id:      user.ID.String(),
-		created: time.Now(),
+	now := time.Now()
+	session := &Session{
+		TokenHash:  sessionTokenHash(sessionToken),
+		ID:         uuid.NewString(),
+		User:       user.ID,
+		Team:       user.Team,
+		Created:    now,
+		LastActive: now,
+		Expires:    now.Add(time.Second * httpSessionExpire),
+		IP:         r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+	}
+	if err := sessionStore.Store(nil, session); err != nil {
+		return err
 	}
 	// set session cookie
 	http.SetCookie(w, &http.Cookie{
 		Name:     httpSessionCookieName,
 		Value:    sessionToken,
-		Expires:  time.Now().Add(time.Second * httpSessionExpire),
+		Expires:  now.Add(time.Second * httpSessionMaxAge),
 		Path:     "/api",
 		SameSite: http.SameSiteStrictMode,
 	})
+	return nil
 }
 
-func HTTPExpireSession(w http.ResponseWriter) {
+func HTTPExpireSession(w http.ResponseWriter, r *http.Request, tx Store) {
+	if c, err := r.Cookie(httpSessionCookieName); err == nil {
+		if err := sessionStore.Delete(tx, sessionTokenHash(c.Value)); err != nil {
+			log.Printf("Session delete failed: %s", err)
+		}
+	}
 	http.SetCookie(w, &http.Cookie{
 		Name:     httpSessionCookieName,
 		Value:    "",
@@ -47,33 +67,54 @@ func HTTPExpireSession(w http.ResponseWriter) {
 	})
 }
 
-func HTTPGetSession(r *http.Request) httpSession {
+// HTTPGetSession returns the session for the request, nil if there is no valid session.
+// Active sessions have their expiry extended.
+func HTTPGetSession(r *http.Request) *Session {
+	// requests of a batch use the session of the batch
+	if session, ok := r.Context().Value(httpSessionContextKey{}).(*Session); ok {
+		return session
+	}
+	// api token
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, httpBearerPrefix) {
+		return httpGetAPITokenSession(strings.TrimPrefix(auth, httpBearerPrefix))
+	}
 	c, err := r.Cookie(httpSessionCookieName)
 	if err != nil {
-		return httpSession{}
+		return nil
 	}
-	return httpSessions[c.Value]
-}
-
-func (s httpSession) hasExpired() bool {
-	expireTime := s.created.Add(time.Second * httpSessionExpire)
-	return time.Now().After(expireTime)
-}
-
-func (s httpSession) getUser() *User {
-	if s.id == "" {
+	session, err := sessionStore.Fetch(sessionTokenHash(c.Value))
+	if err != nil {
+		return nil
+	}
+	if session.hasExpired() || time.Now().After(session.Created.Add(time.Second*httpSessionMaxAge)) {
+		sessionStore.Delete(nil, session.TokenHash)
 		return nil
 	}
-	user, _ := FetchUserByID(s.id)
-	return user
+	// sliding expiry
+	if time.Since(session.LastActive) > httpSessionTouchInterval {
+		session.LastActive = time.Now()
+		session.Expires = session.LastActive.Add(time.Second * httpSessionExpire)
+		if err := sessionStore.Store(nil, session); err != nil {
+			log.Printf("Session update failed: %s", err)
+		}
+	}
+	return session
 }
 
-func httpCleanUpSessions() {
-	for t := range httpSessions {
-		if httpSessions[t].hasExpired() {
-			delete(httpSessions, t)
-			httpCleanUpSessions()
-			break
-		}
+func httpGetAPITokenSession(token string) *Session {
+	apiToken, err := FetchAPITokenByToken(strings.TrimSpace(token))
+	if err != nil {
+		return nil
+	}
+	if err := apiToken.touch(nil); err != nil {
+		log.Printf("API token update failed: %s", err)
+	}
+	return &Session{
+		User:       apiToken.User,
+		Team:       apiToken.Team,
+		Created:    apiToken.Created,
+		LastActive: apiToken.LastUsed,
+		Expires:    apiToken.Expires,
+		APIToken:   apiToken,
 	}
 }
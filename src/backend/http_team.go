@@ -2,7 +2,6 @@ package main
 
 import (
 	"net/http"
-	"strconv"
 )
 
 type HTTPTeamPayload struct {
@@ -72,12 +71,8 @@ func HTTPTeamStore(w http.ResponseWriter, r *http.Request) {
 }
 
 func HTTPTeamUsers(w http.ResponseWriter, r *http.Request) {
-	// get offset param
-	offsetStr := r.URL.Query().Get("offset")
-	offset, _ := strconv.Atoi(offsetStr)
-	if offset < 0 {
-		offset = 0
-	}
+	// get page
+	page := HTTPReadListPage(r)
 	// get user from session
 	s := HTTPGetSession(r)
 	user := s.getUser()
@@ -86,7 +81,35 @@ func HTTPTeamUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// fetch team users
-	userList, count, err := ListUserTeam(user, offset)
+	userList, info, err := ListUserTeam(user, page)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// send success response
+	HTTPSendMessage(w, &HTTPMessage{
+		Success:    true,
+		Count:      info.Count,
+		NextCursor: info.NextCursor,
+		Data:       userList,
+	}, http.StatusOK)
+}
+
+func HTTPTeamSessions(w http.ResponseWriter, r *http.Request) {
+	// get user from session
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	if user == nil {
+		HTTPSendError(w, ErrHTTPLoginRequired)
+		return
+	}
+	// admin only
+	if !user.HasPermission(PermAdmin) {
+		HTTPSendError(w, ErrInvalidPermission)
+		return
+	}
+	// fetch active sessions
+	sessions, err := sessionStore.ListTeam(user.getStore(), user.Team)
 	if err != nil {
 		HTTPSendError(w, err)
 		return
@@ -94,7 +117,7 @@ func HTTPTeamUsers(w http.ResponseWriter, r *http.Request) {
 	// send success response
 	HTTPSendMessage(w, &HTTPMessage{
 		Success: true,
-		Count:   count,
-		Data:    userList,
+		Count:   len(sessions),
+		Data:    sessions,
 	}, http.StatusOK)
 }
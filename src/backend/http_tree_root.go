@@ -2,15 +2,16 @@ package main
 
 import (
 	"net/http"
-	"strconv"
 )
 
 type HTTPTreeRootPayload struct {
-	ID    string `json:"id"`
-	Team  string `json:"team"`
-	Form  string `json:"form"`
-	Type  string `json:"type"`
-	Label string `json:"label"`
+	ID     string         `json:"id"`
+	Team   string         `json:"team"`
+	Form   string         `json:"form"`
+	Type   string         `json:"type"`
+	Label  string         `json:"label"`
+	ACL    []TreeACLEntry `json:"acl"`
+	Public *bool          `json:"public"`
 }
 
 func HTTPTreeRootFetch(w http.ResponseWriter, r *http.Request) {
@@ -37,12 +38,8 @@ func HTTPTreeRootFetch(w http.ResponseWriter, r *http.Request) {
 }
 
 func HTTPTreeRootList(w http.ResponseWriter, r *http.Request) {
-	// get offset
-	offsetStr := r.URL.Query().Get("offset")
-	offset, _ := strconv.Atoi(offsetStr)
-	if offset < 0 {
-		offset = 0
-	}
+	// get page
+	page := HTTPReadListPage(r)
 	// require published items only
 	requirePublished := r.URL.Query().Get("published") != ""
 	// get user
@@ -51,7 +48,7 @@ func HTTPTreeRootList(w http.ResponseWriter, r *http.Request) {
 	// determine type + fetch
 	var res []*TreeRoot
 	var err error
-	count := 0
+	info := ListPageInfo{}
 	rootType := r.URL.Query().Get("type")
 	formId := r.URL.Query().Get("form")
 	switch rootType {
@@ -62,19 +59,19 @@ func HTTPTreeRootList(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			if requirePublished {
-				res, count, err = ListPublishedDocumentRoot(formId, user, offset)
+				res, info, err = ListPublishedDocumentRoot(formId, user, page)
 				break
 			}
-			res, count, err = ListDocumentRoot(formId, user, offset)
+			res, info, err = ListDocumentRoot(formId, user, page)
 			break
 		}
 	default:
 		{
 			if requirePublished {
-				res, count, err = ListPublishedFormRoot(user, offset)
+				res, info, err = ListPublishedFormRoot(user, page)
 				break
 			}
-			res, count, err = ListFormRoot(user, offset)
+			res, info, err = ListFormRoot(user, page)
 			break
 		}
 	}
@@ -92,9 +89,10 @@ func HTTPTreeRootList(w http.ResponseWriter, r *http.Request) {
 	}*/
 	// send results
 	HTTPSendMessage(w, &HTTPMessage{
-		Success: true,
-		Count:   count,
-		Data:    res,
+		Success:    true,
+		Count:      info.Count,
+		NextCursor: info.NextCursor,
+		Data:       res,
 	}, http.StatusOK)
 }
 
@@ -110,6 +108,10 @@ func HTTPTreeRootStore(w http.ResponseWriter, r *http.Request) {
 	treeRoot := TreeRoot{
 		ID:    treeRootId,
 		Label: payload.Label,
+		ACL:   payload.ACL,
+	}
+	if payload.Public != nil {
+		treeRoot.Public = *payload.Public
 	}
 	switch payload.Type {
 	case string(TreeForm):
@@ -141,6 +143,23 @@ func HTTPTreeRootStore(w http.ResponseWriter, r *http.Request) {
 	// get user
 	s := HTTPGetSession(r)
 	user := s.getUser()
+	// keep creator, access control list and sharing when updating
+	if !treeRoot.ID.IsEmpty() {
+		existing, err := FetchTreeRoot(payload.ID, user)
+		if err != nil {
+			HTTPSendError(w, err)
+			return
+		}
+		treeRoot.Created = existing.Created
+		treeRoot.Creator = existing.Creator
+		if payload.ACL == nil {
+			treeRoot.ACL = existing.ACL
+		}
+		if payload.Public == nil {
+			treeRoot.Public = existing.Public
+		}
+		treeRoot.ShareToken = existing.ShareToken
+	}
 	// store
 	if err := treeRoot.Store(user); err != nil {
 		HTTPSendError(w, err)
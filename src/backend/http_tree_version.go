@@ -1,15 +1,21 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type HTTPTreeVersionPayload struct {
-	RootID  string `json:"id"`
-	Version int    `json:"version"`
-	State   string `json:"state"`
-	Tree    []Node `json:"tree"`
+	RootID      string  `json:"id"`
+	Version     int     `json:"version"`
+	State       string  `json:"state"`
+	Tree        []Node  `json:"tree"`
+	Comment     string  `json:"comment"`
+	PublishAt   *string `json:"publish_at"`
+	UnpublishAt *string `json:"unpublish_at"`
 }
 
 func HTTPTreeVersionFetch(w http.ResponseWriter, r *http.Request) {
@@ -50,25 +56,22 @@ func HTTPTreeVersionList(w http.ResponseWriter, r *http.Request) {
 		HTTPSendError(w, ErrHTTPMissingParam)
 		return
 	}
-	offsetStr := r.URL.Query().Get("offset")
-	offset, _ := strconv.Atoi(offsetStr)
-	if offset < 0 {
-		offset = 0
-	}
+	page := HTTPReadListPage(r)
 	// get user
 	s := HTTPGetSession(r)
 	user := s.getUser()
 	// fetch
-	res, count, err := ListTreeVersion(id, user, offset)
+	res, info, err := ListTreeVersion(id, user, page)
 	if err != nil {
 		HTTPSendError(w, err)
 		return
 	}
 	// send results
 	HTTPSendMessage(w, &HTTPMessage{
-		Success: true,
-		Count:   count,
-		Data:    res,
+		Success:    true,
+		Count:      info.Count,
+		NextCursor: info.NextCursor,
+		Data:       res,
 	}, http.StatusOK)
 }
 
@@ -94,11 +97,56 @@ func HTTPTreeVersionStore(w http.ResponseWriter, r *http.Request) {
 		State:   TreeState(payload.State),
 		Tree:    payload.Tree,
 	}
+	// fetch current version
+	var current *TreeVersion
+	if treeVersion.Version > 0 {
+		var err error
+		current, err = FetchTreeVersion(payload.RootID, payload.Version, user)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			HTTPSendError(w, err)
+			return
+		}
+	}
+	if err := httpCheckTreeVersionState(&treeVersion, current, user); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// schedule, times that aren't provided are kept
+	if current != nil {
+		treeVersion.PublishAt = current.PublishAt
+		treeVersion.UnpublishAt = current.UnpublishAt
+		treeVersion.ScheduledBy = current.ScheduledBy
+	}
+	var err error
+	if payload.PublishAt != nil {
+		if treeVersion.PublishAt, err = httpParseTime(*payload.PublishAt); err != nil {
+			HTTPSendError(w, err)
+			return
+		}
+		treeVersion.ScheduledBy = user.ID
+	}
+	if payload.UnpublishAt != nil {
+		if treeVersion.UnpublishAt, err = httpParseTime(*payload.UnpublishAt); err != nil {
+			HTTPSendError(w, err)
+			return
+		}
+	}
+	// versions that aren't live yet are stored as draft and go through Publish
+	publish := treeVersion.State == TreePublished && (current == nil || current.State != TreePublished)
+	if publish {
+		treeVersion.State = TreeDraft
+	}
 	// store
 	if err := treeVersion.Store(user); err != nil {
-		HTTPSendError(w, err)
+		httpSendTreeVersionError(w, err, &treeVersion)
 		return
 	}
+	if publish {
+		if err := treeVersion.Publish(user); err != nil {
+			httpSendTreeVersionError(w, err, &treeVersion)
+			return
+		}
+	}
 	// send results
 	HTTPSendMessage(w, &HTTPMessage{
 		Success: true,
@@ -106,6 +154,75 @@ func HTTPTreeVersionStore(w http.ResponseWriter, r *http.Request) {
 	}, http.StatusOK)
 }
 
+// httpSendTreeVersionError sends an error, tree issues are included when they blocked the action.
+func httpSendTreeVersionError(w http.ResponseWriter, err error, treeVersion *TreeVersion) {
+	if errors.Is(err, ErrTreeInvalid) {
+		HTTPSendMessage(w, &HTTPMessage{
+			Success: false,
+			Message: err.Error(),
+			Errors:  treeVersion.Issues,
+		}, http.StatusBadRequest)
+		return
+	}
+	HTTPSendError(w, err)
+}
+
+// httpCheckTreeVersionState prevents the review workflow from being bypassed by storing a state directly.
+// Editing a version pending review returns it to draft. The tree of a published or archived version can't
+// be changed, changes have to go through a new version, and they can only be archived or (re)published.
+func httpCheckTreeVersionState(treeVersion *TreeVersion, current *TreeVersion, user *User) error {
+	currentState := TreeState("")
+	if current != nil {
+		currentState = current.State
+	}
+	if currentState == TreePendingReview {
+		treeVersion.State = TreeDraft
+		return nil
+	}
+	if currentState == TreePublished || currentState == TreeArchived {
+		diff := DiffTreeVersion(current, treeVersion)
+		if len(diff.Nodes) > 0 {
+			return ErrInvalidTreeState
+		}
+		switch treeVersion.State {
+		case TreeArchived:
+			{
+				return nil
+			}
+		case TreePublished:
+			{
+				// archived versions are published again like new ones
+				if currentState == TreePublished {
+					return nil
+				}
+				break
+			}
+		default:
+			{
+				return ErrInvalidTreeState
+			}
+		}
+	}
+	switch treeVersion.State {
+	case TreePendingReview:
+		{
+			return ErrInvalidTreeState
+		}
+	case TreePublished:
+		{
+			requireApproval, err := treeVersion.RequiresApproval(user.getStore())
+			if err != nil {
+				return err
+			}
+			if requireApproval {
+				return ErrApprovalRequired
+			}
+			break
+		}
+	}
+	return nil
+}
+
 func HTTPTreeVersionDelete(w http.ResponseWriter, r *http.Request) {
 	// parse payload
 	payload := HTTPTreeVersionPayload{}
@@ -161,12 +278,228 @@ func HTTPTreeVersionPublish(w http.ResponseWriter, r *http.Request) {
 	}
 	// publish
 	if err := treeVersion.Publish(user); err != nil {
+		httpSendTreeVersionError(w, err, treeVersion)
+		return
+	}
+	// send results
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Data:    treeVersion,
+	}, http.StatusOK)
+}
+
+func HTTPTreeVersionRequestReview(w http.ResponseWriter, r *http.Request) {
+	// parse payload
+	payload := HTTPTreeVersionPayload{}
+	if err := HTTPReadPayload(r, &payload); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// missing uid or version
+	if payload.RootID == "" || payload.Version <= 0 {
+		HTTPSendError(w, ErrHTTPInvalidPayload)
+		return
+	}
+	// get user
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	// fetch
+	treeVersion, err := FetchTreeVersion(payload.RootID, payload.Version, user)
+	if err != nil {
 		HTTPSendError(w, err)
 		return
 	}
+	// request review
+	if err := treeVersion.RequestReview(user, payload.Comment); err != nil {
+		httpSendTreeVersionError(w, err, treeVersion)
+		return
+	}
 	// send results
 	HTTPSendMessage(w, &HTTPMessage{
 		Success: true,
 		Data:    treeVersion,
 	}, http.StatusOK)
 }
+
+func HTTPTreeVersionApprove(w http.ResponseWriter, r *http.Request) {
+	// parse payload
+	payload := HTTPTreeVersionPayload{}
+	if err := HTTPReadPayload(r, &payload); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// missing uid or version
+	if payload.RootID == "" || payload.Version <= 0 {
+		HTTPSendError(w, ErrHTTPInvalidPayload)
+		return
+	}
+	// get user
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	// fetch
+	treeVersion, err := FetchTreeVersion(payload.RootID, payload.Version, user)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// approve
+	if err := treeVersion.Approve(user, payload.Comment); err != nil {
+		httpSendTreeVersionError(w, err, treeVersion)
+		return
+	}
+	// send results
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Data:    treeVersion,
+	}, http.StatusOK)
+}
+
+func HTTPTreeVersionReject(w http.ResponseWriter, r *http.Request) {
+	// parse payload
+	payload := HTTPTreeVersionPayload{}
+	if err := HTTPReadPayload(r, &payload); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// missing uid or version
+	if payload.RootID == "" || payload.Version <= 0 {
+		HTTPSendError(w, ErrHTTPInvalidPayload)
+		return
+	}
+	// get user
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	// fetch
+	treeVersion, err := FetchTreeVersion(payload.RootID, payload.Version, user)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// reject
+	if err := treeVersion.Reject(user, payload.Comment); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// send results
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Data:    treeVersion,
+	}, http.StatusOK)
+}
+
+func HTTPTreeVersionCopy(w http.ResponseWriter, r *http.Request) {
+	// parse payload
+	payload := HTTPTreeVersionPayload{}
+	if err := HTTPReadPayload(r, &payload); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// missing uid or version
+	if payload.RootID == "" || payload.Version <= 0 {
+		HTTPSendError(w, ErrHTTPInvalidPayload)
+		return
+	}
+	// get user
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	// fetch
+	treeVersion, err := FetchTreeVersion(payload.RootID, payload.Version, user)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// copy
+	res, err := treeVersion.Copy(user)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// send results
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Data:    res,
+	}, http.StatusOK)
+}
+
+func HTTPTreeVersionRollback(w http.ResponseWriter, r *http.Request) {
+	// parse payload
+	payload := HTTPTreeVersionPayload{}
+	if err := HTTPReadPayload(r, &payload); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// missing uid or version
+	if payload.RootID == "" || payload.Version <= 0 {
+		HTTPSendError(w, ErrHTTPInvalidPayload)
+		return
+	}
+	// get user
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	// fetch
+	treeVersion, err := FetchTreeVersion(payload.RootID, payload.Version, user)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// rollback
+	res, err := treeVersion.Rollback(user)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// send results
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Data:    res,
+	}, http.StatusOK)
+}
+
+func HTTPTreeVersionDiff(w http.ResponseWriter, r *http.Request) {
+	// get params
+	query := r.URL.Query()
+	id := query.Get("id")
+	if id == "" {
+		HTTPSendError(w, ErrHTTPMissingParam)
+		return
+	}
+	fromVer, _ := strconv.Atoi(query.Get("from"))
+	toVer, _ := strconv.Atoi(query.Get("to"))
+	// get user
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	// fetch (compare latest version against latest published version by default)
+	var err error
+	var from, to *TreeVersion
+	if fromVer > 0 {
+		from, err = FetchTreeVersion(id, fromVer, user)
+	} else {
+		from, err = FetchTreeVersionLatestPublished(id, user)
+	}
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	if toVer > 0 {
+		to, err = FetchTreeVersion(id, toVer, user)
+	} else {
+		to, err = FetchTreeVersionLatest(id, user)
+	}
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	diff := DiffTreeVersion(from, to)
+	// send results
+	if query.Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(diff.Unified()))
+		return
+	}
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Count:   len(diff.Nodes),
+		Data:    diff,
+	}, http.StatusOK)
+}
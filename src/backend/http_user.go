@@ -8,12 +8,18 @@ type HTTPUserLoginPayload struct {
 	Team     string `json:"team"`
 }
 
+type HTTPUserTokenPayload struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
 type HTTPUserPayload struct {
 	ID         string         `json:"id"`
 	Team       string         `json:"team,omitempty"`
 	Email      string         `json:"email"`
 	Password   string         `json:"password"`
 	Permission UserPermission `json:"permission"`
+	Roles      []string       `json:"roles"`
 }
 
 func HTTPUserLogin(w http.ResponseWriter, r *http.Request) {
@@ -29,7 +35,7 @@ func HTTPUserLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// fetch user
-	user, err := FetchUserByTeamEmail(payload.Team, payload.Email)
+	user, err := FetchUserByTeamEmail(payload.Team, payload.Email, nil)
 	if err != nil {
 		HTTPSendError(w, ErrInvalidCredentials)
 		return
@@ -39,8 +45,15 @@ func HTTPUserLogin(w http.ResponseWriter, r *http.Request) {
 		HTTPSendError(w, ErrInvalidCredentials)
 		return
 	}
+	if user.Pending != "" {
+		HTTPSendError(w, ErrUserPending)
+		return
+	}
 	// set session
-	HTTPNewSession(w, user)
+	if err := HTTPNewSession(w, r, user); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
 	// send success response
 	HTTPSendMessage(w, &HTTPMessage{
 		Success: true,
@@ -48,7 +61,26 @@ func HTTPUserLogin(w http.ResponseWriter, r *http.Request) {
 }
 
 func HTTPUserLogout(w http.ResponseWriter, r *http.Request) {
-	HTTPExpireSession(w)
+	HTTPExpireSession(w, r, nil)
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+	}, http.StatusOK)
+}
+
+func HTTPUserLogoutAll(w http.ResponseWriter, r *http.Request) {
+	// must be logged in
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	if user == nil {
+		HTTPSendError(w, ErrHTTPLoginRequired)
+		return
+	}
+	// delete all sessions of user
+	if err := sessionStore.DeleteUser(user.getStore(), user.ID); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	HTTPExpireSession(w, r, user.getStore())
 	HTTPSendMessage(w, &HTTPMessage{
 		Success: true,
 	}, http.StatusOK)
@@ -69,13 +101,13 @@ func HTTPUserFetch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// fetch
-	fetchedUser, err := FetchUserByID(id)
+	fetchedUser, err := FetchUserByID(id, user.getStore())
 	if err != nil {
 		HTTPSendError(w, err)
 		return
 	}
-	if fetchedUser.Team != user.Team {
-		HTTPSendError(w, ErrInvalidPermission)
+	if err := checkFetchPermission(fetchedUser, user); err != nil {
+		HTTPSendError(w, err)
 		return
 	}
 	// done
@@ -105,6 +137,20 @@ func HTTPUserStore(w http.ResponseWriter, r *http.Request) {
 	if payload.Team != "" {
 		userEdit.Team = DatabaseIDFromString(payload.Team)
 	}
+	for _, role := range payload.Roles {
+		userEdit.Roles = append(userEdit.Roles, DatabaseIDFromString(role))
+	}
+	// keep pending state when updating
+	if !userEdit.ID.IsEmpty() {
+		existing, err := FetchUserByID(payload.ID, user.getStore())
+		if err != nil {
+			HTTPSendError(w, err)
+			return
+		}
+		userEdit.Pending = existing.Pending
+		userEdit.PendingTokenHash = existing.PendingTokenHash
+		userEdit.PendingExpires = existing.PendingExpires
+	}
 	// password
 	if payload.Password != "" {
 		password, err := HashPassword(payload.Password)
@@ -142,7 +188,7 @@ func HTTPUserDelete(w http.ResponseWriter, r *http.Request) {
 	s := HTTPGetSession(r)
 	user := s.getUser()
 	// fetch
-	deleteUser, err := FetchUserByID(payload.ID)
+	deleteUser, err := FetchUserByID(payload.ID, user.getStore())
 	if err != nil {
 		HTTPSendError(w, err)
 		return
@@ -171,3 +217,115 @@ func HTTPUserMe(w http.ResponseWriter, r *http.Request) {
 		Data:    user,
 	}, http.StatusOK)
 }
+
+func HTTPUserSignUp(w http.ResponseWriter, r *http.Request) {
+	if !httpPublicRateLimit(w, r) {
+		return
+	}
+	// parse payload
+	payload := HTTPUserPayload{}
+	if err := HTTPReadPayload(r, &payload); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	if payload.Team == "" || payload.Email == "" || payload.Password == "" {
+		HTTPSendError(w, ErrHTTPInvalidPayload)
+		return
+	}
+	// sign up, the verification token is sent to the user
+	user, _, err := SignUpUser(payload.Team, payload.Email, payload.Password)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// send results
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Data:    user,
+	}, http.StatusOK)
+}
+
+func HTTPUserVerify(w http.ResponseWriter, r *http.Request) {
+	if !httpPublicRateLimit(w, r) {
+		return
+	}
+	// parse payload
+	payload := HTTPUserTokenPayload{}
+	if err := HTTPReadPayload(r, &payload); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	if payload.Token == "" {
+		HTTPSendError(w, ErrHTTPInvalidPayload)
+		return
+	}
+	// verify
+	user, err := VerifyUser(payload.Token)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// send results
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Data:    user,
+	}, http.StatusOK)
+}
+
+func HTTPUserInvite(w http.ResponseWriter, r *http.Request) {
+	// parse payload
+	payload := HTTPUserPayload{}
+	if err := HTTPReadPayload(r, &payload); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	if payload.Email == "" {
+		HTTPSendError(w, ErrHTTPInvalidPayload)
+		return
+	}
+	// get user
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	// invite, the token is sent to the invited user
+	roles := make([]DatabaseID, 0)
+	for _, role := range payload.Roles {
+		roles = append(roles, DatabaseIDFromString(role))
+	}
+	invited, _, err := InviteUser(payload.Email, payload.Permission, roles, user)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// send results
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Data:    invited,
+	}, http.StatusOK)
+}
+
+func HTTPUserAccept(w http.ResponseWriter, r *http.Request) {
+	if !httpPublicRateLimit(w, r) {
+		return
+	}
+	// parse payload
+	payload := HTTPUserTokenPayload{}
+	if err := HTTPReadPayload(r, &payload); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	if payload.Token == "" || payload.Password == "" {
+		HTTPSendError(w, ErrHTTPInvalidPayload)
+		return
+	}
+	// accept
+	user, err := AcceptInvite(payload.Token, payload.Password)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// send results
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Data:    user,
+	}, http.StatusOK)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+type HTTPWebhookPayload struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+	Active bool     `json:"active"`
+}
+
+type HTTPWebhookSecretResponse struct {
+	*Webhook
+	Secret string `json:"secret"`
+}
+
+func HTTPWebhookList(w http.ResponseWriter, r *http.Request) {
+	// get user
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	// fetch
+	res, err := ListWebhook(user)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Count:   len(res),
+		Data:    res,
+	}, http.StatusOK)
+}
+
+func HTTPWebhookStore(w http.ResponseWriter, r *http.Request) {
+	// parse payload
+	payload := HTTPWebhookPayload{}
+	if err := HTTPReadPayload(r, &payload); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// get user
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	// fetch existing
+	webhook := &Webhook{}
+	if payload.ID != "" {
+		var err error
+		webhook, err = FetchWebhook(payload.ID, user)
+		if err != nil {
+			HTTPSendError(w, err)
+			return
+		}
+	}
+	webhook.URL = payload.URL
+	webhook.Events = payload.Events
+	webhook.Active = payload.Active
+	if payload.Secret != "" {
+		webhook.Secret = payload.Secret
+	}
+	// store
+	if err := webhook.Store(user); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// send results, the secret is only sent when the webhook is created
+	var data interface{} = webhook
+	if payload.ID == "" {
+		data = HTTPWebhookSecretResponse{Webhook: webhook, Secret: webhook.Secret}
+	}
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Data:    data,
+	}, http.StatusOK)
+}
+
+func HTTPWebhookRotateSecret(w http.ResponseWriter, r *http.Request) {
+	// parse payload
+	payload := HTTPWebhookPayload{}
+	if err := HTTPReadPayload(r, &payload); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	if payload.ID == "" {
+		HTTPSendError(w, ErrHTTPInvalidPayload)
+		return
+	}
+	// get user
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	// fetch
+	webhook, err := FetchWebhook(payload.ID, user)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// rotate
+	if err := webhook.RotateSecret(user); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// send results, the new secret is only ever sent here
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Data:    HTTPWebhookSecretResponse{Webhook: webhook, Secret: webhook.Secret},
+	}, http.StatusOK)
+}
+
+func HTTPWebhookDelete(w http.ResponseWriter, r *http.Request) {
+	// parse payload
+	payload := HTTPWebhookPayload{}
+	if err := HTTPReadPayload(r, &payload); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	if payload.ID == "" {
+		HTTPSendError(w, ErrHTTPInvalidPayload)
+		return
+	}
+	// get user
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	// fetch
+	webhook, err := FetchWebhook(payload.ID, user)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	// delete
+	if err := webhook.Delete(user); err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+	}, http.StatusOK)
+}
+
+func HTTPWebhookDeliveryList(w http.ResponseWriter, r *http.Request) {
+	// get params
+	id := r.URL.Query().Get("webhook")
+	if id == "" {
+		HTTPSendError(w, ErrHTTPMissingParam)
+		return
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	// get user
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	// fetch
+	res, count, err := ListWebhookDelivery(id, user, offset)
+	if err != nil {
+		HTTPSendError(w, err)
+		return
+	}
+	HTTPSendMessage(w, &HTTPMessage{
+		Success: true,
+		Count:   count,
+		Data:    res,
+	}, http.StatusOK)
+}
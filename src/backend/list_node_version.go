@@ -12,17 +12,26 @@ type NodeLookup struct {
 	Label       string `bson:"label" json:"label"`
 	Parent      string `bson:"parent" json:"parent"`
 	AnswerValue string `bson:"answer_value" json:"answer_value"`
+	DataLabel   string `bson:"data_label" json:"data_label"`
 }
 
 // ListNodeVersion lists all nodes created for given tree root up to given version.
 func ListNodeVersion(id string, maxVersion int, user *User) ([]NodeLookup, error) {
 	// collect params
 	dbId := DatabaseIDFromString(id)
-	// get collection
-	col, err := databaseCollectionFromData(TreeVersion{})
+	// check permission
+	treeRoot, err := databaseFetch(user.getStore(), TreeRoot{}, bson.M{"_id": dbId}, nil)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkFetchPermission(treeRoot, user); err != nil {
+		return nil, err
+	}
+	return listNodeVersion(user.getStore(), dbId, maxVersion)
+}
+
+// listNodeVersion lists all nodes created for given tree root up to given version without checking permission.
+func listNodeVersion(tx Store, dbId DatabaseID, maxVersion int) ([]NodeLookup, error) {
 	// build pipeline
 	filter := bson.M{"root_id": dbId}
 	if maxVersion > 0 {
@@ -38,22 +47,13 @@ func ListNodeVersion(id string, maxVersion int, user *User) ([]NodeLookup, error
 			"label":        bson.M{"$max": "$tree.label"},
 			"parent":       bson.M{"$max": "$tree.parent"},
 			"answer_value": bson.M{"$max": "$tree.data.value"},
+			"data_label":   bson.M{"$max": "$tree.data.label"},
 		}}},
 	}
 	// perform aggregate query
-	cur, err := col.Aggregate(databaseContext(), pipeline)
-	if err != nil {
-		return nil, err
-	}
-	defer cur.Close(databaseContext())
-	// decode results
 	res := make([]NodeLookup, 0)
-	for cur.Next(databaseContext()) {
-		node := NodeLookup{}
-		if err := cur.Decode(&node); err != nil {
-			return nil, err
-		}
-		res = append(res, node)
+	if err := databaseAggregate(tx, TreeVersion{}, pipeline, &res); err != nil {
+		return nil, err
 	}
 	return res, nil
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mail drivers.
+const (
+	MailDriverLog  = "log"
+	MailDriverFile = "file"
+	MailDriverSMTP = "smtp"
+)
+
+// MailMessage is a plain text email.
+type MailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails.
+type Mailer interface {
+	// Send delivers a message.
+	Send(msg MailMessage) error
+}
+
+var mailer Mailer = logMailer{}
+
+// mailerOpen sets the mailer for the mail driver in given config.
+func mailerOpen(config *Config) error {
+	switch config.MailDriver {
+	case "", MailDriverLog:
+		{
+			mailer = logMailer{}
+			break
+		}
+	case MailDriverFile:
+		{
+			if config.MailPath == "" {
+				return ErrInvalidMailDriver
+			}
+			mailer = &fileMailer{path: config.MailPath}
+			break
+		}
+	case MailDriverSMTP:
+		{
+			if config.SMTPHost == "" || config.MailFrom == "" {
+				return ErrInvalidMailDriver
+			}
+			port := config.SMTPPort
+			if port == 0 {
+				port = 25
+			}
+			mailer = smtpMailer{
+				addr:     net.JoinHostPort(config.SMTPHost, strconv.Itoa(port)),
+				host:     config.SMTPHost,
+				from:     config.MailFrom,
+				username: config.SMTPUsername,
+				password: config.SMTPPassword,
+			}
+			break
+		}
+	default:
+		{
+			return ErrInvalidMailDriver
+		}
+	}
+	return nil
+}
+
+// mailFormat formats a message as it is sent.
+func mailFormat(from string, msg MailMessage) []byte {
+	out := strings.Builder{}
+	if from != "" {
+		out.WriteString("From: " + from + "\r\n")
+	}
+	out.WriteString("To: " + msg.To + "\r\n")
+	out.WriteString("Subject: " + msg.Subject + "\r\n")
+	out.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	out.WriteString("MIME-Version: 1.0\r\n")
+	out.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	out.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(out.String())
+}
+
+// mailCheckHeader checks that a header value can't add headers to the message.
+func mailCheckHeader(values ...string) error {
+	for _, value := range values {
+		if strings.ContainsAny(value, "\r\n") {
+			return ErrObjInvalidParam
+		}
+	}
+	return nil
+}
+
+// logMailer logs messages, for development.
+type logMailer struct{}
+
+func (m logMailer) Send(msg MailMessage) error {
+	if err := mailCheckHeader(msg.To, msg.Subject); err != nil {
+		return err
+	}
+	log.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// fileMailer appends messages to a file, for development.
+type fileMailer struct {
+	path string
+	lock sync.Mutex
+}
+
+func (m *fileMailer) Send(msg MailMessage) error {
+	if err := mailCheckHeader(msg.To, msg.Subject); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "%s\r\n\r\n", mailFormat("", msg))
+	return err
+}
+
+// smtpMailer delivers messages through an smtp server, authenticating if a username is set.
+type smtpMailer struct {
+	addr     string
+	host     string
+	from     string
+	username string
+	password string
+}
+
+func (m smtpMailer) Send(msg MailMessage) error {
+	if err := mailCheckHeader(msg.To, msg.Subject); err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+	return smtp.SendMail(m.addr, auth, m.from, []string{msg.To}, mailFormat(m.from, msg))
+}
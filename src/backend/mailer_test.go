@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testMailer keeps sent messages.
+type testMailer struct {
+	sent []MailMessage
+}
+
+func (m *testMailer) Send(msg MailMessage) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// errTestMail is returned by failingMailer.
+var errTestMail = errors.New("mail failed")
+
+// failingMailer fails to send any message.
+type failingMailer struct{}
+
+func (failingMailer) Send(msg MailMessage) error {
+	return errTestMail
+}
+
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.txt")
+	if err := mailerOpen(&Config{MailDriver: MailDriverFile, MailPath: path}); err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() { mailer = logMailer{} }()
+	if err := mailer.Send(MailMessage{To: "user@example.com", Subject: "Hello", Body: "Test body"}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := mailer.Send(MailMessage{To: "user@example.com\r\nBcc: other@example.com", Subject: "Hello"}); err != ErrObjInvalidParam {
+		t.Errorf("expected header injection to be rejected")
+	}
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !strings.Contains(string(out), "To: user@example.com\r\n") || !strings.Contains(string(out), "Test body") {
+		t.Errorf("unexpected mail file contents %s", out)
+	}
+	if err := mailerOpen(&Config{MailDriver: MailDriverSMTP}); err != ErrInvalidMailDriver {
+		t.Errorf("expected incomplete smtp config to be rejected")
+	}
+}
+
+func TestUserInvite(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	sent := &testMailer{}
+	mailer = sent
+	defer func() { mailer = logMailer{} }()
+	testAdmin := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Email:      "admin@example.com",
+		Permission: UserPermission{PermAdmin},
+	}
+	if err := databaseStoreOne(nil, &testAdmin); err != nil {
+		t.Error(err)
+		return
+	}
+	testManager := User{
+		ID:         GenerateDatabaseId(),
+		Team:       testAdmin.Team,
+		Permission: UserPermission{PermManageUser},
+	}
+	// users can't invite with permissions they don't have
+	if _, _, err := InviteUser("new@example.com", UserPermission{PermAdmin}, nil, &testManager); err != ErrInvalidPermission {
+		t.Errorf("expected invalid permission error, got %v", err)
+	}
+	if _, _, err := InviteUser("admin@example.com", UserPermission{}, nil, &testAdmin); err != ErrEmailInUse {
+		t.Errorf("expected email in use error, got %v", err)
+	}
+	// invites that couldn't be sent don't block the email
+	mailer = failingMailer{}
+	if _, _, err := InviteUser("new@example.com", UserPermission{PermRead}, nil, &testAdmin); err != errTestMail {
+		t.Errorf("expected mail error, got %v", err)
+	}
+	mailer = sent
+	invited, oldToken, err := InviteUser("new@example.com", UserPermission{PermRead}, nil, &testAdmin)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// inviting again replaces the token
+	_, token, err := InviteUser("new@example.com", UserPermission{PermRead}, nil, &testAdmin)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(sent.sent) != 2 || sent.sent[1].To != "new@example.com" || !strings.Contains(sent.sent[1].Body, token) {
+		t.Errorf("expected invites to be sent")
+		return
+	}
+	if _, err := AcceptInvite(oldToken, "pw"); err != ErrInvalidToken {
+		t.Errorf("expected replaced token to be invalid, got %v", err)
+	}
+	// a concurrent accept that fetched the user before it was accepted fails
+	concurrent, err := fetchPendingUser(UserPendingInvite, token)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	accepted, err := AcceptInvite(token, "secret123")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := concurrent.clearPending(nil); err != ErrInvalidToken {
+		t.Errorf("expected concurrent accept to fail, got %v", err)
+	}
+	if accepted.ID != invited.ID || accepted.Pending != "" || accepted.CheckPassword("secret123") != nil {
+		t.Errorf("expected invite to be accepted")
+	}
+	if _, err := AcceptInvite(token, "other"); err != ErrInvalidToken {
+		t.Errorf("expected token to only be used once")
+	}
+	fetched, err := FetchUserByTeamEmail(testAdmin.Team.String(), "new@example.com", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if fetched.Pending != "" || !fetched.Permission.Has(PermRead) || fetched.CheckPassword("secret123") != nil {
+		t.Errorf("expected stored user to be active")
+	}
+}
@@ -2,19 +2,20 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"go.mongodb.org/mongo-driver/bson"
 )
 
 func createTestObjects() {
 	// check if user already exists
-	res, err := databaseCount(User{}, bson.M{})
+	res, err := databaseCount(nil, User{}, bson.M{})
 	if err != nil {
 		panic(err)
 	}
 	if res > 0 {
 		if res == 1 {
-			teamList, _, err := databaseList(Team{}, bson.M{}, nil, nil, 0)
+			teamList, _, err := databaseList(nil, Team{}, bson.M{}, nil, nil, 0)
 			if err != nil {
 				panic(err)
 			}
@@ -61,14 +62,32 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	// migrate command, copies the mongo database in to the configured embedded database
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		log.Println("Migrate database.")
+		if err := MigrateFromMongo(&config); err != nil {
+			panic(err)
+		}
+		return
+	}
 	// open database
 	log.Println("Open database.")
 	if err := databaseOpen(&config); err != nil {
 		panic(err)
 	}
 	defer databaseClose()
+	// index objects stored before search entries were kept
+	if err := searchIndexExisting(); err != nil {
+		log.Printf("Failed to index existing objects for search: %s", err)
+	}
 	// TODO this is just for testing, not for prod
 	createTestObjects()
+	// start webhook worker
+	stopWebhookWorker := WebhookStartWorker()
+	defer stopWebhookWorker()
+	// start tree version scheduler
+	stopTreeVersionScheduler := TreeVersionStartScheduler()
+	defer stopTreeVersionScheduler()
 	log.Println("Starting backend.")
 	// start http
 	if err := HTTPStart(&config); err != nil {
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Prefixes of the tokens used by public forms.
+const (
+	publicShareTokenPrefix  = "ccdp_"
+	publicResumeTokenPrefix = "ccdr_"
+)
+
+// PublicForm is what unauthenticated users get to see of a public form.
+type PublicForm struct {
+	ID            DatabaseID      `json:"id"`
+	Label         string          `json:"label"`
+	Version       int             `json:"version"`
+	Tree          []Node          `json:"tree"`
+	RuleTemplates []*RuleTemplate `json:"rule_templates"`
+}
+
+// fetchPublicFormVersion fetches the public form with given share token and its latest published version.
+func fetchPublicFormVersion(shareToken string) (*TreeRoot, *TreeVersion, error) {
+	if shareToken == "" {
+		return nil, nil, ErrPublicFormNotFound
+	}
+	res, err := databaseFetch(nil, TreeRoot{}, bson.M{"share_token": shareToken, "public": true, "type": string(TreeForm)}, nil)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil, ErrPublicFormNotFound
+		}
+		return nil, nil, err
+	}
+	treeRoot := res.(*TreeRoot)
+	res, err = databaseFetch(nil, TreeVersion{}, bson.M{"root_id": treeRoot.ID, "state": TreePublished}, bson.M{"version": -1})
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil, ErrPublicFormNotFound
+		}
+		return nil, nil, err
+	}
+	treeVersion := res.(*TreeVersion)
+	if treeVersion.IsClosed() {
+		return nil, nil, ErrFormClosed
+	}
+	// fetch rule templates
+	treeVersion.RuleTemplates, err = listRuleTemplateByID(nil, treeRoot.Parent, treeVersion.GetRuleTemplateIDs())
+	return treeRoot, treeVersion, err
+}
+
+// FetchPublicForm fetches the latest published version of the public form with given share token.
+func FetchPublicForm(shareToken string) (*PublicForm, error) {
+	treeRoot, treeVersion, err := fetchPublicFormVersion(shareToken)
+	if err != nil {
+		return nil, err
+	}
+	return &PublicForm{
+		ID:            treeRoot.ID,
+		Label:         treeRoot.Label,
+		Version:       treeVersion.Version,
+		Tree:          treeVersion.Tree,
+		RuleTemplates: treeVersion.RuleTemplates,
+	}, nil
+}
+
+// fetchPublicSubmission fetches the submission to form with given resume token.
+func fetchPublicSubmission(form DatabaseID, resumeToken string) (*FormSubmission, error) {
+	if resumeToken == "" {
+		return nil, ErrPublicSubmissionNotFound
+	}
+	res, err := databaseFetch(nil, FormSubmission{}, bson.M{"form_id": form, "resume_token": sessionTokenHash(resumeToken)}, nil)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrPublicSubmissionNotFound
+		}
+		return nil, err
+	}
+	return res.(*FormSubmission), nil
+}
+
+// FetchPublicSubmission fetches an anonymous submission to the public form with given share token.
+func FetchPublicSubmission(shareToken string, resumeToken string) (*FormSubmission, error) {
+	treeRoot, _, err := fetchPublicFormVersion(shareToken)
+	if err != nil {
+		return nil, err
+	}
+	return fetchPublicSubmission(treeRoot.ID, resumeToken)
+}
+
+// StorePublicSubmission creates an anonymous submission to the latest published version of the public form
+// with given share token, or resumes the one of resumeToken. Resumed submissions keep the version they were
+// started on. The resume token is returned, it is only generated for new submissions.
+func StorePublicSubmission(shareToken string, resumeToken string, answers map[string][]string) (*FormSubmission, *SubmissionValidation, string, error) {
+	treeRoot, treeVersion, err := fetchPublicFormVersion(shareToken)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	// fetch or create
+	submission := &FormSubmission{FormID: treeRoot.ID}
+	if resumeToken != "" {
+		if submission, err = fetchPublicSubmission(treeRoot.ID, resumeToken); err != nil {
+			return nil, nil, "", err
+		}
+		if submission.FormVersion != treeVersion.Version {
+			if treeVersion, err = fetchPublicSubmissionVersion(treeRoot, submission.FormVersion); err != nil {
+				return nil, nil, "", err
+			}
+		}
+	} else {
+		if resumeToken, err = generateToken(publicResumeTokenPrefix); err != nil {
+			return nil, nil, "", err
+		}
+		submission.ResumeTokenHash = sessionTokenHash(resumeToken)
+		submission.FormVersion = treeVersion.Version
+	}
+	submission.Answers = answers
+	// validate answers and evaluate rules
+	nodeHistory, err := listNodeVersion(nil, treeRoot.ID, treeVersion.Version)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	validation := ValidateSubmission(treeVersion, nodeHistory, submission)
+	if validation.Rejected() {
+		return nil, validation, "", ErrSubmissionInvalidAnswers
+	}
+	submission.Valid = validation.Valid
+	// store
+	if !submission.ID.IsEmpty() {
+		submission.SaveCount++
+	}
+	if err := submission.store(nil); err != nil {
+		return nil, nil, "", err
+	}
+	return submission, validation, resumeToken, nil
+}
+
+// fetchPublicSubmissionVersion fetches the version of a public form a resumed submission was started on.
+func fetchPublicSubmissionVersion(treeRoot *TreeRoot, version int) (*TreeVersion, error) {
+	res, err := databaseFetch(nil, TreeVersion{}, bson.M{"root_id": treeRoot.ID, "version": version}, nil)
+	if err != nil {
+		return nil, err
+	}
+	treeVersion := res.(*TreeVersion)
+	treeVersion.RuleTemplates, err = listRuleTemplateByID(nil, treeRoot.Parent, treeVersion.GetRuleTemplateIDs())
+	return treeVersion, err
+}
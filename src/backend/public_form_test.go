@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPublicForm(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	testTreeRoot := TreeRoot{Type: TreeForm, Parent: testUser.Team, Label: "Public"}
+	if err := testTreeRoot.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	version, err := FetchTreeVersion(testTreeRoot.ID.String(), 1, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	version.Tree = getTestTree(testTreeRoot.ID.String())
+	if err := version.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := version.Publish(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	// private forms have no share token
+	if testTreeRoot.ShareToken != "" {
+		t.Errorf("expected private form to have no share token")
+	}
+	testTreeRoot.Public = true
+	if err := testTreeRoot.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	shareToken := testTreeRoot.ShareToken
+	if shareToken == "" {
+		t.Errorf("expected public form to have a share token")
+		return
+	}
+	// fetch without a user
+	form, err := FetchPublicForm(shareToken)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if form.ID != testTreeRoot.ID || form.Version != 1 || len(form.Tree) == 0 {
+		t.Errorf("unexpected public form")
+	}
+	if _, err := FetchPublicForm("ccdp_invalid"); err != ErrPublicFormNotFound {
+		t.Errorf("expected public form not found error")
+	}
+	// create and resume
+	submission, _, resumeToken, err := StorePublicSubmission(shareToken, "", map[string][]string{"node-b-1": {"draft"}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if resumeToken == "" || submission.ResumeTokenHash == resumeToken || !submission.Creator.IsEmpty() {
+		t.Errorf("expected anonymous submission with hashed resume token")
+	}
+	resumed, _, _, err := StorePublicSubmission(shareToken, resumeToken, map[string][]string{"node-b-1": {"done"}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if resumed.ID != submission.ID || resumed.SaveCount != 1 {
+		t.Errorf("expected submission to be resumed")
+	}
+	fetched, err := FetchPublicSubmission(shareToken, resumeToken)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if fetched.Answers["node-b-1"][0] != "done" {
+		t.Errorf("expected resumed answers to be stored")
+	}
+	// resumed submissions keep their version after a new one is published
+	newVersion := TreeVersion{RootID: testTreeRoot.ID, State: TreeDraft, Tree: getTestTree(testTreeRoot.ID.String())}
+	if err := newVersion.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := newVersion.Publish(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	resumed, _, _, err = StorePublicSubmission(shareToken, resumeToken, map[string][]string{"node-b-1": {"again"}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if resumed.FormVersion != 1 || resumed.SaveCount != 2 {
+		t.Errorf("expected resumed submission to keep version 1, got %d", resumed.FormVersion)
+	}
+	created, _, _, err := StorePublicSubmission(shareToken, "", map[string][]string{"node-b-1": {"new"}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if created.FormVersion != newVersion.Version {
+		t.Errorf("expected new submission to use the latest version, got %d", created.FormVersion)
+	}
+	if _, err := FetchPublicSubmission(shareToken, "ccdr_invalid"); err != ErrPublicSubmissionNotFound {
+		t.Errorf("expected public submission not found error")
+	}
+	// team can see the submission
+	if _, err := FetchFormSubmission(submission.ID.String(), &testUser); err != nil {
+		t.Error(err)
+	}
+	// making the form private revokes the link
+	testTreeRoot.Public = false
+	if err := testTreeRoot.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := FetchPublicSubmission(shareToken, resumeToken); err != ErrPublicFormNotFound {
+		t.Errorf("expected public form not found error after making form private")
+	}
+}
+
+func TestPublicRateLimit(t *testing.T) {
+	limiter := newRateLimiter(publicRateLimitWindow)
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow("127.0.0.1", 3) {
+			t.Errorf("expected request %d to be allowed", i+1)
+		}
+	}
+	if limiter.Allow("127.0.0.1", 3) {
+		t.Errorf("expected request to be rate limited")
+	}
+	// windows are kept per client
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow("127.0.0.2", 3) {
+			t.Errorf("expected other client to be allowed")
+		}
+	}
+	limiter.windows["127.0.0.1"].start = time.Now().Add(-publicRateLimitWindow)
+	if !limiter.Allow("127.0.0.1", 3) {
+		t.Errorf("expected request to be allowed once the window ended")
+	}
+	if limiter.Allow("127.0.0.2", 3) {
+		t.Errorf("expected other client to stay rate limited until its window ends")
+	}
+}
+
+func TestPublicClientIP(t *testing.T) {
+	defer func() {
+		publicClientIPHeader = ""
+		publicTrustedProxies = nil
+	}()
+	if err := publicSetTrustedProxies([]string{"nope"}); !errors.Is(err, ErrInvalidTrustedProxy) {
+		t.Errorf("expected invalid trusted proxy error, got %v", err)
+	}
+	if err := publicSetTrustedProxies([]string{"10.0.0.1", "192.168.0.0/16"}); err != nil {
+		t.Error(err)
+		return
+	}
+	request := func(remoteAddr string, forwarded string) *http.Request {
+		r := httptest.NewRequest("GET", "/api/public/form", nil)
+		r.RemoteAddr = remoteAddr
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+		return r
+	}
+	// header is ignored until configured
+	if ip := publicClientIP(request("10.0.0.1:1234", "1.2.3.4")); ip != "10.0.0.1" {
+		t.Errorf("expected connection address, got %s", ip)
+	}
+	publicClientIPHeader = "X-Forwarded-For"
+	for _, test := range []struct {
+		remoteAddr string
+		forwarded  string
+		expect     string
+	}{
+		{"10.0.0.1:1234", "1.2.3.4", "1.2.3.4"},
+		// addresses added by the client itself are skipped
+		{"10.0.0.1:1234", "5.6.7.8, 1.2.3.4, 192.168.1.1", "1.2.3.4"},
+		// untrusted connections can't pick their address
+		{"10.0.0.2:1234", "1.2.3.4", "10.0.0.2"},
+		{"10.0.0.1:1234", "", "10.0.0.1"},
+		{"10.0.0.1:1234", "unknown", "10.0.0.1"},
+	} {
+		if ip := publicClientIP(request(test.remoteAddr, test.forwarded)); ip != test.expect {
+			t.Errorf("expected %s for %s from %s, got %s", test.expect, test.forwarded, test.remoteAddr, ip)
+		}
+	}
+}
+
+func TestPublicChallenge(t *testing.T) {
+	if err := VerifyPublicChallenge("", "", 0); err != nil {
+		t.Errorf("expected proof of work to be disabled")
+	}
+	challenge, err := NewPublicChallenge()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// solve
+	difficulty := 8
+	nonce := ""
+	for i := 0; ; i++ {
+		nonce = strconv.Itoa(i)
+		if publicLeadingZeroBits(sha256.Sum256([]byte(challenge+":"+nonce))) >= difficulty {
+			break
+		}
+	}
+	if err := VerifyPublicChallenge(challenge+"0", nonce, difficulty); err != ErrProofOfWorkInvalid {
+		t.Errorf("expected tampered challenge to be rejected")
+	}
+	if err := VerifyPublicChallenge(challenge, nonce, difficulty); err != nil {
+		t.Error(err)
+	}
+	if err := VerifyPublicChallenge(challenge, nonce, difficulty); err != ErrProofOfWorkInvalid {
+		t.Errorf("expected challenge to only be used once")
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const publicRateLimitDefault = 30
+const publicRateLimitWindow = time.Minute
+const publicChallengeExpire = 10 * time.Minute
+
+// publicRateLimit is the number of requests per minute each client can make to public forms.
+var publicRateLimit = publicRateLimitDefault
+
+// publicProofOfWork is the number of leading zero bits the proof of work hash needs, 0 disables it.
+var publicProofOfWork = 0
+
+// publicClientIPHeader is the header trusted proxies put the client address in, empty to always use the
+// address of the connection.
+var publicClientIPHeader = ""
+
+// publicTrustedProxies are the networks of the proxies whose client address header is trusted.
+var publicTrustedProxies []*net.IPNet
+
+// publicSetTrustedProxies parses the addresses or CIDR ranges of the trusted proxies.
+func publicSetTrustedProxies(proxies []string) error {
+	out := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return fmt.Errorf("%w: %s", ErrInvalidTrustedProxy, proxy)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			out = append(out, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidTrustedProxy, proxy)
+		}
+		out = append(out, network)
+	}
+	publicTrustedProxies = out
+	return nil
+}
+
+// publicTrustedProxy checks if an address belongs to a trusted proxy.
+func publicTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range publicTrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// publicClientIP returns the address of the client that made a request. The client address header is only
+// read when the connection comes from a trusted proxy, proxies append to it so it is read from the right
+// and the first address that isn't a trusted proxy is the client.
+func publicClientIP(r *http.Request) string {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	if publicClientIPHeader == "" || !publicTrustedProxy(client) {
+		return client
+	}
+	addrs := strings.Split(strings.Join(r.Header.Values(publicClientIPHeader), ","), ",")
+	for i := len(addrs) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(addrs[i])
+		if net.ParseIP(addr) == nil {
+			break
+		}
+		client = addr
+		if !publicTrustedProxy(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// rateLimiter counts requests per key in fixed windows, each key's window starts with its first request.
+type rateLimiter struct {
+	lock    sync.Mutex
+	window  time.Duration
+	pruned  time.Time
+	windows map[string]*rateWindow
+}
+
+// rateWindow is the request count of a key since start.
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+var publicLimiter = newRateLimiter(publicRateLimitWindow)
+
+func newRateLimiter(window time.Duration) *rateLimiter {
+	return &rateLimiter{window: window, windows: map[string]*rateWindow{}}
+}
+
+// Allow counts a request for key and checks if it is within the limit.
+func (l *rateLimiter) Allow(key string, limit int) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	// drop ended windows, at most once per window
+	now := time.Now()
+	if now.Sub(l.pruned) >= l.window {
+		for k, w := range l.windows {
+			if now.Sub(w.start) >= l.window {
+				delete(l.windows, k)
+			}
+		}
+		l.pruned = now
+	}
+	w := l.windows[key]
+	if w == nil || now.Sub(w.start) >= l.window {
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+	w.count++
+	return w.count <= limit
+}
+
+// Proof of work challenges are signed with a secret generated at start up so that they don't need to be
+// stored, used challenges are remembered until they expire so that they can't be replayed.
+var (
+	publicChallengeSecret     []byte
+	publicChallengeSecretOnce sync.Once
+	publicChallengeUsed       = map[string]time.Time{}
+	publicChallengeLock       sync.Mutex
+)
+
+// publicChallengeSign returns the signature of a challenge.
+func publicChallengeSign(data string) string {
+	publicChallengeSecretOnce.Do(func() {
+		publicChallengeSecret = make([]byte, 32)
+		if _, err := rand.Read(publicChallengeSecret); err != nil {
+			panic(err)
+		}
+	})
+	mac := hmac.New(sha256.New, publicChallengeSecret)
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewPublicChallenge creates a proof of work challenge. A client solves it by finding a nonce for which the
+// sha256 hash of "<challenge>:<nonce>" starts with the given number of zero bits.
+func NewPublicChallenge() (string, error) {
+	rawChallenge := make([]byte, 16)
+	if _, err := rand.Read(rawChallenge); err != nil {
+		return "", err
+	}
+	data := fmt.Sprintf("%d.%s", time.Now().Add(publicChallengeExpire).Unix(), hex.EncodeToString(rawChallenge))
+	return data + "." + publicChallengeSign(data), nil
+}
+
+// VerifyPublicChallenge checks the solution to a proof of work challenge, each challenge can only be used once.
+func VerifyPublicChallenge(challenge string, nonce string, difficulty int) error {
+	if difficulty <= 0 {
+		return nil
+	}
+	// check signature and expiry
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 3 || !hmac.Equal([]byte(parts[2]), []byte(publicChallengeSign(parts[0]+"."+parts[1]))) {
+		return ErrProofOfWorkInvalid
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return ErrProofOfWorkInvalid
+	}
+	// check work
+	if publicLeadingZeroBits(sha256.Sum256([]byte(challenge+":"+nonce))) < difficulty {
+		return ErrProofOfWorkInvalid
+	}
+	// check replay
+	publicChallengeLock.Lock()
+	defer publicChallengeLock.Unlock()
+	now := time.Now()
+	for used, expires := range publicChallengeUsed {
+		if now.After(expires) {
+			delete(publicChallengeUsed, used)
+		}
+	}
+	if _, ok := publicChallengeUsed[challenge]; ok {
+		return ErrProofOfWorkInvalid
+	}
+	publicChallengeUsed[challenge] = time.Unix(expires, 0)
+	return nil
+}
+
+// publicLeadingZeroBits counts the leading zero bits of a hash.
+func publicLeadingZeroBits(hash [sha256.Size]byte) int {
+	out := 0
+	for _, b := range hash {
+		if b != 0 {
+			return out + bits.LeadingZeros8(b)
+		}
+		out += 8
+	}
+	return out
+}
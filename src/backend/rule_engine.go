@@ -0,0 +1,945 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+ * LUA API
+ * Mirrors the API provided by the webapp's rule engine (webapp/rule_engine.js).
+ * GLOBALS
+ *  this        - Function, returns DecisionNodeMT representing rule being evaluated.
+ *  parent      - Function, returns DecisionNodeMT representing parent of the rule being evaluated.
+ *  root        - Function, returns DecisionNodeMT representing root node.
+ *  find        - Function, returns DecisionNodeMT of given uid by searching all available sources (root and previous).
+ *  print       - Function, prints first argument to the log, mostly for debugging.
+ *  has         - Function, returns true if given string is uid for either a question that has one or more answers or a user provided answer.
+ *  get         - Function, returns list of uid and user inputted text answers for question of given uid.
+ *  value       - Function, returns answer value for given answer uid.
+ *  field       - Function, returns the value of a custom rule variable.
+ *  saveCount   - Function, returns number of saves.
+ *  getExtra    - Function, returns user data 'extra' value as a Lua table.
+ *
+ * DecisionNodeMT
+ *  uid         - Function, returns decision node uid.
+ *  parent      - Function, returns DecisionNodeMT representing parent node.
+ *  children    - Function, returns Lua table containing multiple DecisionNodeMT representing child nodes.
+ *  name        - Function, returns name of decision node.
+ *  value       - Function, returns the value of an answer node.
+ *  type        - Function, returns the type name of the decision node.
+ *  param       - Function, returns given parameter of the decision node.
+ *  answers     - Function, returns Lua table containing answers to question, both strings and DecisionNodeMT can be items in table.
+ *  answerValues- Function, returns Lua table containing answer values to question, this is user inputted answers and the 'value' parameter of answer nodes.
+ *  hasAnswer   - Function, returns true if given answer is in user data or given question contains an answer in user data.
+ */
+
+const ruleEngineMetatable = "DecisionNodeMT"
+const ruleEngineTimeout = 5 * time.Second
+const ruleDefaultMessage = "This field is invalid."
+
+// Rule types.
+const (
+	RuleTypeVisibility = "visibility"
+	RuleTypeValidation = "validation"
+)
+
+// Rule field types.
+const (
+	RuleFieldText   = "text"
+	RuleFieldNode   = "node"
+	RuleFieldAnswer = "answer"
+	RuleFieldChoice = "choice"
+)
+
+// RuleResult is the result of a single rule evaluation.
+type RuleResult struct {
+	Rule     string `json:"rule"`
+	Parent   string `json:"parent"`
+	Type     string `json:"type"`
+	MatrixID string `json:"matrix_id,omitempty"`
+	Results  bool   `json:"results"`
+	Message  string `json:"message,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// RuleEvaluation is the outcome of evaluating every rule in a tree version.
+type RuleEvaluation struct {
+	Valid    bool                `json:"valid"`
+	Hidden   []string            `json:"hidden"`
+	Messages map[string][]string `json:"messages"`
+	Results  []RuleResult        `json:"results"`
+}
+
+// ruleProxyNode is the node representation given to Lua scripts.
+type ruleProxyNode struct {
+	UID     string
+	Type    string
+	Version int
+	Label   string
+	Parent  string
+	Value   string
+	Data    NodeData
+}
+
+type ruleInstance struct {
+	node     *Node
+	matrixID string
+}
+
+// RuleEngine evaluates the Lua rules of a tree version against a form submission's answers.
+type RuleEngine struct {
+	L         *lua.LState
+	version   int
+	root      *Node
+	nodes     map[string]*Node
+	children  map[string][]*Node
+	history   map[string]NodeLookup
+	scripts   map[string]string
+	compiled  map[string]*lua.LFunction
+	answers   map[string][]string
+	saveCount int
+	extra     map[string]interface{}
+	hidden    map[string]bool
+	rule      *Node
+	matrixID  string
+}
+
+// NewRuleEngine creates a rule engine for given tree version and submission.
+func NewRuleEngine(treeVersion *TreeVersion, submission *FormSubmission) *RuleEngine {
+	e := &RuleEngine{
+		nodes:    make(map[string]*Node),
+		children: make(map[string][]*Node),
+		history:  make(map[string]NodeLookup),
+		scripts:  make(map[string]string),
+		compiled: make(map[string]*lua.LFunction),
+		answers:  make(map[string][]string),
+		extra:    make(map[string]interface{}),
+		hidden:   make(map[string]bool),
+	}
+	if treeVersion != nil {
+		e.version = treeVersion.Version
+		for i := range treeVersion.Tree {
+			n := &treeVersion.Tree[i]
+			if _, exists := e.nodes[n.UID]; exists {
+				continue
+			}
+			e.nodes[n.UID] = n
+			if n.Parent != "" {
+				e.children[n.Parent] = append(e.children[n.Parent], n)
+			} else if e.root == nil || n.Type == NodeRoot {
+				e.root = n
+			}
+		}
+		for _, t := range treeVersion.RuleTemplates {
+			if t != nil {
+				e.scripts[t.ID.String()] = t.Script
+			}
+		}
+	}
+	if submission != nil {
+		if submission.Answers != nil {
+			e.answers = submission.Answers
+		}
+		e.saveCount = submission.SaveCount
+	}
+	e.L = lua.NewState(lua.Options{SkipOpenLibs: true})
+	e.luaOpenLibs()
+	e.luaRegisterDecisionNode()
+	funcs := map[string]lua.LGFunction{
+		"this":      e.luaThis,
+		"root":      e.luaRoot,
+		"parent":    e.luaParent,
+		"find":      e.luaFind,
+		"get":       e.luaGetAnswers,
+		"has":       e.luaHasAnswer,
+		"value":     e.luaGetAnswerValue,
+		"print":     e.luaPrint,
+		"field":     e.luaField,
+		"saveCount": e.luaSaveCount,
+		"getExtra":  e.luaGetExtra,
+	}
+	for name, f := range funcs {
+		e.L.SetGlobal(name, e.L.NewFunction(f))
+	}
+	return e
+}
+
+// SetNodeHistory sets the nodes from previous tree versions available to 'find.'
+func (e *RuleEngine) SetNodeHistory(nodes []NodeLookup) {
+	e.history = make(map[string]NodeLookup)
+	for _, n := range nodes {
+		e.history[n.UID] = n
+	}
+}
+
+// SetExtra sets the values returned by 'getExtra.'
+func (e *RuleEngine) SetExtra(extra map[string]interface{}) {
+	e.extra = make(map[string]interface{})
+	for k, v := range extra {
+		e.extra[k] = v
+	}
+}
+
+// Close the Lua state.
+func (e *RuleEngine) Close() {
+	if e.L != nil {
+		e.L.Close()
+		e.L = nil
+	}
+}
+
+// Evaluate all rules in the tree, returns visibility and validation results.
+func (e *RuleEngine) Evaluate() *RuleEvaluation {
+	out := &RuleEvaluation{
+		Valid:    true,
+		Hidden:   make([]string, 0),
+		Messages: make(map[string][]string),
+		Results:  make([]RuleResult, 0),
+	}
+	if e.root == nil {
+		return out
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ruleEngineTimeout)
+	defer cancel()
+	e.L.SetContext(ctx)
+	defer e.L.RemoveContext()
+	rules := e.compileRules(e.root, "")
+	// hide nodes with visibility rules
+	e.hidden = make(map[string]bool)
+	for _, r := range rules {
+		if ruleType(r.node) == RuleTypeVisibility && r.node.Parent != "" {
+			e.hidden[r.node.Parent+"_"+r.matrixID] = true
+		}
+	}
+	// evaluate rules
+	for _, r := range rules {
+		res := e.evaluateRule(r)
+		out.Results = append(out.Results, res)
+		if res.Error != "" {
+			log.Printf("RULE ENGINE: Rule '%s' threw an error. %s", res.Rule, res.Error)
+			continue
+		}
+		if res.Type == RuleTypeVisibility && res.Results {
+			delete(e.hidden, res.Parent+"_"+res.MatrixID)
+		}
+	}
+	// collect validation messages, validation rules on hidden nodes are ignored
+	for _, res := range out.Results {
+		if res.Type != RuleTypeValidation || res.Error != "" || res.Results {
+			continue
+		}
+		if e.IsHidden(res.Parent, res.MatrixID) {
+			continue
+		}
+		out.Valid = false
+		out.Messages[answerKey(res.Parent, res.MatrixID)] = append(
+			out.Messages[answerKey(res.Parent, res.MatrixID)], res.Message,
+		)
+	}
+	// collect hidden nodes
+	for key := range e.hidden {
+		out.Hidden = append(out.Hidden, key)
+	}
+	sort.Strings(out.Hidden)
+	return out
+}
+
+// IsHidden returns true if given node, or one of its parents, is hidden by a visibility rule.
+func (e *RuleEngine) IsHidden(uid string, matrixID string) bool {
+	if e.hidden[uid+"_"+matrixID] {
+		return true
+	}
+	node := e.findProxyNode(uid)
+	for i := 0; node != nil && i <= len(e.nodes)+len(e.history); i++ {
+		node = e.findProxyNode(node.Parent)
+		if node == nil {
+			break
+		}
+		if e.hidden[node.UID+"_"+matrixID] || e.hidden[node.UID+"_"] {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *RuleEngine) compileRules(node *Node, matrixID string) []ruleInstance {
+	out := make([]ruleInstance, 0)
+	// handle matrix
+	if node.Type == NodeMatrix {
+		for _, id := range e.findMatrixIDs(node) {
+			for _, child := range e.children[node.UID] {
+				out = append(out, e.compileRules(child, id)...)
+			}
+		}
+		return out
+	}
+	for _, child := range e.children[node.UID] {
+		if child.Type == NodeRule {
+			if child.Data.GetString("template") != "" {
+				out = append(out, ruleInstance{node: child, matrixID: matrixID})
+			}
+			continue
+		}
+		out = append(out, e.compileRules(child, matrixID)...)
+	}
+	return out
+}
+
+func (e *RuleEngine) findMatrixIDs(node *Node) []string {
+	if node.Type == NodeQuestion {
+		if values := e.answers[node.UID]; len(values) > 0 {
+			return values
+		}
+	}
+	out := make([]string, 0)
+	for _, child := range e.children[node.UID] {
+		values := e.findMatrixIDs(child)
+		if len(values) > len(out) {
+			out = values
+		}
+	}
+	return out
+}
+
+func (e *RuleEngine) evaluateRule(r ruleInstance) RuleResult {
+	res := RuleResult{
+		Rule:     r.node.UID,
+		Parent:   r.node.Parent,
+		Type:     ruleType(r.node),
+		MatrixID: r.matrixID,
+	}
+	templateID := r.node.Data.GetString("template")
+	fn, err := e.loadScript(templateID)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	if fn == nil {
+		res.Error = "no rule provided or empty script"
+		return res
+	}
+	e.rule = r.node
+	e.matrixID = r.matrixID
+	defer func() {
+		e.rule = nil
+		e.matrixID = ""
+	}()
+	e.L.SetTop(0)
+	e.L.Push(fn)
+	if err := e.L.PCall(0, 2, nil); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Results = lua.LVAsBool(e.L.Get(1))
+	if !res.Results {
+		res.Message = lua.LVAsString(e.L.Get(2))
+		if res.Message == "" {
+			res.Message = ruleDefaultMessage
+		}
+	}
+	e.L.SetTop(0)
+	return res
+}
+
+func (e *RuleEngine) loadScript(templateID string) (*lua.LFunction, error) {
+	if fn, ok := e.compiled[templateID]; ok {
+		return fn, nil
+	}
+	script := e.scripts[templateID]
+	if script == "" {
+		return nil, nil
+	}
+	fn, err := e.L.LoadString(script)
+	if err != nil {
+		return nil, err
+	}
+	e.compiled[templateID] = fn
+	return fn, nil
+}
+
+func (e *RuleEngine) parentNode() *Node {
+	if e.rule == nil {
+		return nil
+	}
+	return e.nodes[e.rule.Parent]
+}
+
+func (e *RuleEngine) findProxyNode(uid string) *ruleProxyNode {
+	if uid == "" {
+		return nil
+	}
+	if n, ok := e.nodes[uid]; ok {
+		return &ruleProxyNode{
+			UID:     n.UID,
+			Type:    n.Type,
+			Version: e.version,
+			Label:   n.Label,
+			Parent:  n.Parent,
+			Value:   n.Data.GetString("value"),
+			Data:    n.Data,
+		}
+	}
+	if n, ok := e.history[uid]; ok {
+		return &ruleProxyNode{
+			UID:     n.UID,
+			Type:    n.Type,
+			Version: n.Version,
+			Label:   n.Label,
+			Parent:  n.Parent,
+			Value:   n.AnswerValue,
+		}
+	}
+	return nil
+}
+
+func (e *RuleEngine) getQuestionAnswers(uid string, matrixID string) []string {
+	return e.answers[answerKey(uid, matrixID)]
+}
+
+func (e *RuleEngine) hasAnswer(answer string, matrixID string) bool {
+	for key, values := range e.answers {
+		if matrixID != "" && (len(key) <= len(matrixID) || key[len(key)-len(matrixID)-1:] != "_"+matrixID) {
+			continue
+		}
+		for _, v := range values {
+			if v == answer {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contextMatrixID returns the matrix id to use for given uid when none was provided by the script.
+func (e *RuleEngine) contextMatrixID(uid string, matrixID string) string {
+	if matrixID != "" || e.rule == nil {
+		return matrixID
+	}
+	if uid == e.rule.UID || uid == e.rule.Parent {
+		return e.matrixID
+	}
+	return matrixID
+}
+
+func (e *RuleEngine) luaOpenLibs() {
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		e.L.Push(e.L.NewFunction(lib.fn))
+		e.L.Push(lua.LString(lib.name))
+		e.L.Call(1, 0)
+	}
+	// scripts should not be able to read from the server's filesystem
+	for _, name := range []string{"dofile", "loadfile"} {
+		e.L.SetGlobal(name, lua.LNil)
+	}
+}
+
+func (e *RuleEngine) luaNewNode(L *lua.LState, node *ruleProxyNode) lua.LValue {
+	if node == nil {
+		return lua.LNil
+	}
+	ud := L.NewUserData()
+	ud.Value = node
+	L.SetMetatable(ud, L.GetTypeMetatable(ruleEngineMetatable))
+	return ud
+}
+
+func (e *RuleEngine) luaFindUid(L *lua.LState) string {
+	switch v := L.Get(1).(type) {
+	case *lua.LUserData:
+		{
+			if node, ok := v.Value.(*ruleProxyNode); ok {
+				return node.UID
+			}
+		}
+	case *lua.LFunction:
+		{
+			if e.rule == nil {
+				return ""
+			}
+			if v == L.GetGlobal("this") {
+				return e.rule.UID
+			} else if v == L.GetGlobal("parent") {
+				return e.rule.Parent
+			}
+		}
+	case lua.LString, lua.LNumber:
+		{
+			return v.String()
+		}
+	}
+	return ""
+}
+
+func (e *RuleEngine) luaThis(L *lua.LState) int {
+	if e.rule == nil {
+		return 0
+	}
+	L.Push(e.luaNewNode(L, e.findProxyNode(e.rule.UID)))
+	return 1
+}
+
+func (e *RuleEngine) luaRoot(L *lua.LState) int {
+	if e.root == nil {
+		return 0
+	}
+	L.Push(e.luaNewNode(L, e.findProxyNode(e.root.UID)))
+	return 1
+}
+
+func (e *RuleEngine) luaParent(L *lua.LState) int {
+	parent := e.parentNode()
+	if parent == nil {
+		return 0
+	}
+	L.Push(e.luaNewNode(L, e.findProxyNode(parent.UID)))
+	return 1
+}
+
+func (e *RuleEngine) luaFind(L *lua.LState) int {
+	node := e.findProxyNode(e.luaFindUid(L))
+	if node == nil {
+		return 0
+	}
+	L.Push(e.luaNewNode(L, node))
+	return 1
+}
+
+func (e *RuleEngine) luaHasAnswer(L *lua.LState) int {
+	uid := e.luaFindUid(L)
+	if uid == "" {
+		log.Println("RULE ENGINE: Lua 'has' uid not provided.")
+		return 0
+	}
+	// check if answer/question is hidden, if so then it should not have answer
+	hidden := e.IsHidden(uid, e.matrixID)
+	L.Push(lua.LBool(
+		!hidden && (e.hasAnswer(uid, "") || len(e.getQuestionAnswers(uid, "")) > 0),
+	))
+	return 1
+}
+
+func (e *RuleEngine) luaGetAnswers(L *lua.LState) int {
+	uid := e.luaFindUid(L)
+	if uid == "" {
+		log.Println("RULE ENGINE: Lua 'get' uid not provided.")
+		return 0
+	}
+	matrixID := e.contextMatrixID(uid, L.OptString(2, ""))
+	tbl := L.NewTable()
+	for _, answer := range e.getQuestionAnswers(uid, matrixID) {
+		tbl.Append(lua.LString(answer))
+	}
+	L.Push(tbl)
+	return 1
+}
+
+func (e *RuleEngine) luaGetAnswerValue(L *lua.LState) int {
+	uid := e.luaFindUid(L)
+	if uid == "" {
+		log.Println("RULE ENGINE: Lua 'value' uid not provided.")
+		return 0
+	}
+	node := e.findProxyNode(uid)
+	if node == nil {
+		return 0
+	}
+	L.Push(lua.LString(node.Value))
+	return 1
+}
+
+func (e *RuleEngine) luaPrint(L *lua.LState) int {
+	value := L.Get(1)
+	if tbl, ok := value.(*lua.LTable); ok {
+		values := make(map[string]string)
+		tbl.ForEach(func(k lua.LValue, v lua.LValue) {
+			values[k.String()] = v.String()
+		})
+		log.Println("LUA PRINT", values)
+		return 0
+	}
+	log.Println("LUA PRINT", value.String())
+	return 0
+}
+
+func (e *RuleEngine) luaField(L *lua.LState) int {
+	name := L.OptString(1, "")
+	fieldType := L.OptString(2, RuleFieldText)
+	if name == "" {
+		log.Println("RULE ENGINE: Lua 'field' expects at least one argument, none provided.")
+		return 0
+	}
+	defaultValue := ""
+	if fieldType != RuleFieldAnswer && fieldType != RuleFieldNode {
+		defaultValue = L.OptString(3, "")
+	}
+	var value interface{}
+	if e.rule != nil {
+		if fieldValues, ok := toStringMap(e.rule.Data["fieldValues"]); ok {
+			value = fieldValues[name]
+		}
+	}
+	if isEmptyValue(value) {
+		if defaultValue != "" {
+			L.Push(lua.LString(defaultValue))
+			return 1
+		}
+		L.Push(lua.LNil)
+		return 1
+	}
+	switch fieldType {
+	case RuleFieldAnswer, RuleFieldNode:
+		{
+			tbl := L.NewTable()
+			values, _ := toSlice(value)
+			for _, v := range values {
+				if m, ok := toStringMap(v); ok {
+					v = m["uid"]
+				}
+				if s, ok := v.(string); ok {
+					tbl.Append(lua.LString(s))
+				}
+			}
+			L.Push(tbl)
+			break
+		}
+	default:
+		{
+			L.Push(lua.LString(NodeData{"v": value}.GetString("v")))
+			break
+		}
+	}
+	return 1
+}
+
+func (e *RuleEngine) luaSaveCount(L *lua.LState) int {
+	L.Push(lua.LNumber(e.saveCount))
+	return 1
+}
+
+func (e *RuleEngine) luaGetExtra(L *lua.LState) int {
+	tbl := L.NewTable()
+	for key, value := range e.extra {
+		switch v := value.(type) {
+		case bool:
+			{
+				tbl.RawSetString(key, lua.LBool(v))
+				break
+			}
+		case int:
+			{
+				tbl.RawSetString(key, lua.LNumber(v))
+				break
+			}
+		case float64:
+			{
+				tbl.RawSetString(key, lua.LNumber(v))
+				break
+			}
+		default:
+			{
+				tbl.RawSetString(key, lua.LString(NodeData{"v": v}.GetString("v")))
+				break
+			}
+		}
+	}
+	L.Push(tbl)
+	return 1
+}
+
+// luaRegisterDecisionNode registers lua 'DecisionNodeMT.'
+func (e *RuleEngine) luaRegisterDecisionNode() {
+	mt := e.L.NewTypeMetatable(ruleEngineMetatable)
+	e.L.SetField(mt, "__index", e.L.SetFuncs(e.L.NewTable(), map[string]lua.LGFunction{
+		"uid":          e.luaNodeUid,
+		"parent":       e.luaNodeParent,
+		"children":     e.luaNodeChildren,
+		"name":         e.luaNodeName,
+		"value":        e.luaNodeValue,
+		"type":         e.luaNodeType,
+		"param":        e.luaNodeParam,
+		"answers":      e.luaNodeAnswers,
+		"answerValues": e.luaNodeAnswerValues,
+		"hasAnswer":    e.luaNodeHasAnswer,
+	}))
+}
+
+func (e *RuleEngine) luaCheckNode(L *lua.LState) *ruleProxyNode {
+	ud, ok := L.Get(1).(*lua.LUserData)
+	if !ok {
+		return nil
+	}
+	node, _ := ud.Value.(*ruleProxyNode)
+	return node
+}
+
+func (e *RuleEngine) luaNodeUid(L *lua.LState) int {
+	node := e.luaCheckNode(L)
+	if node == nil {
+		return 0
+	}
+	L.Push(lua.LString(node.UID))
+	return 1
+}
+
+func (e *RuleEngine) luaNodeName(L *lua.LState) int {
+	node := e.luaCheckNode(L)
+	if node == nil {
+		return 0
+	}
+	name := node.Label
+	if name == "" {
+		name = node.UID
+	}
+	L.Push(lua.LString(name))
+	return 1
+}
+
+func (e *RuleEngine) luaNodeValue(L *lua.LState) int {
+	node := e.luaCheckNode(L)
+	if node == nil {
+		return 0
+	}
+	if node.Type == NodeAnswer {
+		L.Push(lua.LString(node.Value))
+		return 1
+	}
+	log.Printf(
+		"RULE ENGINE: Lua 'DecisionNodeMT:value' expected node of type %s but got %s (uid=%s).",
+		NodeAnswer, node.Type, node.UID,
+	)
+	return 0
+}
+
+func (e *RuleEngine) luaNodeType(L *lua.LState) int {
+	node := e.luaCheckNode(L)
+	if node == nil {
+		return 0
+	}
+	L.Push(lua.LString(node.Type))
+	return 1
+}
+
+func (e *RuleEngine) luaNodeParam(L *lua.LState) int {
+	node := e.luaCheckNode(L)
+	if node == nil {
+		return 0
+	}
+	name := L.OptString(2, "")
+	var value interface{}
+	switch name {
+	case "uid":
+		value = node.UID
+	case "type":
+		value = node.Type
+	case "version":
+		value = node.Version
+	case "label":
+		value = node.Label
+	case "value":
+		value = node.Value
+	case "parent":
+		value = node.Parent
+	default:
+		if node.Data != nil {
+			value = node.Data[name]
+		}
+	}
+	if isEmptyValue(value) {
+		L.Push(lua.LNil)
+		return 1
+	}
+	str := NodeData{"v": value}.GetString("v")
+	if num, err := strconv.ParseFloat(str, 64); err == nil {
+		L.Push(lua.LNumber(num))
+		return 1
+	}
+	L.Push(lua.LString(str))
+	return 1
+}
+
+func (e *RuleEngine) luaNodeAnswers(L *lua.LState) int {
+	node := e.luaCheckNode(L)
+	if node == nil {
+		return 0
+	}
+	if node.Type != NodeQuestion {
+		log.Printf(
+			"RULE ENGINE: Lua 'DecisionNodeMT:answers' expected node of type %s but got %s (uid=%s).",
+			NodeQuestion, node.Type, node.UID,
+		)
+		return 0
+	}
+	matrixID := e.contextMatrixID(node.UID, L.OptString(2, ""))
+	tbl := L.NewTable()
+	for _, answer := range e.getQuestionAnswers(node.UID, matrixID) {
+		if answerNode := e.findProxyNode(answer); answerNode != nil {
+			tbl.Append(e.luaNewNode(L, answerNode))
+			continue
+		}
+		tbl.Append(lua.LString(answer))
+	}
+	L.Push(tbl)
+	return 1
+}
+
+func (e *RuleEngine) luaNodeAnswerValues(L *lua.LState) int {
+	node := e.luaCheckNode(L)
+	if node == nil {
+		return 0
+	}
+	if node.Type != NodeQuestion {
+		log.Printf(
+			"RULE ENGINE: Lua 'DecisionNodeMT:answerValues' expected node of type %s but got %s (uid=%s).",
+			NodeQuestion, node.Type, node.UID,
+		)
+		return 0
+	}
+	matrixID := e.contextMatrixID(node.UID, L.OptString(2, ""))
+	tbl := L.NewTable()
+	for _, answer := range e.getQuestionAnswers(node.UID, matrixID) {
+		if answerNode, ok := e.nodes[answer]; ok {
+			tbl.Append(lua.LString(answerNode.Data.GetString("value")))
+			continue
+		}
+		tbl.Append(lua.LString(answer))
+	}
+	L.Push(tbl)
+	return 1
+}
+
+func (e *RuleEngine) luaNodeHasAnswer(L *lua.LState) int {
+	node := e.luaCheckNode(L)
+	if node == nil {
+		return 0
+	}
+	matrixID := e.contextMatrixID(node.UID, L.OptString(2, ""))
+	if e.IsHidden(node.UID, matrixID) {
+		L.Push(lua.LFalse)
+		return 1
+	}
+	switch node.Type {
+	case NodeAnswer:
+		{
+			L.Push(lua.LBool(e.hasAnswer(node.UID, matrixID)))
+			return 1
+		}
+	case NodeQuestion:
+		{
+			L.Push(lua.LBool(len(e.getQuestionAnswers(node.UID, matrixID)) > 0))
+			return 1
+		}
+	}
+	log.Printf(
+		"RULE ENGINE: Lua 'DecisionNodeMT:hasAnswer' expected node of type %s or %s but got %s (uid=%s).",
+		NodeAnswer, NodeQuestion, node.Type, node.UID,
+	)
+	return 0
+}
+
+func (e *RuleEngine) luaNodeParent(L *lua.LState) int {
+	node := e.luaCheckNode(L)
+	if node == nil {
+		return 0
+	}
+	L.Push(e.luaNewNode(L, e.findProxyNode(node.Parent)))
+	return 1
+}
+
+func (e *RuleEngine) luaNodeChildren(L *lua.LState) int {
+	node := e.luaCheckNode(L)
+	if node == nil {
+		return 0
+	}
+	tbl := L.NewTable()
+	for _, child := range e.children[node.UID] {
+		tbl.Append(e.luaNewNode(L, e.findProxyNode(child.UID)))
+	}
+	// include nodes from previous versions
+	historyUids := make([]string, 0)
+	for uid, n := range e.history {
+		if _, exists := e.nodes[uid]; !exists && n.Parent == node.UID {
+			historyUids = append(historyUids, uid)
+		}
+	}
+	sort.Strings(historyUids)
+	for _, uid := range historyUids {
+		tbl.Append(e.luaNewNode(L, e.findProxyNode(uid)))
+	}
+	L.Push(tbl)
+	return 1
+}
+
+func ruleType(node *Node) string {
+	if node.Data.GetString("type") == RuleTypeValidation {
+		return RuleTypeValidation
+	}
+	return RuleTypeVisibility
+}
+
+// answerKey returns the key used in submission answers for given question and matrix id.
+func answerKey(uid string, matrixID string) string {
+	if matrixID == "" {
+		return uid
+	}
+	return uid + "_" + matrixID
+}
+
+func isEmptyValue(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case bool:
+		return !v
+	}
+	if values, ok := toSlice(v); ok {
+		return len(values) == 0
+	}
+	return false
+}
+
+func toStringMap(v interface{}) (map[string]interface{}, bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return v, true
+	case NodeData:
+		return v, true
+	case primitive.M:
+		return v, true
+	case primitive.D:
+		return v.Map(), true
+	}
+	return nil, false
+}
+
+func toSlice(v interface{}) ([]interface{}, bool) {
+	switch v := v.(type) {
+	case []interface{}:
+		return v, true
+	case primitive.A:
+		return v, true
+	case []string:
+		out := make([]interface{}, len(v))
+		for i := range v {
+			out[i] = v[i]
+		}
+		return out, true
+	}
+	return nil, false
+}
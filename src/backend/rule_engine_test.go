@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+)
+
+func getTestRuleTreeVersion() *TreeVersion {
+	// fixed ids, generated ones can collide within a second
+	showTemplate := &RuleTemplate{
+		ID:     DatabaseIDFromString("showrule"),
+		Script: `return has("node-a-1-1")`,
+	}
+	requiredTemplate := &RuleTemplate{
+		ID:     DatabaseIDFromString("reqdrule"),
+		Script: `if #get(parent()) == 0 then return false, field("message", "text", "Required.") end return true`,
+	}
+	tree := getTestTree("root")
+	tree = append(tree,
+		Node{
+			UID:    "rule-show-b",
+			Type:   NodeRule,
+			Parent: "node-b",
+			Data: map[string]interface{}{
+				"type":     RuleTypeVisibility,
+				"template": showTemplate.ID.String(),
+			},
+		},
+		Node{
+			UID:    "rule-required-b-1",
+			Type:   NodeRule,
+			Parent: "node-b-1",
+			Data: map[string]interface{}{
+				"type":        RuleTypeValidation,
+				"template":    requiredTemplate.ID.String(),
+				"fieldValues": map[string]interface{}{"message": "Please enter something."},
+			},
+		},
+	)
+	return &TreeVersion{
+		Version:       1,
+		Tree:          tree,
+		RuleTemplates: []*RuleTemplate{showTemplate, requiredTemplate},
+	}
+}
+
+func TestRuleEngineVisibility(t *testing.T) {
+	treeVersion := getTestRuleTreeVersion()
+	// group b should be hidden when 'yes' isn't answered, its validation rule is then ignored
+	engine := NewRuleEngine(treeVersion, &FormSubmission{Answers: map[string][]string{"node-a-1": {"node-a-1-2"}}})
+	defer engine.Close()
+	res := engine.Evaluate()
+	if !engine.IsHidden("node-b", "") || !engine.IsHidden("node-b-1", "") {
+		t.Errorf("expected node-b and its children to be hidden")
+		return
+	}
+	if !res.Valid {
+		t.Errorf("expected validation rule on hidden question to be ignored")
+		return
+	}
+	// group b should be visible when 'yes' is answered
+	engine = NewRuleEngine(treeVersion, &FormSubmission{Answers: map[string][]string{"node-a-1": {"node-a-1-1"}}})
+	defer engine.Close()
+	res = engine.Evaluate()
+	if engine.IsHidden("node-b", "") {
+		t.Errorf("expected node-b to be visible")
+		return
+	}
+	if res.Valid {
+		t.Errorf("expected submission to be invalid")
+		return
+	}
+	if len(res.Messages["node-b-1"]) != 1 || res.Messages["node-b-1"][0] != "Please enter something." {
+		t.Errorf("unexpected validation messages %v", res.Messages)
+		return
+	}
+}
+
+func TestRuleEngineNodeAPI(t *testing.T) {
+	template := &RuleTemplate{
+		ID: GenerateDatabaseId(),
+		Script: `
+			local answers = find("node-a-1"):answerValues()
+			if answers[1] ~= "yes" then return false, "expected yes" end
+			if parent():parent():uid() ~= "node-a" then return false, "unexpected parent" end
+			if #root():children() ~= 2 then return false, "unexpected children" end
+			if find("node-old"):name() ~= "Old Node" then return false, "expected history node" end
+			if value("node-a-1-2") ~= "no" then return false, "unexpected value" end
+			if saveCount() ~= 3 then return false, "unexpected save count" end
+			return true
+		`,
+	}
+	treeVersion := getTestRuleTreeVersion()
+	treeVersion.Tree = append(treeVersion.Tree, Node{
+		UID:    "rule-api",
+		Type:   NodeRule,
+		Parent: "node-a-1",
+		Data: map[string]interface{}{
+			"type":     RuleTypeValidation,
+			"template": template.ID.String(),
+		},
+	})
+	treeVersion.RuleTemplates = append(treeVersion.RuleTemplates, template)
+	engine := NewRuleEngine(treeVersion, &FormSubmission{
+		Answers:   map[string][]string{"node-a-1": {"node-a-1-1"}, "node-b-1": {"test"}},
+		SaveCount: 3,
+	})
+	defer engine.Close()
+	engine.SetNodeHistory([]NodeLookup{{UID: "node-old", Type: NodeQuestion, Label: "Old Node", Version: 1}})
+	res := engine.Evaluate()
+	for _, r := range res.Results {
+		if r.Error != "" {
+			t.Errorf("rule %s threw an error, %s", r.Rule, r.Error)
+			return
+		}
+	}
+	if !res.Valid {
+		t.Errorf("expected submission to be valid, %v", res.Messages)
+		return
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Search result types.
+const (
+	SearchForm         = "form"
+	SearchDocument     = "document"
+	SearchNode         = "node"
+	SearchRuleTemplate = "rule_template"
+)
+
+const searchSnippetLength = 120
+
+// Field weights, matches in labels rank above tags and scripts.
+var searchWeights = map[string]int{
+	"label":      10,
+	"data.label": 8,
+	"tags":       6,
+	"script":     3,
+}
+
+// SearchResult is a form, document, node or rule template matching a search. Nodes carry the uid and
+// version of the tree they were found in.
+type SearchResult struct {
+	Type     string     `json:"type"`
+	ID       DatabaseID `json:"id"`
+	RootType TreeType   `json:"root_type,omitempty"`
+	Label    string     `json:"label"`
+	Node     string     `json:"node,omitempty"`
+	NodeType string     `json:"node_type,omitempty"`
+	Version  int        `json:"version,omitempty"`
+	Field    string     `json:"field"`
+	Snippet  string     `json:"snippet"`
+	Score    int        `json:"score"`
+}
+
+// searchField is a searchable field of a result.
+type searchField struct {
+	name  string
+	value string
+}
+
+// searchEntryFields maps the searchable fields to the fields of search entries.
+var searchEntryFields = []searchField{
+	{"label", "label"},
+	{"data.label", "data_label"},
+	{"tags", "tags"},
+	{"script", "script"},
+}
+
+// SearchEntry is a searchable form, document, node or rule template. Entries are kept up to date when
+// objects are stored, nodes are those of the latest version of their tree. Form is the form whose access
+// control list applies, Text holds all searchable fields in lower case.
+type SearchEntry struct {
+	ID        string     `bson:"_id" json:"id"`
+	Team      DatabaseID `bson:"team" json:"team"`
+	Form      DatabaseID `bson:"form,omitempty" json:"form,omitempty"`
+	Type      string     `bson:"type" json:"type"`
+	ObjectID  DatabaseID `bson:"object_id" json:"object_id"`
+	RootType  TreeType   `bson:"root_type,omitempty" json:"root_type,omitempty"`
+	Node      string     `bson:"node,omitempty" json:"node,omitempty"`
+	NodeType  string     `bson:"node_type,omitempty" json:"node_type,omitempty"`
+	Version   int        `bson:"version,omitempty" json:"version,omitempty"`
+	Label     string     `bson:"label" json:"label"`
+	DataLabel string     `bson:"data_label,omitempty" json:"data_label,omitempty"`
+	Tags      string     `bson:"tags,omitempty" json:"tags,omitempty"`
+	Script    string     `bson:"script,omitempty" json:"script,omitempty"`
+	Text      string     `bson:"text" json:"-"`
+}
+
+// searchHit is a search entry matching a search along with its score.
+type searchHit struct {
+	SearchEntry `bson:",inline"`
+	Score       int `bson:"score"`
+}
+
+// fields returns the searchable fields of the entry.
+func (e *SearchEntry) fields() []searchField {
+	return []searchField{
+		{"label", e.Label},
+		{"data.label", e.DataLabel},
+		{"tags", e.Tags},
+		{"script", e.Script},
+	}
+}
+
+// Search searches the labels of the forms and documents of user's team, the labels and tags of the nodes
+// in the latest version of their trees and the labels and scripts of the team's rule templates. All terms
+// of the query must match a single result. Results are ranked by score, types limits the result types.
+// Types the user isn't allowed to read are left out.
+func Search(query string, types []string, limit int, user *User) ([]*SearchResult, error) {
+	if user == nil {
+		return nil, ErrNoUser
+	}
+	terms := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, term := range strings.Fields(strings.ToLower(query)) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	if len(terms) == 0 {
+		return nil, ErrObjMissingParam
+	}
+	// result types and the permission kinds needed to see them
+	kinds := map[string]string{
+		SearchForm:         permKindForm,
+		SearchDocument:     permKindDocument,
+		SearchNode:         permKindForm,
+		SearchRuleTemplate: permKindRuleTemplate,
+	}
+	wants := func(resultType string) bool {
+		if !userCan(user, kinds[resultType], false) {
+			return false
+		}
+		if len(types) == 0 {
+			return true
+		}
+		for _, t := range types {
+			if t == resultType {
+				return true
+			}
+		}
+		return false
+	}
+	// entries the user can see, trees are limited to the forms the user can view and their documents
+	visible := bson.A{}
+	if wants(SearchForm) || wants(SearchDocument) || wants(SearchNode) {
+		formIDs, err := searchFormIDs(user)
+		if err != nil {
+			return nil, err
+		}
+		for _, resultType := range []string{SearchForm, SearchDocument, SearchNode} {
+			if !wants(resultType) {
+				continue
+			}
+			filter := bson.M{"type": resultType, "form": bson.M{"$in": formIDs}}
+			if resultType == SearchNode && !userCan(user, permKindDocument, false) {
+				filter["root_type"] = string(TreeForm)
+			}
+			visible = append(visible, filter)
+		}
+	}
+	if wants(SearchRuleTemplate) {
+		visible = append(visible, bson.M{"type": SearchRuleTemplate})
+	}
+	if len(visible) == 0 {
+		return make([]*SearchResult, 0), nil
+	}
+	// all terms must match, entries are ranked by the database
+	match := bson.A{}
+	for _, term := range terms {
+		match = append(match, bson.M{"text": bson.M{"$regex": regexp.QuoteMeta(term)}})
+	}
+	if limit <= 0 {
+		limit = dbFetchLimit
+	}
+	if limit > dbMaxFetchLimit {
+		limit = dbMaxFetchLimit
+	}
+	hits := make([]searchHit, 0)
+	if err := databaseAggregate(user.getStore(), SearchEntry{}, mongo.Pipeline{
+		bson.D{{Key: "$match", Value: bson.M{"team": user.Team, "$or": visible, "$and": match}}},
+		bson.D{{Key: "$addFields", Value: bson.M{
+			"score": searchScoreExpr(terms),
+			// results are labeled by the data label of nodes that have one
+			"sort_label": bson.M{"$toLower": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$data_label", ""}}, "$data_label", "$label"}}},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "score", Value: -1}, {Key: "sort_label", Value: 1}, {Key: "_id", Value: 1}}}},
+		bson.D{{Key: "$limit", Value: limit}},
+	}, &hits); err != nil {
+		return nil, err
+	}
+	out := make([]*SearchResult, 0, len(hits))
+	for i := range hits {
+		hit := &hits[i]
+		_, field, snippet := searchScore(terms, hit.fields())
+		out = append(out, &SearchResult{
+			Type:     hit.Type,
+			ID:       hit.ObjectID,
+			RootType: hit.RootType,
+			Label:    firstNonEmpty(hit.DataLabel, hit.Label),
+			Node:     hit.Node,
+			NodeType: hit.NodeType,
+			Version:  hit.Version,
+			Field:    field,
+			Snippet:  snippet,
+			Score:    hit.Score,
+		})
+	}
+	return out, nil
+}
+
+// searchScoreExpr is the aggregation expression of the score searchScore gives an entry.
+func searchScoreExpr(terms []string) bson.M {
+	phrase := regexp.QuoteMeta(strings.Join(terms, " "))
+	score := bson.A{}
+	for _, field := range searchEntryFields {
+		weight := searchWeights[field.name]
+		matches := func(pattern string) bson.M {
+			return bson.M{"$cond": bson.A{
+				bson.M{"$regexMatch": bson.M{"input": bson.M{"$ifNull": bson.A{"$" + field.value, ""}}, "regex": pattern, "options": "i"}},
+				weight,
+				0,
+			}}
+		}
+		for _, term := range terms {
+			score = append(score, matches(regexp.QuoteMeta(term)))
+		}
+		// whole phrase and exact matches rank higher
+		if len(terms) > 1 {
+			score = append(score, matches(phrase))
+		}
+		score = append(score, matches(`^\s*`+phrase+`\s*$`))
+	}
+	return bson.M{"$add": score}
+}
+
+// searchFormIDs lists the ids of the forms of user's team that user can view.
+func searchFormIDs(user *User) (bson.A, error) {
+	res, err := databaseListAll(user.getStore(), TreeRoot{}, formRootFilter(user), bson.M{"created": -1}, bson.M{"_id": 1})
+	if err != nil {
+		return nil, err
+	}
+	out := make(bson.A, 0, len(res))
+	for _, item := range res {
+		out = append(out, item.(*TreeRoot).ID)
+	}
+	return out, nil
+}
+
+// searchEntryText returns the text of an entry that search terms are matched against.
+func searchEntryText(e *SearchEntry) string {
+	values := make([]string, 0, 4)
+	for _, field := range e.fields() {
+		if field.value != "" {
+			values = append(values, strings.ToLower(field.value))
+		}
+	}
+	return strings.Join(values, "\n")
+}
+
+// searchStoreEntry stores a search entry.
+func searchStoreEntry(tx Store, e *SearchEntry) error {
+	e.Text = searchEntryText(e)
+	return databaseStoreOne(tx, e)
+}
+
+// searchRootOwner returns the team and the form of a form or document.
+func searchRootOwner(tx Store, root *TreeRoot) (DatabaseID, DatabaseID, error) {
+	if root.Type != TreeDocument {
+		return root.Parent, root.ID, nil
+	}
+	team, err := auditTreeTeam(tx, root.Parent)
+	return team, root.Parent, err
+}
+
+// searchIndexRoot updates the search entry of a form or document.
+func searchIndexRoot(tx Store, root *TreeRoot) error {
+	team, form, err := searchRootOwner(tx, root)
+	if err != nil {
+		return err
+	}
+	resultType := SearchForm
+	if root.Type == TreeDocument {
+		resultType = SearchDocument
+	}
+	return searchStoreEntry(tx, &SearchEntry{
+		ID:       resultType + ":" + root.ID.String(),
+		Team:     team,
+		Form:     form,
+		Type:     resultType,
+		ObjectID: root.ID,
+		RootType: root.Type,
+		Label:    root.Label,
+	})
+}
+
+// searchIndexNodes replaces the search entries of a tree's nodes with those of its latest version.
+func searchIndexNodes(tx Store, rootID DatabaseID) error {
+	if err := databaseDelete(tx, SearchEntry{}, bson.M{"type": SearchNode, "object_id": rootID}); err != nil {
+		return err
+	}
+	res, err := databaseFetch(tx, TreeRoot{}, bson.M{"_id": rootID}, nil)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return err
+	}
+	root := res.(*TreeRoot)
+	team, form, err := searchRootOwner(tx, root)
+	if err != nil {
+		return err
+	}
+	res, err = databaseFetch(tx, TreeVersion{}, bson.M{"root_id": rootID}, bson.M{"version": -1})
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return err
+	}
+	version := res.(*TreeVersion)
+	for _, node := range version.Tree {
+		if err := searchStoreEntry(tx, &SearchEntry{
+			ID:        SearchNode + ":" + rootID.String() + ":" + node.UID,
+			Team:      team,
+			Form:      form,
+			Type:      SearchNode,
+			ObjectID:  rootID,
+			RootType:  root.Type,
+			Node:      node.UID,
+			NodeType:  node.Type,
+			Version:   version.Version,
+			Label:     node.Label,
+			DataLabel: node.Data.GetString("label"),
+			Tags:      strings.Join(node.Tags, " "),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// searchIndexRuleTemplate updates the search entry of a rule template.
+func searchIndexRuleTemplate(tx Store, template *RuleTemplate) error {
+	return searchStoreEntry(tx, &SearchEntry{
+		ID:       SearchRuleTemplate + ":" + template.ID.String(),
+		Team:     template.Team,
+		Type:     SearchRuleTemplate,
+		ObjectID: template.ID,
+		Label:    template.Label,
+		Script:   template.Script,
+	})
+}
+
+// searchRemove removes the search entries of a deleted object.
+func searchRemove(tx Store, id DatabaseID) error {
+	return databaseDelete(tx, SearchEntry{}, bson.M{"object_id": id})
+}
+
+// searchIndexExisting indexes all trees and rule templates when the search collection is empty, this
+// indexes databases created before search entries were kept.
+func searchIndexExisting() error {
+	count, err := databaseCount(nil, SearchEntry{}, bson.M{})
+	if err != nil || count > 0 {
+		return err
+	}
+	res, err := databaseListAll(nil, TreeRoot{}, bson.M{}, bson.M{"_id": 1}, nil)
+	if err != nil {
+		return err
+	}
+	for _, item := range res {
+		root := item.(*TreeRoot)
+		if err := searchIndexRoot(nil, root); err != nil {
+			return err
+		}
+		if err := searchIndexNodes(nil, root.ID); err != nil {
+			return err
+		}
+	}
+	res, err = databaseListAll(nil, RuleTemplate{}, bson.M{}, bson.M{"_id": 1}, nil)
+	if err != nil {
+		return err
+	}
+	for _, item := range res {
+		if err := searchIndexRuleTemplate(nil, item.(*RuleTemplate)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// searchScore scores the fields against the terms, zero if any term doesn't match. Returns the score
+// along with the best matching field and a snippet of it.
+func searchScore(terms []string, fields []searchField) (int, string, string) {
+	score, best, bestScore := 0, -1, 0
+	matched := make(map[string]bool)
+	phrase := strings.Join(terms, " ")
+	for i, field := range fields {
+		value := strings.ToLower(field.value)
+		if value == "" {
+			continue
+		}
+		fieldScore := 0
+		for _, term := range terms {
+			if strings.Contains(value, term) {
+				matched[term] = true
+				fieldScore += searchWeights[field.name]
+			}
+		}
+		if fieldScore == 0 {
+			continue
+		}
+		// whole phrase and exact matches rank higher
+		if len(terms) > 1 && strings.Contains(value, phrase) {
+			fieldScore += searchWeights[field.name]
+		}
+		if strings.TrimSpace(value) == phrase {
+			fieldScore += searchWeights[field.name]
+		}
+		score += fieldScore
+		if fieldScore > bestScore {
+			best, bestScore = i, fieldScore
+		}
+	}
+	if best < 0 || len(matched) < len(terms) {
+		return 0, "", ""
+	}
+	snippet := ""
+	for _, term := range terms {
+		if snippet = searchSnippet(fields[best].value, term); snippet != "" {
+			break
+		}
+	}
+	return score, fields[best].name, snippet
+}
+
+// searchSnippet returns the line of value containing term, shortened around the term.
+func searchSnippet(value string, term string) string {
+	for _, line := range strings.Split(value, "\n") {
+		index := strings.Index(strings.ToLower(line), term)
+		if index < 0 {
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if len(line) <= searchSnippetLength {
+			return line
+		}
+		index = strings.Index(strings.ToLower(line), term)
+		start := index - searchSnippetLength/2
+		if start < 0 {
+			start = 0
+		}
+		end := start + searchSnippetLength
+		if end > len(line) {
+			end, start = len(line), len(line)-searchSnippetLength
+		}
+		return strings.ToValidUTF8("..."+line[start:end]+"...", "")
+	}
+	return ""
+}
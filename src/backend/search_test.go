@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSearch(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	otherUser := User{ID: testUser.ID, Team: testUser.Team, Permission: testUser.Permission}
+	otherUser.ID[0]++
+	otherUser.Team[0]++
+	// form with a second version
+	testForm := TreeRoot{Type: TreeForm, Parent: testUser.Team, Label: "Customer Intake"}
+	if err := testForm.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	testVersion := TreeVersion{RootID: testForm.ID, Version: 2, State: TreeDraft, Tree: getTestTree(testForm.ID.String())}
+	if err := testVersion.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	// rule templates
+	testTemplate := RuleTemplate{Team: testUser.Team, Label: "Totals", Script: "local x = 1\nlocal total = answers[\"node-b-1\"]\nreturn total"}
+	if err := testTemplate.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	otherTemplate := RuleTemplate{Team: otherUser.Team, Label: "Other Totals", Script: "local total = 0"}
+	if err := otherTemplate.Store(&otherUser); err != nil {
+		t.Error(err)
+		return
+	}
+	// node label
+	res, err := Search("TEST question", nil, 0, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(res) != 1 || res[0].Type != SearchNode || res[0].Node != "node-a-1" || res[0].Version != 2 || res[0].ID != testForm.ID {
+		t.Errorf("expected question node in latest version, got %+v", res)
+		return
+	}
+	// exact tag ranks above partial label
+	res, err = Search("test", nil, 0, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(res) != 2 || res[0].Node != testForm.ID.String() || res[0].Field != "tags" || res[1].Node != "node-a-1" {
+		t.Errorf("unexpected ranking %+v", res)
+	}
+	res, err = Search("intake", nil, 0, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(res) != 1 || res[0].Type != SearchForm || res[0].Label != "Customer Intake" {
+		t.Errorf("expected form, got %+v", res)
+	}
+	// script snippet, other team's templates are excluded
+	res, err = Search("answers node-b-1", []string{SearchRuleTemplate}, 0, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(res) != 1 || res[0].ID != testTemplate.ID || res[0].Field != "script" || !strings.HasPrefix(res[0].Snippet, "local total") {
+		t.Errorf("expected rule template script match, got %+v", res)
+	}
+	res, err = Search("totals", []string{SearchForm}, 0, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(res) != 0 {
+		t.Errorf("expected type filter to exclude rule templates")
+	}
+	// limit applies to the ranked results
+	res, err = Search("test", nil, 1, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(res) != 1 || res[0].Node != testForm.ID.String() {
+		t.Errorf("expected best result only, got %+v", res)
+	}
+	// entries follow renames and deletes
+	testForm.Label = "Customer Onboarding"
+	if err := testForm.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	if res, err = Search("intake", nil, 0, &testUser); err != nil || len(res) != 0 {
+		t.Errorf("expected old label not to match, got %+v %v", res, err)
+	}
+	if err := testTemplate.Delete(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	if res, err = Search("totals", nil, 0, &testUser); err != nil || len(res) != 0 {
+		t.Errorf("expected deleted template not to match, got %+v %v", res, err)
+	}
+	// objects stored before entries were kept are indexed once
+	if err := databaseDelete(nil, SearchEntry{}, bson.M{}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := searchIndexExisting(); err != nil {
+		t.Error(err)
+		return
+	}
+	if res, err = Search("onboarding", nil, 0, &testUser); err != nil || len(res) != 1 {
+		t.Errorf("expected existing form to be indexed, got %+v %v", res, err)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Session drivers.
+const (
+	SessionDriverMemory   = "memory"
+	SessionDriverDatabase = "database"
+)
+
+// Session is a logged in user session. Only a hash of the session token is kept.
+// Requests authenticated with an api token get a session that is not stored.
+type Session struct {
+	TokenHash  string     `bson:"_id" json:"-"`
+	ID         string     `bson:"id" json:"id"`
+	User       DatabaseID `bson:"user" json:"user"`
+	Team       DatabaseID `bson:"team" json:"team"`
+	Created    time.Time  `bson:"created" json:"created"`
+	LastActive time.Time  `bson:"last_active" json:"last_active"`
+	Expires    time.Time  `bson:"expires" json:"expires"`
+	IP         string     `bson:"ip,omitempty" json:"ip"`
+	UserAgent  string     `bson:"user_agent,omitempty" json:"user_agent"`
+	APIToken   *APIToken  `bson:"-" json:"-"`
+	txStore    Store
+}
+
+// SessionStore stores user sessions. Methods taking a database store use it when sessions are kept in the
+// database so that they are part of its transaction, nil is the default store.
+type SessionStore interface {
+	// Store inserts or updates a session.
+	Store(tx Store, session *Session) error
+	// Fetch fetches the session for given token hash.
+	Fetch(tokenHash string) (*Session, error)
+	// Delete deletes the session for given token hash.
+	Delete(tx Store, tokenHash string) error
+	// DeleteUser deletes all sessions of a user.
+	DeleteUser(tx Store, user DatabaseID) error
+	// ListTeam lists the active sessions of a team, most recently active first.
+	ListTeam(tx Store, team DatabaseID) ([]*Session, error)
+	// CleanUp deletes expired sessions.
+	CleanUp() error
+}
+
+var sessionStore SessionStore = newMemorySessionStore()
+
+// sessionStoreOpen sets the session store for the session driver in given config.
+func sessionStoreOpen(config *Config) error {
+	switch config.SessionDriver {
+	case "", SessionDriverMemory:
+		{
+			sessionStore = newMemorySessionStore()
+			break
+		}
+	case SessionDriverDatabase:
+		{
+			sessionStore = databaseSessionStore{}
+			break
+		}
+	default:
+		{
+			return ErrInvalidSessionDriver
+		}
+	}
+	return nil
+}
+
+// sessionTokenHash returns the hash of a session token used to store the session.
+func sessionTokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func (s *Session) hasExpired() bool {
+	return time.Now().After(s.Expires)
+}
+
+func (s *Session) getUser() *User {
+	if s == nil || s.User.IsEmpty() {
+		return nil
+	}
+	user, _ := FetchUserByID(s.User.String(), s.txStore)
+	if user != nil && s.APIToken != nil {
+		return s.APIToken.scopeUser(user)
+	}
+	return user
+}
+
+// memorySessionStore keeps sessions in memory, sessions are lost on restart.
+type memorySessionStore struct {
+	mutex    sync.RWMutex
+	sessions map[string]Session
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{sessions: make(map[string]Session)}
+}
+
+func (m *memorySessionStore) Store(tx Store, session *Session) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sessions[session.TokenHash] = *session
+	return nil
+}
+
+func (m *memorySessionStore) Fetch(tokenHash string) (*Session, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	session, exists := m.sessions[tokenHash]
+	if !exists {
+		return nil, ErrHTTPInvalidSession
+	}
+	return &session, nil
+}
+
+func (m *memorySessionStore) Delete(tx Store, tokenHash string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.sessions, tokenHash)
+	return nil
+}
+
+func (m *memorySessionStore) DeleteUser(tx Store, user DatabaseID) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for tokenHash, session := range m.sessions {
+		if session.User == user {
+			delete(m.sessions, tokenHash)
+		}
+	}
+	return nil
+}
+
+func (m *memorySessionStore) ListTeam(tx Store, team DatabaseID) ([]*Session, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	out := make([]*Session, 0)
+	for _, session := range m.sessions {
+		if session.Team == team && !session.hasExpired() {
+			session := session
+			out = append(out, &session)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].LastActive.After(out[j].LastActive)
+	})
+	return out, nil
+}
+
+func (m *memorySessionStore) CleanUp() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for tokenHash, session := range m.sessions {
+		if session.hasExpired() {
+			delete(m.sessions, tokenHash)
+		}
+	}
+	return nil
+}
+
+// databaseSessionStore keeps sessions in the database so they persist between restarts and can be shared between instances.
+type databaseSessionStore struct{}
+
+func (d databaseSessionStore) Store(tx Store, session *Session) error {
+	return databaseStoreOne(tx, session)
+}
+
+func (d databaseSessionStore) Fetch(tokenHash string) (*Session, error) {
+	res, err := databaseFetch(nil, Session{}, bson.M{"_id": tokenHash}, nil)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrHTTPInvalidSession
+		}
+		return nil, err
+	}
+	return res.(*Session), nil
+}
+
+func (d databaseSessionStore) Delete(tx Store, tokenHash string) error {
+	return databaseDelete(tx, Session{}, bson.M{"_id": tokenHash})
+}
+
+func (d databaseSessionStore) DeleteUser(tx Store, user DatabaseID) error {
+	return databaseDelete(tx, Session{}, bson.M{"user": user})
+}
+
+func (d databaseSessionStore) ListTeam(tx Store, team DatabaseID) ([]*Session, error) {
+	res, err := databaseListAll(
+		tx,
+		Session{},
+		bson.M{"team": team, "expires": bson.M{"$gt": time.Now()}},
+		bson.M{"last_active": -1},
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*Session, 0)
+	for _, item := range res {
+		out = append(out, item.(*Session))
+	}
+	return out, nil
+}
+
+func (d databaseSessionStore) CleanUp() error {
+	return databaseDelete(nil, Session{}, bson.M{"expires": bson.M{"$lte": time.Now()}})
+}
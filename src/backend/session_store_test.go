@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func testSessionRequest(w MockResponseWriter) *http.Request {
+	r, _ := http.NewRequest("GET", "/api/user/me", nil)
+	for _, c := range (&http.Response{Header: w.Header()}).Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestSessionStore(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	if err := databaseStoreOne(nil, &testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	defer sessionStoreOpen(&Config{})
+	for _, driver := range []string{SessionDriverMemory, SessionDriverDatabase} {
+		if err := sessionStoreOpen(&Config{SessionDriver: driver}); err != nil {
+			t.Error(err)
+			return
+		}
+		// two logins
+		loginReq, _ := http.NewRequest("POST", "/api/user/login", nil)
+		w1, w2 := NewMockResponseWriter(), NewMockResponseWriter()
+		if err := HTTPNewSession(w1, loginReq, &testUser); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := HTTPNewSession(w2, loginReq, &testUser); err != nil {
+			t.Error(err)
+			return
+		}
+		r1, r2 := testSessionRequest(w1), testSessionRequest(w2)
+		if user := HTTPGetSession(r1).getUser(); user == nil || user.ID != testUser.ID {
+			t.Errorf("%s: expected session user", driver)
+			return
+		}
+		sessions, err := sessionStore.ListTeam(nil, testUser.Team)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(sessions) != 2 {
+			t.Errorf("%s: expected 2 active sessions, got %d", driver, len(sessions))
+		}
+		// sliding expiry
+		session := HTTPGetSession(r1)
+		session.LastActive = time.Now().Add(-time.Hour)
+		session.Expires = time.Now().Add(time.Minute)
+		sessionStore.Store(nil, session)
+		if session = HTTPGetSession(r1); session == nil || time.Until(session.Expires) < time.Hour {
+			t.Errorf("%s: expected session expiry to be extended", driver)
+		}
+		// expired
+		session.Expires = time.Now().Add(-time.Minute)
+		sessionStore.Store(nil, session)
+		if HTTPGetSession(r1) != nil {
+			t.Errorf("%s: expected expired session", driver)
+		}
+		// log out everywhere
+		if HTTPGetSession(r2) == nil {
+			t.Errorf("%s: expected second session to be active", driver)
+		}
+		if err := sessionStore.DeleteUser(nil, testUser.ID); err != nil {
+			t.Error(err)
+			return
+		}
+		if HTTPGetSession(r2) != nil {
+			t.Errorf("%s: expected all sessions to be deleted", driver)
+		}
+	}
+}
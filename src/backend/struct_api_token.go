@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const apiTokenPrefix = "ccde_"
+const apiTokenDefaultExpire = 90 * 24 * time.Hour
+const apiTokenMaxExpire = 365 * 24 * time.Hour
+const apiTokenTouchInterval = time.Minute // minimum time between last used updates
+
+// APIToken is a personal access token for machine clients, it grants a subset of its user's permissions.
+// Only a hash of the token is stored.
+type APIToken struct {
+	ID         DatabaseID     `bson:"_id" json:"id"`
+	TokenHash  string         `bson:"token_hash" json:"-"`
+	User       DatabaseID     `bson:"user" json:"user"`
+	Team       DatabaseID     `bson:"team" json:"team"`
+	Label      string         `bson:"label" json:"label"`
+	Permission UserPermission `bson:"permission" json:"permission"`
+	Created    time.Time      `bson:"created" json:"created"`
+	Expires    time.Time      `bson:"expires" json:"expires"`
+	LastUsed   time.Time      `bson:"last_used,omitempty" json:"last_used"`
+}
+
+// NewAPIToken creates a new api token for user and returns it along with the plain text token.
+func NewAPIToken(label string, permission UserPermission, expires time.Time, user *User) (*APIToken, string, error) {
+	if user == nil {
+		return nil, "", ErrNoUser
+	}
+	// validate
+	now := time.Now()
+	if expires.IsZero() {
+		expires = now.Add(apiTokenDefaultExpire)
+	}
+	if !expires.After(now) || expires.After(now.Add(apiTokenMaxExpire)) {
+		return nil, "", ErrObjInvalidParam
+	}
+	for _, perm := range permission {
+		if !user.HasPermission(perm) {
+			return nil, "", ErrInvalidPermission
+		}
+	}
+	// generate token
+	rawToken := make([]byte, 32)
+	if _, err := rand.Read(rawToken); err != nil {
+		return nil, "", err
+	}
+	token := apiTokenPrefix + hex.EncodeToString(rawToken)
+	t := &APIToken{
+		TokenHash:  sessionTokenHash(token),
+		Label:      label,
+		Permission: permission,
+		Expires:    expires,
+	}
+	if err := t.Store(user); err != nil {
+		return nil, "", err
+	}
+	return t, token, nil
+}
+
+// FetchAPITokenByToken fetches the api token for given plain text token, expired tokens are not returned.
+func FetchAPITokenByToken(token string) (*APIToken, error) {
+	res, err := databaseFetch(nil, APIToken{}, bson.M{"token_hash": sessionTokenHash(token)}, nil)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrHTTPInvalidSession
+		}
+		return nil, err
+	}
+	apiToken := res.(*APIToken)
+	if time.Now().After(apiToken.Expires) {
+		return nil, ErrHTTPInvalidSession
+	}
+	return apiToken, nil
+}
+
+// FetchAPIToken fetches an api token by id.
+func FetchAPIToken(id string, user *User) (*APIToken, error) {
+	if user == nil {
+		return nil, ErrNoUser
+	}
+	res, err := databaseFetch(user.getStore(), APIToken{}, bson.M{"_id": DatabaseIDFromString(id)}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFetchPermission(res, user); err != nil {
+		return nil, err
+	}
+	return res.(*APIToken), nil
+}
+
+// ListAPIToken lists all api tokens of user.
+func ListAPIToken(user *User) ([]*APIToken, error) {
+	if user == nil {
+		return nil, ErrNoUser
+	}
+	res, err := databaseListAll(user.getStore(), APIToken{}, bson.M{"user": user.ID}, bson.M{"created": -1}, nil)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*APIToken, 0)
+	for _, item := range res {
+		out = append(out, item.(*APIToken))
+	}
+	return out, nil
+}
+
+// Store the api token.
+func (t *APIToken) Store(user *User) error {
+	if err := checkStorePermission(t, user); err != nil {
+		return err
+	}
+	if t.ID.IsEmpty() {
+		t.ID = GenerateDatabaseId()
+		t.User = user.ID
+		t.Team = user.Team
+		t.Created = time.Now()
+	}
+	return databaseStoreOne(user.getStore(), t)
+}
+
+// Delete (revoke) the api token.
+func (t *APIToken) Delete(user *User) error {
+	if t.ID.IsEmpty() {
+		return ErrObjMissingParam
+	}
+	if err := checkDeletePermission(t, user); err != nil {
+		return err
+	}
+	return databaseDelete(user.getStore(), APIToken{}, bson.M{"_id": t.ID})
+}
+
+// touch records the use of the api token.
+func (t *APIToken) touch(tx Store) error {
+	if time.Since(t.LastUsed) < apiTokenTouchInterval {
+		return nil
+	}
+	t.LastUsed = time.Now()
+	return databaseStoreOne(tx, t)
+}
+
+// scopeUser limits the permissions of the token's user to those granted to the token.
+func (t *APIToken) scopeUser(user *User) *User {
+	permission := UserPermission{}
+	for _, perm := range t.Permission {
+		if user.HasPermission(perm) {
+			permission = permission.Add(perm)
+		}
+	}
+	user.Permission = permission
+	user.RolePermission = nil
+	user.tokenScoped = true
+	return user
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAPIToken(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermManageForm, PermManageSubmission},
+	}
+	if err := databaseStoreOne(nil, &testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	// cannot grant permissions the user doesn't have
+	if _, _, err := NewAPIToken("CI", UserPermission{PermManageUser}, time.Time{}, &testUser); !errors.Is(err, ErrInvalidPermission) {
+		t.Errorf("expected permission error")
+		return
+	}
+	if _, _, err := NewAPIToken("CI", nil, time.Now().Add(-time.Hour), &testUser); !errors.Is(err, ErrObjInvalidParam) {
+		t.Errorf("expected invalid expiry error")
+		return
+	}
+	apiToken, token, err := NewAPIToken("CI", UserPermission{PermManageForm}, time.Time{}, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// bearer auth
+	r, _ := http.NewRequest("GET", "/api/tree/list", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	s := HTTPGetSession(r)
+	user := s.getUser()
+	if user == nil || user.ID != testUser.ID {
+		t.Errorf("expected api token user")
+		return
+	}
+	if !user.HasPermission(PermManageForm) || user.HasPermission(PermManageSubmission) {
+		t.Errorf("expected user permissions to be scoped to token")
+	}
+	tokens, err := ListAPIToken(&testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(tokens) != 1 || tokens[0].LastUsed.IsZero() || tokens[0].TokenHash == token {
+		t.Errorf("expected last used time to be recorded")
+	}
+	// tokens without a permission can't edit or delete forms their user created
+	testForm := TreeRoot{Type: TreeForm, Parent: testUser.Team, Label: "Form"}
+	if err := testForm.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	_, emptyToken, err := NewAPIToken("Empty", nil, time.Time{}, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	emptyRequest, _ := http.NewRequest("GET", "/api/tree/list", nil)
+	emptyRequest.Header.Set("Authorization", "Bearer "+emptyToken)
+	emptyUser := HTTPGetSession(emptyRequest).getUser()
+	testForm.Label = "Changed"
+	if err := testForm.Store(emptyUser); !errors.Is(err, ErrInvalidPermission) {
+		t.Errorf("expected empty token to not edit its user's form, got %v", err)
+	}
+	if err := testForm.Delete(emptyUser); !errors.Is(err, ErrInvalidPermission) {
+		t.Errorf("expected empty token to not delete its user's form, got %v", err)
+	}
+	// other users cannot revoke
+	otherUser := User{ID: testUser.ID, Team: testUser.Team, Permission: UserPermission{PermAdmin}}
+	otherUser.ID[0]++
+	if err := apiToken.Delete(&otherUser); !errors.Is(err, ErrInvalidPermission) {
+		t.Errorf("expected permission error")
+	}
+	// revoke
+	if err := apiToken.Delete(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	if HTTPGetSession(r) != nil {
+		t.Errorf("expected revoked token to be rejected")
+	}
+}
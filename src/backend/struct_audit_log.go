@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Audit log actions.
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditPublish = "publish"
+)
+
+// auditIgnoreFields are fields left out of audit diffs.
+var auditIgnoreFields = map[string]bool{"modified": true, "modifier": true}
+
+// auditRedactFields are fields whose values are never written to the audit log.
+var auditRedactFields = map[string]bool{"password": true}
+
+// AuditChange is the change of a single field, nested fields use dotted paths and nodes are keyed by uid.
+type AuditChange struct {
+	Field    string      `bson:"field" json:"field"`
+	Before   interface{} `bson:"before,omitempty" json:"before,omitempty"`
+	After    interface{} `bson:"after,omitempty" json:"after,omitempty"`
+	Redacted bool        `bson:"redacted,omitempty" json:"redacted,omitempty"`
+}
+
+// AuditLog is an entry in the append only audit log.
+type AuditLog struct {
+	ID            string        `bson:"_id" json:"id"`
+	Created       time.Time     `bson:"created" json:"created"`
+	Team          DatabaseID    `bson:"team" json:"team"`
+	User          DatabaseID    `bson:"user,omitempty" json:"user"`
+	Action        string        `bson:"action" json:"action"`
+	ObjectType    string        `bson:"object_type" json:"object_type"`
+	ObjectID      DatabaseID    `bson:"object_id" json:"object_id"`
+	ObjectVersion int           `bson:"object_version,omitempty" json:"object_version,omitempty"`
+	Changes       []AuditChange `bson:"changes" json:"changes"`
+}
+
+// AuditFilter filters the audit log listing.
+type AuditFilter struct {
+	ObjectType string
+	ObjectID   string
+	User       string
+	From       time.Time
+	To         time.Time
+}
+
+// ListAuditLog lists the audit log of user's team, newest first.
+func ListAuditLog(filter AuditFilter, user *User, offset int) ([]*AuditLog, int, error) {
+	if user == nil {
+		return nil, 0, ErrNoUser
+	}
+	if !user.HasPermission(PermAdmin) {
+		return nil, 0, ErrInvalidPermission
+	}
+	// build filter
+	filterParams := bson.M{"team": user.Team}
+	if filter.ObjectType != "" {
+		filterParams["object_type"] = filter.ObjectType
+	}
+	if filter.ObjectID != "" {
+		filterParams["object_id"] = DatabaseIDFromString(filter.ObjectID)
+	}
+	if filter.User != "" {
+		filterParams["user"] = DatabaseIDFromString(filter.User)
+	}
+	created := bson.M{}
+	if !filter.From.IsZero() {
+		created["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		created["$lt"] = filter.To
+	}
+	if len(created) > 0 {
+		filterParams["created"] = created
+	}
+	// database fetch
+	res, count, err := databaseList(user.getStore(), AuditLog{}, filterParams, bson.D{{Key: "created", Value: -1}, {Key: "_id", Value: -1}}, nil, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	// format output
+	out := make([]*AuditLog, 0)
+	for _, item := range res {
+		out = append(out, item.(*AuditLog))
+	}
+	return out, count, nil
+}
+
+// auditFetch returns the currently stored state of given object, nil if it isn't stored.
+func auditFetch(tx Store, data interface{}) (interface{}, error) {
+	res, err := databaseFetch(tx, data, databaseFilter(data), nil)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// auditStoreOne stores an object and records its creation or update in the audit log.
+func auditStoreOne(data interface{}, user *User) error {
+	before, err := auditFetch(user.getStore(), data)
+	if err != nil {
+		return err
+	}
+	if err := databaseStoreOne(user.getStore(), data); err != nil {
+		return err
+	}
+	action := AuditUpdate
+	if before == nil {
+		action = AuditCreate
+	}
+	return auditRecord(action, before, data, user)
+}
+
+// auditRecord writes an audit log entry for an action made by user, before and after are the states
+// of the object before and after the action, nil if it didn't exist.
+func auditRecord(action string, before interface{}, after interface{}, user *User) error {
+	object := after
+	if object == nil {
+		object = before
+	}
+	if object == nil {
+		return nil
+	}
+	entry := AuditLog{
+		ID:         uuid.NewString(),
+		Created:    time.Now(),
+		Action:     action,
+		ObjectType: getDatabaseCollectionNameFromData(object),
+	}
+	if user != nil {
+		entry.User = user.ID
+	}
+	// the entry belongs to the team that owns the object, submissions can be made without a user
+	var err error
+	switch o := object.(type) {
+	case *TreeRoot:
+		{
+			entry.ObjectID = o.ID
+			entry.Team = o.Parent
+			if o.Type == TreeDocument {
+				entry.Team, err = auditTreeTeam(user.getStore(), o.Parent)
+			}
+			break
+		}
+	case *TreeVersion:
+		{
+			entry.ObjectID = o.RootID
+			entry.ObjectVersion = o.Version
+			entry.Team, err = auditTreeTeam(user.getStore(), o.RootID)
+			break
+		}
+	case *FormSubmission:
+		{
+			entry.ObjectID = o.ID
+			entry.Team, err = auditTreeTeam(user.getStore(), o.FormID)
+			break
+		}
+	case *User:
+		{
+			entry.ObjectID = o.ID
+			entry.Team = o.Team
+			break
+		}
+	case *Team:
+		{
+			entry.ObjectID = o.ID
+			entry.Team = o.ID
+			break
+		}
+	case *RuleTemplate:
+		{
+			entry.ObjectID = o.ID
+			entry.Team = o.Team
+			break
+		}
+	case *Role:
+		{
+			entry.ObjectID = o.ID
+			entry.Team = o.Team
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if entry.Team.IsEmpty() && user != nil {
+		entry.Team = user.Team
+	}
+	// diff
+	beforeDoc, afterDoc := bson.M{}, bson.M{}
+	if before != nil {
+		if beforeDoc, err = queryToDoc(before); err != nil {
+			return err
+		}
+	}
+	if after != nil {
+		if afterDoc, err = queryToDoc(after); err != nil {
+			return err
+		}
+	}
+	entry.Changes = auditDiff("", beforeDoc, afterDoc)
+	return databaseInsertOne(user.getStore(), &entry)
+}
+
+// auditTreeTeam returns the team owning the form or document with given id, empty if it was deleted.
+func auditTreeTeam(tx Store, rootID DatabaseID) (DatabaseID, error) {
+	res, err := databaseFetch(tx, TreeRoot{}, bson.M{"_id": rootID}, nil)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return DatabaseID{}, nil
+		}
+		return DatabaseID{}, err
+	}
+	// documents belong to the team of their form
+	if treeRoot := res.(*TreeRoot); treeRoot.Type == TreeDocument {
+		return auditTreeTeam(tx, treeRoot.Parent)
+	}
+	return res.(*TreeRoot).Parent, nil
+}
+
+// auditDiff returns the changes between two documents.
+func auditDiff(prefix string, before bson.M, after bson.M) []AuditChange {
+	keys := make([]string, 0, len(before)+len(after))
+	for k := range before {
+		keys = append(keys, k)
+	}
+	for k := range after {
+		if _, exists := before[k]; !exists {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	out := make([]AuditChange, 0)
+	for _, k := range keys {
+		if prefix == "" && auditIgnoreFields[k] {
+			continue
+		}
+		field := k
+		if prefix != "" {
+			field = prefix + "." + k
+		}
+		b, a := before[k], after[k]
+		if queryEqual(b, a) {
+			continue
+		}
+		if auditRedactFields[k] {
+			out = append(out, AuditChange{Field: field, Redacted: true})
+			continue
+		}
+		// nested documents
+		bDoc, bIsDoc := queryDoc(b)
+		aDoc, aIsDoc := queryDoc(a)
+		if bIsDoc && aIsDoc {
+			out = append(out, auditDiff(field, bDoc, aDoc)...)
+			continue
+		}
+		// lists of nodes
+		if bNodes, ok := auditNodeMap(b); ok {
+			if aNodes, ok := auditNodeMap(a); ok {
+				out = append(out, auditDiff(field, bNodes, aNodes)...)
+				continue
+			}
+		}
+		out = append(out, AuditChange{Field: field, Before: auditValue(b), After: auditValue(a)})
+	}
+	return out
+}
+
+// auditNodeMap converts a list of documents with a uid to a map keyed by uid.
+func auditNodeMap(v interface{}) (bson.M, bool) {
+	values, ok := querySlice(v)
+	if !ok {
+		return nil, v == nil
+	}
+	out := bson.M{}
+	for _, value := range values {
+		doc, ok := queryDoc(value)
+		if !ok {
+			return nil, false
+		}
+		uid, ok := doc["uid"].(string)
+		if !ok || uid == "" {
+			return nil, false
+		}
+		out[uid] = doc
+	}
+	return out, true
+}
+
+// auditValue converts stored values to values that are readable in the JSON output.
+func auditValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case primitive.Binary:
+		{
+			if len(v.Data) == len(DatabaseID{}) {
+				id := DatabaseID{}
+				copy(id[:], v.Data)
+				return id.String()
+			}
+			return v.Data
+		}
+	case primitive.DateTime:
+		{
+			return v.Time()
+		}
+	case primitive.A:
+		{
+			out := make([]interface{}, len(v))
+			for i := range v {
+				out[i] = auditValue(v[i])
+			}
+			return out
+		}
+	}
+	if doc, ok := queryDoc(v); ok {
+		out := make(map[string]interface{}, len(doc))
+		for k, value := range doc {
+			out[k] = auditValue(value)
+		}
+		return out
+	}
+	return v
+}
+
+func (c AuditChange) MarshalJSON() ([]byte, error) {
+	type auditChangeJSON AuditChange
+	return json.Marshal(auditChangeJSON{
+		Field:    c.Field,
+		Before:   auditValue(c.Before),
+		After:    auditValue(c.After),
+		Redacted: c.Redacted,
+	})
+}
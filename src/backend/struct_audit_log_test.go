@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testAuditEntries maps audit log entries by action, entries created within the same millisecond have no defined order.
+func testAuditEntries(res []*AuditLog) map[string]*AuditLog {
+	out := make(map[string]*AuditLog)
+	for _, entry := range res {
+		out[entry.Action] = entry
+	}
+	return out
+}
+
+func TestAuditLog(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	testTreeRoot := TreeRoot{Type: TreeForm, Parent: testUser.Team, Label: "Testing 123"}
+	if err := testTreeRoot.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	testTreeRoot.Label = "Testing 456"
+	if err := testTreeRoot.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	// root create + update
+	res, count, err := ListAuditLog(AuditFilter{ObjectType: "tree_root", ObjectID: testTreeRoot.ID.String()}, &testUser, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	entries := testAuditEntries(res)
+	if count != 2 || entries[AuditCreate] == nil || entries[AuditUpdate] == nil {
+		t.Errorf("expected create and update entries")
+		return
+	}
+	changes := entries[AuditUpdate].Changes
+	if len(changes) != 1 || changes[0].Field != "label" || changes[0].Before != "Testing 123" || changes[0].After != "Testing 456" {
+		t.Errorf("unexpected changes %v", changes)
+	}
+	// node changes are keyed by uid
+	version, err := FetchTreeVersion(testTreeRoot.ID.String(), 1, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	version.Tree = getTestTree(testTreeRoot.ID.String())
+	if err := version.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	version.Tree[1].Label = "Changed"
+	if err := version.Publish(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	res, count, err = ListAuditLog(AuditFilter{ObjectType: "tree_version", ObjectID: testTreeRoot.ID.String()}, &testUser, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	entries = testAuditEntries(res)
+	if count != 4 || entries[AuditPublish] == nil || len(entries[AuditPublish].Changes) != 0 {
+		t.Errorf("expected publish entry")
+		return
+	}
+	expectField := "tree." + version.Tree[1].UID + ".label"
+	hasField := false
+	for _, entry := range res {
+		for _, c := range entry.Changes {
+			if c.Field == expectField && c.After == "Changed" {
+				hasField = true
+			}
+		}
+	}
+	if !hasField {
+		t.Errorf("expected change of %s", expectField)
+	}
+	// submissions without a user are logged in the team of their form
+	testSubmission := FormSubmission{ID: GenerateDatabaseId(), FormID: testTreeRoot.ID, FormVersion: 1}
+	if err := auditStoreOne(&testSubmission, nil); err != nil {
+		t.Error(err)
+		return
+	}
+	_, count, err = ListAuditLog(AuditFilter{ObjectType: "submission", ObjectID: testSubmission.ID.String()}, &testUser, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if count != 1 {
+		t.Errorf("expected anonymous submission entry, got %d", count)
+	}
+	// readable output
+	rawJSON, _ := json.Marshal(entries[AuditCreate])
+	if strings.Contains(string(rawJSON), "Subtype") || !strings.Contains(string(rawJSON), testTreeRoot.ID.String()) {
+		t.Errorf("unexpected json output %s", rawJSON)
+	}
+	// date and user filters
+	_, count, _ = ListAuditLog(AuditFilter{User: testUser.ID.String(), From: time.Now().Add(time.Hour)}, &testUser, 0)
+	if count != 0 {
+		t.Errorf("expected no entries after date")
+	}
+	_, count, _ = ListAuditLog(AuditFilter{User: testUser.ID.String(), To: time.Now().Add(time.Hour)}, &testUser, 0)
+	if count != 6 {
+		t.Errorf("expected 6 entries for user, got %d", count)
+	}
+	// admin only
+	testUser.Permission = UserPermission{PermManageForm}
+	if _, _, err := ListAuditLog(AuditFilter{}, &testUser, 0); !errors.Is(err, ErrInvalidPermission) {
+		t.Errorf("expected permission error")
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"regexp"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type FormSubmission struct {
@@ -17,6 +20,14 @@ type FormSubmission struct {
 	Answers     map[string][]string `bson:"answers" json:"answers"`
 	Valid       bool                `bson:"valid" json:"valid"`
 	SaveCount   int                 `bson:"save_count" json:"save_count"`
+	// anonymous submissions to public forms are resumed with a token, only its hash is stored
+	ResumeTokenHash string `bson:"resume_token,omitempty" json:"-"`
+}
+
+// formSubmissionSortFields are the sorts accepted when listing form submissions.
+var formSubmissionSortFields = ListSortFields{
+	Default: "-created",
+	Fields:  map[string]string{"created": "created", "modified": "modified", "version": "form_version"},
 }
 
 // FetchFormSubmission fetches a form submission of given id.
@@ -26,7 +37,7 @@ func FetchFormSubmission(id string, user *User) (*FormSubmission, error) {
 	}
 	// database fetch
 	dbId := DatabaseIDFromString(id)
-	res, err := databaseFetch(FormSubmission{}, bson.M{"_id": dbId}, nil)
+	res, err := databaseFetch(user.getStore(), FormSubmission{}, bson.M{"_id": dbId}, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -38,38 +49,164 @@ func FetchFormSubmission(id string, user *User) (*FormSubmission, error) {
 	return submission, nil
 }
 
-// ListFormSubmission lists all form submission for given form and/or given creator.
-func ListFormSubmission(formId string, userId string, user *User, offset int) ([]*FormSubmission, int, error) {
-	if user == nil {
-		return nil, 0, ErrNoUser
-	}
-	if formId == "" && userId == "" {
-		return nil, 0, ErrObjMissingParam
+// Answer filter operators.
+const (
+	AnswerEquals   = "eq"
+	AnswerContains = "contains"
+	AnswerExists   = "exists"
+)
+
+// FormSubmissionAnswerFilter matches submissions by the answer to a question, Value is unused by AnswerExists.
+type FormSubmissionAnswerFilter struct {
+	Question string
+	Op       string
+	Value    string
+}
+
+// FormSubmissionFilter filters listed form submissions, zero values are ignored and all answer filters must match.
+type FormSubmissionFilter struct {
+	FormID       string
+	UserID       string
+	Version      int
+	Valid        *bool
+	CreatedFrom  time.Time
+	CreatedTo    time.Time
+	ModifiedFrom time.Time
+	ModifiedTo   time.Time
+	Answers      []FormSubmissionAnswerFilter
+}
+
+// query builds the database filter, answers are matched on the answers.<question> field so that the
+// answers wildcard index is used.
+func (f FormSubmissionFilter) query() (bson.M, error) {
+	if f.FormID == "" && f.UserID == "" {
+		return nil, ErrObjMissingParam
 	}
-	// database fetch
 	filterParams := bson.M{}
-	if formId != "" {
-		filterParams["form_id"] = DatabaseIDFromString(formId)
+	if f.FormID != "" {
+		filterParams["form_id"] = DatabaseIDFromString(f.FormID)
 	}
-	if userId != "" {
-		filterParams["creator"] = DatabaseIDFromString(userId)
+	if f.UserID != "" {
+		filterParams["creator"] = DatabaseIDFromString(f.UserID)
 	}
-	res, count, err := databaseList(FormSubmission{}, filterParams, bson.M{"created": -1}, nil, offset)
-	if err != nil {
-		return nil, 0, err
+	if f.Version > 0 {
+		filterParams["form_version"] = f.Version
 	}
-	// check permission
-	if len(res) > 0 {
-		if err := checkFetchPermission(res[0], user); err != nil {
-			return nil, 0, err
+	if f.Valid != nil {
+		filterParams["valid"] = *f.Valid
+	}
+	if created := queryTimeRange(f.CreatedFrom, f.CreatedTo); created != nil {
+		filterParams["created"] = created
+	}
+	if modified := queryTimeRange(f.ModifiedFrom, f.ModifiedTo); modified != nil {
+		filterParams["modified"] = modified
+	}
+	answers := bson.A{}
+	for _, answer := range f.Answers {
+		if answer.Question == "" || strings.ContainsAny(answer.Question, ".$") {
+			return nil, ErrObjInvalidParam
 		}
+		field := "answers." + answer.Question
+		switch answer.Op {
+		case AnswerEquals:
+			{
+				answers = append(answers, bson.M{field: answer.Value})
+				break
+			}
+		case AnswerContains:
+			{
+				answers = append(answers, bson.M{field: bson.M{"$regex": regexp.QuoteMeta(answer.Value), "$options": "i"}})
+				break
+			}
+		case AnswerExists:
+			{
+				answers = append(answers, bson.M{field + ".0": bson.M{"$exists": true}})
+				break
+			}
+		default:
+			{
+				return nil, ErrObjInvalidParam
+			}
+		}
+	}
+	if len(answers) > 0 {
+		filterParams["$and"] = answers
+	}
+	return filterParams, nil
+}
+
+// queryTimeRange returns a condition matching times from (inclusive) to to (exclusive), nil if both are zero.
+func queryTimeRange(from time.Time, to time.Time) bson.M {
+	cond := bson.M{}
+	if !from.IsZero() {
+		cond["$gte"] = from
+	}
+	if !to.IsZero() {
+		cond["$lt"] = to
+	}
+	if len(cond) == 0 {
+		return nil
+	}
+	return cond
+}
+
+// ListFormSubmission lists the form submissions matching filter. Like checkFetchPermission only the user's own
+// submissions and submissions to forms of the user's team they can read submissions of are listed.
+func ListFormSubmission(filter FormSubmissionFilter, user *User, page ListPage) ([]*FormSubmission, ListPageInfo, error) {
+	if user == nil {
+		return nil, ListPageInfo{}, ErrNoUser
+	}
+	filterParams, err := filter.query()
+	if err != nil {
+		return nil, ListPageInfo{}, err
+	}
+	// limit to submissions user can fetch
+	formFilter := aclListFilter(user, TreeACLManageSubmission, permKindSubmission)
+	formFilter["type"] = string(TreeForm)
+	formFilter["parent"] = user.Team
+	forms, err := databaseListAll(user.getStore(), TreeRoot{}, formFilter, bson.M{"_id": 1}, bson.M{"_id": 1})
+	if err != nil {
+		return nil, ListPageInfo{}, err
+	}
+	formIDs := make(bson.A, 0, len(forms))
+	for _, form := range forms {
+		formIDs = append(formIDs, form.(*TreeRoot).ID)
+	}
+	filterParams["$or"] = bson.A{
+		bson.M{"creator": user.ID},
+		bson.M{"form_id": bson.M{"$in": formIDs}},
+	}
+	// database fetch
+	res, info, err := databasePage(
+		user.getStore(),
+		FormSubmission{},
+		mongo.Pipeline{bson.D{{Key: "$match", Value: filterParams}}},
+		nil,
+		formSubmissionSortFields,
+		page,
+	)
+	if err != nil {
+		return nil, info, err
 	}
 	// format output
 	out := make([]*FormSubmission, 0)
 	for _, item := range res {
 		out = append(out, item.(*FormSubmission))
 	}
-	return out, count, nil
+	return out, info, nil
+}
+
+// Validate checks the submission against the form version it references and evaluates its rules.
+func (s *FormSubmission) Validate(user *User) (*SubmissionValidation, error) {
+	treeVersion, err := FetchTreeVersion(s.FormID.String(), s.FormVersion, user)
+	if err != nil {
+		return nil, err
+	}
+	nodeHistory, err := ListNodeVersion(s.FormID.String(), s.FormVersion, user)
+	if err != nil {
+		return nil, err
+	}
+	return ValidateSubmission(treeVersion, nodeHistory, s), nil
 }
 
 // Store the form submission.
@@ -80,14 +217,43 @@ func (s *FormSubmission) Store(user *User) error {
 	if err := checkStorePermission(s, user); err != nil {
 		return err
 	}
-	s.Modifier = user.ID
+	return s.store(user)
+}
+
+// store the form submission without checking permission, callers must have done so.
+// Anonymous submissions to public forms are stored without a user.
+func (s *FormSubmission) store(user *User) error {
+	isNew := s.ID.IsEmpty()
+	// new submissions can't be made once the form version is closed
+	if isNew {
+		res, err := databaseFetch(user.getStore(), TreeVersion{}, bson.M{"root_id": s.FormID, "version": s.FormVersion}, nil)
+		if err != nil {
+			return err
+		}
+		if res.(*TreeVersion).IsClosed() {
+			return ErrFormClosed
+		}
+	}
 	s.Modified = time.Now()
-	if s.ID.IsEmpty() {
+	if user != nil {
+		s.Modifier = user.ID
+	}
+	if isNew {
 		s.ID = GenerateDatabaseId()
-		s.Creator = user.ID
 		s.Created = s.Modified
+		if user != nil {
+			s.Creator = user.ID
+		}
 	}
-	return databaseStoreOne(s)
+	if err := auditStoreOne(s, user); err != nil {
+		return err
+	}
+	if isNew {
+		webhookDispatch(user.getStore(), webhookFormTeam(user.getStore(), s.FormID), WebhookSubmissionCreated, s)
+	} else if s.Valid {
+		webhookDispatch(user.getStore(), webhookFormTeam(user.getStore(), s.FormID), WebhookSubmissionUpdated, s)
+	}
+	return nil
 }
 
 // Delete the tree root.
@@ -95,5 +261,19 @@ func (s *FormSubmission) Delete(user *User) error {
 	if err := checkDeletePermission(s, user); err != nil {
 		return err
 	}
-	return databaseDelete(FormSubmission{}, bson.M{"_id": s.ID})
+	before, err := auditFetch(user.getStore(), s)
+	if err != nil {
+		return err
+	}
+	if err := databaseDelete(user.getStore(), FormSubmission{}, bson.M{"_id": s.ID}); err != nil {
+		return err
+	}
+	if err := auditRecord(AuditDelete, before, nil, user); err != nil {
+		return err
+	}
+	if before != nil {
+		s = before.(*FormSubmission)
+	}
+	webhookDispatch(user.getStore(), webhookFormTeam(user.getStore(), s.FormID), WebhookSubmissionDeleted, s)
+	return nil
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestListFormSubmissionFilter(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	otherUser := User{ID: testUser.ID, Team: testUser.Team}
+	otherUser.ID[0]++
+	otherUser.Team[0]++
+	testForm := TreeRoot{ID: GenerateDatabaseId(), Type: TreeForm, Parent: testUser.Team, Label: "Filter"}
+	if err := databaseStoreOne(nil, &testForm); err != nil {
+		t.Error(err)
+		return
+	}
+	created := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	submissions := []FormSubmission{
+		{Creator: testUser.ID, FormVersion: 1, Valid: true, Answers: map[string][]string{"node-a-1": {"yes"}, "node-b-1": {"Hello World"}}},
+		{Creator: testUser.ID, FormVersion: 2, Valid: false, Answers: map[string][]string{"node-a-1": {"no"}}},
+		{Creator: otherUser.ID, FormVersion: 2, Valid: true, Answers: map[string][]string{"node-a-1": {"yes"}, "node-b-1": {"goodbye"}}},
+	}
+	for i := range submissions {
+		submissions[i].ID = testForm.ID
+		submissions[i].ID[0] += byte(i + 1)
+		submissions[i].FormID = testForm.ID
+		submissions[i].Created = created.Add(time.Duration(i) * time.Minute)
+		submissions[i].Modified = submissions[i].Created
+		if err := databaseStoreOne(nil, &submissions[i]); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	valid := true
+	for _, test := range []struct {
+		name   string
+		filter FormSubmissionFilter
+		user   *User
+		expect []int
+	}{
+		{"form", FormSubmissionFilter{}, &testUser, []int{2, 1, 0}},
+		{"version", FormSubmissionFilter{Version: 2}, &testUser, []int{2, 1}},
+		{"valid", FormSubmissionFilter{Valid: &valid}, &testUser, []int{2, 0}},
+		{"created", FormSubmissionFilter{CreatedFrom: created.Add(time.Minute), CreatedTo: created.Add(2 * time.Minute)}, &testUser, []int{1}},
+		{"answer", FormSubmissionFilter{Answers: []FormSubmissionAnswerFilter{{Question: "node-a-1", Op: AnswerEquals, Value: "yes"}}}, &testUser, []int{2, 0}},
+		{"contains", FormSubmissionFilter{Answers: []FormSubmissionAnswerFilter{{Question: "node-b-1", Op: AnswerContains, Value: "world"}}}, &testUser, []int{0}},
+		{"answered", FormSubmissionFilter{Answers: []FormSubmissionAnswerFilter{{Question: "node-b-1", Op: AnswerExists}}}, &testUser, []int{2, 0}},
+		{"combined", FormSubmissionFilter{Version: 2, Answers: []FormSubmissionAnswerFilter{{Question: "node-a-1", Op: AnswerEquals, Value: "yes"}}}, &testUser, []int{2}},
+		// other team only sees its own submissions
+		{"permission", FormSubmissionFilter{}, &otherUser, []int{2}},
+	} {
+		test.filter.FormID = testForm.ID.String()
+		res, _, err := ListFormSubmission(test.filter, test.user, ListPage{})
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if len(res) != len(test.expect) {
+			t.Errorf("%s: expected %d submissions, got %d", test.name, len(test.expect), len(res))
+			continue
+		}
+		for i, index := range test.expect {
+			if res[i].ID != submissions[index].ID {
+				t.Errorf("%s: unexpected submission at %d", test.name, i)
+			}
+		}
+	}
+	// question uids can't address other fields
+	_, _, err := ListFormSubmission(FormSubmissionFilter{
+		FormID:  testForm.ID.String(),
+		Answers: []FormSubmissionAnswerFilter{{Question: "$where", Op: AnswerEquals}},
+	}, &testUser, ListPage{})
+	if !errors.Is(err, ErrObjInvalidParam) {
+		t.Errorf("expected invalid param error, got %v", err)
+	}
+}
+
+func TestHTTPFormSubmissionStoreKeepsForm(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	if err := databaseStoreOne(nil, &testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	_, token, err := NewAPIToken("Submissions", UserPermission{PermAdmin}, time.Time{}, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	forms := make([]TreeRoot, 2)
+	for i := range forms {
+		forms[i] = TreeRoot{Type: TreeForm, Parent: testUser.Team, Label: "Form"}
+		if err := forms[i].Store(&testUser); err != nil {
+			t.Error(err)
+			return
+		}
+		version, err := FetchTreeVersion(forms[i].ID.String(), 1, &testUser)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		version.Tree = getTestTree(forms[i].ID.String())
+		if err := version.Publish(&testUser); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	// updates can't move a submission to another form or version
+	res, err := testBatch(token, "", []HTTPBatchPayload{
+		{Path: "/api/submission/store", Payload: map[string]interface{}{"form_id": forms[0].ID.String(), "form_version": 1}},
+		{Path: "/api/submission/store", Payload: map[string]interface{}{
+			"id":           map[string]interface{}{"$ref": "1/data/id"},
+			"form_id":      forms[1].ID.String(),
+			"form_version": 2,
+		}},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !res.Success {
+		t.Errorf("expected submission to be stored, got %+v", res)
+		return
+	}
+	steps := res.Data.([]interface{})
+	id := steps[0].(map[string]interface{})["data"].(map[string]interface{})["id"].(string)
+	submission, err := FetchFormSubmission(id, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if submission.FormID != forms[0].ID || submission.FormVersion != 1 || submission.SaveCount != 1 {
+		t.Errorf("expected submission to keep its form and version, got %s version %d", submission.FormID.String(), submission.FormVersion)
+	}
+}
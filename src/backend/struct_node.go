@@ -1,5 +1,17 @@
 package main
 
+import "fmt"
+
+// Node types, these match the type names used by the webapp.
+const (
+	NodeRoot     = "root"
+	NodeGroup    = "group"
+	NodeQuestion = "question"
+	NodeAnswer   = "answer"
+	NodeRule     = "rule"
+	NodeMatrix   = "matrix"
+)
+
 // Node is a node in a form or document.
 type Node struct {
 	UID    string   `bson:"uid" json:"uid"`
@@ -12,3 +24,41 @@ type Node struct {
 
 // NodeData is data related to a node.
 type NodeData map[string]interface{}
+
+// GetString returns the given data key as a string.
+func (d NodeData) GetString(key string) string {
+	if d == nil || d[key] == nil {
+		return ""
+	}
+	switch v := d[key].(type) {
+	case string:
+		{
+			return v
+		}
+	case float64:
+		{
+			if v == float64(int64(v)) {
+				return fmt.Sprintf("%d", int64(v))
+			}
+		}
+	}
+	return fmt.Sprintf("%v", d[key])
+}
+
+// GetBool returns the given data key as a boolean.
+func (d NodeData) GetBool(key string) bool {
+	if d == nil {
+		return false
+	}
+	switch v := d[key].(type) {
+	case bool:
+		{
+			return v
+		}
+	case string:
+		{
+			return v == "true" || v == "1"
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Role is a named set of permissions defined by a team. Users get the permissions of all of their roles
+// on top of their own.
+type Role struct {
+	ID         DatabaseID     `bson:"_id" json:"id"`
+	Created    time.Time      `bson:"created,omitempty" json:"created,omitempty"`
+	Modified   time.Time      `bson:"modified,omitempty" json:"modified,omitempty"`
+	Creator    DatabaseID     `bson:"creator,omitempty" json:"creator,omitempty"`
+	Modifier   DatabaseID     `bson:"modifier,omitempty" json:"modifier,omitempty"`
+	Team       DatabaseID     `bson:"team,omitempty" json:"team,omitempty"`
+	Label      string         `bson:"label,omitempty" json:"label,omitempty"`
+	Permission UserPermission `bson:"permission" json:"permission"`
+}
+
+// roleSortFields are the sorts accepted when listing roles.
+var roleSortFields = ListSortFields{
+	Default: "label",
+	Fields:  map[string]string{"created": "created", "modified": "modified", "label": "label"},
+}
+
+// FetchRole fetches a role of user's team.
+func FetchRole(id string, user *User) (*Role, error) {
+	if user == nil {
+		return nil, ErrNoUser
+	}
+	// database fetch
+	res, err := databaseFetch(user.getStore(), Role{}, bson.M{"_id": DatabaseIDFromString(id)}, nil)
+	if err != nil {
+		return nil, err
+	}
+	// check permission
+	if err := checkFetchPermission(res, user); err != nil {
+		return nil, err
+	}
+	return res.(*Role), nil
+}
+
+// ListRole lists the roles of user's team.
+func ListRole(user *User, page ListPage) ([]*Role, ListPageInfo, error) {
+	if err := checkListPermission(permKindRole, user); err != nil {
+		return nil, ListPageInfo{}, err
+	}
+	// database fetch
+	res, info, err := databasePage(
+		user.getStore(),
+		Role{},
+		mongo.Pipeline{bson.D{{Key: "$match", Value: bson.M{"team": user.Team}}}},
+		nil,
+		roleSortFields,
+		page,
+	)
+	if err != nil {
+		return nil, info, err
+	}
+	// format output
+	out := make([]*Role, 0)
+	for _, item := range res {
+		out = append(out, item.(*Role))
+	}
+	return out, info, nil
+}
+
+// listTeamRoles lists the roles of team with the given ids, ids of other teams are left out.
+func listTeamRoles(tx Store, team DatabaseID, ids []DatabaseID) ([]*Role, error) {
+	if len(ids) == 0 {
+		return []*Role{}, nil
+	}
+	dbIDs := make(bson.A, 0, len(ids))
+	for _, id := range ids {
+		dbIDs = append(dbIDs, id)
+	}
+	res, err := databaseListAll(tx, Role{}, bson.M{"team": team, "_id": bson.M{"$in": dbIDs}}, bson.M{"label": 1}, nil)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*Role, 0, len(res))
+	for _, item := range res {
+		out = append(out, item.(*Role))
+	}
+	return out, nil
+}
+
+// Store the role.
+func (r *Role) Store(user *User) error {
+	if user == nil {
+		return ErrNoUser
+	}
+	if r.Label == "" {
+		return ErrObjMissingParam
+	}
+	if err := r.Permission.Validate(); err != nil {
+		return err
+	}
+	// check permission
+	if err := checkStorePermission(r, user); err != nil {
+		return err
+	}
+	// update create/modifier
+	r.Modifier = user.ID
+	r.Modified = time.Now()
+	if r.ID.IsEmpty() {
+		r.ID = GenerateDatabaseId()
+		r.Creator = user.ID
+		r.Created = r.Modified
+	}
+	return auditStoreOne(r, user)
+}
+
+// Delete the role, users that have it lose its permissions.
+func (r *Role) Delete(user *User) error {
+	if user == nil {
+		return ErrNoUser
+	}
+	if err := checkDeletePermission(r, user); err != nil {
+		return err
+	}
+	before, err := auditFetch(user.getStore(), r)
+	if err != nil {
+		return err
+	}
+	if err := databaseDelete(user.getStore(), Role{}, bson.M{"_id": r.ID}); err != nil {
+		return err
+	}
+	return auditRecord(AuditDelete, before, nil, user)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRole(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	// fixed user ids, generated ones can collide with the users stored below within a second
+	admin := User{
+		ID:         DatabaseIDFromString("roleadmin"),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	if err := databaseStoreOne(nil, &admin); err != nil {
+		t.Error(err)
+		return
+	}
+	// only admins manage roles
+	manager := User{ID: DatabaseIDFromString("rolemanager"), Team: admin.Team, Permission: UserPermission{PermManageUser, PermRead, PermSubmit}}
+	if err := databaseStoreOne(nil, &manager); err != nil {
+		t.Error(err)
+		return
+	}
+	reader := Role{Team: admin.Team, Label: "Reader", Permission: UserPermission{PermRead}}
+	if err := reader.Store(&manager); !errors.Is(err, ErrInvalidPermission) {
+		t.Errorf("expected invalid permission error, got %v", err)
+	}
+	if err := reader.Store(&admin); err != nil {
+		t.Error(err)
+		return
+	}
+	submitter := Role{Team: admin.Team, Label: "Submitter", Permission: UserPermission{PermSubmit}}
+	if err := submitter.Store(&admin); err != nil {
+		t.Error(err)
+		return
+	}
+	superuser := Role{Team: admin.Team, Label: "Superuser", Permission: UserPermission{PermAdmin}}
+	if err := superuser.Store(&admin); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := (&Role{Team: admin.Team, Label: "Unknown", Permission: UserPermission{"fly"}}).Store(&admin); !errors.Is(err, ErrInvalidPermissionName) {
+		t.Errorf("expected unknown permission error, got %v", err)
+	}
+	// users can't grant roles with permissions they don't have
+	if err := (&User{Team: admin.Team, Email: "escalate@example.com", Roles: []DatabaseID{superuser.ID}}).Store(&manager); !errors.Is(err, ErrInvalidPermission) {
+		t.Errorf("expected invalid permission error, got %v", err)
+	}
+	otherRole := DatabaseIDFromString("zzzzzzzz")
+	if otherRole.IsEmpty() || otherRole == reader.ID || otherRole == submitter.ID || otherRole == superuser.ID {
+		t.Errorf("expected %s to not be a role id", otherRole.String())
+		return
+	}
+	if err := (&User{Team: admin.Team, Email: "unknown@example.com", Roles: []DatabaseID{otherRole}}).Store(&admin); !errors.Is(err, ErrInvalidRole) {
+		t.Errorf("expected invalid role error, got %v", err)
+	}
+	readUser := User{Team: admin.Team, Email: "reader@example.com", Roles: []DatabaseID{reader.ID}}
+	if err := readUser.Store(&manager); err != nil {
+		t.Error(err)
+		return
+	}
+	submitUser := User{Team: admin.Team, Email: "submitter@example.com", Roles: []DatabaseID{submitter.ID}}
+	if err := submitUser.Store(&manager); err != nil {
+		t.Error(err)
+		return
+	}
+	// form + document + submission
+	form := TreeRoot{Type: TreeForm, Parent: admin.Team, Label: "Roles"}
+	if err := form.Store(&admin); err != nil {
+		t.Error(err)
+		return
+	}
+	document := TreeRoot{Type: TreeDocument, Parent: form.ID, Label: "Roles Document"}
+	if err := document.Store(&admin); err != nil {
+		t.Error(err)
+		return
+	}
+	version := TreeVersion{RootID: form.ID, State: TreePublished, Tree: getTestTree(form.ID.String())}
+	if err := version.Store(&admin); err != nil {
+		t.Error(err)
+		return
+	}
+	adminSubmission := FormSubmission{FormID: form.ID, FormVersion: 1}
+	if err := adminSubmission.Store(&admin); err != nil {
+		t.Error(err)
+		return
+	}
+	// permissions are resolved from roles
+	readSession, err := FetchUserByID(readUser.ID.String(), nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	submitSession, err := FetchUserByID(submitUser.ID.String(), nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !readSession.HasPermission(PermRead) || readSession.HasPermission(PermSubmit) || !submitSession.HasPermission(PermSubmit) {
+		t.Errorf("expected permissions of roles, got %v and %v", readSession.RolePermission, submitSession.RolePermission)
+	}
+	for _, test := range []struct {
+		name   string
+		action string
+		object interface{}
+		user   *User
+		allow  bool
+	}{
+		{"read form", permActionFetch, &form, readSession, true},
+		{"read document", permActionFetch, &document, readSession, true},
+		{"read submission", permActionFetch, &adminSubmission, readSession, true},
+		{"read only form", permActionStore, &form, readSession, false},
+		{"read only submit", permActionStore, &FormSubmission{FormID: form.ID, FormVersion: 1}, readSession, false},
+		{"submit form", permActionFetch, &form, submitSession, true},
+		{"submit version", permActionFetch, &version, submitSession, true},
+		{"submit document", permActionFetch, &document, submitSession, false},
+		{"submit other submission", permActionFetch, &adminSubmission, submitSession, false},
+		{"submit", permActionStore, &FormSubmission{FormID: form.ID, FormVersion: 1}, submitSession, true},
+		{"submit role", permActionFetch, &reader, submitSession, false},
+	} {
+		err := checkPermission(test.action, test.object, test.user)
+		if test.allow && err != nil {
+			t.Errorf("%s: expected to be allowed, got %s", test.name, err)
+		} else if !test.allow && !errors.Is(err, ErrInvalidPermission) {
+			t.Errorf("%s: expected to be denied, got %v", test.name, err)
+		}
+	}
+	// submit only users list their own submissions
+	ownSubmission := FormSubmission{FormID: form.ID, FormVersion: 1}
+	if err := ownSubmission.Store(submitSession); err != nil {
+		t.Error(err)
+		return
+	}
+	res, _, err := ListFormSubmission(FormSubmissionFilter{FormID: form.ID.String()}, submitSession, ListPage{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(res) != 1 || res[0].ID != ownSubmission.ID {
+		t.Errorf("expected only own submission to be listed, got %d", len(res))
+	}
+	// removing all roles of a user revokes their permissions
+	submitUser.Roles = nil
+	if err := submitUser.Store(&admin); err != nil {
+		t.Error(err)
+		return
+	}
+	if submitSession, err = FetchUserByID(submitUser.ID.String(), nil); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(submitSession.Roles) != 0 || submitSession.HasPermission(PermSubmit) {
+		t.Errorf("expected removed roles to grant nothing")
+	}
+	// editing a role changes the permissions of all of its users
+	reader.Permission = UserPermission{PermRead, PermManageForm}
+	if err := reader.Store(&admin); err != nil {
+		t.Error(err)
+		return
+	}
+	if readSession, err = FetchUserByID(readUser.ID.String(), nil); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := checkStorePermission(&form, readSession); err != nil {
+		t.Errorf("expected permission of edited role, got %s", err)
+	}
+	// deleted roles no longer grant anything
+	if err := reader.Delete(&admin); err != nil {
+		t.Error(err)
+		return
+	}
+	if readSession, err = FetchUserByID(readUser.ID.String(), nil); err != nil {
+		t.Error(err)
+		return
+	}
+	if readSession.HasPermission(PermRead) {
+		t.Errorf("expected deleted role to grant nothing")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type RuleTemplate struct {
@@ -17,6 +18,12 @@ type RuleTemplate struct {
 	Script   string     `bson:"script,omitempty" json:"script,omitempty"`
 }
 
+// ruleTemplateSortFields are the sorts accepted when listing rule templates.
+var ruleTemplateSortFields = ListSortFields{
+	Default: "-created",
+	Fields:  map[string]string{"created": "created", "modified": "modified", "label": "label"},
+}
+
 // Fetch a rule template object from the database.
 func FetchRuleTemplate(id string, user *User) (*RuleTemplate, error) {
 	if user == nil {
@@ -24,7 +31,7 @@ func FetchRuleTemplate(id string, user *User) (*RuleTemplate, error) {
 	}
 	// database fetch
 	dbId := DatabaseIDFromString(id)
-	res, err := databaseFetch(RuleTemplate{}, bson.M{"_id": dbId}, nil)
+	res, err := databaseFetch(user.getStore(), RuleTemplate{}, bson.M{"_id": dbId}, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -37,19 +44,26 @@ func FetchRuleTemplate(id string, user *User) (*RuleTemplate, error) {
 }
 
 // List all rule templates that user's team has access to.
-func ListRuleTemplate(user *User, offset int) ([]*RuleTemplate, int, error) {
+func ListRuleTemplate(user *User, page ListPage) ([]*RuleTemplate, ListPageInfo, error) {
 	if user == nil {
-		return nil, 0, ErrNoUser
+		return nil, ListPageInfo{}, ErrNoUser
 	}
 	// databse fetch
-	res, count, err := databaseList(RuleTemplate{}, bson.M{"team": user.Team}, bson.M{"created": -1}, nil, offset)
+	res, info, err := databasePage(
+		user.getStore(),
+		RuleTemplate{},
+		mongo.Pipeline{bson.D{{Key: "$match", Value: bson.M{"team": user.Team}}}},
+		nil,
+		ruleTemplateSortFields,
+		page,
+	)
 	if err != nil {
-		return nil, 0, err
+		return nil, info, err
 	}
 	// check permission
 	if len(res) > 0 {
 		if err := checkFetchPermission(res[0], user); err != nil {
-			return nil, 0, err
+			return nil, ListPageInfo{}, err
 		}
 	}
 	// format output
@@ -57,16 +71,17 @@ func ListRuleTemplate(user *User, offset int) ([]*RuleTemplate, int, error) {
 	for _, item := range res {
 		out = append(out, item.(*RuleTemplate))
 	}
-	return out, count, nil
+	return out, info, nil
 }
 
 // List all rule templates that user's team has access to.
 func ListAllRuleTemplate(user *User) ([]*RuleTemplate, error) {
-	if user == nil {
-		return nil, ErrNoUser
+	if err := checkListPermission(permKindRuleTemplate, user); err != nil {
+		return nil, err
 	}
 	// databse fetch
 	res, err := databaseListAll(
+		user.getStore(),
 		RuleTemplate{},
 		bson.M{"team": user.Team},
 		bson.M{"created": -1},
@@ -87,6 +102,22 @@ func ListRuleTemplateByID(user *User, ids []string) ([]*RuleTemplate, error) {
 	if user == nil {
 		return nil, ErrNoUser
 	}
+	res, err := listRuleTemplateByID(user.getStore(), user.Team, ids)
+	if err != nil {
+		return nil, err
+	}
+	// check permission
+	if len(res) > 0 {
+		if err := checkFetchPermission(res[0], user); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// listRuleTemplateByID lists the rule templates of team with given ids without checking permission, it's
+// used for the templates of tree versions users have access to.
+func listRuleTemplateByID(tx Store, team DatabaseID, ids []string) ([]*RuleTemplate, error) {
 	if ids == nil {
 		return []*RuleTemplate{}, nil
 	}
@@ -97,20 +128,15 @@ func ListRuleTemplateByID(user *User, ids []string) ([]*RuleTemplate, error) {
 	}
 	// databse fetch
 	res, err := databaseListAll(
+		tx,
 		RuleTemplate{},
-		bson.M{"team": user.Team, "_id": bson.M{"$in": dbIDs}},
+		bson.M{"team": team, "_id": bson.M{"$in": dbIDs}},
 		bson.M{"created": -1},
 		nil,
 	)
 	if err != nil {
 		return nil, err
 	}
-	// check permission
-	if len(res) > 0 {
-		if err := checkFetchPermission(res[0], user); err != nil {
-			return nil, err
-		}
-	}
 	// format output
 	out := make([]*RuleTemplate, 0)
 	for _, item := range res {
@@ -136,10 +162,10 @@ func (t *RuleTemplate) Store(user *User) error {
 		t.Creator = user.ID
 		t.Created = t.Modified
 	}
-	if err := databaseStoreOne(t); err != nil {
+	if err := auditStoreOne(t, user); err != nil {
 		return err
 	}
-	return nil
+	return searchIndexRuleTemplate(user.getStore(), t)
 }
 
 // Delete the rule template.
@@ -150,5 +176,15 @@ func (t *RuleTemplate) Delete(user *User) error {
 	if err := checkDeletePermission(t, user); err != nil {
 		return err
 	}
-	return databaseDelete(RuleTemplate{}, bson.M{"_id": t.ID})
+	before, err := auditFetch(user.getStore(), t)
+	if err != nil {
+		return err
+	}
+	if err := databaseDelete(user.getStore(), RuleTemplate{}, bson.M{"_id": t.ID}); err != nil {
+		return err
+	}
+	if err := searchRemove(user.getStore(), t.ID); err != nil {
+		return err
+	}
+	return auditRecord(AuditDelete, before, nil, user)
 }
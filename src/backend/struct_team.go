@@ -1,12 +1,20 @@
 package main
 
 import (
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 )
 
 const TeamOptionAllowSignUp = "allowSignUp"
+const TeamOptionRequireApproval = "requireApproval"
+
+// Sign up options are comma separated lists. Users can only sign up with an email of one of the
+// allowed domains, any domain if none are set, and get the default permissions and roles.
+const TeamOptionSignUpDomains = "signUpDomains"
+const TeamOptionSignUpPermissions = "signUpPermissions"
+const TeamOptionSignUpRoles = "signUpRoles"
 
 type Team struct {
 	ID        DatabaseID        `bson:"_id" json:"id"`
@@ -24,7 +32,7 @@ func FetchTeamByID(id string, user *User) (*Team, error) {
 		return nil, ErrInvalidPermission
 	}
 	dbId := DatabaseIDFromString(id)
-	res, err := databaseFetch(Team{}, bson.M{"_id": dbId}, nil)
+	res, err := databaseFetch(user.getStore(), Team{}, bson.M{"_id": dbId}, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -36,6 +44,9 @@ func (t *Team) Store(user *User) error {
 	if err := checkStorePermission(t, user); err != nil {
 		return err
 	}
+	if err := t.validateSignUpOptions(user.getStore()); err != nil {
+		return err
+	}
 	t.Modified = time.Now()
 	t.Modifier = user.ID
 	if t.ID.IsEmpty() {
@@ -43,7 +54,65 @@ func (t *Team) Store(user *User) error {
 		t.Created = t.Modified
 		t.Creator = user.ID
 	}
-	return databaseStoreOne(t)
+	return auditStoreOne(t, user)
+}
+
+// optionList returns the values of a comma separated option.
+func (t *Team) optionList(name string) []string {
+	out := make([]string, 0)
+	for _, value := range strings.Split(t.Options[name], ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			out = append(out, value)
+		}
+	}
+	return out
+}
+
+// signUpPermission returns the permissions and roles given to users that sign up.
+func (t *Team) signUpPermission() (UserPermission, []DatabaseID) {
+	perms := UserPermission(t.optionList(TeamOptionSignUpPermissions))
+	roles := make([]DatabaseID, 0)
+	for _, role := range t.optionList(TeamOptionSignUpRoles) {
+		roles = append(roles, DatabaseIDFromString(role))
+	}
+	return perms, roles
+}
+
+// validateSignUpOptions checks that the default permissions exist and the default roles are roles of the team.
+func (t *Team) validateSignUpOptions(tx Store) error {
+	perms, roles := t.signUpPermission()
+	if err := perms.Validate(); err != nil {
+		return err
+	}
+	res, err := listTeamRoles(tx, t.ID, roles)
+	if err != nil {
+		return err
+	}
+	if len(res) != len(roles) {
+		return ErrInvalidRole
+	}
+	return nil
+}
+
+// CanSignUp checks if a user with given email can sign up to the team.
+func (t *Team) CanSignUp(email string) error {
+	if t.Options[TeamOptionAllowSignUp] != "true" {
+		return ErrSignUpDisabled
+	}
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return ErrObjInvalidParam
+	}
+	domains := t.optionList(TeamOptionSignUpDomains)
+	if len(domains) == 0 {
+		return nil
+	}
+	for _, domain := range domains {
+		if strings.EqualFold(strings.TrimPrefix(domain, "@"), email[at+1:]) {
+			return nil
+		}
+	}
+	return ErrSignUpDomain
 }
 
 func (t *Team) Delete(user *User) error {
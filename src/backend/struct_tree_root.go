@@ -16,16 +16,43 @@ const (
 	TreeDocument TreeType = "document"
 )
 
-// TreeRoot is the container for a decision tree.
+// Rights granted by the access control list of a form.
+const (
+	TreeACLView             = "view"              // View the form, its versions and documents.
+	TreeACLSubmit           = "submit"            // Create submissions.
+	TreeACLEdit             = "edit"              // Create/Edit/Delete the form, its versions and documents.
+	TreeACLPublish          = "publish"           // Publish versions.
+	TreeACLManageSubmission = "manage_submission" // View/Edit/Delete the submissions of other users.
+)
+
+// TreeACLEntry grants rights on a form to a user or to every user with a role. All rights include view.
+type TreeACLEntry struct {
+	User   DatabaseID `bson:"user,omitempty" json:"user,omitempty"`
+	Role   DatabaseID `bson:"role,omitempty" json:"role,omitempty"`
+	Rights []string   `bson:"rights" json:"rights"`
+}
+
+// TreeRoot is the container for a decision tree. Forms with an access control list can only be used by
+// the users and roles it lists, team admins and the form's creator. Documents use the list of their form.
+// Public forms can be filled in by anyone with their share token.
 type TreeRoot struct {
-	ID       DatabaseID `bson:"_id" json:"id"`
-	Created  time.Time  `bson:"created,omitempty" json:"created"`
-	Modified time.Time  `bson:"modified,omitempty" json:"modified"`
-	Creator  DatabaseID `bson:"creator,omitempty" json:"creator"`
-	Modifier DatabaseID `bson:"modifier,omitempty" json:"modifier"`
-	Type     TreeType   `bson:"type" json:"type"`
-	Parent   DatabaseID `bson:"parent" json:"parent"`
-	Label    string     `bson:"label" json:"label"`
+	ID         DatabaseID     `bson:"_id" json:"id"`
+	Created    time.Time      `bson:"created,omitempty" json:"created"`
+	Modified   time.Time      `bson:"modified,omitempty" json:"modified"`
+	Creator    DatabaseID     `bson:"creator,omitempty" json:"creator"`
+	Modifier   DatabaseID     `bson:"modifier,omitempty" json:"modifier"`
+	Type       TreeType       `bson:"type" json:"type"`
+	Parent     DatabaseID     `bson:"parent" json:"parent"`
+	Label      string         `bson:"label" json:"label"`
+	ACL        []TreeACLEntry `bson:"acl" json:"acl,omitempty"`
+	Public     bool           `bson:"public" json:"public"`
+	ShareToken string         `bson:"share_token" json:"share_token,omitempty"`
+}
+
+// treeRootSortFields are the sorts accepted when listing tree roots.
+var treeRootSortFields = ListSortFields{
+	Default: "-created",
+	Fields:  map[string]string{"created": "created", "modified": "modified", "label": "label"},
 }
 
 // Fetch a tree root object from the database.
@@ -35,7 +62,7 @@ func FetchTreeRoot(id string, user *User) (*TreeRoot, error) {
 	}
 	// database fetch
 	dbId := DatabaseIDFromString(id)
-	res, err := databaseFetch(TreeRoot{}, bson.M{"_id": dbId}, nil)
+	res, err := databaseFetch(user.getStore(), TreeRoot{}, bson.M{"_id": dbId}, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -47,19 +74,19 @@ func FetchTreeRoot(id string, user *User) (*TreeRoot, error) {
 	return treeRoot, nil
 }
 
-func listRoot(filter bson.M, user *User, offset int) ([]*TreeRoot, int, error) {
+func listRoot(pipeline mongo.Pipeline, user *User, page ListPage) ([]*TreeRoot, ListPageInfo, error) {
 	if user == nil {
-		return nil, 0, ErrNoUser
+		return nil, ListPageInfo{}, ErrNoUser
 	}
 	// databse fetch
-	res, count, err := databaseList(TreeRoot{}, filter, bson.M{"created": -1}, nil, offset)
+	res, info, err := databasePage(user.getStore(), TreeRoot{}, pipeline, nil, treeRootSortFields, page)
 	if err != nil {
-		return nil, 0, err
+		return nil, info, err
 	}
 	// check permission
 	if len(res) > 0 {
 		if err := checkFetchPermission(res[0], user); err != nil {
-			return nil, 0, err
+			return nil, ListPageInfo{}, err
 		}
 	}
 	// format output
@@ -67,37 +94,45 @@ func listRoot(filter bson.M, user *User, offset int) ([]*TreeRoot, int, error) {
 	for _, item := range res {
 		out = append(out, item.(*TreeRoot))
 	}
-	return out, count, nil
+	return out, info, nil
+}
+
+// formRootFilter returns the filter matching the forms of user's team that user can view.
+func formRootFilter(user *User) bson.M {
+	filter := aclListFilter(user, TreeACLView, permKindForm)
+	filter["type"] = string(TreeForm)
+	filter["parent"] = user.Team
+	return filter
 }
 
 // List all tree root forms that user's team has access to.
-func ListFormRoot(user *User, offset int) ([]*TreeRoot, int, error) {
+func ListFormRoot(user *User, page ListPage) ([]*TreeRoot, ListPageInfo, error) {
 	if user == nil {
-		return nil, 0, ErrNoUser
+		return nil, ListPageInfo{}, ErrNoUser
 	}
 	return listRoot(
-		bson.M{"type": string(TreeForm), "parent": user.Team},
+		mongo.Pipeline{bson.D{{Key: "$match", Value: formRootFilter(user)}}},
 		user,
-		offset,
+		page,
 	)
 }
 
 // List all documents for given form tree root uid.
-func ListDocumentRoot(formRootUid string, user *User, offset int) ([]*TreeRoot, int, error) {
+func ListDocumentRoot(formRootUid string, user *User, page ListPage) ([]*TreeRoot, ListPageInfo, error) {
+	// documents use the access control list of their form
+	if _, err := FetchTreeRoot(formRootUid, user); err != nil {
+		return nil, ListPageInfo{}, err
+	}
 	pFormRootUid := DatabaseIDFromString(formRootUid)
 	return listRoot(
-		bson.M{"type": string(TreeDocument), "parent": pFormRootUid},
+		mongo.Pipeline{bson.D{{Key: "$match", Value: bson.M{"type": string(TreeDocument), "parent": pFormRootUid}}}},
 		user,
-		offset,
+		page,
 	)
 }
 
-func listPublishedRoot(filter bson.M, user *User, offset int) ([]*TreeRoot, int, error) {
-	if user == nil {
-		return nil, 0, ErrNoUser
-	}
-	res, count, err := databaseListAggregate(
-		TreeRoot{},
+func listPublishedRoot(filter bson.M, user *User, page ListPage) ([]*TreeRoot, ListPageInfo, error) {
+	return listRoot(
 		mongo.Pipeline{
 			bson.D{bson.E{Key: "$match", Value: filter}},
 			bson.D{bson.E{Key: "$lookup", Value: bson.M{
@@ -110,6 +145,11 @@ func listPublishedRoot(filter bson.M, user *User, offset int) ([]*TreeRoot, int,
 								"$and": bson.A{
 									bson.M{"$eq": bson.A{"$root_id", "$$root_id"}},
 									bson.M{"$eq": bson.A{"$state", TreePublished}},
+									// exclude closed versions
+									bson.M{"$or": bson.A{
+										bson.M{"$lte": bson.A{"$unpublish_at", time.Time{}}},
+										bson.M{"$gt": bson.A{"$unpublish_at", time.Now()}},
+									}},
 								},
 							},
 						},
@@ -126,48 +166,35 @@ func listPublishedRoot(filter bson.M, user *User, offset int) ([]*TreeRoot, int,
 			bson.D{bson.E{Key: "$project", Value: bson.M{
 				"published_version": 0,
 			}}},
-			bson.D{bson.E{Key: "$sort", Value: bson.M{
-				"created": -1,
-			}}},
 		},
-		offset,
+		user,
+		page,
 	)
-	if err != nil {
-		return nil, 0, err
-	}
-	// check permission
-	if len(res) > 0 {
-		if err := checkFetchPermission(res[0], user); err != nil {
-			return nil, 0, err
-		}
-	}
-	// format output
-	out := make([]*TreeRoot, 0)
-	for _, item := range res {
-		out = append(out, item.(*TreeRoot))
-	}
-	return out, count, nil
 }
 
-// List all form that contain published version.
-func ListPublishedFormRoot(user *User, offset int) ([]*TreeRoot, int, error) {
+// List all form that contain a published version that isn't closed.
+func ListPublishedFormRoot(user *User, page ListPage) ([]*TreeRoot, ListPageInfo, error) {
 	if user == nil {
-		return nil, 0, ErrNoUser
+		return nil, ListPageInfo{}, ErrNoUser
 	}
 	return listPublishedRoot(
-		bson.M{"type": string(TreeForm), "parent": user.Team},
+		formRootFilter(user),
 		user,
-		offset,
+		page,
 	)
 }
 
 // List all documents that contain published version for given form tree root uid.
-func ListPublishedDocumentRoot(formRootUid string, user *User, offset int) ([]*TreeRoot, int, error) {
+func ListPublishedDocumentRoot(formRootUid string, user *User, page ListPage) ([]*TreeRoot, ListPageInfo, error) {
+	// documents use the access control list of their form
+	if _, err := FetchTreeRoot(formRootUid, user); err != nil {
+		return nil, ListPageInfo{}, err
+	}
 	pFormRootUid := DatabaseIDFromString(formRootUid)
 	return listPublishedRoot(
 		bson.M{"type": string(TreeDocument), "parent": pFormRootUid},
 		user,
-		offset,
+		page,
 	)
 }
 
@@ -176,6 +203,24 @@ func (t *TreeRoot) Store(user *User) error {
 	if user == nil {
 		return ErrNoUser
 	}
+	// documents use the access control list of their form and can't be public
+	if t.Type == TreeDocument {
+		t.ACL = nil
+		t.Public = false
+	}
+	if err := t.validateACL(); err != nil {
+		return err
+	}
+	// public forms get a share token, making a form private revokes it
+	if !t.Public {
+		t.ShareToken = ""
+	} else if t.ShareToken == "" {
+		token, err := generateToken(publicShareTokenPrefix)
+		if err != nil {
+			return err
+		}
+		t.ShareToken = token
+	}
 	// check permission
 	if err := checkStorePermission(t, user); err != nil {
 		return err
@@ -190,7 +235,10 @@ func (t *TreeRoot) Store(user *User) error {
 		t.Creator = user.ID
 		t.Created = t.Modified
 	}
-	if err := databaseStoreOne(t); err != nil {
+	if err := auditStoreOne(t, user); err != nil {
+		return err
+	}
+	if err := searchIndexRoot(user.getStore(), t); err != nil {
 		return err
 	}
 	// create first version if new tree
@@ -219,18 +267,22 @@ func (t *TreeRoot) Delete(user *User) error {
 	if err := checkDeletePermission(t, user); err != nil {
 		return err
 	}
+	before, err := auditFetch(user.getStore(), t)
+	if err != nil {
+		return err
+	}
 	// delete tree versions
-	if err := databaseDelete(TreeVersion{}, bson.M{"root_id": t.ID}); err != nil {
+	if err := databaseDelete(user.getStore(), TreeVersion{}, bson.M{"root_id": t.ID}); err != nil {
 		return err
 	}
 	if t.Type == TreeForm {
 		// delete form submissions
-		if err := databaseDelete(FormSubmission{}, bson.M{"form_id": t.ID}); err != nil {
+		if err := databaseDelete(user.getStore(), FormSubmission{}, bson.M{"form_id": t.ID}); err != nil {
 			return err
 		}
 		// delete documents
 		for {
-			res, count, err := databaseList(TreeRoot{}, bson.M{"parent": t.ID, "type": TreeDocument}, nil, nil, 0)
+			res, count, err := databaseList(user.getStore(), TreeRoot{}, bson.M{"parent": t.ID, "type": TreeDocument}, nil, nil, 0)
 			if count == 0 {
 				break
 			}
@@ -244,5 +296,34 @@ func (t *TreeRoot) Delete(user *User) error {
 			}
 		}
 	}
-	return databaseDelete(TreeRoot{}, bson.M{"_id": t.ID})
+	if err := databaseDelete(user.getStore(), TreeRoot{}, bson.M{"_id": t.ID}); err != nil {
+		return err
+	}
+	if err := searchRemove(user.getStore(), t.ID); err != nil {
+		return err
+	}
+	return auditRecord(AuditDelete, before, nil, user)
+}
+
+// validateACL checks that every entry of the access control list is for either a user or a role and
+// only grants known rights.
+func (t *TreeRoot) validateACL() error {
+	for _, entry := range t.ACL {
+		if entry.User.IsEmpty() == entry.Role.IsEmpty() || len(entry.Rights) == 0 {
+			return ErrObjInvalidParam
+		}
+		for _, right := range entry.Rights {
+			switch right {
+			case TreeACLView, TreeACLSubmit, TreeACLEdit, TreeACLPublish, TreeACLManageSubmission:
+				{
+					break
+				}
+			default:
+				{
+					return ErrObjInvalidParam
+				}
+			}
+		}
+	}
+	return nil
 }
@@ -78,3 +78,173 @@ func TestTreeRootPermissions(t *testing.T) {
 		return
 	}
 }
+
+func TestTreeRootACL(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	owner := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermManageForm, PermManageDocument},
+	}
+	nextID := owner.ID
+	newUser := func(permission UserPermission) *User {
+		nextID[0]++
+		return &User{ID: nextID, Team: owner.Team, Permission: permission}
+	}
+	reader := newUser(UserPermission{PermRead})
+	manager := newUser(UserPermission{PermManageForm})
+	viewer := newUser(UserPermission{})
+	submitter := newUser(UserPermission{})
+	editor := newUser(UserPermission{})
+	editorRole := Role{ID: owner.Team, Team: owner.Team, Label: "Editors"}
+	editor.Roles = []DatabaseID{editorRole.ID}
+	// restricted form + document
+	restricted := TreeRoot{Type: TreeForm, Parent: owner.Team, Label: "Restricted", ACL: []TreeACLEntry{
+		{User: viewer.ID, Rights: []string{TreeACLView}},
+		{User: submitter.ID, Rights: []string{TreeACLSubmit}},
+		{Role: editorRole.ID, Rights: []string{TreeACLEdit, TreeACLPublish}},
+	}}
+	if err := restricted.Store(&owner); err != nil {
+		t.Error(err)
+		return
+	}
+	document := TreeRoot{Type: TreeDocument, Parent: restricted.ID, Label: "Restricted Document"}
+	if err := document.Store(&owner); err != nil {
+		t.Error(err)
+		return
+	}
+	open := TreeRoot{Type: TreeForm, Parent: owner.Team, Label: "Open"}
+	if err := open.Store(&owner); err != nil {
+		t.Error(err)
+		return
+	}
+	version, err := FetchTreeVersion(restricted.ID.String(), 1, &owner)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, test := range []struct {
+		name   string
+		action string
+		object interface{}
+		user   *User
+		allow  bool
+	}{
+		{"reader restricted", permActionFetch, &restricted, reader, false},
+		{"reader document", permActionFetch, &document, reader, false},
+		{"reader open", permActionFetch, &open, reader, true},
+		{"manager restricted", permActionStore, &restricted, manager, false},
+		{"manager open", permActionStore, &open, manager, true},
+		{"viewer restricted", permActionFetch, &restricted, viewer, true},
+		{"viewer document", permActionFetch, &document, viewer, true},
+		{"viewer version", permActionFetch, version, viewer, true},
+		{"viewer edit", permActionStore, &restricted, viewer, false},
+		{"viewer open", permActionFetch, &open, viewer, false},
+		{"viewer submit", permActionStore, &FormSubmission{FormID: restricted.ID, FormVersion: 1}, viewer, false},
+		{"submitter submit", permActionStore, &FormSubmission{FormID: restricted.ID, FormVersion: 1}, submitter, true},
+		{"editor edit", permActionStore, &restricted, editor, true},
+		{"editor document", permActionStore, &document, editor, true},
+		{"editor publish", permActionPublish, version, editor, true},
+		{"viewer publish", permActionPublish, version, viewer, false},
+	} {
+		err := checkPermission(test.action, test.object, test.user)
+		if test.allow && err != nil {
+			t.Errorf("%s: expected to be allowed, got %s", test.name, err)
+		} else if !test.allow && !errors.Is(err, ErrInvalidPermission) {
+			t.Errorf("%s: expected to be denied, got %v", test.name, err)
+		}
+	}
+	// lists only contain forms user can view
+	for _, test := range []struct {
+		name   string
+		user   *User
+		expect []DatabaseID
+	}{
+		{"owner", &owner, []DatabaseID{open.ID, restricted.ID}},
+		{"reader", reader, []DatabaseID{open.ID}},
+		{"viewer", viewer, []DatabaseID{restricted.ID}},
+	} {
+		res, _, err := ListFormRoot(test.user, ListPage{Sort: "label"})
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if len(res) != len(test.expect) {
+			t.Errorf("%s: expected %d forms, got %d", test.name, len(test.expect), len(res))
+			continue
+		}
+		for i := range res {
+			if res[i].ID != test.expect[i] {
+				t.Errorf("%s: unexpected form %s", test.name, res[i].Label)
+			}
+		}
+	}
+	if _, _, err := ListDocumentRoot(restricted.ID.String(), reader, ListPage{}); !errors.Is(err, ErrInvalidPermission) {
+		t.Errorf("expected documents of restricted form to be hidden, got %v", err)
+	}
+	// only the creator and admins change the list
+	restricted.ACL = append(restricted.ACL, TreeACLEntry{User: editor.ID, Rights: []string{TreeACLManageSubmission}})
+	if err := restricted.Store(editor); !errors.Is(err, ErrInvalidPermission) {
+		t.Errorf("expected editor to be denied changing the list, got %v", err)
+	}
+	if err := restricted.Store(&owner); err != nil {
+		t.Error(err)
+		return
+	}
+	// submissions are listed to users with the manage submission right
+	submission := FormSubmission{FormID: restricted.ID, FormVersion: 1}
+	if err := submission.Store(submitter); err != nil {
+		t.Error(err)
+		return
+	}
+	for _, test := range []struct {
+		name   string
+		user   *User
+		expect int
+	}{
+		{"submitter", submitter, 1},
+		{"editor", editor, 1},
+		{"reader", reader, 0},
+	} {
+		res, _, err := ListFormSubmission(FormSubmissionFilter{FormID: restricted.ID.String()}, test.user, ListPage{})
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if len(res) != test.expect {
+			t.Errorf("%s: expected %d submissions, got %d", test.name, test.expect, len(res))
+		}
+	}
+	restricted.ACL = []TreeACLEntry{{User: editor.ID, Role: editorRole.ID, Rights: []string{TreeACLView}}}
+	if err := restricted.Store(&owner); !errors.Is(err, ErrObjInvalidParam) {
+		t.Errorf("expected invalid entry error, got %v", err)
+	}
+	// clearing the list opens the form to the team
+	restricted.ACL = nil
+	if err := restricted.Store(&owner); err != nil {
+		t.Error(err)
+		return
+	}
+	stored, err := FetchTreeRoot(restricted.ID.String(), &owner)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(stored.ACL) != 0 {
+		t.Errorf("expected list to be cleared")
+	}
+	if err := checkFetchPermission(stored, reader); err != nil {
+		t.Errorf("expected reader to see form without list, got %s", err)
+	}
+	res, _, err := ListFormRoot(reader, ListPage{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(res) != 2 {
+		t.Errorf("expected reader to list both forms, got %d", len(res))
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
@@ -13,11 +14,27 @@ import (
 type TreeState string
 
 const (
-	TreeDraft     TreeState = "draft"
-	TreePublished TreeState = "published"
-	TreeArchived  TreeState = "archived"
+	TreeDraft         TreeState = "draft"
+	TreePendingReview TreeState = "pending_review"
+	TreePublished     TreeState = "published"
+	TreeArchived      TreeState = "archived"
 )
 
+// Tree review actions.
+const (
+	TreeReviewRequested = "requested"
+	TreeReviewApproved  = "approved"
+	TreeReviewRejected  = "rejected"
+)
+
+// TreeReview is a review request or decision made on a tree version.
+type TreeReview struct {
+	User    DatabaseID `bson:"user" json:"user"`
+	Action  string     `bson:"action" json:"action"`
+	Comment string     `bson:"comment,omitempty" json:"comment,omitempty"`
+	Created time.Time  `bson:"created" json:"created"`
+}
+
 // TreeVersion is a tree's version and its state.
 type TreeVersion struct {
 	RootID        DatabaseID      `bson:"root_id" json:"root_id"`
@@ -28,13 +45,27 @@ type TreeVersion struct {
 	Version       int             `bson:"version" json:"version"`
 	State         TreeState       `bson:"state" json:"state"`
 	Tree          []Node          `bson:"tree" json:"tree"`
+	CopiedFrom    int             `bson:"copied_from,omitempty" json:"copied_from,omitempty"`
+	PublishAt     time.Time       `bson:"publish_at" json:"publish_at"`
+	UnpublishAt   time.Time       `bson:"unpublish_at" json:"unpublish_at"`
+	ScheduledBy   DatabaseID      `bson:"scheduled_by,omitempty" json:"scheduled_by,omitempty"`
+	ScheduleError string          `bson:"schedule_error" json:"schedule_error,omitempty"`
+	Reviews       []TreeReview    `bson:"reviews,omitempty" json:"reviews"`
+	Issues        []TreeIssue     `bson:"-" json:"issues,omitempty"`
 	RuleTemplates []*RuleTemplate `bson:"-" json:"rule_templates"`
 }
 
+// treeVersionSortFields are the sorts accepted when listing tree versions, versions are unique within a tree.
+var treeVersionSortFields = ListSortFields{
+	Default: "-created",
+	Unique:  "version",
+	Fields:  map[string]string{"created": "created", "modified": "modified", "version": "version", "state": "state"},
+}
+
 func FetchTreeVersion(rootId string, version int, user *User) (*TreeVersion, error) {
 	// database fetch
 	rootDbId := DatabaseIDFromString(rootId)
-	res, err := databaseFetch(TreeVersion{}, bson.M{"root_id": rootDbId, "version": version}, nil)
+	res, err := databaseFetch(user.getStore(), TreeVersion{}, bson.M{"root_id": rootDbId, "version": version}, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -44,14 +75,14 @@ func FetchTreeVersion(rootId string, version int, user *User) (*TreeVersion, err
 		return nil, err
 	}
 	// fetch rule templates
-	treeVersion.RuleTemplates, err = ListRuleTemplateByID(user, treeVersion.GetRuleTemplateIDs())
+	treeVersion.RuleTemplates, err = listRuleTemplateByID(user.getStore(), user.Team, treeVersion.GetRuleTemplateIDs())
 	return treeVersion, err
 }
 
 func FetchTreeVersionLatest(rootId string, user *User) (*TreeVersion, error) {
 	// database fetch
 	rootDbId := DatabaseIDFromString(rootId)
-	res, err := databaseFetch(TreeVersion{}, bson.M{"root_id": rootDbId}, bson.M{"version": -1})
+	res, err := databaseFetch(user.getStore(), TreeVersion{}, bson.M{"root_id": rootDbId}, bson.M{"version": -1})
 	if err != nil {
 		return nil, err
 	}
@@ -61,14 +92,14 @@ func FetchTreeVersionLatest(rootId string, user *User) (*TreeVersion, error) {
 		return nil, err
 	}
 	// fetch rule templates
-	treeVersion.RuleTemplates, err = ListRuleTemplateByID(user, treeVersion.GetRuleTemplateIDs())
+	treeVersion.RuleTemplates, err = listRuleTemplateByID(user.getStore(), user.Team, treeVersion.GetRuleTemplateIDs())
 	return treeVersion, err
 }
 
 func FetchTreeVersionLatestPublished(rootId string, user *User) (*TreeVersion, error) {
 	// database fetch
 	rootDbId := DatabaseIDFromString(rootId)
-	res, err := databaseFetch(TreeVersion{}, bson.M{"root_id": rootDbId, "state": TreePublished}, bson.M{"version": -1})
+	res, err := databaseFetch(user.getStore(), TreeVersion{}, bson.M{"root_id": rootDbId, "state": TreePublished}, bson.M{"version": -1})
 	if err != nil {
 		return nil, err
 	}
@@ -78,27 +109,28 @@ func FetchTreeVersionLatestPublished(rootId string, user *User) (*TreeVersion, e
 		return nil, err
 	}
 	// fetch rule templates
-	treeVersion.RuleTemplates, err = ListRuleTemplateByID(user, treeVersion.GetRuleTemplateIDs())
+	treeVersion.RuleTemplates, err = listRuleTemplateByID(user.getStore(), user.Team, treeVersion.GetRuleTemplateIDs())
 	return treeVersion, err
 }
 
-func ListTreeVersion(rootId string, user *User, offset int) ([]*TreeVersion, int, error) {
+func ListTreeVersion(rootId string, user *User, page ListPage) ([]*TreeVersion, ListPageInfo, error) {
 	// database fetch
 	rootDbId := DatabaseIDFromString(rootId)
-	res, count, err := databaseList(
+	res, info, err := databasePage(
+		user.getStore(),
 		TreeVersion{},
-		bson.M{"root_id": rootDbId},
-		bson.M{"created": -1},
+		mongo.Pipeline{bson.D{{Key: "$match", Value: bson.M{"root_id": rootDbId}}}},
 		bson.M{"tree": 0},
-		offset,
+		treeVersionSortFields,
+		page,
 	)
 	if err != nil {
-		return nil, 0, err
+		return nil, info, err
 	}
 	// check permission
 	if len(res) > 0 {
 		if err := checkFetchPermission(res[0], user); err != nil {
-			return nil, 0, err
+			return nil, ListPageInfo{}, err
 		}
 	}
 	// format output + check permissions
@@ -106,7 +138,7 @@ func ListTreeVersion(rootId string, user *User, offset int) ([]*TreeVersion, int
 	for _, item := range res {
 		out = append(out, item.(*TreeVersion))
 	}
-	return out, count, nil
+	return out, info, nil
 }
 
 func (t *TreeVersion) Store(user *User) error {
@@ -118,6 +150,23 @@ func (t *TreeVersion) Store(user *User) error {
 	if err := checkStorePermission(t, user); err != nil {
 		return err
 	}
+	// drafts are stored with their issues, they only block publishing
+	validation, err := t.Validate(user)
+	if err != nil {
+		return err
+	}
+	t.Issues = validation.Issues
+	if t.State == TreePublished && validation.HasErrors() {
+		return ErrTreeInvalid
+	}
+	return t.store(user)
+}
+
+// store the tree version without checking permission, callers must have done so.
+func (t *TreeVersion) store(user *User) error {
+	if !t.PublishAt.IsZero() && !t.UnpublishAt.IsZero() && !t.UnpublishAt.After(t.PublishAt) {
+		return ErrObjInvalidParam
+	}
 	// update modifier
 	t.Modifier = user.ID
 	t.Modified = time.Now()
@@ -149,7 +198,10 @@ func (t *TreeVersion) Store(user *User) error {
 		}
 	}
 
-	return databaseStoreOne(t)
+	if err := auditStoreOne(t, user); err != nil {
+		return err
+	}
+	return searchIndexNodes(user.getStore(), t.RootID)
 }
 
 // Delete the tree version.
@@ -162,22 +214,47 @@ func (t *TreeVersion) Delete(user *User) error {
 		return err
 	}
 	// ensure this isn't the only version
-	count, err := databaseCount(TreeVersion{}, bson.M{"root_id": t.RootID})
+	count, err := databaseCount(user.getStore(), TreeVersion{}, bson.M{"root_id": t.RootID})
 	if err != nil {
 		return err
 	}
 	if count <= 1 {
 		return ErrCannotDeleteOnlyVersion
 	}
-	return databaseDelete(TreeVersion{}, bson.M{"root_id": t.RootID, "version": t.Version})
+	before, err := auditFetch(user.getStore(), t)
+	if err != nil {
+		return err
+	}
+	if err := databaseDelete(user.getStore(), TreeVersion{}, bson.M{"root_id": t.RootID, "version": t.Version}); err != nil {
+		return err
+	}
+	if err := searchIndexNodes(user.getStore(), t.RootID); err != nil {
+		return err
+	}
+	return auditRecord(AuditDelete, before, nil, user)
 }
 
 // Publish the tree version, archive any previously published versions.
+// Teams that require approval can only publish through Approve.
 func (t *TreeVersion) Publish(user *User) error {
 	if t.RootID.IsEmpty() || t.Version <= 0 {
 		return ErrObjMissingParam
 	}
-	if err := checkStorePermission(t, user); err != nil {
+	if err := checkPublishPermission(t, user); err != nil {
+		return err
+	}
+	requireApproval, err := t.RequiresApproval(user.getStore())
+	if err != nil {
+		return err
+	}
+	if requireApproval {
+		return ErrApprovalRequired
+	}
+	return t.publish(user)
+}
+
+func (t *TreeVersion) publish(user *User) error {
+	if err := t.checkValid(user); err != nil {
 		return err
 	}
 	currentPublished, err := FetchTreeVersionLatestPublished(t.RootID.String(), user)
@@ -189,26 +266,203 @@ func (t *TreeVersion) Publish(user *User) error {
 		currentPublished.Modified = now
 		currentPublished.Modifier = user.ID
 		currentPublished.State = TreeArchived
-		if err := currentPublished.Store(user); err != nil {
+		if err := currentPublished.store(user); err != nil {
 			return err
 		}
 	}
 	t.State = TreePublished
 	t.Modified = now
 	t.Modifier = user.ID
-	if err := t.Store(user); err != nil {
+	if err := t.store(user); err != nil {
 		return err
 	}
+	if err := auditRecord(AuditPublish, t, t, user); err != nil {
+		return err
+	}
+	webhookDispatch(user.getStore(), user.Team, WebhookTreePublished, t)
 	return nil
 }
 
+// Copy the tree version into a new draft version.
+func (t *TreeVersion) Copy(user *User) (*TreeVersion, error) {
+	if t.RootID.IsEmpty() || t.Version <= 0 {
+		return nil, ErrObjMissingParam
+	}
+	out := &TreeVersion{
+		RootID:     t.RootID,
+		State:      TreeDraft,
+		Tree:       append([]Node{}, t.Tree...),
+		CopiedFrom: t.Version,
+	}
+	if err := out.Store(user); err != nil {
+		return nil, err
+	}
+	out.RuleTemplates = t.RuleTemplates
+	return out, nil
+}
+
+// Rollback publishes the tree of this version as a new version, history is left untouched.
+// Teams that require approval get the new version submitted for review instead.
+func (t *TreeVersion) Rollback(user *User) (*TreeVersion, error) {
+	if t.State == TreePublished {
+		return nil, ErrTreeVersionPublished
+	}
+	requireApproval, err := t.RequiresApproval(user.getStore())
+	if err != nil {
+		return nil, err
+	}
+	out, err := t.Copy(user)
+	if err != nil {
+		return nil, err
+	}
+	if requireApproval {
+		err = out.RequestReview(user, fmt.Sprintf("Rollback to version %d.", t.Version))
+	} else {
+		err = out.Publish(user)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RequestReview submits a draft version for review.
+func (t *TreeVersion) RequestReview(user *User, comment string) error {
+	if t.RootID.IsEmpty() || t.Version <= 0 {
+		return ErrObjMissingParam
+	}
+	if t.State != TreeDraft {
+		return ErrInvalidTreeState
+	}
+	if err := checkStorePermission(t, user); err != nil {
+		return err
+	}
+	if err := t.checkValid(user); err != nil {
+		return err
+	}
+	t.State = TreePendingReview
+	t.addReview(user, TreeReviewRequested, comment)
+	return t.Store(user)
+}
+
+// Approve publishes a version pending review. Authors can't approve their own version.
+func (t *TreeVersion) Approve(user *User, comment string) error {
+	if err := t.checkReviewer(user); err != nil {
+		return err
+	}
+	if t.isAuthor(user) {
+		return ErrSelfApproval
+	}
+	t.addReview(user, TreeReviewApproved, comment)
+	// scheduled versions stay pending until the scheduler publishes them
+	if t.PublishAt.After(time.Now()) {
+		return t.store(user)
+	}
+	return t.publish(user)
+}
+
+// Reject returns a version pending review to draft.
+func (t *TreeVersion) Reject(user *User, comment string) error {
+	if err := t.checkReviewer(user); err != nil {
+		return err
+	}
+	t.State = TreeDraft
+	t.addReview(user, TreeReviewRejected, comment)
+	return t.store(user)
+}
+
+// RequiresApproval returns true if the team that owns the tree requires versions to be approved before publishing.
+func (t *TreeVersion) RequiresApproval(tx Store) (bool, error) {
+	treeRoot, err := databaseFetch(tx, TreeRoot{}, bson.M{"_id": t.RootID}, nil)
+	if err != nil {
+		return false, err
+	}
+	if treeRoot.(*TreeRoot).Type == TreeDocument {
+		if treeRoot, err = databaseFetch(tx, TreeRoot{}, bson.M{"_id": treeRoot.(*TreeRoot).Parent}, nil); err != nil {
+			return false, err
+		}
+	}
+	team, err := databaseFetch(tx, Team{}, bson.M{"_id": treeRoot.(*TreeRoot).Parent}, nil)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, nil
+		}
+		return false, err
+	}
+	return team.(*Team).Options[TeamOptionRequireApproval] == "true", nil
+}
+
+func (t *TreeVersion) checkReviewer(user *User) error {
+	if t.RootID.IsEmpty() || t.Version <= 0 {
+		return ErrObjMissingParam
+	}
+	if user == nil {
+		return ErrNoUser
+	}
+	if err := checkFetchPermission(t, user); err != nil {
+		return err
+	}
+	if !user.HasPermission(PermApproveForm) {
+		return ErrInvalidPermission
+	}
+	if t.State != TreePendingReview {
+		return ErrInvalidTreeState
+	}
+	return nil
+}
+
+// checkValid validates the tree and returns an error if it has issues that block publishing.
+func (t *TreeVersion) checkValid(user *User) error {
+	validation, err := t.Validate(user)
+	if err != nil {
+		return err
+	}
+	t.Issues = validation.Issues
+	if validation.HasErrors() {
+		return ErrTreeInvalid
+	}
+	return nil
+}
+
+// IsClosed returns true if the version's submission window has closed.
+func (t *TreeVersion) IsClosed() bool {
+	return !t.UnpublishAt.IsZero() && !time.Now().Before(t.UnpublishAt)
+}
+
+// isApproved returns true if the version is pending review and its latest review is an approval.
+func (t *TreeVersion) isApproved() bool {
+	return t.State == TreePendingReview && len(t.Reviews) > 0 && t.Reviews[len(t.Reviews)-1].Action == TreeReviewApproved
+}
+
+// isAuthor returns true if user created, last modified or requested review of the version.
+func (t *TreeVersion) isAuthor(user *User) bool {
+	if user.ID == t.Creator || user.ID == t.Modifier {
+		return true
+	}
+	for _, review := range t.Reviews {
+		if review.Action == TreeReviewRequested && review.User == user.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *TreeVersion) addReview(user *User, action string, comment string) {
+	t.Reviews = append(t.Reviews, TreeReview{
+		User:    user.ID,
+		Action:  action,
+		Comment: comment,
+		Created: time.Now(),
+	})
+}
+
 // GetRuleTemplateUIDs retrieves the rule template ids.
 func (t *TreeVersion) GetRuleTemplateIDs() []string {
 	out := make([]string, 0)
 	for _, n := range t.Tree {
 		if n.Type == "rule" {
-			templateId := n.Data["template"]
-			if templateId != nil {
+			templateId, ok := n.Data["template"].(string)
+			if ok && templateId != "" {
 				hasTemplateId := false
 				for i := range out {
 					if hasTemplateId = out[i] == templateId; hasTemplateId {
@@ -216,7 +470,7 @@ func (t *TreeVersion) GetRuleTemplateIDs() []string {
 					}
 				}
 				if !hasTemplateId {
-					out = append(out, templateId.(string))
+					out = append(out, templateId)
 				}
 			}
 		}
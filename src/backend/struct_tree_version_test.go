@@ -103,18 +103,224 @@ func TestTreeVersion(t *testing.T) {
 	}
 
 	// list all versions
-	_, count, err := ListTreeVersion(testTreeVersion.RootID.String(), &testUser, 0)
+	_, info, err := ListTreeVersion(testTreeVersion.RootID.String(), &testUser, ListPage{Count: true})
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	if count != 3 {
+	if info.Count != 3 {
 		t.Errorf("expected three versions")
 		return
 	}
 
 }
 
+func TestTreeVersionCopyRollback(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermManageForm},
+	}
+	testTreeRoot := TreeRoot{Parent: testUser.Team, Type: TreeForm}
+	if err := testTreeRoot.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	// publish version 1, then version 2 which archives version 1
+	v1, err := FetchTreeVersion(testTreeRoot.ID.String(), 1, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	v1.Tree = getTestTree(testTreeRoot.ID.String())
+	if err := v1.Publish(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	v2 := TreeVersion{RootID: testTreeRoot.ID, Tree: v1.Tree[:2]}
+	if err := v2.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := v2.Publish(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	// copy archived version
+	v1, err = FetchTreeVersion(testTreeRoot.ID.String(), 1, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if v1.State != TreeArchived {
+		t.Errorf("expected version 1 to be archived")
+	}
+	v3, err := v1.Copy(&testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if v3.Version != 3 || v3.State != TreeDraft || v3.CopiedFrom != 1 || len(v3.Tree) != len(v1.Tree) {
+		t.Errorf("unexpected copy %+v", v3)
+	}
+	// rollback creates a new published version
+	v4, err := v1.Rollback(&testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	published, err := FetchTreeVersionLatestPublished(testTreeRoot.ID.String(), &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if v4.Version != 4 || published.Version != 4 || published.CopiedFrom != 1 || len(published.Tree) != len(v1.Tree) {
+		t.Errorf("unexpected rollback %+v", published)
+	}
+	// history is untouched
+	v1, err = FetchTreeVersion(testTreeRoot.ID.String(), 1, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if v1.State != TreeArchived || v1.CopiedFrom != 0 {
+		t.Errorf("expected version 1 to be unchanged")
+	}
+	// published version can't be rolled back to
+	if _, err := published.Rollback(&testUser); err != ErrTreeVersionPublished {
+		t.Errorf("expected already published error")
+	}
+}
+
+func TestTreeVersionApproval(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+
+	testTeam := Team{ID: GenerateDatabaseId(), Options: map[string]string{TeamOptionRequireApproval: "true"}}
+	if err := databaseStoreOne(nil, &testTeam); err != nil {
+		t.Error(err)
+		return
+	}
+	author := User{ID: GenerateDatabaseId(), Team: testTeam.ID, Permission: UserPermission{PermManageForm, PermApproveForm}}
+	approver := User{ID: author.ID, Team: testTeam.ID, Permission: UserPermission{PermApproveForm}}
+	approver.ID[0]++
+	editor := User{ID: author.ID, Team: testTeam.ID, Permission: UserPermission{PermManageForm}}
+	editor.ID[0] += 2
+	testTreeRoot := TreeRoot{Parent: testTeam.ID, Type: TreeForm}
+	if err := testTreeRoot.Store(&author); err != nil {
+		t.Error(err)
+		return
+	}
+	version, err := FetchTreeVersion(testTreeRoot.ID.String(), 1, &author)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	version.Tree = getTestTree(testTreeRoot.ID.String())
+	if err := version.Store(&author); err != nil {
+		t.Error(err)
+		return
+	}
+	// publishing directly isn't allowed
+	if err := version.Publish(&author); err != ErrApprovalRequired {
+		t.Errorf("expected approval required error, got %v", err)
+	}
+	published := TreeVersion{RootID: testTreeRoot.ID, Version: 1, State: TreePublished}
+	if err := httpCheckTreeVersionState(&published, version, &author); err != ErrApprovalRequired {
+		t.Errorf("expected approval required error when storing published state, got %v", err)
+	}
+	if err := version.Approve(&approver, ""); err != ErrInvalidTreeState {
+		t.Errorf("expected invalid state error, got %v", err)
+	}
+	// request review, reject
+	if err := version.RequestReview(&author, "Please review."); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := version.Approve(&author, ""); err != ErrSelfApproval {
+		t.Errorf("expected self approval error, got %v", err)
+	}
+	if err := version.Approve(&editor, ""); err != ErrInvalidPermission {
+		t.Errorf("expected invalid permission error, got %v", err)
+	}
+	if err := version.Reject(&approver, "Needs work."); err != nil {
+		t.Error(err)
+		return
+	}
+	version, err = FetchTreeVersion(testTreeRoot.ID.String(), 1, &author)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if version.State != TreeDraft || len(version.Reviews) != 2 || version.Reviews[1].Comment != "Needs work." {
+		t.Errorf("expected rejected version to return to draft with review comments")
+	}
+	// request review again and approve
+	if err := version.RequestReview(&author, ""); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := version.Approve(&approver, "Looks good."); err != nil {
+		t.Error(err)
+		return
+	}
+	latest, err := FetchTreeVersionLatestPublished(testTreeRoot.ID.String(), &author)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if latest.Version != 1 || len(latest.Reviews) != 4 || latest.Reviews[3].Action != TreeReviewApproved {
+		t.Errorf("expected approved version to be published")
+	}
+	// the tree of a published version can't be replaced, only its schedule or state changed
+	published = TreeVersion{RootID: testTreeRoot.ID, Version: 1, State: TreePublished, Tree: latest.Tree[:len(latest.Tree)-1]}
+	if err := httpCheckTreeVersionState(&published, latest, &author); err != ErrInvalidTreeState {
+		t.Errorf("expected invalid state error when changing a published tree, got %v", err)
+	}
+	published.Tree = latest.Tree
+	if err := httpCheckTreeVersionState(&published, latest, &author); err != nil {
+		t.Errorf("expected unchanged published tree to be accepted, got %v", err)
+	}
+	// published versions can only be archived, not moved back in to the workflow
+	for _, state := range []TreeState{"", TreeDraft, TreePendingReview} {
+		published.State = state
+		if err := httpCheckTreeVersionState(&published, latest, &author); err != ErrInvalidTreeState {
+			t.Errorf("expected invalid state error when storing %q state on a published version, got %v", state, err)
+		}
+	}
+	published.State = TreeArchived
+	if err := httpCheckTreeVersionState(&published, latest, &author); err != nil {
+		t.Errorf("expected published version to be archived, got %v", err)
+	}
+	// the tree of an archived version can't be replaced either, publishing it again needs approval
+	archived := *latest
+	archived.State = TreeArchived
+	published = TreeVersion{RootID: testTreeRoot.ID, Version: 1, State: TreeArchived, Tree: latest.Tree[:len(latest.Tree)-1]}
+	if err := httpCheckTreeVersionState(&published, &archived, &author); err != ErrInvalidTreeState {
+		t.Errorf("expected invalid state error when changing an archived tree, got %v", err)
+	}
+	published.Tree = latest.Tree
+	published.State = TreeDraft
+	if err := httpCheckTreeVersionState(&published, &archived, &author); err != ErrInvalidTreeState {
+		t.Errorf("expected invalid state error when storing draft state on an archived version, got %v", err)
+	}
+	published.State = TreePublished
+	if err := httpCheckTreeVersionState(&published, &archived, &author); err != ErrApprovalRequired {
+		t.Errorf("expected approval required error when publishing an archived version, got %v", err)
+	}
+}
+
 func TestTreeTypeahead(t *testing.T) {
 
 	// init database
@@ -1,37 +1,73 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Pending user states, pending users can't log in until they use their pending token.
+const (
+	UserPendingVerify = "verify"
+	UserPendingInvite = "invite"
+)
+
+const userPendingTokenPrefix = "ccdv_"
+const userVerifyExpire = 24 * time.Hour
+const userInviteExpire = 7 * 24 * time.Hour
+
+// User is a member of a team. RolePermission holds the permissions of the user's roles once they're
+// loaded with loadRoles, it isn't stored. Users of api tokens are scoped to the token's permissions, users
+// of a batch transaction carry its store so everything they do is part of it.
 type User struct {
-	ID         DatabaseID     `bson:"_id" json:"id"`
-	Created    time.Time      `bson:"created,omitempty" json:"created"`
-	Modified   time.Time      `bson:"modified,omitempty" json:"modified"`
-	Creator    DatabaseID     `bson:"creator,omitempty" json:"creator"`
-	Modifier   DatabaseID     `bson:"modifier,omitempty" json:"modifier"`
-	Email      string         `bson:"email" json:"email"`
-	Password   []byte         `bson:"password,omitempty" json:"-"`
-	Team       DatabaseID     `bson:"team,omitempty" json:"team"`
-	Permission UserPermission `bson:"permission" json:"permission"`
-}
-
-func FetchUserByID(id string) (*User, error) {
+	ID               DatabaseID     `bson:"_id" json:"id"`
+	Created          time.Time      `bson:"created,omitempty" json:"created"`
+	Modified         time.Time      `bson:"modified,omitempty" json:"modified"`
+	Creator          DatabaseID     `bson:"creator,omitempty" json:"creator"`
+	Modifier         DatabaseID     `bson:"modifier,omitempty" json:"modifier"`
+	Email            string         `bson:"email" json:"email"`
+	Password         []byte         `bson:"password,omitempty" json:"-"`
+	Team             DatabaseID     `bson:"team,omitempty" json:"team"`
+	Permission       UserPermission `bson:"permission" json:"permission"`
+	Roles            []DatabaseID   `bson:"roles" json:"roles"`
+	RolePermission   UserPermission `bson:"-" json:"role_permission,omitempty"`
+	Pending          string         `bson:"pending" json:"pending,omitempty"`
+	PendingTokenHash string         `bson:"pending_token" json:"-"`
+	PendingExpires   time.Time      `bson:"pending_expires" json:"-"`
+	tokenScoped      bool
+	txStore          Store
+}
+
+// userSortFields are the sorts accepted when listing users.
+var userSortFields = ListSortFields{
+	Default: "-created",
+	Fields:  map[string]string{"created": "created", "modified": "modified", "email": "email"},
+}
+
+// FetchUserByID fetches a user along with the permissions of their roles, tx is the store of the
+// transaction the user acts in, nil for none.
+func FetchUserByID(id string, tx Store) (*User, error) {
 	dbId := DatabaseIDFromString(id)
-	res, err := databaseFetch(User{}, bson.M{"_id": dbId}, nil)
+	res, err := databaseFetch(tx, User{}, bson.M{"_id": dbId}, nil)
 	if err != nil {
 		return nil, err
 	}
 	user := res.(*User)
+	user.txStore = tx
+	if err := user.loadRoles(); err != nil {
+		return nil, err
+	}
 	return user, nil
 }
 
-func FetchUserByTeamEmail(team string, email string) (*User, error) {
+func FetchUserByTeamEmail(team string, email string, tx Store) (*User, error) {
 	dbTeam := DatabaseIDFromString(team)
-	res, err := databaseFetch(User{}, bson.M{"team": dbTeam, "email": email}, nil)
+	res, err := databaseFetch(tx, User{}, bson.M{"team": dbTeam, "email": email}, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -39,27 +75,225 @@ func FetchUserByTeamEmail(team string, email string) (*User, error) {
 	return user, nil
 }
 
-func ListUserTeam(user *User, offset int) ([]*User, int, error) {
-	if user == nil {
-		return nil, 0, ErrNoUser
+func ListUserTeam(user *User, page ListPage) ([]*User, ListPageInfo, error) {
+	if err := checkListPermission(permKindUser, user); err != nil {
+		return nil, ListPageInfo{}, err
 	}
 	// databse fetch
-	res, count, err := databaseList(User{}, bson.M{"team": user.Team}, bson.M{"created": -1}, nil, offset)
+	res, info, err := databasePage(
+		user.getStore(),
+		User{},
+		mongo.Pipeline{bson.D{{Key: "$match", Value: bson.M{"team": user.Team}}}},
+		nil,
+		userSortFields,
+		page,
+	)
 	if err != nil {
-		return nil, 0, err
-	}
-	// check permission
-	if len(res) > 0 {
-		if err := checkFetchPermission(res[0], user); err != nil {
-			return nil, 0, err
-		}
+		return nil, info, err
 	}
 	// format output
 	out := make([]*User, 0)
 	for _, item := range res {
 		out = append(out, item.(*User))
 	}
-	return out, count, nil
+	return out, info, nil
+}
+
+// SignUpUser creates a user that signed up to team, the team's options must allow it. The user is pending
+// until they verify their email with the returned token. Expired sign ups of the same email are replaced.
+func SignUpUser(team string, email string, password string) (*User, string, error) {
+	if team == "" || email == "" || password == "" {
+		return nil, "", ErrObjMissingParam
+	}
+	existing, err := FetchUserByTeamEmail(team, email, nil)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, "", err
+	}
+	if existing != nil {
+		if existing.Pending != UserPendingVerify || time.Now().Before(existing.PendingExpires) {
+			return nil, "", ErrEmailInUse
+		}
+		if err := databaseDelete(nil, User{}, bson.M{"_id": existing.ID}); err != nil {
+			return nil, "", err
+		}
+		if err := auditRecord(AuditDelete, existing, nil, nil); err != nil {
+			return nil, "", err
+		}
+	}
+	// build
+	hashedPw, err := HashPassword(password)
+	if err != nil {
+		return nil, "", err
+	}
+	token, err := generateToken(userPendingTokenPrefix)
+	if err != nil {
+		return nil, "", err
+	}
+	u := &User{
+		Email:            email,
+		Password:         hashedPw,
+		Team:             DatabaseIDFromString(team),
+		Pending:          UserPendingVerify,
+		PendingTokenHash: sessionTokenHash(token),
+		PendingExpires:   time.Now().Add(userVerifyExpire),
+	}
+	// store, checks that the team allows sign up
+	if err := u.Store(nil); err != nil {
+		return nil, "", err
+	}
+	err = mailer.Send(MailMessage{
+		To:      u.Email,
+		Subject: "Verify your email",
+		Body:    fmt.Sprintf("Use this token to verify your email and finish signing up:\n\n%s\n\nIt expires in 24 hours.", token),
+	})
+	if err != nil {
+		// the email can be signed up again right away
+		if err := u.removePending(nil); err != nil {
+			return nil, "", err
+		}
+		return nil, "", err
+	}
+	return u, token, nil
+}
+
+// VerifyUser verifies the email of the user that signed up with given token.
+func VerifyUser(token string) (*User, error) {
+	u, err := fetchPendingUser(UserPendingVerify, token)
+	if err != nil {
+		return nil, err
+	}
+	return u, u.clearPending(nil)
+}
+
+// InviteUser creates a pending user on editor's team with given permissions and roles and sends them an
+// invite token. Users that were already invited get a new token.
+func InviteUser(email string, permission UserPermission, roles []DatabaseID, editor *User) (*User, string, error) {
+	if editor == nil {
+		return nil, "", ErrNoUser
+	}
+	if email == "" || !strings.Contains(email, "@") {
+		return nil, "", ErrObjInvalidParam
+	}
+	u := &User{Email: email, Team: editor.Team}
+	isNew := true
+	existing, err := FetchUserByTeamEmail(editor.Team.String(), email, editor.getStore())
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, "", err
+	}
+	if existing != nil {
+		if existing.Pending != UserPendingInvite {
+			return nil, "", ErrEmailInUse
+		}
+		u = existing
+		isNew = false
+	}
+	// build
+	token, err := generateToken(userPendingTokenPrefix)
+	if err != nil {
+		return nil, "", err
+	}
+	u.Permission = permission
+	u.Roles = roles
+	u.Pending = UserPendingInvite
+	u.PendingTokenHash = sessionTokenHash(token)
+	u.PendingExpires = time.Now().Add(userInviteExpire)
+	// store, checks that editor can manage users and grant the permissions
+	if err := u.Store(editor); err != nil {
+		return nil, "", err
+	}
+	err = mailer.Send(MailMessage{
+		To:      u.Email,
+		Subject: "You have been invited",
+		Body:    fmt.Sprintf("You have been invited to join a team. Use this token to set your password and accept the invite:\n\n%s\n\nIt expires in 7 days.", token),
+	})
+	if err != nil {
+		// new invites are removed, invites that were sent before keep their replaced token and can be resent
+		if isNew {
+			if err := u.removePending(editor); err != nil {
+				return nil, "", err
+			}
+		}
+		return nil, "", err
+	}
+	return u, token, nil
+}
+
+// AcceptInvite sets the password of the user invited with given token, the token can only be used once.
+func AcceptInvite(token string, password string) (*User, error) {
+	if password == "" {
+		return nil, ErrObjMissingParam
+	}
+	u, err := fetchPendingUser(UserPendingInvite, token)
+	if err != nil {
+		return nil, err
+	}
+	hashedPw, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	return u, u.clearPending(hashedPw)
+}
+
+// fetchPendingUser fetches the user in pending state with given token, expired tokens are invalid.
+func fetchPendingUser(state string, token string) (*User, error) {
+	res, err := databaseFetch(nil, User{}, bson.M{"pending": state, "pending_token": sessionTokenHash(token)}, nil)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	u := res.(*User)
+	if time.Now().After(u.PendingExpires) {
+		return nil, ErrInvalidToken
+	}
+	return u, nil
+}
+
+// clearPending ends the pending state of the user and sets password if given, it is made by the user
+// themselves. The update is conditional on the pending token so that it can only be used once, also by
+// concurrent requests.
+func (u *User) clearPending(password []byte) error {
+	if u.PendingTokenHash == "" {
+		return ErrInvalidToken
+	}
+	before := *u
+	u.Pending = ""
+	u.PendingTokenHash = ""
+	u.PendingExpires = time.Time{}
+	u.Modified = time.Now()
+	u.Modifier = u.ID
+	if password != nil {
+		u.Password = password
+	}
+	updated, err := databaseUpdateOne(
+		nil,
+		User{},
+		bson.M{"_id": u.ID, "pending_token": before.PendingTokenHash},
+		bson.M{
+			"pending":         u.Pending,
+			"pending_token":   u.PendingTokenHash,
+			"pending_expires": u.PendingExpires,
+			"password":        u.Password,
+			"modified":        u.Modified,
+			"modifier":        u.Modifier,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrInvalidToken
+	}
+	return auditRecord(AuditUpdate, &before, u, u)
+}
+
+// removePending deletes a pending user whose token couldn't be sent so that their email isn't blocked.
+func (u *User) removePending(editor *User) error {
+	if err := databaseDelete(editor.getStore(), User{}, bson.M{"_id": u.ID}); err != nil {
+		return err
+	}
+	return auditRecord(AuditDelete, u, nil, editor)
 }
 
 func HashPassword(password string) ([]byte, error) {
@@ -95,7 +329,7 @@ func (u *User) Store(editor *User) error {
 		u.Creator = prevUser.Creator
 		u.Created = prevUser.Created
 	}*/
-	return databaseStoreOne(u)
+	return auditStoreOne(u, editor)
 }
 
 func (u *User) Delete(editor *User) error {
@@ -106,7 +340,21 @@ func (u *User) Delete(editor *User) error {
 	if err := checkDeletePermission(u, editor); err != nil {
 		return err
 	}
-	return databaseDelete(User{}, bson.M{"_id": u.ID})
+	before, err := auditFetch(editor.getStore(), u)
+	if err != nil {
+		return err
+	}
+	// revoke api tokens and sessions
+	if err := databaseDelete(editor.getStore(), APIToken{}, bson.M{"user": u.ID}); err != nil {
+		return err
+	}
+	if err := sessionStore.DeleteUser(editor.getStore(), u.ID); err != nil {
+		return err
+	}
+	if err := databaseDelete(editor.getStore(), User{}, bson.M{"_id": u.ID}); err != nil {
+		return err
+	}
+	return auditRecord(AuditDelete, before, nil, editor)
 }
 
 func (u User) CheckPassword(password string) error {
@@ -116,6 +364,39 @@ func (u User) CheckPassword(password string) error {
 	return nil
 }
 
+// HasPermission checks if the user has a permission, directly or through one of their roles.
 func (u User) HasPermission(perm string) bool {
-	return u.Permission.Has(PermAdmin) || u.Permission.Has(perm)
+	return u.Permission.Has(PermAdmin) || u.Permission.Has(perm) ||
+		u.RolePermission.Has(PermAdmin) || u.RolePermission.Has(perm)
+}
+
+// HasRole checks if the user has a role.
+func (u User) HasRole(role DatabaseID) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// getStore returns the store of the transaction the user acts in, nil for none.
+func (u *User) getStore() Store {
+	if u == nil {
+		return nil
+	}
+	return u.txStore
+}
+
+// loadRoles resolves the permissions of the user's roles, roles that no longer exist are ignored.
+func (u *User) loadRoles() error {
+	roles, err := listTeamRoles(u.getStore(), u.Team, u.Roles)
+	if err != nil {
+		return err
+	}
+	u.RolePermission = UserPermission{}
+	for _, role := range roles {
+		u.RolePermission = u.RolePermission.Merge(role.Permission)
+	}
+	return nil
 }
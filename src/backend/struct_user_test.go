@@ -0,0 +1,137 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestUserSignUp(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	testAdmin := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	testTeam := Team{ID: testAdmin.Team}
+	if err := databaseStoreOne(nil, &testTeam); err != nil {
+		t.Error(err)
+		return
+	}
+	testRole := Role{Team: testTeam.ID, Label: "Member", Permission: UserPermission{PermSubmit}}
+	if err := testRole.Store(&testAdmin); err != nil {
+		t.Error(err)
+		return
+	}
+	// sign up disabled by default
+	if _, _, err := SignUpUser(testTeam.ID.String(), "user@example.com", "pw"); err != ErrSignUpDisabled {
+		t.Errorf("expected sign up disabled error, got %v", err)
+	}
+	// anonymous users can't be created without signing up
+	anonUser := User{Team: testTeam.ID, Email: "user@example.com", Permission: UserPermission{PermAdmin}}
+	if err := anonUser.Store(nil); err != ErrNoUser {
+		t.Errorf("expected no user error, got %v", err)
+	}
+	// default permissions are validated
+	testTeam.Options = map[string]string{TeamOptionAllowSignUp: "true", TeamOptionSignUpPermissions: "unknown"}
+	if err := testTeam.Store(&testAdmin); err != ErrInvalidPermissionName {
+		t.Errorf("expected invalid permission name error, got %v", err)
+	}
+	testTeam.Options = map[string]string{
+		TeamOptionAllowSignUp:       "true",
+		TeamOptionSignUpDomains:     "example.com, example.org",
+		TeamOptionSignUpPermissions: PermRead,
+		TeamOptionSignUpRoles:       testRole.ID.String(),
+	}
+	if err := testTeam.Store(&testAdmin); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, _, err := SignUpUser(testTeam.ID.String(), "user@example.net", "pw"); err != ErrSignUpDomain {
+		t.Errorf("expected sign up domain error, got %v", err)
+	}
+	// sign ups whose verification couldn't be sent don't block the email
+	mailer = failingMailer{}
+	if _, _, err := SignUpUser(testTeam.ID.String(), "user@Example.com", "pw"); err != errTestMail {
+		t.Errorf("expected mail error, got %v", err)
+	}
+	mailer = logMailer{}
+	user, token, err := SignUpUser(testTeam.ID.String(), "user@Example.com", "pw")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if user.Pending != UserPendingVerify || !user.Permission.Has(PermRead) || user.Permission.Has(PermAdmin) || !user.HasRole(testRole.ID) {
+		t.Errorf("expected pending user with default permissions")
+	}
+	if _, _, err := SignUpUser(testTeam.ID.String(), "user@Example.com", "pw"); err != ErrEmailInUse {
+		t.Errorf("expected email in use error, got %v", err)
+	}
+	// verify
+	if _, err := VerifyUser("ccdv_invalid"); err != ErrInvalidToken {
+		t.Errorf("expected invalid token error, got %v", err)
+	}
+	verified, err := VerifyUser(token)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if verified.ID != user.ID || verified.Pending != "" {
+		t.Errorf("expected user to be verified")
+	}
+	if _, err := VerifyUser(token); err != ErrInvalidToken {
+		t.Errorf("expected token to only be used once")
+	}
+	fetched, err := FetchUserByID(user.ID.String(), nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if fetched.Pending != "" || !fetched.HasPermission(PermSubmit) {
+		t.Errorf("expected verified user with role permissions")
+	}
+}
+
+func TestUserStoreOtherTeam(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	testAdmin := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	otherUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Email:      "other@example.com",
+		Permission: UserPermission{PermRead},
+	}
+	if err := databaseStoreOne(nil, &otherUser); err != nil {
+		t.Error(err)
+		return
+	}
+	// team of the edited user is the stored one, leaving it out doesn't move the user to the editor's team
+	edit := User{ID: otherUser.ID, Email: otherUser.Email, Permission: UserPermission{PermAdmin}}
+	if err := edit.Store(&testAdmin); err != ErrInvalidPermission {
+		t.Errorf("expected invalid permission error, got %v", err)
+	}
+	edit.Team = testAdmin.Team
+	if err := edit.Store(&testAdmin); err != ErrInvalidPermission {
+		t.Errorf("expected invalid permission error, got %v", err)
+	}
+	fetched, err := FetchUserByID(otherUser.ID.String(), nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if fetched.Team != otherUser.Team || fetched.HasPermission(PermAdmin) {
+		t.Errorf("expected user of other team to be unchanged")
+	}
+}
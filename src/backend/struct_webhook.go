@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Webhook events.
+const (
+	WebhookTreePublished     = "tree.published"     // a tree version was published
+	WebhookSubmissionCreated = "submission.created" // a form submission was created
+	WebhookSubmissionUpdated = "submission.updated" // a form submission was updated and is valid
+	WebhookSubmissionDeleted = "submission.deleted" // a form submission was deleted
+)
+
+var webhookEvents = []string{WebhookTreePublished, WebhookSubmissionCreated, WebhookSubmissionUpdated, WebhookSubmissionDeleted}
+
+// Webhook delivery states.
+const (
+	WebhookDeliveryPending = "pending"
+	WebhookDeliverySuccess = "success"
+	WebhookDeliveryFailed  = "failed"
+)
+
+// Webhook is a team subscription to events, payloads are signed with the secret. The secret is never
+// sent with the webhook, only when it is generated.
+type Webhook struct {
+	ID       DatabaseID `bson:"_id" json:"id"`
+	Created  time.Time  `bson:"created,omitempty" json:"created"`
+	Modified time.Time  `bson:"modified,omitempty" json:"modified"`
+	Creator  DatabaseID `bson:"creator,omitempty" json:"creator"`
+	Modifier DatabaseID `bson:"modifier,omitempty" json:"modifier"`
+	Team     DatabaseID `bson:"team" json:"team"`
+	URL      string     `bson:"url" json:"url"`
+	Secret   string     `bson:"secret" json:"-"`
+	Events   []string   `bson:"events" json:"events"`
+	Active   bool       `bson:"active" json:"active"`
+}
+
+// WebhookDelivery is a single event sent to a webhook, along with the result of the last attempt.
+type WebhookDelivery struct {
+	ID           string     `bson:"_id" json:"id"`
+	Created      time.Time  `bson:"created" json:"created"`
+	Webhook      DatabaseID `bson:"webhook" json:"webhook"`
+	Team         DatabaseID `bson:"team" json:"team"`
+	Event        string     `bson:"event" json:"event"`
+	Payload      string     `bson:"payload" json:"payload"`
+	Status       string     `bson:"status" json:"status"`
+	Attempts     int        `bson:"attempts" json:"attempts"`
+	NextAttempt  time.Time  `bson:"next_attempt" json:"next_attempt"`
+	LastAttempt  time.Time  `bson:"last_attempt,omitempty" json:"last_attempt"`
+	ResponseCode int        `bson:"response_code,omitempty" json:"response_code"`
+	Error        string     `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// WebhookPayload is the JSON body sent to webhooks.
+type WebhookPayload struct {
+	ID      string      `json:"id"`
+	Event   string      `json:"event"`
+	Created time.Time   `json:"created"`
+	Team    DatabaseID  `json:"team"`
+	Data    interface{} `json:"data"`
+}
+
+// FetchWebhook fetches a webhook of given id.
+func FetchWebhook(id string, user *User) (*Webhook, error) {
+	if user == nil {
+		return nil, ErrNoUser
+	}
+	res, err := databaseFetch(user.getStore(), Webhook{}, bson.M{"_id": DatabaseIDFromString(id)}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFetchPermission(res, user); err != nil {
+		return nil, err
+	}
+	return res.(*Webhook), nil
+}
+
+// ListWebhook lists all webhooks of user's team.
+func ListWebhook(user *User) ([]*Webhook, error) {
+	if user == nil {
+		return nil, ErrNoUser
+	}
+	if err := checkListPermission(permKindWebhook, user); err != nil {
+		return nil, err
+	}
+	res, err := databaseListAll(user.getStore(), Webhook{}, bson.M{"team": user.Team}, bson.M{"created": -1}, nil)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*Webhook, 0)
+	for _, item := range res {
+		out = append(out, item.(*Webhook))
+	}
+	return out, nil
+}
+
+// ListWebhookDelivery lists the deliveries of a webhook, newest first.
+func ListWebhookDelivery(webhookId string, user *User, offset int) ([]*WebhookDelivery, int, error) {
+	webhook, err := FetchWebhook(webhookId, user)
+	if err != nil {
+		return nil, 0, err
+	}
+	res, count, err := databaseList(user.getStore(), WebhookDelivery{}, bson.M{"webhook": webhook.ID}, bson.M{"created": -1}, nil, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	out := make([]*WebhookDelivery, 0)
+	for _, item := range res {
+		out = append(out, item.(*WebhookDelivery))
+	}
+	return out, count, nil
+}
+
+// Store the webhook, a secret is generated if one isn't set.
+func (h *Webhook) Store(user *User) error {
+	if user == nil {
+		return ErrNoUser
+	}
+	// validate
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrObjInvalidParam
+	}
+	for _, event := range h.Events {
+		valid := false
+		for _, e := range webhookEvents {
+			valid = valid || e == event
+		}
+		if !valid {
+			return ErrObjInvalidParam
+		}
+	}
+	if h.Team.IsEmpty() {
+		h.Team = user.Team
+	}
+	if err := checkStorePermission(h, user); err != nil {
+		return err
+	}
+	// generate secret
+	if h.Secret == "" {
+		if err := h.generateSecret(); err != nil {
+			return err
+		}
+	}
+	h.Modifier = user.ID
+	h.Modified = time.Now()
+	if h.ID.IsEmpty() {
+		h.ID = GenerateDatabaseId()
+		h.Creator = user.ID
+		h.Created = h.Modified
+	}
+	return databaseStoreOne(user.getStore(), h)
+}
+
+// RotateSecret replaces the secret of the webhook with a newly generated one.
+func (h *Webhook) RotateSecret(user *User) error {
+	if err := h.generateSecret(); err != nil {
+		return err
+	}
+	return h.Store(user)
+}
+
+// generateSecret sets a new random secret.
+func (h *Webhook) generateSecret() error {
+	rawSecret := make([]byte, 32)
+	if _, err := rand.Read(rawSecret); err != nil {
+		return err
+	}
+	h.Secret = hex.EncodeToString(rawSecret)
+	return nil
+}
+
+// Delete the webhook and its delivery log.
+func (h *Webhook) Delete(user *User) error {
+	if err := checkDeletePermission(h, user); err != nil {
+		return err
+	}
+	if err := databaseDelete(user.getStore(), WebhookDelivery{}, bson.M{"webhook": h.ID}); err != nil {
+		return err
+	}
+	return databaseDelete(user.getStore(), Webhook{}, bson.M{"_id": h.ID})
+}
+
+// HasEvent returns true if the webhook is subscribed to given event, no events means all events.
+func (h *Webhook) HasEvent(event string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookDispatch queues deliveries of an event to all subscribed webhooks of a team, in the transaction
+// of tx if there is one. Errors are logged, they never fail the action that triggered the event.
+func webhookDispatch(tx Store, team DatabaseID, event string, data interface{}) {
+	res, err := databaseListAll(tx, Webhook{}, bson.M{"team": team, "active": true}, bson.M{"created": 1}, nil)
+	if err != nil {
+		log.Printf("Webhook dispatch of %s failed: %s", event, err)
+		return
+	}
+	queued := false
+	for _, item := range res {
+		webhook := item.(*Webhook)
+		if !webhook.HasEvent(event) {
+			continue
+		}
+		delivery := WebhookDelivery{
+			ID:          uuid.NewString(),
+			Created:     time.Now(),
+			Webhook:     webhook.ID,
+			Team:        team,
+			Event:       event,
+			Status:      WebhookDeliveryPending,
+			NextAttempt: time.Now(),
+		}
+		payload, err := json.Marshal(WebhookPayload{
+			ID:      delivery.ID,
+			Event:   event,
+			Created: delivery.Created,
+			Team:    team,
+			Data:    data,
+		})
+		if err != nil {
+			log.Printf("Webhook dispatch of %s failed: %s", event, err)
+			return
+		}
+		delivery.Payload = string(payload)
+		if err := databaseInsertOne(tx, &delivery); err != nil {
+			log.Printf("Webhook dispatch of %s failed: %s", event, err)
+			continue
+		}
+		queued = true
+	}
+	if queued {
+		webhookNotify()
+	}
+}
+
+// webhookFormTeam returns the team that owns a form.
+func webhookFormTeam(tx Store, formID DatabaseID) DatabaseID {
+	res, err := databaseFetch(tx, TreeRoot{}, bson.M{"_id": formID}, nil)
+	if err != nil {
+		return DatabaseID{}
+	}
+	return res.(*TreeRoot).Parent
+}
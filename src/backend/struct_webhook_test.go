@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestWebhookDelivery(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	// receiver fails the first request and verifies signatures
+	mutex := sync.Mutex{}
+	requests := 0
+	events := make([]string, 0)
+	secret := "testsecret"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(webhookSignatureHeader) != WebhookSignature(secret, r.Header.Get(webhookTimestampHeader), body) {
+			t.Errorf("invalid signature")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		payload := WebhookPayload{}
+		if err := json.Unmarshal(body, &payload); err != nil || payload.Event != r.Header.Get(webhookEventHeader) {
+			t.Errorf("invalid payload")
+		}
+		events = append(events, payload.Event)
+	}))
+	defer server.Close()
+	prevBackoff := webhookBackoff
+	webhookBackoff = 10 * time.Millisecond
+	defer func() { webhookBackoff = prevBackoff }()
+	stop := WebhookStartWorker()
+	defer stop()
+
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	// permission
+	otherUser := User{ID: testUser.ID, Team: testUser.Team, Permission: UserPermission{PermManageForm}}
+	otherUser.ID[0]++
+	if err := (&Webhook{URL: server.URL}).Store(&otherUser); !errors.Is(err, ErrInvalidPermission) {
+		t.Errorf("expected invalid permission error")
+	}
+	// validation
+	if err := (&Webhook{URL: "ftp://example.com"}).Store(&testUser); !errors.Is(err, ErrObjInvalidParam) {
+		t.Errorf("expected invalid param error for url")
+	}
+	if err := (&Webhook{URL: server.URL, Events: []string{"nope"}}).Store(&testUser); !errors.Is(err, ErrObjInvalidParam) {
+		t.Errorf("expected invalid param error for event")
+	}
+	webhook := Webhook{URL: server.URL, Secret: secret, Events: []string{WebhookTreePublished}, Active: true}
+	if err := webhook.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	// publish tree, the submission event is filtered out
+	testTreeRoot := TreeRoot{Type: TreeForm, Parent: testUser.Team, Label: "Testing 123"}
+	if err := testTreeRoot.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	version, err := FetchTreeVersion(testTreeRoot.ID.String(), 1, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	version.Tree = getTestTree(testTreeRoot.ID.String())
+	if err := version.Publish(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	submission := FormSubmission{FormID: testTreeRoot.ID, FormVersion: 1}
+	if err := submission.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	// wait for retry
+	var deliveries []*WebhookDelivery
+	for i := 0; i < 200; i++ {
+		deliveries, _, err = ListWebhookDelivery(webhook.ID.String(), &testUser, 0)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(deliveries) == 1 && deliveries[0].Status != WebhookDeliveryPending {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(deliveries) != 1 {
+		t.Errorf("expected one delivery, got %d", len(deliveries))
+		return
+	}
+	if deliveries[0].Status != WebhookDeliverySuccess || deliveries[0].Attempts != 2 || deliveries[0].ResponseCode != http.StatusOK {
+		t.Errorf("expected successful delivery on second attempt, got %s after %d attempts", deliveries[0].Status, deliveries[0].Attempts)
+	}
+	mutex.Lock()
+	if len(events) != 1 || events[0] != WebhookTreePublished {
+		t.Errorf("unexpected events %v", events)
+	}
+	mutex.Unlock()
+	// other users can't list deliveries
+	if _, _, err := ListWebhookDelivery(webhook.ID.String(), &otherUser, 0); !errors.Is(err, ErrInvalidPermission) {
+		t.Errorf("expected invalid permission error")
+	}
+	// delete removes deliveries
+	if err := webhook.Delete(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	if count, _ := databaseCount(nil, WebhookDelivery{}, bson.M{"webhook": webhook.ID}); count != 0 {
+		t.Errorf("expected deliveries to be deleted")
+	}
+}
+
+func TestWebhookDeliveryClaim(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	mutex := sync.Mutex{}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests++
+		mutex.Unlock()
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer server.Close()
+	webhook := Webhook{ID: GenerateDatabaseId(), Team: GenerateDatabaseId(), URL: server.URL, Active: true}
+	if err := databaseStoreOne(nil, &webhook); err != nil {
+		t.Error(err)
+		return
+	}
+	delivery := WebhookDelivery{
+		ID:          uuid.NewString(),
+		Webhook:     webhook.ID,
+		Team:        webhook.Team,
+		Event:       WebhookTreePublished,
+		Status:      WebhookDeliveryPending,
+		NextAttempt: time.Now().Add(-time.Second).Truncate(time.Millisecond),
+	}
+	if err := databaseStoreOne(nil, &delivery); err != nil {
+		t.Error(err)
+		return
+	}
+	// workers of several instances send a delivery once
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			webhookProcessDeliveries(done)
+		}()
+	}
+	wg.Wait()
+	if requests != 1 {
+		t.Errorf("expected delivery to be sent once, got %d requests", requests)
+	}
+	res, err := databaseFetch(nil, WebhookDelivery{}, bson.M{"_id": delivery.ID}, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if res.(*WebhookDelivery).Status != WebhookDeliverySuccess || res.(*WebhookDelivery).Attempts != 1 {
+		t.Errorf("expected one successful attempt, got %+v", res)
+	}
+	// claimed deliveries can't be claimed again
+	stale := delivery
+	stale.Status = WebhookDeliveryPending
+	if claimed, err := stale.claim(); err != nil || claimed {
+		t.Errorf("expected sent delivery to not be claimed, got %t %v", claimed, err)
+	}
+}
+
+func TestWebhookSecret(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	webhook := Webhook{URL: "https://example.com/hook", Active: true}
+	if err := webhook.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	// secret is kept out of listings
+	webhooks, err := ListWebhook(&testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	raw, _ := json.Marshal(webhooks)
+	if webhook.Secret == "" || strings.Contains(string(raw), webhook.Secret) {
+		t.Errorf("expected secret to not be listed")
+	}
+	// rotate
+	prevSecret := webhook.Secret
+	if err := webhook.RotateSecret(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	fetched, err := FetchWebhook(webhook.ID.String(), &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if fetched.Secret == prevSecret || fetched.Secret != webhook.Secret {
+		t.Errorf("expected secret to be rotated")
+	}
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Submission export formats.
+const (
+	ExportCSV    = "csv"
+	ExportXLSX   = "xlsx"
+	ExportNDJSON = "ndjson"
+)
+
+// submissionExportFields are the columns written before the question columns.
+var submissionExportFields = []string{"id", "created", "modified", "creator", "form_version", "valid"}
+
+// SubmissionExportFilter filters the submissions included in an export.
+type SubmissionExportFilter struct {
+	Version int
+	Valid   *bool
+	From    time.Time
+	To      time.Time
+}
+
+// SubmissionExportColumn is a question column of an export.
+type SubmissionExportColumn struct {
+	UID   string `json:"uid"`
+	Label string `json:"label"`
+}
+
+// SubmissionExportAnswer is an answer to a question in the NDJSON export.
+type SubmissionExportAnswer struct {
+	Question string   `json:"question"`
+	Label    string   `json:"label"`
+	MatrixID string   `json:"matrix_id,omitempty"`
+	Values   []string `json:"values"`
+	Raw      []string `json:"raw"`
+}
+
+// SubmissionExport exports all submissions of a form.
+type SubmissionExport struct {
+	Form    *TreeRoot
+	Format  string
+	Filter  SubmissionExportFilter
+	Columns []SubmissionExportColumn
+	labels  map[string]string
+	parents map[string]string
+	columns map[string]bool
+	tx      Store
+}
+
+// submissionExportWriter writes export rows in a specific format.
+type submissionExportWriter interface {
+	Header(fields []string, columns []SubmissionExportColumn) error
+	Row(fields []string, answers [][]SubmissionExportAnswer) error
+	Close() error
+}
+
+// NewSubmissionExport prepares the export of a form's submissions, the columns are the questions of
+// the filtered version or of every version when no version is given.
+func NewSubmissionExport(formId string, format string, filter SubmissionExportFilter, user *User) (*SubmissionExport, error) {
+	if err := checkListPermission(permKindSubmission, user); err != nil {
+		return nil, err
+	}
+	if _, err := submissionExportContentType(format); err != nil {
+		return nil, err
+	}
+	form, err := FetchTreeRoot(formId, user)
+	if err != nil {
+		return nil, err
+	}
+	if form.Type != TreeForm {
+		return nil, ErrObjInvalidParam
+	}
+	// tree of the referenced version
+	var treeVersion *TreeVersion
+	if filter.Version > 0 {
+		treeVersion, err = FetchTreeVersion(formId, filter.Version, user)
+	} else {
+		treeVersion, err = FetchTreeVersionLatest(formId, user)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// node history for questions and answers removed in later versions
+	nodeHistory, err := ListNodeVersion(formId, filter.Version, user)
+	if err != nil {
+		return nil, err
+	}
+	out := &SubmissionExport{
+		Form:    form,
+		Format:  format,
+		Filter:  filter,
+		Columns: make([]SubmissionExportColumn, 0),
+		labels:  make(map[string]string),
+		parents: make(map[string]string),
+		columns: make(map[string]bool),
+		tx:      user.getStore(),
+	}
+	sort.Slice(nodeHistory, func(i, j int) bool {
+		if nodeHistory[i].Version != nodeHistory[j].Version {
+			return nodeHistory[i].Version < nodeHistory[j].Version
+		}
+		return nodeHistory[i].UID < nodeHistory[j].UID
+	})
+	for _, node := range nodeHistory {
+		out.labels[node.UID] = firstNonEmpty(node.DataLabel, node.Label, node.AnswerValue, node.UID)
+		out.parents[node.UID] = node.Parent
+	}
+	for _, node := range treeVersion.Tree {
+		out.labels[node.UID] = firstNonEmpty(node.Data.GetString("label"), node.Label, node.Data.GetString("value"), node.UID)
+		out.parents[node.UID] = node.Parent
+		if node.Type == NodeQuestion {
+			out.Columns = append(out.Columns, SubmissionExportColumn{UID: node.UID, Label: out.labels[node.UID]})
+			out.columns[node.UID] = true
+		}
+	}
+	if filter.Version <= 0 {
+		for _, node := range nodeHistory {
+			if node.Type == NodeQuestion && !out.columns[node.UID] {
+				out.Columns = append(out.Columns, SubmissionExportColumn{UID: node.UID, Label: out.labels[node.UID]})
+				out.columns[node.UID] = true
+			}
+		}
+	}
+	return out, nil
+}
+
+// ContentType returns the content type and file extension of the export.
+func (e *SubmissionExport) ContentType() (string, string) {
+	contentType, _ := submissionExportContentType(e.Format)
+	return contentType, e.Format
+}
+
+// Write streams all submissions matching the filter to w.
+func (e *SubmissionExport) Write(w io.Writer) error {
+	var writer submissionExportWriter
+	switch e.Format {
+	case ExportCSV:
+		{
+			writer = &csvExportWriter{w: csv.NewWriter(w)}
+			break
+		}
+	case ExportXLSX:
+		{
+			writer = &xlsxExportWriter{w: zip.NewWriter(w)}
+			break
+		}
+	case ExportNDJSON:
+		{
+			writer = &ndjsonExportWriter{w: json.NewEncoder(w)}
+			break
+		}
+	default:
+		{
+			return ErrObjInvalidParam
+		}
+	}
+	if err := writer.Header(submissionExportFields, e.Columns); err != nil {
+		return err
+	}
+	// build filter
+	filter := bson.M{"form_id": e.Form.ID}
+	if e.Filter.Version > 0 {
+		filter["form_version"] = e.Filter.Version
+	}
+	if e.Filter.Valid != nil {
+		filter["valid"] = *e.Filter.Valid
+	}
+	created := bson.M{}
+	if !e.Filter.From.IsZero() {
+		created["$gte"] = e.Filter.From
+	}
+	if !e.Filter.To.IsZero() {
+		created["$lt"] = e.Filter.To
+	}
+	if len(created) > 0 {
+		filter["created"] = created
+	}
+	// page through submissions with a cursor so they never have to be held in memory at once
+	pipeline := mongo.Pipeline{bson.D{{Key: "$match", Value: filter}}}
+	page := ListPage{Sort: "created", Limit: dbMaxFetchLimit}
+	for {
+		res, info, err := databasePage(e.tx, FormSubmission{}, pipeline, nil, formSubmissionSortFields, page)
+		if err != nil {
+			return err
+		}
+		for _, item := range res {
+			if err := e.writeSubmission(writer, item.(*FormSubmission)); err != nil {
+				return err
+			}
+		}
+		if info.NextCursor == "" {
+			break
+		}
+		page.Cursor = info.NextCursor
+	}
+	return writer.Close()
+}
+
+func (e *SubmissionExport) writeSubmission(writer submissionExportWriter, s *FormSubmission) error {
+	fields := []string{
+		s.ID.String(),
+		s.Created.UTC().Format(time.RFC3339),
+		s.Modified.UTC().Format(time.RFC3339),
+		s.Creator.String(),
+		strconv.Itoa(s.FormVersion),
+		strconv.FormatBool(s.Valid),
+	}
+	answers := make([][]SubmissionExportAnswer, len(e.Columns))
+	keys := make([]string, 0, len(s.Answers))
+	for key := range s.Answers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for i, column := range e.Columns {
+		for _, key := range keys {
+			matrixID := ""
+			if key != column.UID {
+				// matrix answers are keyed by question uid and matrix id
+				if e.columns[key] || !strings.HasPrefix(key, column.UID+"_") {
+					continue
+				}
+				matrixID = key[len(column.UID)+1:]
+			}
+			raw := nonEmptyAnswers(s.Answers[key])
+			values := make([]string, 0, len(raw))
+			for _, value := range raw {
+				values = append(values, e.answerLabel(column.UID, value))
+			}
+			answers[i] = append(answers[i], SubmissionExportAnswer{
+				Question: column.UID,
+				Label:    column.Label,
+				MatrixID: matrixID,
+				Values:   values,
+				Raw:      raw,
+			})
+		}
+	}
+	return writer.Row(fields, answers)
+}
+
+// answerLabel resolves a choice answer uid to its label, other answers are returned as is.
+func (e *SubmissionExport) answerLabel(question string, value string) string {
+	if e.parents[value] == question {
+		return e.labels[value]
+	}
+	return value
+}
+
+// submissionExportCell formats the answers of a question as a single cell.
+func submissionExportCell(answers []SubmissionExportAnswer) string {
+	out := make([]string, 0, len(answers))
+	for _, answer := range answers {
+		value := strings.Join(answer.Values, ", ")
+		if answer.MatrixID != "" {
+			value = answer.MatrixID + ": " + value
+		}
+		out = append(out, value)
+	}
+	return strings.Join(out, "; ")
+}
+
+func submissionExportContentType(format string) (string, error) {
+	switch format {
+	case ExportCSV:
+		{
+			return "text/csv", nil
+		}
+	case ExportXLSX:
+		{
+			return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", nil
+		}
+	case ExportNDJSON:
+		{
+			return "application/x-ndjson", nil
+		}
+	}
+	return "", ErrObjInvalidParam
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// csvExportWriter writes a CSV file with one column per question.
+type csvExportWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvExportWriter) Header(fields []string, columns []SubmissionExportColumn) error {
+	row := append([]string{}, fields...)
+	for _, column := range columns {
+		row = append(row, column.Label)
+	}
+	return c.w.Write(row)
+}
+
+func (c *csvExportWriter) Row(fields []string, answers [][]SubmissionExportAnswer) error {
+	row := append([]string{}, fields...)
+	for _, answer := range answers {
+		row = append(row, submissionExportCell(answer))
+	}
+	return c.w.Write(row)
+}
+
+func (c *csvExportWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// ndjsonExportWriter writes one JSON object per submission.
+type ndjsonExportWriter struct {
+	w      *json.Encoder
+	fields []string
+}
+
+func (n *ndjsonExportWriter) Header(fields []string, columns []SubmissionExportColumn) error {
+	n.fields = fields
+	return nil
+}
+
+func (n *ndjsonExportWriter) Row(fields []string, answers [][]SubmissionExportAnswer) error {
+	out := make(map[string]interface{}, len(fields)+1)
+	for i, field := range n.fields {
+		out[field] = fields[i]
+	}
+	out["form_version"], _ = strconv.Atoi(fields[4])
+	out["valid"] = fields[5] == "true"
+	list := make([]SubmissionExportAnswer, 0)
+	for _, answer := range answers {
+		list = append(list, answer...)
+	}
+	out["answers"] = list
+	return n.w.Encode(out)
+}
+
+func (n *ndjsonExportWriter) Close() error {
+	return nil
+}
+
+// xlsxExportWriter writes a workbook with a single sheet, rows are streamed to the sheet as they are written.
+type xlsxExportWriter struct {
+	w     *zip.Writer
+	sheet io.Writer
+}
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+
+const xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Submissions" sheetId="1" r:id="rId1"/></sheets></workbook>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+
+func (x *xlsxExportWriter) Header(fields []string, columns []SubmissionExportColumn) error {
+	for _, file := range [][2]string{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	} {
+		f, err := x.w.Create(file[0])
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, file[1]); err != nil {
+			return err
+		}
+	}
+	var err error
+	if x.sheet, err = x.w.Create("xl/worksheets/sheet1.xml"); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(x.sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+"\n"+
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`); err != nil {
+		return err
+	}
+	row := append([]string{}, fields...)
+	for _, column := range columns {
+		row = append(row, column.Label)
+	}
+	return x.writeRow(row)
+}
+
+func (x *xlsxExportWriter) Row(fields []string, answers [][]SubmissionExportAnswer) error {
+	row := append([]string{}, fields...)
+	for _, answer := range answers {
+		row = append(row, submissionExportCell(answer))
+	}
+	return x.writeRow(row)
+}
+
+func (x *xlsxExportWriter) writeRow(row []string) error {
+	b := strings.Builder{}
+	b.WriteString("<row>")
+	for _, value := range row {
+		b.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+		if err := xml.EscapeText(&b, []byte(xlsxCleanText(value))); err != nil {
+			return err
+		}
+		b.WriteString("</t></is></c>")
+	}
+	b.WriteString("</row>")
+	_, err := io.WriteString(x.sheet, b.String())
+	return err
+}
+
+func (x *xlsxExportWriter) Close() error {
+	if _, err := io.WriteString(x.sheet, "</sheetData></worksheet>"); err != nil {
+		return err
+	}
+	return x.w.Close()
+}
+
+// xlsxCleanText removes characters that are not allowed in XML documents.
+func xlsxCleanText(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' || (r >= 0x20 && r <= 0xD7FF) || (r >= 0xE000 && r <= 0xFFFD) || (r >= 0x10000 && r <= 0x10FFFF) {
+			return r
+		}
+		return -1
+	}, value)
+}
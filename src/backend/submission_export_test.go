@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubmissionExport(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	testTreeRoot := TreeRoot{Type: TreeForm, Parent: testUser.Team, Label: "Testing 123"}
+	if err := testTreeRoot.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	version, err := FetchTreeVersion(testTreeRoot.ID.String(), 1, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	version.Tree = getTestTree(testTreeRoot.ID.String())
+	if err := version.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	// second version removes the text question
+	version2 := TreeVersion{RootID: testTreeRoot.ID, State: TreeDraft, Tree: version.Tree[:5]}
+	if err := version2.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	submissions := []FormSubmission{
+		{FormID: testTreeRoot.ID, FormVersion: 1, Valid: true, Answers: map[string][]string{"node-a-1": {"node-a-1-1"}, "node-b-1": {"hello, world"}}},
+		{FormID: testTreeRoot.ID, FormVersion: 1, Valid: false, Answers: map[string][]string{"node-b-1": {"incomplete"}}},
+		{FormID: testTreeRoot.ID, FormVersion: 2, Valid: true, Answers: map[string][]string{"node-a-1": {"node-a-1-2"}}},
+	}
+	for i := range submissions {
+		if err := submissions[i].Store(&testUser); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	// csv, all versions
+	export, err := NewSubmissionExport(testTreeRoot.ID.String(), ExportCSV, SubmissionExportFilter{}, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	buf := bytes.Buffer{}
+	if err := export.Write(&buf); err != nil {
+		t.Error(err)
+		return
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(rows) != 4 {
+		t.Errorf("expected header and 3 rows, got %d", len(rows))
+		return
+	}
+	header := strings.Join(rows[0][len(submissionExportFields):], "|")
+	if header != "Is this a test question?|Enter something..." {
+		t.Errorf("unexpected question columns %s", header)
+	}
+	values := make(map[string][]string)
+	for _, row := range rows[1:] {
+		values[row[0]] = row[len(submissionExportFields):]
+	}
+	if v := values[submissions[0].ID.String()]; v[0] != "Yes" || v[1] != "hello, world" {
+		t.Errorf("unexpected row %v", v)
+	}
+	if v := values[submissions[2].ID.String()]; v[0] != "No" || v[1] != "" {
+		t.Errorf("unexpected row %v", v)
+	}
+	// ndjson, valid submissions of version 1
+	valid := true
+	export, err = NewSubmissionExport(testTreeRoot.ID.String(), ExportNDJSON, SubmissionExportFilter{Version: 1, Valid: &valid}, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	buf.Reset()
+	if err := export.Write(&buf); err != nil {
+		t.Error(err)
+		return
+	}
+	lines := make([]map[string]interface{}, 0)
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		line := make(map[string]interface{})
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Error(err)
+			return
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 1 || lines[0]["id"] != submissions[0].ID.String() || lines[0]["form_version"] != float64(1) {
+		t.Errorf("unexpected ndjson output %v", lines)
+	}
+	// xlsx
+	export, err = NewSubmissionExport(testTreeRoot.ID.String(), ExportXLSX, SubmissionExportFilter{Version: 2}, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	buf.Reset()
+	if err := export.Write(&buf); err != nil {
+		t.Error(err)
+		return
+	}
+	zipReader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sheet := ""
+	for _, f := range zipReader.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			rc, _ := f.Open()
+			raw, _ := io.ReadAll(rc)
+			rc.Close()
+			sheet = string(raw)
+		}
+	}
+	if strings.Count(sheet, "<row>") != 2 || !strings.Contains(sheet, ">No<") || strings.Contains(sheet, "Enter something...") {
+		t.Errorf("unexpected sheet %s", sheet)
+	}
+	// exports page through more submissions than fit a page, with the same created time
+	created := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	pagedID := GenerateDatabaseId()
+	pagedID[1] ^= 0xff
+	for i := 0; i < dbMaxFetchLimit+5; i++ {
+		pagedID[0] = byte(i)
+		paged := FormSubmission{ID: pagedID, FormID: testTreeRoot.ID, FormVersion: 2, Created: created, Modified: created}
+		if err := databaseStoreOne(nil, &paged); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	export, err = NewSubmissionExport(testTreeRoot.ID.String(), ExportCSV, SubmissionExportFilter{From: created}, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	buf.Reset()
+	if err := export.Write(&buf); err != nil {
+		t.Error(err)
+		return
+	}
+	if rows, err = csv.NewReader(&buf).ReadAll(); err != nil {
+		t.Error(err)
+		return
+	}
+	exported := make(map[string]bool)
+	for _, row := range rows[1:] {
+		exported[row[0]] = true
+	}
+	if len(rows) != dbMaxFetchLimit+6 || len(exported) != dbMaxFetchLimit+5 {
+		t.Errorf("expected %d distinct rows, got %d rows", dbMaxFetchLimit+5, len(rows)-1)
+	}
+	// other team and invalid format
+	otherUser := User{ID: testUser.ID, Team: testUser.Team, Permission: UserPermission{PermAdmin}}
+	otherUser.Team[0]++
+	if _, err := NewSubmissionExport(testTreeRoot.ID.String(), ExportCSV, SubmissionExportFilter{}, &otherUser); err != ErrInvalidPermission {
+		t.Errorf("expected invalid permission error")
+	}
+	if _, err := NewSubmissionExport(testTreeRoot.ID.String(), "pdf", SubmissionExportFilter{}, &testUser); err != ErrObjInvalidParam {
+		t.Errorf("expected invalid param error")
+	}
+}
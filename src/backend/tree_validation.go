@@ -0,0 +1,168 @@
+package main
+
+import "fmt"
+
+// Tree issue severities, errors block publishing while warnings are informational.
+const (
+	TreeIssueError   = "error"
+	TreeIssueWarning = "warning"
+)
+
+// Tree issue codes.
+const (
+	TreeErrMissingUID      = "missing_uid"
+	TreeErrDuplicateUID    = "duplicate_uid"
+	TreeErrMultipleRoots   = "multiple_roots"
+	TreeErrInvalidParent   = "invalid_parent"
+	TreeErrCycle           = "cycle"
+	TreeErrAnswerParent    = "invalid_answer_parent"
+	TreeErrMissingTemplate = "missing_template"
+	TreeWarnUnknownType    = "unknown_type"
+	TreeWarnNoAnswers      = "no_answers"
+)
+
+var treeNodeTypes = map[string]bool{
+	NodeRoot: true, NodeGroup: true, NodeQuestion: true, NodeAnswer: true, NodeRule: true, NodeMatrix: true,
+}
+
+// TreeIssue is a structural problem with a node of a tree, located by its uid and position in the tree.
+type TreeIssue struct {
+	Node     string `json:"node"`
+	Index    int    `json:"index"`
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+}
+
+// TreeValidation is the result of checking the structure of a tree.
+type TreeValidation struct {
+	Issues []TreeIssue `json:"issues"`
+}
+
+// HasErrors returns true if any of the issues should block publishing.
+func (v *TreeValidation) HasErrors() bool {
+	for _, issue := range v.Issues {
+		if issue.Severity == TreeIssueError {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the structure of the version's tree, rule templates are looked up in the user's team.
+func (t *TreeVersion) Validate(user *User) (*TreeValidation, error) {
+	templateIDs := t.GetRuleTemplateIDs()
+	templates := make(map[string]bool)
+	if len(templateIDs) > 0 {
+		res, err := listRuleTemplateByID(user.getStore(), user.Team, templateIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, template := range res {
+			templates[template.ID.String()] = true
+		}
+	}
+	return ValidateTree(t.RootID.String(), t.Tree, templates), nil
+}
+
+// ValidateTree checks that node uids are unique, parents exist and don't form cycles, answers belong to
+// choice questions and rule templates exist. Top level nodes may use the tree root id as their parent.
+func ValidateTree(rootID string, tree []Node, templates map[string]bool) *TreeValidation {
+	out := &TreeValidation{Issues: make([]TreeIssue, 0)}
+	add := func(index int, severity string, code string, message string) {
+		uid := ""
+		if index >= 0 && index < len(tree) {
+			uid = tree[index].UID
+		}
+		out.Issues = append(out.Issues, TreeIssue{Node: uid, Index: index, Severity: severity, Code: code, Message: message})
+	}
+	// index nodes
+	nodes := make(map[string]*Node)
+	children := make(map[string]int)
+	roots := 0
+	for i := range tree {
+		n := &tree[i]
+		if n.UID == "" {
+			add(i, TreeIssueError, TreeErrMissingUID, "Node has no uid.")
+			continue
+		}
+		if nodes[n.UID] != nil {
+			add(i, TreeIssueError, TreeErrDuplicateUID, fmt.Sprintf("Uid %s is used by more than one node.", n.UID))
+			continue
+		}
+		nodes[n.UID] = n
+		children[n.Parent]++
+		if n.Type == NodeRoot {
+			roots++
+			if roots > 1 {
+				add(i, TreeIssueError, TreeErrMultipleRoots, "Tree has more than one root node.")
+			}
+		}
+	}
+	for i := range tree {
+		n := &tree[i]
+		if n.UID == "" || nodes[n.UID] != n {
+			continue
+		}
+		if !treeNodeTypes[n.Type] {
+			add(i, TreeIssueWarning, TreeWarnUnknownType, fmt.Sprintf("Unknown node type %q.", n.Type))
+		}
+		// parent
+		parent := nodes[n.Parent]
+		if n.Type != NodeRoot && parent == nil && (n.Parent == "" || n.Parent != rootID) {
+			add(i, TreeIssueError, TreeErrInvalidParent, fmt.Sprintf("Parent %q does not exist.", n.Parent))
+		}
+		if treeHasCycle(n, nodes) {
+			add(i, TreeIssueError, TreeErrCycle, "Node is its own ancestor.")
+		}
+		switch n.Type {
+		case NodeAnswer:
+			{
+				if parent == nil {
+					break
+				}
+				questionType := parent.Data.GetString("type")
+				if parent.Type != NodeQuestion || (questionType != "" && questionType != QuestionChoice && questionType != QuestionDropdown) {
+					add(i, TreeIssueError, TreeErrAnswerParent, "Answers must belong to a choice or dropdown question.")
+				}
+				break
+			}
+		case NodeQuestion:
+			{
+				questionType := n.Data.GetString("type")
+				if (questionType == QuestionChoice || questionType == QuestionDropdown) && children[n.UID] == 0 {
+					add(i, TreeIssueWarning, TreeWarnNoAnswers, "Choice question has no answers.")
+				}
+				break
+			}
+		case NodeRule:
+			{
+				template, exists := n.Data["template"]
+				if !exists || template == nil || template == "" {
+					break
+				}
+				if id, ok := template.(string); !ok || !templates[id] {
+					add(i, TreeIssueError, TreeErrMissingTemplate, fmt.Sprintf("Rule template %v does not exist.", template))
+				}
+				break
+			}
+		}
+	}
+	return out
+}
+
+// treeHasCycle returns true if following the parents of a node leads back to it.
+func treeHasCycle(n *Node, nodes map[string]*Node) bool {
+	seen := map[string]bool{n.UID: true}
+	for parent := nodes[n.Parent]; parent != nil; parent = nodes[parent.Parent] {
+		if parent.UID == n.UID {
+			return true
+		}
+		if seen[parent.UID] {
+			// cycle further up that doesn't include this node
+			return false
+		}
+		seen[parent.UID] = true
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testTreeIssueCodes maps issue codes to the uids of the nodes they were found on.
+func testTreeIssueCodes(v *TreeValidation) map[string]string {
+	out := make(map[string]string)
+	for _, issue := range v.Issues {
+		out[issue.Code] = issue.Node
+	}
+	return out
+}
+
+func TestValidateTree(t *testing.T) {
+	// valid tree
+	if v := ValidateTree("root", getTestTree("root"), nil); len(v.Issues) != 0 {
+		t.Errorf("expected no issues, got %+v", v.Issues)
+	}
+	// top level nodes can reference the tree root id
+	tree := getTestTree("root")[1:]
+	if v := ValidateTree("root", tree, nil); len(v.Issues) != 0 {
+		t.Errorf("expected no issues, got %+v", v.Issues)
+	}
+	// broken tree
+	tree = getTestTree("root")
+	tree[4].UID = "node-a-1-1"
+	tree[5].Parent = "missing"
+	tree[6].Data["type"] = QuestionText
+	tree[6].Parent = "node-c"
+	tree = append(tree,
+		Node{UID: "node-c", Type: NodeGroup, Parent: "node-d"},
+		Node{UID: "node-d", Type: NodeGroup, Parent: "node-c"},
+		Node{UID: "node-b-1-1", Type: NodeAnswer, Parent: "node-b-1"},
+		Node{UID: "node-e", Type: NodeQuestion, Parent: "node-c", Data: NodeData{"type": QuestionChoice}},
+		Node{UID: "rule-a", Type: NodeRule, Parent: "node-e", Data: NodeData{"template": "missing"}},
+		Node{UID: "rule-b", Type: NodeRule, Parent: "node-e", Data: NodeData{"template": "exists"}},
+		Node{UID: "widget", Type: "widget", Parent: "root"},
+		Node{Type: NodeGroup, Parent: "root"},
+		Node{UID: "root-2", Type: NodeRoot},
+	)
+	v := ValidateTree("root", tree, map[string]bool{"exists": true})
+	if !v.HasErrors() {
+		t.Errorf("expected errors")
+	}
+	codes := testTreeIssueCodes(v)
+	for code, uid := range map[string]string{
+		TreeErrDuplicateUID:    "node-a-1-1",
+		TreeErrInvalidParent:   "node-b",
+		TreeErrCycle:           "node-d",
+		TreeErrAnswerParent:    "node-b-1-1",
+		TreeErrMissingTemplate: "rule-a",
+		TreeErrMissingUID:      "",
+		TreeErrMultipleRoots:   "root-2",
+		TreeWarnUnknownType:    "widget",
+	} {
+		if found, exists := codes[code]; !exists || found != uid {
+			t.Errorf("expected %s issue on %q, got %+v", code, uid, v.Issues)
+		}
+	}
+	if _, exists := codes[TreeWarnNoAnswers]; exists {
+		t.Errorf("expected no answers warning not to be raised for question with rule children")
+	}
+	for _, issue := range v.Issues {
+		if issue.Code == TreeErrDuplicateUID && issue.Index != 4 {
+			t.Errorf("expected duplicate uid issue at index 4, got %d", issue.Index)
+		}
+	}
+}
+
+func TestTreeVersionPublishValidation(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermManageForm},
+	}
+	testTreeRoot := TreeRoot{Parent: testUser.Team, Type: TreeForm}
+	if err := testTreeRoot.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	version, err := FetchTreeVersion(testTreeRoot.ID.String(), 1, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// drafts store with issues
+	version.Tree = getTestTree(testTreeRoot.ID.String())
+	version.Tree[2].Parent = "missing"
+	if err := version.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(version.Issues) != 1 || version.Issues[0].Code != TreeErrInvalidParent {
+		t.Errorf("expected invalid parent issue, got %+v", version.Issues)
+	}
+	// publish is blocked
+	if err := version.Publish(&testUser); err != ErrTreeInvalid {
+		t.Errorf("expected invalid tree error, got %v", err)
+	}
+	if _, err := FetchTreeVersionLatestPublished(testTreeRoot.ID.String(), &testUser); err == nil {
+		t.Errorf("expected no published version")
+	}
+	// storing the published state directly is blocked too
+	version.State = TreePublished
+	if err := version.Store(&testUser); err != ErrTreeInvalid {
+		t.Errorf("expected invalid tree error when storing published state, got %v", err)
+	}
+	version.State = TreeDraft
+	version.Tree[2].Parent = "node-a"
+	if err := version.Publish(&testUser); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHTTPTreeVersionStorePublish(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermAdmin},
+	}
+	if err := databaseStoreOne(nil, &testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	_, token, err := NewAPIToken("Publish", UserPermission{PermAdmin}, time.Time{}, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	testTreeRoot := TreeRoot{Parent: testUser.Team, Type: TreeForm}
+	if err := testTreeRoot.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	tree := getTestTree(testTreeRoot.ID.String())
+	tree[2].Parent = "missing"
+	store := HTTPBatchPayload{Path: "/api/tree/version/store", Payload: map[string]interface{}{
+		"id": testTreeRoot.ID.String(), "version": 1, "state": "published", "tree": tree,
+	}}
+	// invalid trees aren't published
+	res, err := testBatch(token, "", []HTTPBatchPayload{store})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	steps, _ := res.Data.([]interface{})
+	if len(steps) != 1 || steps[0].(map[string]interface{})["success"] != false {
+		t.Errorf("expected invalid tree to be rejected, got %+v", res)
+	}
+	if _, err := FetchTreeVersionLatestPublished(testTreeRoot.ID.String(), &testUser); err == nil {
+		t.Errorf("expected no published version")
+	}
+	// valid trees are published with an audit entry
+	tree[2].Parent = "node-a"
+	if _, err := testBatch(token, "", []HTTPBatchPayload{store}); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := FetchTreeVersionLatestPublished(testTreeRoot.ID.String(), &testUser); err != nil {
+		t.Errorf("expected version to be published, got %v", err)
+	}
+	entries, _, err := ListAuditLog(AuditFilter{ObjectType: "tree_version", ObjectID: testTreeRoot.ID.String()}, &testUser, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if testAuditEntries(entries)[AuditPublish] == nil {
+		t.Errorf("expected publish audit entry")
+	}
+}
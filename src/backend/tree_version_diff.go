@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Tree diff actions.
+const (
+	TreeDiffAdded   = "added"
+	TreeDiffRemoved = "removed"
+	TreeDiffChanged = "changed"
+)
+
+// TreeFieldChange is a change of a single node field, data fields are prefixed with "data.".
+type TreeFieldChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// TreeNodeDiff is the difference of a single node between two tree versions.
+type TreeNodeDiff struct {
+	UID        string            `json:"uid"`
+	Type       string            `json:"type"`
+	Action     string            `json:"action"`
+	Moved      bool              `json:"moved,omitempty"`
+	FromParent string            `json:"from_parent,omitempty"`
+	ToParent   string            `json:"to_parent,omitempty"`
+	Changes    []TreeFieldChange `json:"changes,omitempty"`
+}
+
+// TreeVersionDiff is the difference between two versions of a tree.
+type TreeVersionDiff struct {
+	RootID  DatabaseID     `json:"root_id"`
+	From    int            `json:"from"`
+	To      int            `json:"to"`
+	Added   int            `json:"added"`
+	Removed int            `json:"removed"`
+	Moved   int            `json:"moved"`
+	Changed int            `json:"changed"`
+	Nodes   []TreeNodeDiff `json:"nodes"`
+}
+
+// DiffTreeVersion compares the trees of two versions by node uid. Nodes are listed in the order of
+// the newer tree followed by removed nodes in the order of the older tree.
+func DiffTreeVersion(from *TreeVersion, to *TreeVersion) *TreeVersionDiff {
+	out := &TreeVersionDiff{
+		RootID: to.RootID,
+		From:   from.Version,
+		To:     to.Version,
+		Nodes:  make([]TreeNodeDiff, 0),
+	}
+	fromNodes := make(map[string]*Node)
+	for i := range from.Tree {
+		fromNodes[from.Tree[i].UID] = &from.Tree[i]
+	}
+	toNodes := make(map[string]*Node)
+	for i := range to.Tree {
+		toNodes[to.Tree[i].UID] = &to.Tree[i]
+	}
+	// added and changed
+	for i := range to.Tree {
+		node := &to.Tree[i]
+		prev := fromNodes[node.UID]
+		if prev == nil {
+			out.Added++
+			out.Nodes = append(out.Nodes, TreeNodeDiff{
+				UID:      node.UID,
+				Type:     node.Type,
+				Action:   TreeDiffAdded,
+				ToParent: node.Parent,
+				Changes:  treeNodeChanges(&Node{Type: node.Type}, node),
+			})
+			continue
+		}
+		diff := TreeNodeDiff{
+			UID:     node.UID,
+			Type:    node.Type,
+			Action:  TreeDiffChanged,
+			Changes: treeNodeChanges(prev, node),
+		}
+		if prev.Parent != node.Parent {
+			diff.Moved = true
+			diff.FromParent = prev.Parent
+			diff.ToParent = node.Parent
+			out.Moved++
+		}
+		if len(diff.Changes) > 0 {
+			out.Changed++
+		}
+		if diff.Moved || len(diff.Changes) > 0 {
+			out.Nodes = append(out.Nodes, diff)
+		}
+	}
+	// removed
+	for i := range from.Tree {
+		node := &from.Tree[i]
+		if toNodes[node.UID] == nil {
+			out.Removed++
+			out.Nodes = append(out.Nodes, TreeNodeDiff{
+				UID:        node.UID,
+				Type:       node.Type,
+				Action:     TreeDiffRemoved,
+				FromParent: node.Parent,
+				Changes:    treeNodeChanges(node, &Node{Type: node.Type}),
+			})
+		}
+	}
+	return out
+}
+
+// treeNodeChanges returns the label, type, tag and data changes between two nodes.
+func treeNodeChanges(before *Node, after *Node) []TreeFieldChange {
+	out := make([]TreeFieldChange, 0)
+	if before.Type != after.Type {
+		out = append(out, TreeFieldChange{Field: "type", Before: before.Type, After: after.Type})
+	}
+	if before.Label != after.Label {
+		out = append(out, TreeFieldChange{Field: "label", Before: before.Label, After: after.Label})
+	}
+	if strings.Join(before.Tags, "\x00") != strings.Join(after.Tags, "\x00") {
+		change := TreeFieldChange{Field: "tags"}
+		if len(before.Tags) > 0 {
+			change.Before = before.Tags
+		}
+		if len(after.Tags) > 0 {
+			change.After = after.Tags
+		}
+		out = append(out, change)
+	}
+	keys := make([]string, 0, len(before.Data)+len(after.Data))
+	for k := range before.Data {
+		keys = append(keys, k)
+	}
+	for k := range after.Data {
+		if _, exists := before.Data[k]; !exists {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b, a := before.Data[k], after.Data[k]
+		if queryEqual(b, a) {
+			continue
+		}
+		out = append(out, TreeFieldChange{Field: "data." + k, Before: auditValue(b), After: auditValue(a)})
+	}
+	return out
+}
+
+// Unified renders the diff as text in the style of a unified diff for code review.
+func (d *TreeVersionDiff) Unified() string {
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "--- %s version %d\n", d.RootID.String(), d.From)
+	fmt.Fprintf(&b, "+++ %s version %d\n", d.RootID.String(), d.To)
+	for _, node := range d.Nodes {
+		fmt.Fprintf(&b, "@@ %s (%s) %s @@\n", node.UID, node.Type, node.Action)
+		switch node.Action {
+		case TreeDiffAdded:
+			{
+				fmt.Fprintf(&b, "+parent: %s\n", node.ToParent)
+				break
+			}
+		case TreeDiffRemoved:
+			{
+				fmt.Fprintf(&b, "-parent: %s\n", node.FromParent)
+				break
+			}
+		default:
+			{
+				if node.Moved {
+					fmt.Fprintf(&b, "-parent: %s\n", node.FromParent)
+					fmt.Fprintf(&b, "+parent: %s\n", node.ToParent)
+				}
+			}
+		}
+		for _, change := range node.Changes {
+			if change.Before != nil && change.Before != "" {
+				fmt.Fprintf(&b, "-%s: %s\n", change.Field, treeDiffValue(change.Before))
+			}
+			if change.After != nil && change.After != "" {
+				fmt.Fprintf(&b, "+%s: %s\n", change.Field, treeDiffValue(change.After))
+			}
+		}
+	}
+	return b.String()
+}
+
+// treeDiffValue formats a value for the unified rendering, strings are written as is.
+func treeDiffValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return strings.ReplaceAll(s, "\n", "\\n")
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(raw)
+}
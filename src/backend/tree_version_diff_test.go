@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffTreeVersion(t *testing.T) {
+	from := &TreeVersion{RootID: GenerateDatabaseId(), Version: 1, Tree: getTestTree("root")}
+	to := &TreeVersion{RootID: from.RootID, Version: 2, Tree: getTestTree("root")}
+	// move question, change label, tags and data
+	to.Tree[2].Parent = "node-b"
+	to.Tree[3].Label = "Affirmative"
+	to.Tree[3].Tags = []string{"yes"}
+	to.Tree[3].Data = NodeData{"label": "Yes!", "value": "yes"}
+	// remove answer and add question
+	to.Tree = append(to.Tree[:4], to.Tree[5:]...)
+	to.Tree = append(to.Tree, Node{UID: "node-b-2", Type: NodeQuestion, Parent: "node-b", Data: NodeData{"label": "New"}})
+	diff := DiffTreeVersion(from, to)
+	if diff.Added != 1 || diff.Removed != 1 || diff.Moved != 1 || diff.Changed != 1 || len(diff.Nodes) != 4 {
+		t.Errorf("unexpected diff counts %+v", diff)
+		return
+	}
+	moved := diff.Nodes[0]
+	if moved.UID != "node-a-1" || !moved.Moved || moved.FromParent != "node-a" || moved.ToParent != "node-b" || len(moved.Changes) != 0 {
+		t.Errorf("unexpected moved node %+v", moved)
+	}
+	changed := diff.Nodes[1]
+	if changed.UID != "node-a-1-1" || changed.Action != TreeDiffChanged || len(changed.Changes) != 3 {
+		t.Errorf("unexpected changed node %+v", changed)
+		return
+	}
+	if changed.Changes[0].Field != "label" || changed.Changes[1].Field != "tags" || changed.Changes[2].Field != "data.label" || changed.Changes[2].After != "Yes!" {
+		t.Errorf("unexpected changes %+v", changed.Changes)
+	}
+	if diff.Nodes[2].UID != "node-b-2" || diff.Nodes[2].Action != TreeDiffAdded {
+		t.Errorf("expected added node, got %+v", diff.Nodes[2])
+	}
+	if diff.Nodes[3].UID != "node-a-1-2" || diff.Nodes[3].Action != TreeDiffRemoved {
+		t.Errorf("expected removed node, got %+v", diff.Nodes[3])
+	}
+	// unified
+	text := diff.Unified()
+	for _, line := range []string{
+		"@@ node-a-1 (question) changed @@\n-parent: node-a\n+parent: node-b\n",
+		"-data.label: Yes\n+data.label: Yes!\n",
+		"+tags: [\"yes\"]\n",
+		"@@ node-b-2 (question) added @@\n+parent: node-b\n+data.label: New\n",
+		"@@ node-a-1-2 (answer) removed @@\n-parent: node-a-1\n-data.label: No\n-data.value: no\n",
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("expected unified diff to contain %q, got\n%s", line, text)
+		}
+	}
+	// no changes
+	if diff := DiffTreeVersion(from, from); len(diff.Nodes) != 0 {
+		t.Errorf("expected no changes")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const treeVersionSchedulePollInterval = 30 * time.Second
+
+// TreeVersionStartScheduler starts the background scheduler that publishes versions when their publish_at time is reached.
+// The returned function stops the scheduler and waits for it to finish.
+func TreeVersionStartScheduler() func() {
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(treeVersionSchedulePollInterval)
+		defer ticker.Stop()
+		for {
+			treeVersionPublishScheduled()
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// treeVersionPublishScheduled publishes all versions whose publish_at time has passed.
+// Each version is claimed by clearing its publish_at so that it is only tried once, also when several
+// instances run the scheduler. Failures are kept on the version for its authors to fix.
+func treeVersionPublishScheduled() {
+	res, err := databaseListAll(
+		nil,
+		TreeVersion{},
+		bson.M{
+			"state":      bson.M{"$in": bson.A{TreeDraft, TreePendingReview}},
+			"publish_at": bson.M{"$gt": time.Time{}, "$lte": time.Now()},
+		},
+		bson.M{"publish_at": 1},
+		nil,
+	)
+	if err != nil {
+		log.Printf("Tree version scheduler failed to list versions: %s", err)
+		return
+	}
+	for _, item := range res {
+		treeVersion := item.(*TreeVersion)
+		claimed, err := treeVersion.claimScheduled()
+		if err != nil {
+			log.Printf("Tree version scheduler failed to claim tree %s version %d: %s", treeVersion.RootID.String(), treeVersion.Version, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		if err := treeVersion.publishScheduled(); err != nil {
+			log.Printf("Scheduled publish of tree %s version %d failed: %s", treeVersion.RootID.String(), treeVersion.Version, err)
+			if _, err := databaseUpdateOne(
+				nil,
+				TreeVersion{},
+				bson.M{"root_id": treeVersion.RootID, "version": treeVersion.Version},
+				bson.M{"schedule_error": err.Error()},
+			); err != nil {
+				log.Printf("Tree version scheduler failed to store error of tree %s version %d: %s", treeVersion.RootID.String(), treeVersion.Version, err)
+			}
+		}
+	}
+}
+
+// claimScheduled clears publish_at if it is still the one the version was listed with, false is returned
+// when the version was changed or claimed by another scheduler in the meantime.
+func (t *TreeVersion) claimScheduled() (bool, error) {
+	claimed, err := databaseUpdateOne(
+		nil,
+		TreeVersion{},
+		bson.M{"root_id": t.RootID, "version": t.Version, "publish_at": t.PublishAt},
+		bson.M{"publish_at": time.Time{}},
+	)
+	if err != nil || !claimed {
+		return false, err
+	}
+	t.PublishAt = time.Time{}
+	return true, nil
+}
+
+// publishScheduled publishes the version on behalf of the user that scheduled it, approved versions are
+// published on behalf of their approver.
+func (t *TreeVersion) publishScheduled() error {
+	userID := t.ScheduledBy
+	if userID.IsEmpty() {
+		userID = t.Modifier
+	}
+	if t.isApproved() {
+		userID = t.Reviews[len(t.Reviews)-1].User
+	}
+	user, err := FetchUserByID(userID.String(), nil)
+	if err != nil {
+		return err
+	}
+	if t.isApproved() {
+		return t.publish(user)
+	}
+	return t.Publish(user)
+}
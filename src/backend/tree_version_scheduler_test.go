@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTreeVersionScheduler(t *testing.T) {
+	if err := databaseOpen(testGetConfig()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer databaseClose()
+	testCleanDatabase()
+
+	testUser := User{
+		ID:         GenerateDatabaseId(),
+		Team:       GenerateDatabaseId(),
+		Permission: UserPermission{PermManageForm, PermSubmit},
+	}
+	if err := databaseStoreOne(nil, &testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	testTreeRoot := TreeRoot{Parent: testUser.Team, Type: TreeForm}
+	if err := testTreeRoot.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	// version 1 is due, version 2 is scheduled in the future
+	v1, err := FetchTreeVersion(testTreeRoot.ID.String(), 1, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	v1.Tree = getTestTree(testTreeRoot.ID.String())
+	v1.PublishAt = time.Now().Add(-time.Second)
+	if err := v1.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	v2 := TreeVersion{RootID: testTreeRoot.ID, State: TreeDraft, Tree: v1.Tree, PublishAt: time.Now().Add(time.Hour)}
+	if err := v2.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	// unpublish must come after publish
+	v2.UnpublishAt = v2.PublishAt.Add(-time.Minute)
+	if err := v2.Store(&testUser); err != ErrObjInvalidParam {
+		t.Errorf("expected invalid param error")
+	}
+	// version 3 is due but can't be published
+	v3 := TreeVersion{RootID: testTreeRoot.ID, State: TreeDraft, Tree: getTestTree(testTreeRoot.ID.String()), PublishAt: time.Now().Add(-time.Second)}
+	v3.Tree[2].Parent = "missing"
+	if err := v3.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	treeVersionPublishScheduled()
+	published, err := FetchTreeVersionLatestPublished(testTreeRoot.ID.String(), &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if published.Version != 1 {
+		t.Errorf("expected version 1 to be published, got %d", published.Version)
+	}
+	// failed versions aren't retried, the error is kept for the authors
+	failed, err := FetchTreeVersion(testTreeRoot.ID.String(), v3.Version, &testUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !failed.PublishAt.IsZero() || failed.ScheduleError != ErrTreeInvalid.Error() {
+		t.Errorf("expected failed version to be unscheduled with its error, got %v %q", failed.PublishAt, failed.ScheduleError)
+	}
+	// versions can only be claimed once
+	if claimed, err := v3.claimScheduled(); err != nil || claimed {
+		t.Errorf("expected claimed version not to be claimed again, got %v %v", claimed, err)
+	}
+	res, _, err := ListPublishedFormRoot(&testUser, ListPage{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(res) != 1 {
+		t.Errorf("expected published form")
+	}
+	submission := FormSubmission{FormID: testTreeRoot.ID, FormVersion: 1}
+	if err := submission.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	// close version 1
+	published.UnpublishAt = time.Now().Add(-time.Millisecond)
+	if err := published.Store(&testUser); err != nil {
+		t.Error(err)
+		return
+	}
+	res, _, err = ListPublishedFormRoot(&testUser, ListPage{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(res) != 0 {
+		t.Errorf("expected closed form to be excluded")
+	}
+	if err := (&FormSubmission{FormID: testTreeRoot.ID, FormVersion: 1}).Store(&testUser); err != ErrFormClosed {
+		t.Errorf("expected form closed error, got %v", err)
+	}
+	// existing submissions can still be updated
+	if err := submission.Store(&testUser); err != nil {
+		t.Error(err)
+	}
+}
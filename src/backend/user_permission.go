@@ -9,8 +9,18 @@ const (
 	PermManageDocument     = "manage_document"      // Create/Edit/Delete documents.
 	PermManageSubmission   = "manage_submission"    // Create/Edit/Delete submissions.
 	PermManageRuleTemplate = "manage_rule_template" // Create/Edit/Delete rule templates.
+	PermManageWebhook      = "manage_webhook"       // Create/Edit/Delete webhooks and view their deliveries.
+	PermApproveForm        = "approve_form"         // Approve/Reject form versions pending review.
+	PermRead               = "read"                 // View everything in team except webhooks, read-only.
+	PermSubmit             = "submit"               // View forms and rule templates and create submissions.
 )
 
+// Permissions lists every permission.
+var Permissions = UserPermission{
+	PermAdmin, PermManageUser, PermManageForm, PermManageDocument, PermManageSubmission,
+	PermManageRuleTemplate, PermManageWebhook, PermApproveForm, PermRead, PermSubmit,
+}
+
 func (p UserPermission) Add(flag string) UserPermission {
 	return append(p, flag)
 }
@@ -33,3 +43,24 @@ func (p UserPermission) Has(flag string) bool {
 	}
 	return false
 }
+
+// Merge returns the permissions of both p and other.
+func (p UserPermission) Merge(other UserPermission) UserPermission {
+	out := append(UserPermission{}, p...)
+	for _, flag := range other {
+		if !out.Has(flag) {
+			out = out.Add(flag)
+		}
+	}
+	return out
+}
+
+// Validate checks that every permission is known.
+func (p UserPermission) Validate() error {
+	for _, flag := range p {
+		if !Permissions.Has(flag) {
+			return ErrInvalidPermissionName
+		}
+	}
+	return nil
+}
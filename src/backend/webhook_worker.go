@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const webhookMaxAttempts = 6
+const webhookPollInterval = 10 * time.Second
+const webhookTimeout = 10 * time.Second
+const webhookWorkers = 4
+
+// webhookLease is how long a claimed delivery is kept from other workers, it outlasts a request.
+const webhookLease = webhookTimeout + 20*time.Second
+const webhookSignatureHeader = "X-CCDE-Signature"
+const webhookEventHeader = "X-CCDE-Event"
+const webhookDeliveryHeader = "X-CCDE-Delivery"
+const webhookTimestampHeader = "X-CCDE-Timestamp"
+
+// webhookBackoff is the delay before the first retry, it doubles with every failed attempt.
+var webhookBackoff = 30 * time.Second
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+var webhookSignal = make(chan struct{}, 1)
+
+// WebhookStartWorker starts the background worker that sends pending webhook deliveries.
+// The returned function stops the worker and waits for it to finish.
+func WebhookStartWorker() func() {
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			// wait until the next retry is due, polling at least every interval
+			wait := webhookPollInterval
+			if next := webhookProcessDeliveries(done); !next.IsZero() && time.Until(next) < wait {
+				wait = time.Until(next)
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-done:
+				timer.Stop()
+				return
+			case <-timer.C:
+			case <-webhookSignal:
+				timer.Stop()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// webhookNotify wakes the worker up to send new deliveries.
+func webhookNotify() {
+	select {
+	case webhookSignal <- struct{}{}:
+	default:
+	}
+}
+
+// WebhookSignature returns the signature of a payload, receivers should compare it with the signature header.
+func WebhookSignature(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookProcessDeliveries sends all due deliveries, up to webhookWorkers at a time, and returns the time
+// of the next pending attempt.
+func webhookProcessDeliveries(done chan struct{}) time.Time {
+	next := time.Time{}
+	res, err := databaseListAll(nil, WebhookDelivery{}, bson.M{"status": WebhookDeliveryPending}, bson.M{"next_attempt": 1}, nil)
+	if err != nil {
+		log.Printf("Webhook worker failed to list deliveries: %s", err)
+		return next
+	}
+	lock := sync.Mutex{}
+	setNext := func(t time.Time) {
+		lock.Lock()
+		defer lock.Unlock()
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, webhookWorkers)
+	for _, item := range res {
+		select {
+		case <-done:
+			wg.Wait()
+			return next
+		case sem <- struct{}{}:
+		}
+		delivery := item.(*WebhookDelivery)
+		if delivery.NextAttempt.After(time.Now()) {
+			<-sem
+			setNext(delivery.NextAttempt)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			// another worker may be sending it
+			claimed, err := delivery.claim()
+			if err != nil {
+				log.Printf("Webhook delivery %s could not be claimed: %s", delivery.ID, err)
+				return
+			}
+			if !claimed {
+				return
+			}
+			if err := delivery.attempt(); err != nil {
+				log.Printf("Webhook delivery %s failed: %s", delivery.ID, err)
+			}
+			if delivery.Status == WebhookDeliveryPending {
+				setNext(delivery.NextAttempt)
+			}
+		}()
+	}
+	wg.Wait()
+	return next
+}
+
+// claim marks the delivery as being sent by moving its next attempt to the end of a lease, other workers
+// skip it until then. If the worker stops while sending, the delivery is attempted again after the lease.
+func (d *WebhookDelivery) claim() (bool, error) {
+	lease := time.Now().Add(webhookLease)
+	claimed, err := databaseUpdateOne(
+		nil,
+		WebhookDelivery{},
+		bson.M{"_id": d.ID, "status": WebhookDeliveryPending, "next_attempt": d.NextAttempt},
+		bson.M{"next_attempt": lease},
+	)
+	if err != nil || !claimed {
+		return false, err
+	}
+	d.NextAttempt = lease
+	return true, nil
+}
+
+// attempt sends the delivery and records the result, failed deliveries are retried with exponential backoff.
+// The delivery must be claimed first.
+func (d *WebhookDelivery) attempt() error {
+	d.Attempts++
+	d.LastAttempt = time.Now()
+	d.ResponseCode = 0
+	d.Error = ""
+	// webhook may have been removed or disabled
+	res, err := databaseFetch(nil, Webhook{}, bson.M{"_id": d.Webhook}, nil)
+	if err != nil || !res.(*Webhook).Active {
+		d.Status = WebhookDeliveryFailed
+		d.Error = "webhook removed or inactive"
+		return databaseStoreOne(nil, d)
+	}
+	webhook := res.(*Webhook)
+	// send
+	if err := d.send(webhook); err != nil {
+		d.Error = err.Error()
+		d.Status = WebhookDeliveryFailed
+		if d.Attempts < webhookMaxAttempts {
+			d.Status = WebhookDeliveryPending
+			d.NextAttempt = time.Now().Add(webhookBackoff * time.Duration(1<<(d.Attempts-1)))
+		}
+		return databaseStoreOne(nil, d)
+	}
+	d.Status = WebhookDeliverySuccess
+	return databaseStoreOne(nil, d)
+}
+
+func (d *WebhookDelivery) send(webhook *Webhook) error {
+	payload := []byte(d.Payload)
+	timestamp := strconv.FormatInt(d.LastAttempt.Unix(), 10)
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, d.Event)
+	req.Header.Set(webhookDeliveryHeader, d.ID)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, WebhookSignature(webhook.Secret, timestamp, payload))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	d.ResponseCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return nil
+}
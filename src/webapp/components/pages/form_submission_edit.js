@@ -137,6 +137,7 @@ export default class FormSubmissionEditPageComponent extends BasePageComponent {
      */
     onStoreResponse(res) {
         if (this.msgLoadPromise) { this.msgLoadPromise.then(({destory}) => { destory(); } ); }
+        if (res?.errors) { this.userData.importErrors(res.errors); }
         if (this.handleErrorResponse(res)) { return; }
         msgPopup.success(MSG_SAVED, MSG_DISPLAY_TIME);
         this.hasChange = false;
@@ -40,7 +40,7 @@ export default class UserNewPageComponent extends UserEditPageComponent {
             return;
         }
         BackendAPI.post(
-            'user/store', null, {
+            'user/signup', null, {
                 team: this.state.team.id,
                 email: this.state.email,
                 password: this.state.password
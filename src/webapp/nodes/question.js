@@ -13,6 +13,7 @@ export default class QuestionNode extends BaseNode {
         this.textLines = 1;
         this.defaultAnswer = '';
         this.multiple = false;
+        this.required = false;
     }
 
     /**
@@ -30,13 +31,15 @@ export default class QuestionNode extends BaseNode {
             'type' : this.type,
             'textLines' : this.textLines,
             'defaultAnswer' : this.defaultAnswer,
-            'multiple' : this.multiple            
+            'multiple' : this.multiple,
+            'required' : this.required
         };
     }
 
     builderFields() {
         let out = [
             ['label', 'Label', 'text'],
+            ['required', 'Required', 'checkbox'],
         ];
         switch (this.type) {
             case FIELD_TEXT: {
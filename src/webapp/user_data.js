@@ -364,6 +364,22 @@ export default class UserData {
         return [];
     }
 
+    /**
+     * Add validation messages from the errors returned by the submission API.
+     * @param {Array} errors 
+     */
+    importErrors(errors) {
+        for (let i in errors) {
+            let key = errors[i].question + '_' + (errors[i].matrix_id ? errors[i].matrix_id : '');
+            if (!(key in this.questionValidationMessages)) {
+                this.questionValidationMessages[key] = [];
+            }
+            if (this.questionValidationMessages[key].indexOf(errors[i].message) == -1) {
+                this.questionValidationMessages[key].push(errors[i].message);
+            }
+        }
+    }
+
     /**
      * Export to JSON compatible object.
      * @return {object}